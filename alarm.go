@@ -0,0 +1,255 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+)
+
+const (
+	// alarmTimeout is timeout of a single alarm webhook request or command run
+	alarmTimeout = 2 * time.Second
+	// alarmRetries is number of times a failed alarm webhook request is retried
+	alarmRetries = 3
+	// alarmQueueSize is number of alarm events waiting to be delivered before new events are dropped
+	alarmQueueSize = 16
+)
+
+// AlarmEvent is sent to alarm outputs when a part defect is confirmed
+type AlarmEvent struct {
+	// Source is name of the video source the defect was detected in
+	Source string `json:"source"`
+	// Time is time when the defect was confirmed
+	Time time.Time `json:"time"`
+	// Rect is defected part rectangle in original frame coordinates
+	Rect [4]int `json:"rect"`
+	// Area is measured area of the defected part
+	Area int `json:"area"`
+	// TotalParts contains total number of detected parts
+	TotalParts int `json:"total_parts"`
+	// TotalDefects contains total number of defected parts
+	TotalDefects int `json:"total_defects"`
+	// EventID is unique identifier of the defect
+	EventID string `json:"event_id"`
+	// Seq is sequence number of the result the defect was confirmed in
+	Seq uint64 `json:"seq"`
+	// Zone is name of the zone the defect was detected in; it's empty if no zones are configured
+	Zone string `json:"zone,omitempty"`
+	// Confidence is confidence of the defect classification in range [0, 1]
+	Confidence float64 `json:"confidence"`
+	// WindowMinArea is the smallest part area measured in the frames the defect was confirmed over
+	WindowMinArea int `json:"window_min_area"`
+	// WindowMaxArea is the largest part area measured in the frames the defect was confirmed over
+	WindowMaxArea int `json:"window_max_area"`
+	// WindowMeanArea is mean part area measured in the frames the defect was confirmed over
+	WindowMeanArea float64 `json:"window_mean_area"`
+	// Profile is name of the configuration profile the defect was detected with; it's empty if no profile is used
+	Profile string `json:"profile,omitempty"`
+}
+
+// NewAlarmEvent creates new alarm event from detection result r and returns it
+func NewAlarmEvent(r *detector.Result) *AlarmEvent {
+	rect := r.OrigRect
+	return &AlarmEvent{
+		Source:       r.Source,
+		Time:         time.Now(),
+		Rect:         [4]int{rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y},
+		Area:         detector.Area(r.Rect),
+		TotalParts:   r.TotalParts,
+		TotalDefects: r.TotalDefects,
+		EventID:      r.EventID,
+		Seq:          r.Seq,
+		Zone:         r.Zone,
+		Confidence:   r.Confidence,
+		Profile:      r.Profile,
+	}
+}
+
+// Alarm delivers alarm events to webhook and external command asynchronously
+type Alarm struct {
+	// webhook is URL alarm events are POSTed to
+	webhook string
+	// cmd is shell command which receives alarm events on its stdin
+	cmd string
+	// cooldown is minimum time between two alarms fired for the same part
+	cooldown time.Duration
+	// mu protects lastFired as alarms are fired from multiple frameRunners
+	mu sync.Mutex
+	// lastFired maps the parts to the time their alarm was last fired
+	lastFired map[string]time.Time
+	// client is HTTP client used for webhook requests
+	client *http.Client
+	// queue holds alarm events waiting to be delivered
+	queue chan *AlarmEvent
+	// failures counts failed alarm deliveries
+	failures uint64
+	// dropped counts alarm events dropped because the queue was full
+	dropped uint64
+}
+
+// NewAlarm creates new alarm which delivers events to webhook and cmd and returns it
+// Empty webhook or cmd disables the particular output.
+func NewAlarm(webhook, cmd string, cooldown time.Duration) *Alarm {
+	return &Alarm{
+		webhook:   webhook,
+		cmd:       cmd,
+		cooldown:  cooldown,
+		lastFired: make(map[string]time.Time),
+		client:    &http.Client{Timeout: alarmTimeout},
+		queue:     make(chan *AlarmEvent, alarmQueueSize),
+	}
+}
+
+// Fire queues alarm event e for delivery without blocking.
+// Events of the part, i.e. of the same source, zone and defect event ID, fired within cooldown since its last fired
+// event are ignored, so every new part fires its alarm; it returns false if e was not queued.
+func (a *Alarm) Fire(e *AlarmEvent) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// parts whose cooldown has passed can't suppress any alarm
+	for key, t := range a.lastFired {
+		if e.Time.Sub(t) >= a.cooldown {
+			delete(a.lastFired, key)
+		}
+	}
+
+	key := e.Source + "/" + e.Zone + "/" + e.EventID
+	if _, ok := a.lastFired[key]; ok {
+		return false
+	}
+
+	select {
+	case a.queue <- e:
+		a.lastFired[key] = e.Time
+		return true
+	default:
+		atomic.AddUint64(&a.dropped, 1)
+		fmt.Fprintf(os.Stderr, "Dropping alarm event: alarm queue is full\n")
+		return false
+	}
+}
+
+// Failures returns number of failed alarm deliveries
+func (a *Alarm) Failures() uint64 {
+	return atomic.LoadUint64(&a.failures)
+}
+
+// Dropped returns number of alarm events dropped because the queue was full
+func (a *Alarm) Dropped() uint64 {
+	return atomic.LoadUint64(&a.dropped)
+}
+
+// Run delivers queued alarm events until it receives a signal on doneChan
+// The events still queued then are delivered before it returns, so no confirmed defect is left without its alarm.
+func (a *Alarm) Run(doneChan <-chan struct{}) error {
+	for {
+		select {
+		case e := <-a.queue:
+			a.deliver(e)
+		case <-doneChan:
+			fmt.Printf("Stopping alarm: received stop signal\n")
+			for {
+				select {
+				case e := <-a.queue:
+					a.deliver(e)
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// deliver sends alarm event e to all configured outputs logging and counting failures
+func (a *Alarm) deliver(e *AlarmEvent) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		atomic.AddUint64(&a.failures, 1)
+		fmt.Fprintf(os.Stderr, "Error encoding alarm event: %v\n", err)
+		return
+	}
+
+	if a.webhook != "" {
+		if err := a.post(data); err != nil {
+			atomic.AddUint64(&a.failures, 1)
+			fmt.Fprintf(os.Stderr, "Error sending alarm to %s: %v\n", a.webhook, err)
+		}
+	}
+
+	if a.cmd != "" {
+		if err := a.exec(data); err != nil {
+			atomic.AddUint64(&a.failures, 1)
+			fmt.Fprintf(os.Stderr, "Error running alarm command %q: %v\n", a.cmd, err)
+		}
+	}
+}
+
+// post POSTs data to alarm webhook retrying up to alarmRetries times on failure
+func (a *Alarm) post(data []byte) error {
+	var err error
+	for i := 0; i <= alarmRetries; i++ {
+		if i > 0 {
+			time.Sleep(time.Duration(i) * 100 * time.Millisecond)
+		}
+
+		var resp *http.Response
+		resp, err = a.client.Post(a.webhook, "application/json", bytes.NewReader(data))
+		if err != nil {
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		err = fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	return err
+}
+
+// exec runs alarm command with data on its stdin
+func (a *Alarm) exec(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), alarmTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", a.cmd)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
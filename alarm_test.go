@@ -0,0 +1,86 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAlarmFireCooldown(t *testing.T) {
+	a := NewAlarm("", "", 2*time.Second)
+	start := time.Now()
+
+	tests := []struct {
+		name  string
+		event AlarmEvent
+		fired bool
+	}{
+		{"first part", AlarmEvent{Source: "cam", EventID: "a", Time: start}, true},
+		{"same part within cooldown", AlarmEvent{Source: "cam", EventID: "a", Time: start.Add(time.Second)}, false},
+		{"next part within cooldown", AlarmEvent{Source: "cam", EventID: "b", Time: start.Add(time.Second)}, true},
+		{"same part in another zone", AlarmEvent{Source: "cam", Zone: "left", EventID: "b", Time: start.Add(time.Second)}, true},
+		{"same part in another source", AlarmEvent{Source: "cam2", EventID: "a", Time: start.Add(time.Second)}, true},
+		{"same part after cooldown", AlarmEvent{Source: "cam", EventID: "a", Time: start.Add(3 * time.Second)}, true},
+	}
+
+	for _, tt := range tests {
+		e := tt.event
+		if fired := a.Fire(&e); fired != tt.fired {
+			t.Errorf("%s: Fire() = %v, want %v", tt.name, fired, tt.fired)
+		}
+	}
+}
+
+func TestAlarmRunDeliversQueuedEvents(t *testing.T) {
+	var delivered int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&delivered, 1)
+	}))
+	defer srv.Close()
+
+	a := NewAlarm(srv.URL, "", 0)
+	for _, id := range []string{"a", "b", "c"} {
+		if !a.Fire(&AlarmEvent{Source: "cam", EventID: id, Time: time.Now()}) {
+			t.Fatalf("alarm %s was not queued", id)
+		}
+	}
+
+	// the events are queued before the stop signal arrives, so all of them must be delivered
+	doneChan := make(chan struct{})
+	close(doneChan)
+	if err := a.Run(doneChan); err != nil {
+		t.Fatalf("Run() = %v", err)
+	}
+
+	if n := atomic.LoadInt32(&delivered); n != 3 {
+		t.Errorf("delivered %d alarms, want 3", n)
+	}
+	if a.Failures() != 0 {
+		t.Errorf("Failures() = %d, want 0", a.Failures())
+	}
+}
@@ -0,0 +1,71 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+// beepTimeout is timeout of a single beep command run
+const beepTimeout = 10 * time.Second
+
+// Beeper runs audible alert command when a part defect is confirmed
+type Beeper struct {
+	// cmd is shell command which plays the alert
+	cmd string
+	// busy is set while the command is running
+	busy int32
+}
+
+// NewBeeper creates new beeper which runs shell command cmd and returns it
+func NewBeeper(cmd string) *Beeper {
+	return &Beeper{cmd: cmd}
+}
+
+// Beep runs the beeper command asynchronously
+// It does nothing if the command started by the previous Beep is still running.
+func (b *Beeper) Beep() {
+	if !atomic.CompareAndSwapInt32(&b.busy, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&b.busy, 0)
+
+		ctx, cancel := context.WithTimeout(context.Background(), beepTimeout)
+		defer cancel()
+
+		cmd := exec.CommandContext(ctx, "/bin/sh", "-c", b.cmd)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "Beep command failed: %v\n", err)
+		}
+	}()
+}
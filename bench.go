@@ -0,0 +1,194 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+)
+
+// benchStages are names of the benchmarked processing stages in the order they run
+var benchStages = []string{"read", "transform", "detectBlob", "detectStatus", "serialize"}
+
+// stageTimer collects durations of processing stages
+type stageTimer struct {
+	// durations maps stage names to their recorded durations
+	durations map[string][]time.Duration
+}
+
+// newStageTimer creates new stage timer and returns it
+func newStageTimer() *stageTimer {
+	return &stageTimer{durations: make(map[string][]time.Duration)}
+}
+
+// record records duration d of stage
+func (t *stageTimer) record(stage string, d time.Duration) {
+	t.durations[stage] = append(t.durations[stage], d)
+}
+
+// total returns total duration of stage
+func (t *stageTimer) total(stage string) time.Duration {
+	var total time.Duration
+	for _, d := range t.durations[stage] {
+		total += d
+	}
+
+	return total
+}
+
+// percentile returns p-th percentile of stage durations; p must be within (0, 1]
+func (t *stageTimer) percentile(stage string, p float64) time.Duration {
+	ds := append([]time.Duration(nil), t.durations[stage]...)
+	if len(ds) == 0 {
+		return 0
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+
+	return ds[int(math.Ceil(p*float64(len(ds))))-1]
+}
+
+// benchSource processes all frames of video source src as fast as possible using cfg and times every stage
+// It returns the stage timer, number of processed frames and total processing time.
+func benchSource(src Source, cfg Config) (*stageTimer, int, time.Duration, error) {
+	p, err := newPipeline(src, cfg)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer p.close()
+
+	t := newStageTimer()
+	result := &detector.Result{Source: src.Name}
+	frames := 0
+	begin := time.Now()
+
+	for {
+		start := time.Now()
+		// frameSize may have already read the first frame
+		if p.pending {
+			p.pending = false
+		} else if ok := p.vc.Read(&p.img); !ok {
+			break
+		}
+		if p.img.Empty() {
+			continue
+		}
+		t.record("read", time.Since(start))
+
+		start = time.Now()
+		p.transform()
+		t.record("transform", time.Since(start))
+
+		start = time.Now()
+		var partial bool
+		if roi, ok := p.cfg.Region(&p.img); ok {
+			region := p.img.Region(roi)
+			result.Rect, result.Centroid, partial = detector.DetectBlob(&region, p.cfg.DetectorConfig, nil)
+			region.Close()
+		} else {
+			result.Rect, result.Centroid, partial = detector.DetectBlob(&p.img, p.cfg.DetectorConfig, nil)
+		}
+		t.record("detectBlob", time.Since(start))
+
+		start = time.Now()
+		status := detector.DetectStatus(&result.Rect, partial, p.cfg.DetectorConfig)
+		t.record("detectStatus", time.Since(start))
+
+		start = time.Now()
+		result.Defect, result.Severity, result.Partial = status.Defect, status.Severity, status.Partial
+		result.OrigRect = origRect(result.Rect, p.cfg.Scale)
+		_ = result.ToMQTTMessage()
+		t.record("serialize", time.Since(start))
+
+		frames++
+	}
+
+	return t, frames, time.Since(begin), nil
+}
+
+// bench benchmarks processing of all video sources configured in cfg and prints a report for each of them
+// The report is also written to cfg.BenchCSV if it's set.
+// It returns program exit code.
+func bench(cfg Config) int {
+	// every frame is read exactly once
+	cfg.Loop = false
+
+	var w *csv.Writer
+	if cfg.BenchCSV != "" {
+		f, err := os.Create(cfg.BenchCSV)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create benchmark report: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = csv.NewWriter(f)
+		w.Write([]string{"source", "stage", "count", "total_ms", "mean_ms", "p50_ms", "p95_ms", "p99_ms"})
+	}
+
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+	}
+
+	for _, src := range cfg.Sources() {
+		t, frames, elapsed, err := benchSource(src, cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to benchmark %s: %v\n", src.Name, err)
+			return 1
+		}
+		if frames == 0 {
+			fmt.Fprintf(os.Stderr, "No frames read from %s\n", src.Name)
+			return 1
+		}
+
+		fmt.Printf("\nSource %s: %d frames in %v, %.1f FPS\n", src.Name, frames, elapsed,
+			float64(frames)/elapsed.Seconds())
+		fmt.Printf("%-14s %12s %10s %10s %10s %10s\n", "STAGE", "TOTAL ms", "MEAN ms", "P50 ms", "P95 ms", "P99 ms")
+		for _, stage := range benchStages {
+			total := t.total(stage)
+			mean := total / time.Duration(len(t.durations[stage]))
+			p50, p95, p99 := t.percentile(stage, 0.5), t.percentile(stage, 0.95), t.percentile(stage, 0.99)
+			fmt.Printf("%-14s %12s %10s %10s %10s %10s\n", stage, ms(total), ms(mean), ms(p50), ms(p95), ms(p99))
+			if w != nil {
+				w.Write([]string{src.Name, stage, strconv.Itoa(len(t.durations[stage])),
+					ms(total), ms(mean), ms(p50), ms(p95), ms(p99)})
+			}
+		}
+	}
+
+	if w != nil {
+		w.Flush()
+		if err := w.Error(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write benchmark report: %v\n", err)
+			return 1
+		}
+	}
+
+	return 0
+}
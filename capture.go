@@ -0,0 +1,234 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gocv.io/x/gocv"
+)
+
+// defaultFPS is frame rate assumed for video files which report invalid frame rate
+const defaultFPS = 30.0
+
+// Capture is a source of video frames
+type Capture interface {
+	// Read reads the next frame into img; it returns false if no frame could be read
+	Read(img *gocv.Mat) bool
+	// Get returns the value of the video capture property prop
+	Get(prop gocv.VideoCaptureProperties) float64
+	// Close closes the capture and releases its resources
+	Close() error
+}
+
+// FileCapture is video capture which reads frames from video file
+type FileCapture struct {
+	// vc is underlying video capture
+	vc *gocv.VideoCapture
+	// loop rewinds the video file when it reaches its end
+	loop bool
+}
+
+// NewFileCapture opens video file at path and returns it
+// It fails with error if the video file can't be opened
+func NewFileCapture(path string, loop bool) (*FileCapture, error) {
+	vc, err := gocv.VideoCaptureFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileCapture{
+		vc:   vc,
+		loop: loop,
+	}, nil
+}
+
+// Read reads the next video frame into img
+// If loop is enabled, Read rewinds the video once it reaches its end
+func (c *FileCapture) Read(img *gocv.Mat) bool {
+	if ok := c.vc.Read(img); ok {
+		return true
+	}
+
+	if !c.loop {
+		return false
+	}
+
+	c.vc.Set(gocv.VideoCapturePosFrames, 0)
+	return c.vc.Read(img)
+}
+
+// Get returns the value of the video capture property prop
+func (c *FileCapture) Get(prop gocv.VideoCaptureProperties) float64 {
+	return c.vc.Get(prop)
+}
+
+// Close closes the video file
+func (c *FileCapture) Close() error {
+	return c.vc.Close()
+}
+
+// DirectoryCapture is video capture which reads image sequence from a directory
+type DirectoryCapture struct {
+	// files contains paths to images in lexicographic order
+	files []string
+	// next is index of the next image to read
+	next int
+	// loop restarts the sequence when it reaches its end
+	loop bool
+}
+
+// NewDirectoryCapture creates new video capture from *.jpg and *.png files found in dir and returns it
+// It fails with error if dir can't be read or if it does not contain any images
+func NewDirectoryCapture(dir string, loop bool) (*DirectoryCapture, error) {
+	var files []string
+	for _, pattern := range []string{"*.jpg", "*.png"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no *.jpg or *.png images found in %s", dir)
+	}
+	sort.Strings(files)
+
+	return &DirectoryCapture{
+		files: files,
+		loop:  loop,
+	}, nil
+}
+
+// Read reads the next image of the sequence into img
+// If loop is enabled, Read restarts the sequence once it reaches its end
+func (c *DirectoryCapture) Read(img *gocv.Mat) bool {
+	if c.next >= len(c.files) {
+		if !c.loop {
+			return false
+		}
+		c.next = 0
+	}
+
+	m := gocv.IMRead(c.files[c.next], gocv.IMReadColor)
+	defer m.Close()
+	c.next++
+
+	if m.Empty() {
+		return false
+	}
+	m.CopyTo(img)
+
+	return true
+}
+
+// Get returns the value of the video capture property prop
+// Only frame count and frame position are known; all the other properties are reported as 0
+func (c *DirectoryCapture) Get(prop gocv.VideoCaptureProperties) float64 {
+	switch prop {
+	case gocv.VideoCaptureFrameCount:
+		return float64(len(c.files))
+	case gocv.VideoCapturePosFrames:
+		return float64(c.next)
+	}
+
+	return 0
+}
+
+// Close closes the directory capture
+func (c *DirectoryCapture) Close() error {
+	c.files = nil
+	return nil
+}
+
+// Source is video source configuration
+type Source struct {
+	// Name identifies the source in results and on display
+	Name string
+	// Input is path to image or video file
+	Input string
+	// InputDir is path to directory with image sequence
+	InputDir string
+	// DeviceID is camera device ID
+	DeviceID int
+}
+
+// captureFPS returns frame rate reported by video capture vc of source name
+// Some containers, streams and cameras report no frame rate at all; invalid frame rate is replaced by defaultFPS.
+func captureFPS(vc Capture, name string) float64 {
+	fps := vc.Get(gocv.VideoCaptureFPS)
+	if fps <= 0 || math.IsNaN(fps) || math.IsInf(fps, 0) {
+		fmt.Fprintf(os.Stderr, "Warning: %s reports invalid frame rate %g; assuming %g FPS\n", name, fps, defaultFPS)
+		fps = defaultFPS
+	}
+
+	return fps
+}
+
+// NewCapture creates new video capture for source src and returns it together with video play delay and frame rate.
+// The capture reads image sequence from src.InputDir if it is not empty, video file from src.Input
+// if it is not empty, or camera device src.DeviceID otherwise.
+// If src.Input is not empty, the returned delay matches FPS in the video file; otherwise it's cfg.Delay.
+// The frame rate is reported by the video file or the camera; it's 0, i.e. unknown, for image sequences.
+// Both the delay and the frame rate always match cfg.FPSOverride if it's set.
+// It fails with ErrCaptureOpen if it either can't open the input video file, image directory or the video device
+func NewCapture(src Source, cfg Config) (Capture, float64, float64, error) {
+	var vc Capture
+	var err error
+	switch {
+	case src.InputDir != "":
+		// open image sequence
+		vc, err = NewDirectoryCapture(src.InputDir, cfg.Loop)
+	case src.Input != "":
+		// open video file
+		vc, err = NewFileCapture(src.Input, cfg.Loop)
+	default:
+		// open camera device
+		vc, err = gocv.VideoCaptureDevice(src.DeviceID)
+	}
+	if err != nil {
+		return nil, 0, 0, &ErrCaptureOpen{src.Name, err}
+	}
+
+	switch {
+	case cfg.FPSOverride > 0:
+		return vc, 1000 / cfg.FPSOverride, cfg.FPSOverride, nil
+	case src.InputDir != "":
+		// image sequences have no frame rate; they're played with the configured delay
+		return vc, cfg.Delay, 0, nil
+	}
+
+	fps := captureFPS(vc, src.Name)
+	if src.Input != "" {
+		return vc, 1000 / fps, fps, nil
+	}
+
+	// camera delivers frames at its own pace
+	return vc, cfg.Delay, fps, nil
+}
@@ -0,0 +1,73 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"math"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// fakeCapture is video capture which reports frame rate fps and reads no frames
+type fakeCapture struct {
+	fps float64
+}
+
+func (c *fakeCapture) Read(img *gocv.Mat) bool {
+	return false
+}
+
+func (c *fakeCapture) Get(prop gocv.VideoCaptureProperties) float64 {
+	if prop == gocv.VideoCaptureFPS {
+		return c.fps
+	}
+
+	return 0
+}
+
+func (c *fakeCapture) Close() error {
+	return nil
+}
+
+func TestCaptureFPS(t *testing.T) {
+	tests := []struct {
+		fps  float64
+		want float64
+	}{
+		{25, 25},
+		{29.97, 29.97},
+		// invalid frame rates fall back to the default
+		{0, defaultFPS},
+		{-1, defaultFPS},
+		{math.NaN(), defaultFPS},
+		{math.Inf(1), defaultFPS},
+	}
+
+	for _, tt := range tests {
+		if got := captureFPS(&fakeCapture{tt.fps}, "cam0"); got != tt.want {
+			t.Errorf("captureFPS() of capture reporting %g FPS = %g, want %g", tt.fps, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/synthetic"
+	"gocv.io/x/gocv"
+)
+
+const (
+	// checkFrames is number of frames the self-test grabs and processes from every video source
+	checkFrames = 10
+	// checkTimeout is timeout of the self-test network operations
+	checkTimeout = 5 * time.Second
+	// selfTestFailed is exit code of the program when the -self-test detection fails
+	selfTestFailed = 2
+	// selfTestTolerance is relative error of the area detected in the synthetic frame the self-test accepts
+	selfTestTolerance = 0.05
+)
+
+// selfTestSize is size of the synthetic self-test frame and selfTestRect is the part rectangle drawn in it
+var (
+	selfTestSize = image.Point{640, 480}
+	selfTestRect = image.Rect(240, 180, 400, 300)
+)
+
+// errCheckSkipped is returned by checks which are not applicable to the configuration
+var errCheckSkipped = errors.New("not configured")
+
+// selfCheck is a single self-test check
+type selfCheck struct {
+	// name describes what is checked
+	name string
+	// run performs the check; it returns errCheckSkipped if the check does not apply
+	run func() error
+}
+
+// selfChecks returns self-test checks of configuration cfg
+func selfChecks(cfg Config) []selfCheck {
+	checks := []selfCheck{{name: "synthetic detection", run: func() error { return checkSynthetic(cfg) }}}
+	for _, src := range cfg.Sources() {
+		src := src
+		checks = append(checks, selfCheck{
+			name: fmt.Sprintf("capture %s", src.Name),
+			run:  func() error { return checkCapture(src, cfg) },
+		})
+	}
+
+	checks = append(checks,
+		selfCheck{name: "MQTT TLS handshake", run: func() error { return checkTLS(cfg.MQTT) }},
+		selfCheck{name: "MQTT publish", run: func() error { return checkMQTT(cfg.MQTT, topic+"/selftest") }},
+	)
+
+	return checks
+}
+
+// checkCapture opens video source src and detects parts in checkFrames of its frames using cfg
+// It returns error if the source can't be opened or if it does not produce enough frames.
+func checkCapture(src Source, cfg Config) error {
+	p, err := newPipeline(src, cfg)
+	if err != nil {
+		return err
+	}
+	defer p.close()
+
+	for i := 0; i < checkFrames; i++ {
+		if ok := p.read(); !ok {
+			return fmt.Errorf("read %d of %d frames", i, checkFrames)
+		}
+		rect, _, partial := detector.DetectBlob(&p.img, p.cfg.DetectorConfig, nil)
+		detector.DetectStatus(&rect, partial, p.cfg.DetectorConfig)
+	}
+
+	return nil
+}
+
+// checkSynthetic detects part in a synthetic frame, a white rectangle on black background, using detection options in cfg
+// The colors are swapped if the threshold is inverted. Region of interest, zones and mask don't apply to the synthetic frame.
+// It returns error if the detection panics or if the detected area differs from the drawn one by more than selfTestTolerance.
+func checkSynthetic(cfg Config) (err error) {
+	img := synthetic.GenerateFrame(selfTestSize.X, selfTestSize.Y, selfTestRect, 0)
+	defer img.Close()
+	// inverted detection looks for dark parts on bright belt
+	if cfg.Invert {
+		gocv.BitwiseNot(*img, img)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("detection panicked: %v", r)
+		}
+	}()
+
+	dc := cfg.DetectorConfig
+	dc.UseROI, dc.Zones, dc.Mask = false, nil, nil
+	rect, _, partial := detector.DetectBlob(img, dc, nil)
+	switch {
+	case rect.Empty():
+		return fmt.Errorf("no part detected in %v", selfTestRect)
+	case partial:
+		return fmt.Errorf("part %v detected as partial", rect)
+	}
+
+	want, got := detector.Area(selfTestRect), detector.Area(rect)
+	if diff := float64(got-want) / float64(want); diff > selfTestTolerance || diff < -selfTestTolerance {
+		return fmt.Errorf("detected area %d differs from expected %d by %.1f%%", got, want, 100*diff)
+	}
+
+	return nil
+}
+
+// checkTLS performs TLS handshake with MQTT server configured in cfg
+// It returns errCheckSkipped if TLS is not configured.
+func checkTLS(cfg MQTTConfig) error {
+	if cfg.Server == "" || !cfg.TLSEnabled() {
+		return errCheckSkipped
+	}
+
+	tlsConfig, err := MQTTNewTLSConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(cfg.Server)
+	if err != nil {
+		return fmt.Errorf("invalid MQTT server: %v", err)
+	}
+
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: checkTimeout}, "tcp", u.Host, tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// checkMQTT connects to MQTT server configured in cfg and publishes a test message to topic
+// It returns errCheckSkipped if MQTT server is not configured.
+func checkMQTT(cfg MQTTConfig, topic string) error {
+	if cfg.Server == "" {
+		return errCheckSkipped
+	}
+
+	c, err := NewMQTTPublisher(cfg)
+	if err != nil {
+		return err
+	}
+	defer c.Disconnect(100)
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	return c.PublishContext(ctx, topic, `{"Status":"selftest"}`)
+}
+
+// check runs the self-test of configuration parsed from command line arguments args
+// It prints the result of every check and returns non-zero exit code if any of them failed.
+func check(args []string) int {
+	fs := flag.NewFlagSet(name+" check", flag.ExitOnError)
+	cfg, err := LoadConfig(fs, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %v\n", err)
+		return 1
+	}
+
+	code := 0
+	results := make([]string, 0)
+	for _, c := range selfChecks(cfg) {
+		err := c.run()
+		switch {
+		case err == errCheckSkipped:
+			results = append(results, fmt.Sprintf("%-30s SKIP  %v", c.name, err))
+		case err != nil:
+			results = append(results, fmt.Sprintf("%-30s FAIL  %v", c.name, err))
+			code = 1
+		default:
+			results = append(results, fmt.Sprintf("%-30s PASS", c.name))
+		}
+	}
+
+	// checks print their own progress so the table is printed once all of them finish
+	fmt.Printf("\n%-30s %s\n", "CHECK", "RESULT")
+	for _, r := range results {
+		fmt.Println(r)
+	}
+
+	return code
+}
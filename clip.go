@@ -0,0 +1,286 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+	"gocv.io/x/gocv"
+)
+
+const (
+	// clipQueueSize is number of clips waiting to be written before new clips are skipped
+	clipQueueSize = 4
+	// clipCodec is FourCC code of the defect clip video codec
+	clipCodec = "MJPG"
+	// clipMaxFrames bounds number of frames buffered for a single clip regardless of the frame rate
+	clipMaxFrames = 1000
+)
+
+// clipFrame is a raw frame buffered for defect clips
+type clipFrame struct {
+	// img is downscaled raw frame
+	img gocv.Mat
+	// time is time when the frame was captured
+	time time.Time
+}
+
+// clip is a defect clip collecting the frames around the defect
+type clip struct {
+	// name is file name of the clip
+	name string
+	// frames are the clip frames; they're owned by the clip
+	frames []clipFrame
+	// end is time after which no more frames are added to the clip
+	end time.Time
+}
+
+// close closes all the clip frames
+func (c *clip) close() {
+	for _, f := range c.frames {
+		f.img.Close()
+	}
+}
+
+// ClipBuffer keeps the raw frames of the last seconds of a single video source and cuts defect clips from them
+// The frames are downscaled and only every skip-th frame is kept to bound the memory usage.
+// Every clip owns copies of its frames, so overlapping clips never share or corrupt frames.
+type ClipBuffer struct {
+	// pre is duration of the clip before the defect
+	pre time.Duration
+	// post is duration of the clip after the defect
+	post time.Duration
+	// scale is scale factor of the buffered frames
+	scale float64
+	// skip is number of frames out of which one is buffered
+	skip int
+	// frameNum is number of frames added to the buffer
+	frameNum int
+	// frames are the buffered frames from the oldest to the newest
+	frames []clipFrame
+	// pending are clips still collecting frames after their defect
+	pending []*clip
+	// events are event IDs of the defects clips were cut for, by zone
+	events map[string]string
+}
+
+// NewClipBuffer creates new buffer which cuts clips spanning pre before and post after the defects
+// from every skip-th frame scaled by scale and returns it
+func NewClipBuffer(pre, post time.Duration, scale float64, skip int) *ClipBuffer {
+	return &ClipBuffer{pre: pre, post: post, scale: scale, skip: skip, events: make(map[string]string)}
+}
+
+// Add buffers a downscaled copy of raw frame img and adds it to the pending clips
+// Clips which are complete are handed over to recorder r.
+func (b *ClipBuffer) Add(img gocv.Mat, r *ClipRecorder) {
+	b.frameNum++
+	if (b.frameNum-1)%b.skip != 0 {
+		return
+	}
+
+	now := time.Now()
+	f := clipFrame{img: gocv.NewMat(), time: now}
+	size := image.Point{int(float64(img.Cols()) * b.scale), int(float64(img.Rows()) * b.scale)}
+	gocv.Resize(img, &f.img, size, 0, 0, gocv.InterpolationLinear)
+
+	// finished clips are written; the others get their own copy of the frame
+	pending := b.pending[:0]
+	for _, c := range b.pending {
+		if now.After(c.end) || len(c.frames) >= clipMaxFrames {
+			r.Write(c)
+			continue
+		}
+		c.frames = append(c.frames, clipFrame{img: f.img.Clone(), time: now})
+		pending = append(pending, c)
+	}
+	b.pending = pending
+
+	b.frames = append(b.frames, f)
+	for len(b.frames) > 0 && (now.Sub(b.frames[0].time) > b.pre || len(b.frames) > clipMaxFrames) {
+		b.frames[0].img.Close()
+		b.frames = b.frames[1:]
+	}
+}
+
+// Cut starts a new clip for every defect of result which has not been clipped yet
+// The clip starts with copies of the buffered frames and collects the frames added until post after now.
+func (b *ClipBuffer) Cut(result *detector.Result) {
+	results := []detector.Result{*result}
+	if len(result.Zones) > 0 {
+		results = result.Zones
+	}
+
+	for _, r := range results {
+		if r.EventID == "" || b.events[r.Zone] == r.EventID {
+			continue
+		}
+		b.events[r.Zone] = r.EventID
+
+		name := r.Source
+		if r.Zone != "" {
+			name += "-" + r.Zone
+		}
+		c := &clip{
+			name: fmt.Sprintf("%s-%d-%s.avi", name, r.Seq, r.EventID),
+			end:  time.Now().Add(b.post),
+		}
+		for _, f := range b.frames {
+			c.frames = append(c.frames, clipFrame{img: f.img.Clone(), time: f.time})
+		}
+		b.pending = append(b.pending, c)
+	}
+}
+
+// Close closes the buffered frames and drops the pending clips
+func (b *ClipBuffer) Close() {
+	for _, f := range b.frames {
+		f.img.Close()
+	}
+	b.frames = nil
+	for _, c := range b.pending {
+		c.close()
+	}
+	b.pending = nil
+}
+
+// ClipRecorder writes defect clips into video files in a worker goroutine
+type ClipRecorder struct {
+	// dir is directory the clips are written to
+	dir string
+	// maxBytes is disk usage of dir above which no more clips are written
+	maxBytes int64
+	// size is current disk usage of dir
+	size int64
+	// queue contains clips waiting to be written
+	queue chan *clip
+	// skipped counts clips which were not written
+	skipped uint64
+}
+
+// NewClipRecorder creates new clip recorder which writes clips to directory dir until it exceeds maxMB megabytes.
+// It returns error if the directory can't be created or its disk usage can't be determined.
+func NewClipRecorder(dir string, maxMB int) (*ClipRecorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create clip directory: %v", err)
+	}
+
+	// clips written by previous runs count towards the disk usage
+	size, err := dirSize(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read clip directory: %v", err)
+	}
+
+	return &ClipRecorder{
+		dir:      dir,
+		maxBytes: int64(maxMB) * 1024 * 1024,
+		size:     size,
+		queue:    make(chan *clip, clipQueueSize),
+	}, nil
+}
+
+// Write queues clip c to be written; the clip is skipped if too many clips are already waiting
+func (r *ClipRecorder) Write(c *clip) {
+	select {
+	case r.queue <- c:
+	default:
+		c.close()
+		atomic.AddUint64(&r.skipped, 1)
+	}
+}
+
+// Skipped returns number of clips which were not written
+func (r *ClipRecorder) Skipped() uint64 {
+	return atomic.LoadUint64(&r.skipped)
+}
+
+// Run writes queued clips until it receives a signal on doneChan
+// doneChan is used to receive a signal from the main goroutine to notify the routine to stop and return
+func (r *ClipRecorder) Run(doneChan <-chan struct{}) error {
+	for {
+		select {
+		case c := <-r.queue:
+			if err := r.save(c); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write defect clip %s: %v\n", c.name, err)
+				atomic.AddUint64(&r.skipped, 1)
+			}
+			c.close()
+		case <-doneChan:
+			fmt.Printf("Stopping clip recorder: received stop signal\n")
+			for {
+				select {
+				case c := <-r.queue:
+					c.close()
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// save writes clip c into a video file in the clip directory unless it's full
+// The frame rate of the file is measured from the timestamps of the clip frames.
+func (r *ClipRecorder) save(c *clip) error {
+	if r.size >= r.maxBytes {
+		return fmt.Errorf("clip directory exceeds %d MB", r.maxBytes/1024/1024)
+	}
+	if len(c.frames) == 0 {
+		return fmt.Errorf("no frames buffered")
+	}
+
+	fps := defaultFPS
+	if d := c.frames[len(c.frames)-1].time.Sub(c.frames[0].time); len(c.frames) > 1 && d > 0 {
+		fps = float64(len(c.frames)-1) / d.Seconds()
+	}
+
+	path := filepath.Join(r.dir, c.name)
+	vw, err := gocv.VideoWriterFile(path, clipCodec, fps, c.frames[0].img.Cols(), c.frames[0].img.Rows(), true)
+	if err != nil {
+		return err
+	}
+	for _, f := range c.frames {
+		if err := vw.Write(f.img); err != nil {
+			vw.Close()
+			return err
+		}
+	}
+	if err := vw.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote defect clip %s\n", path)
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	r.size += info.Size()
+
+	return nil
+}
@@ -0,0 +1,225 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// osd-replay replays per-frame detection results logged in JSON Lines format
+// through the part defect detection using different thresholds and prints corrected totals.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Event is a single frame detection result in the format published by the monitor
+type Event struct {
+	// Source is name of the video source the result belongs to
+	Source string
+	// Defect is set if the part has a confirmed defect
+	Defect bool
+	// Severity is severity of the result: ok, warn or defect
+	Severity string
+	// Partial is set if the part is not fully in view
+	Partial bool
+	// Rect is detected part rectangle in original frame coordinates
+	Rect [4]int
+	// DefectRate is rolling defect rate of the recent parts
+	DefectRate float64
+	// PublishRate is number of messages published per second
+	PublishRate float64
+	// TotalParts contains total number of detected parts; it's only written by the replay
+	TotalParts int `json:",omitempty"`
+	// TotalDefects contains total number of defected parts; it's only written by the replay
+	TotalDefects int `json:",omitempty"`
+}
+
+// area returns area of the event rectangle
+func (e *Event) area() int {
+	return (e.Rect[2] - e.Rect[0]) * (e.Rect[3] - e.Rect[1])
+}
+
+// part tracks the part currently in view of a single video source
+type part struct {
+	// defectFrames counts frames the part was measured out of range
+	defectFrames int
+	// okFrames counts frames the part was measured within range
+	okFrames int
+	// counted is set once the part has been counted
+	counted bool
+	// defect is set once the part defect has been confirmed
+	defect bool
+	// totalParts contains total number of detected parts
+	totalParts int
+	// totalDefects contains total number of defected parts
+	totalDefects int
+}
+
+// replayer replays events through part defect detection
+type replayer struct {
+	// min is minimum part area
+	min int
+	// max is maximum part area
+	max int
+	// defectFrames is number of consecutive frames a part has to be out of range to be marked as a defect
+	defectFrames int
+	// okFrames is number of consecutive frames a part has to be within range to reset its defect frames
+	okFrames int
+	// parts tracks parts of every video source
+	parts map[string]*part
+	// sources lists video sources in the order they were first seen
+	sources []string
+}
+
+// replay updates the part of event e source and rewrites e with the corrected results
+func (r *replayer) replay(e *Event) {
+	p, ok := r.parts[e.Source]
+	if !ok {
+		p = new(part)
+		r.parts[e.Source] = p
+		r.sources = append(r.sources, e.Source)
+	}
+
+	area := e.area()
+	defect := area > r.max || area < r.min
+
+	if area <= 0 {
+		// no part detected -- empty belt: reset counts
+		p.defect, p.counted = false, false
+		p.okFrames, p.defectFrames = 0, 0
+	} else if !e.Partial {
+		if defect {
+			p.defectFrames++
+		} else {
+			p.okFrames++
+		}
+
+		if p.counted {
+			if !defect && p.okFrames > r.okFrames {
+				p.defectFrames = 0
+			}
+			if defect && p.defectFrames > r.defectFrames {
+				if !p.defect {
+					p.defect = true
+					p.totalDefects++
+				}
+				p.okFrames = 0
+			}
+		} else {
+			p.totalParts++
+			p.counted = true
+		}
+	}
+
+	// confirmed defect trumps the frame measurement; unconfirmed defect is only a warning
+	switch {
+	case p.defect:
+		e.Severity = "defect"
+	case area > 0 && !e.Partial && defect:
+		e.Severity = "warn"
+	default:
+		e.Severity = "ok"
+	}
+	e.Defect = p.defect
+	e.TotalParts, e.TotalDefects = p.totalParts, p.totalDefects
+}
+
+func main() {
+	input := flag.String("input", "", "Path to JSON Lines file with per-frame detection results")
+	output := flag.String("output", "", "Path to JSON Lines file the corrected results are written to")
+	min := flag.Int("min", 20000, "Minimum part area of assembly object")
+	max := flag.Int("max", 30000, "Maximum part area of assembly object")
+	defectFrames := flag.Int("defect-frames", 10, "Number of consecutive frames a part has to be out of range to be marked as a defect")
+	okFrames := flag.Int("ok-frames", 10, "Number of consecutive frames a part has to be within range to reset its defect count")
+	flag.Parse()
+
+	if *input == "" {
+		fmt.Fprintf(os.Stderr, "Missing -input\n")
+		os.Exit(1)
+	}
+
+	if *min > *max {
+		fmt.Fprintf(os.Stderr, "Minimum area %d exceeds maximum area %d\n", *min, *max)
+		os.Exit(1)
+	}
+
+	f, err := os.Open(*input)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open input: %v\n", err)
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	var out io.Writer
+	if *output != "" {
+		of, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create output: %v\n", err)
+			os.Exit(1)
+		}
+		defer of.Close()
+		bw := bufio.NewWriter(of)
+		defer bw.Flush()
+		out = bw
+	}
+
+	r := &replayer{
+		min:          *min,
+		max:          *max,
+		defectFrames: *defectFrames,
+		okFrames:     *okFrames,
+		parts:        make(map[string]*part),
+	}
+
+	dec := json.NewDecoder(f)
+	var enc *json.Encoder
+	if out != nil {
+		enc = json.NewEncoder(out)
+	}
+
+	for n := 1; ; n++ {
+		var e Event
+		if err := dec.Decode(&e); err == io.EOF {
+			break
+		} else if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read event %d: %v\n", n, err)
+			os.Exit(1)
+		}
+
+		r.replay(&e)
+
+		if enc != nil {
+			if err := enc.Encode(&e); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write event %d: %v\n", n, err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	for _, source := range r.sources {
+		p := r.parts[source]
+		fmt.Printf("Source %s: Total parts: %d, Total defects: %d\n", source, p.totalParts, p.totalDefects)
+	}
+}
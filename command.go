@@ -0,0 +1,170 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+const (
+	// commandsTopic is MQTT topic operator commands are received on
+	commandsTopic = "defects/commands"
+	// commandsAckTopic is MQTT topic the results of the operator commands are published to
+	commandsAckTopic = "defects/commands/ack"
+	// CommandResetCounters resets the part and defect totals, the defect rate and the part history
+	CommandResetCounters = "reset_counters"
+	// CommandProfile switches the detector settings to the named profile
+	CommandProfile = "profile"
+	// CommandTrigger detects part in a single frame in trigger mode
+	CommandTrigger = "trigger"
+)
+
+// Command is operator command, e.g. {"action":"reset_counters","reason":"new product run"}
+// or {"cmd":"profile","name":"bracket-B"}
+type Command struct {
+	// Action is the commanded action
+	Action string `json:"action"`
+	// Cmd is the commanded action if Action is empty
+	Cmd string `json:"cmd"`
+	// Name is name of the profile switched to by profile command
+	Name string `json:"name"`
+	// Reason is operator-supplied reason of the command; it's included in the acknowledgement
+	Reason string `json:"reason"`
+}
+
+// Commander executes operator commands received over MQTT and HTTP
+type Commander struct {
+	// cmdChans deliver commands to frameRunners of the video sources
+	cmdChans []chan<- Command
+	// history is the part history cleared by counter resets
+	history *PartHistory
+	// control switches the profiles; it's nil if the configuration can't be changed at runtime
+	control *ConfigHandler
+	// msgChan is used to publish the acknowledgements; it can be nil if publishing is disabled
+	msgChan chan<- mqttMessage
+}
+
+// NewCommander creates new commander which delivers commands to frameRunners over cmdChans, clears history,
+// switches profiles with control and acknowledges the commands to msgChan; control can be nil if the configuration
+// can't be changed at runtime and msgChan can be nil if publishing is disabled
+func NewCommander(cmdChans []chan<- Command, history *PartHistory, control *ConfigHandler, msgChan chan<- mqttMessage) *Commander {
+	return &Commander{cmdChans: cmdChans, history: history, control: control, msgChan: msgChan}
+}
+
+// Execute executes command cmd and publishes its acknowledgement
+// It returns error if the action is unknown.
+func (c *Commander) Execute(cmd Command) error {
+	if cmd.Action == "" {
+		cmd.Action = cmd.Cmd
+	}
+
+	var err error
+	switch cmd.Action {
+	case CommandResetCounters:
+		// frameRunners reset their counters in between frames, so no part is counted half way through the reset;
+		// a reset which is still pending already covers this one
+		for _, ch := range c.cmdChans {
+			select {
+			case ch <- cmd:
+			default:
+			}
+		}
+		c.history.Reset()
+		fmt.Printf("Resetting counters: %q\n", cmd.Reason)
+	case CommandProfile:
+		if c.control == nil {
+			err = fmt.Errorf("no profiles are configured")
+			break
+		}
+		err = c.control.SwitchProfile(cmd.Name)
+	default:
+		err = fmt.Errorf("unknown action %q", cmd.Action)
+	}
+
+	ack := fmt.Sprintf("{\"Action\":%q,\"Name\":%q,\"Reason\":%q,\"Timestamp\":%q,\"OK\":%v,\"Error\":%q}",
+		cmd.Action, cmd.Name, cmd.Reason, time.Now().UTC().Format(time.RFC3339Nano), err == nil, errString(err))
+	select {
+	case c.msgChan <- mqttMessage{commandsAckTopic, ack}:
+	default:
+	}
+
+	return err
+}
+
+// errString returns message of err; it's empty if err is nil
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
+
+// handleMessage implements MQTT.MessageHandler; it executes command received in msg
+func (c *Commander) handleMessage(client MQTT.Client, msg MQTT.Message) {
+	var cmd Command
+	if err := json.Unmarshal(msg.Payload(), &cmd); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid command received on %s: %v\n", msg.Topic(), err)
+		return
+	}
+
+	if err := c.Execute(cmd); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to execute command received on %s: %v\n", msg.Topic(), err)
+	}
+}
+
+// serveResetCounters resets the counters on POST requests; the reason is read from reason query parameter
+func (c *Commander) serveResetCounters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c.Execute(Command{Action: CommandResetCounters, Reason: r.URL.Query().Get("reason")})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// subscriber subscribes to MQTT topics
+type subscriber interface {
+	// Subscribe subscribes to topic with qos; messages are handled by handler
+	Subscribe(topic string, qos byte, handler MQTT.MessageHandler) error
+}
+
+// subscribeCommands subscribes commander to the commands topic of publisher p if it can subscribe to topics
+func subscribeCommands(p Publisher, commander *Commander) {
+	s, ok := p.(subscriber)
+	if !ok {
+		return
+	}
+
+	if err := s.Subscribe(commandsTopic, QOS, commander.handleMessage); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to subscribe to %s: %v\n", commandsTopic, err)
+	}
+}
@@ -0,0 +1,895 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DetectorConfig configures part detection
+// It's embedded in Config, so the detector settings are promoted to the program configuration.
+type DetectorConfig = detector.Config
+
+// Config is program configuration populated from command line flags, configuration file and environment variables
+type Config struct {
+	// ConfigFile is path to YAML configuration file
+	ConfigFile string
+	// ConfigBackend is remote store detector settings are read from: env, consul or etcd
+	ConfigBackend string
+	// ConfigPrint prints the resolved detector and MQTT settings as JSON and exits
+	ConfigPrint bool
+	// sources maps the detector and MQTT settings, e.g. detector.min, to sources of their values;
+	// settings which are not listed have default values
+	sources map[string]string
+	// DetectorConfig configures part detection
+	DetectorConfig
+	// Profiles are named detector configurations of the products run on the line; they're read from the config file
+	Profiles map[string]DetectorConfig
+	// ProfileResetCounters resets the part and defect totals when the profile is switched
+	ProfileResetCounters bool
+	// profileSpecs are profile settings read from the config file; they're applied over the detector settings
+	profileSpecs map[string]yaml.MapSlice
+	// MQTT configures MQTT client used to publish analytics
+	MQTT MQTTConfig
+	// Devices contains camera device IDs
+	Devices intList
+	// Inputs contains paths to image or video files
+	Inputs stringList
+	// InputDirs contains paths to directories with image sequences
+	InputDirs stringList
+	// Overlay configures rendering of detection results over displayed frames
+	Overlay OverlayConfig
+	// Layout controls how multiple sources are displayed: windows or grid
+	Layout string
+	// Headless disables the display windows
+	Headless bool
+	// SelectROI lets the user select region of interest in the first frame before the detection starts
+	SelectROI bool
+	// SaveROI writes the selected region of interest to ConfigFile
+	SaveROI bool
+	// SelectMask is path the mask drawn by the user in the first frame is saved to; the mask is not drawn if empty
+	SelectMask string
+	// Loop restarts file and directory input when it reaches its end
+	Loop bool
+	// FramesBuf is buffer size of the channel frames are sent for detection through
+	FramesBuf int
+	// ResultsBuf is buffer size of the channel detection results are displayed from
+	ResultsBuf int
+	// PubBuf is per-source buffer size of the channel results are published from
+	PubBuf int
+	// Publish is a flag which instructs the program to publish data analytics
+	Publish bool
+	// MQTTEncoding is encoding of published analytics: json or proto
+	MQTTEncoding string
+	// Sink is where the analytics are published to: mqtt or stdout
+	Sink string
+	// EventsOnly streams only results of the confirmed parts instead of every processed frame to stdout sink
+	EventsOnly bool
+	// FailOnDefect makes the program exit with non-zero code if any defect was confirmed
+	FailOnDefect bool
+	// AlertQoS is MQTT QoS level alarms, such as confirmed defects and belt jams, are published with
+	AlertQoS int
+	// AnalyticsQoS is MQTT QoS level the periodic analytics are published with
+	AnalyticsQoS int
+	// Rate is number of seconds between analytics are collected and sent to a remote server
+	Rate int
+	// PublishMode controls when analytics are published: interval or on-change
+	PublishMode string
+	// Heartbeat is number of seconds after which unchanged analytics are published in on-change mode;
+	// the publish statistics are published at the same interval
+	Heartbeat int
+	// FastRateThreshold is rolling defect rate above which analytics are published every FastRateInterval
+	FastRateThreshold float64
+	// FastRateHysteresis is rolling defect rate below which analytics are published every Rate seconds again
+	FastRateHysteresis float64
+	// FastRateInterval is number of milliseconds between analytics are sent when defect rate is high
+	FastRateInterval int
+	// PublishTimeout is number of milliseconds to wait for analytics publish to finish
+	PublishTimeout int
+	// MQTTDisconnect is number of milliseconds to wait for pending messages when disconnecting from MQTT server
+	MQTTDisconnect uint
+	// SpoolDir is directory messages which failed to publish are spooled to; spooling is disabled if empty
+	SpoolDir string
+	// SpoolSize is maximum number of spooled messages
+	SpoolSize int
+	// AlarmWebhook is URL defect alarm events are POSTed to
+	AlarmWebhook string
+	// AlarmCmd is command which receives defect alarm events on its stdin
+	AlarmCmd string
+	// AlarmCooldown is number of seconds after an alarm during which no new alarm is fired for the same part
+	AlarmCooldown int
+	// MaxDwell is number of seconds a part can stay in view before it's reported as stuck; disabled if zero
+	MaxDwell float64
+	// MinDwellMs is number of milliseconds below which a part leaving the view is a defect; disabled if zero
+	MinDwellMs int
+	// MaxDwellMs is number of milliseconds above which a part leaving the view is a defect; disabled if zero
+	MaxDwellMs int
+	// PresenceTimeout is number of seconds without any part after which the belt is considered jammed
+	PresenceTimeout int
+	// SceneCheckEvery is number of frames between the camera blocked and line stalled checks; disabled if zero
+	SceneCheckEvery int
+	// MinBrightness is mean gray level of the frame below which the camera is considered blocked
+	MinBrightness float64
+	// StaticDiff is mean gray level difference of the checked frames below which the frame is considered static
+	StaticDiff float64
+	// ExpectedPPM is expected number of parts per minute; the line is not checked for stalls if zero
+	ExpectedPPM float64
+	// ReportAt is comma-separated list of @hourly and times of day in HH:MM format aggregate reports are published at;
+	// the reports are disabled if it's empty
+	ReportAt string
+	// Reports is parsed ReportAt
+	Reports ReportSchedule
+	// FrameValidation enables skipping of corrupt frames: on or off
+	FrameValidation string
+	// CorruptFlatRows is fraction of rows without texture above which the frame is corrupt
+	CorruptFlatRows float64
+	// CorruptHistJump is distance of the frame histogram from the recent frames above which the frame is corrupt
+	CorruptHistJump float64
+	// BeepCmd is command which plays audible alert when a defect is confirmed
+	BeepCmd string
+	// FrameTimeout is number of milliseconds within which a frame must be processed
+	FrameTimeout int
+	// StaleResult is number of milliseconds after which the displayed detection result is faded as stale; disabled if 0
+	StaleResult int
+	// FrameTimeoutCount is number of consecutive frame timeouts after which the program stops
+	FrameTimeoutCount int
+	// Single detects part in a single image, prints the result and exits
+	Single bool
+	// TriggerMode detects part in a single frame grabbed from the warm capture on every trigger instead of every frame
+	TriggerMode bool
+	// TriggerImage publishes the frame grabbed on every trigger as JPEG
+	TriggerImage bool
+	// SelfTest detects part in a synthetic frame at startup and exits if the detection does not work
+	SelfTest bool
+	// Bench processes the sources as fast as possible without display and prints per-stage timing report
+	Bench bool
+	// BenchCSV is path to CSV file the benchmark report is written to
+	BenchCSV string
+	// OutVideo is path of the video file annotated frames are recorded to; recording is disabled if empty
+	OutVideo string
+	// OutCodec is FourCC code of the recorded video codec
+	OutCodec string
+	// OutVideoMaxFrames is number of frames after which a new video file is started; unlimited if zero
+	OutVideoMaxFrames int
+	// ExportFramesDir is directory unannotated frames are exported to; export is disabled if empty
+	ExportFramesDir string
+	// ExportFramesFPS is number of frames exported per second
+	ExportFramesFPS float64
+	// ExportFramesMaxMB is size of ExportFramesDir in megabytes above which no more frames are exported
+	ExportFramesMaxMB int
+	// ClipDir is directory raw defect clips are written to; clips are disabled if empty
+	ClipDir string
+	// ClipPre is number of seconds a defect clip spans before the defect
+	ClipPre float64
+	// ClipPost is number of seconds a defect clip spans after the defect
+	ClipPost float64
+	// ClipScale is scale factor of the defect clip frames
+	ClipScale float64
+	// ClipSkip is number of frames out of which one is kept in the defect clips
+	ClipSkip int
+	// ClipMaxMB is size of ClipDir in megabytes above which no more clips are written
+	ClipMaxMB int
+	// DebugFramesDir is directory thresholded and original frames are dumped to
+	DebugFramesDir string
+	// DebugEvery is number of frames between debug frame dumps; dumps are disabled if zero
+	DebugEvery int
+	// DebugAnnotate draws detected part over dumped thresholded frames
+	DebugAnnotate bool
+	// HTTPAddr is address the HTTP server listens on; it's disabled if empty
+	HTTPAddr string
+	// HTTPToken is bearer token which authorizes configuration updates over HTTP; updates are disabled if empty
+	HTTPToken string
+	// SnapshotWidth is width snapshots served over HTTP are resized to; they're not resized if 0
+	SnapshotWidth int
+	// SnapshotQuality is JPEG quality of snapshots served over HTTP
+	SnapshotQuality int
+	// StateFile is path to the file result sequence numbers are persisted to across restarts
+	StateFile string
+	// HistorySize is number of the most recent parts kept in the part history
+	HistorySize int
+	// MaxDefectRate is rolling defect rate above which the program stops; it never stops if it's 1
+	MaxDefectRate float64
+	// DefectRateWindow is number of the most recent parts the rolling defect rate is checked over
+	DefectRateWindow int
+	// HistogramBucket is width of the part area histogram buckets
+	HistogramBucket int
+	// Schedule is path to JSON file with shift schedule of part area ranges; thresholds are not scheduled if empty
+	Schedule string
+	// Shifts is shift schedule loaded from Schedule
+	Shifts Schedule
+	// OTel enables export of per-frame pipeline traces over OTLP
+	OTel bool
+	// OTelSample is fraction of frames which are traced
+	OTelSample float64
+	// OTelEndpoint is URL of the OTLP/HTTP traces endpoint
+	OTelEndpoint string
+	// Delay is video play delay
+	Delay float64
+	// FPSOverride replaces frame rate reported by the video source; it's disabled if 0
+	FPSOverride float64
+	// MaskFile is path to binary mask image excluding fixed structures from detection
+	MaskFile string
+	// MaskDevice is camera device ID capturing foreground mask which replaces frame preprocessing; disabled if -1
+	MaskDevice int
+	// Realtime paces playback of video files to the capture timestamps of their frames
+	Realtime bool
+	// Speed is playback speed multiplier of Realtime playback
+	Speed float64
+	// ProcWidth is width of the frame used for detection; height is computed to preserve aspect ratio
+	ProcWidth int
+	// CalibRes is frame resolution min and max were calibrated at
+	CalibRes string
+	// Calib is parsed CalibRes; it's zero if CalibRes is empty
+	Calib image.Point
+	// PerspectivePoints are corners of the belt in the camera frame: top-left, top-right, bottom-right and bottom-left
+	PerspectivePoints string
+	// Perspective is parsed PerspectivePoints; it's nil if PerspectivePoints is empty
+	Perspective []image.Point
+	// WarpWidth is width of the top-down view of the belt; it defaults to the camera frame width
+	WarpWidth int
+	// WarpHeight is height of the top-down view of the belt; it defaults to the camera frame height
+	WarpHeight int
+	// Scale is ratio between processing frame and original frame dimensions
+	Scale float64
+	// FPS is frame rate of the video source; it's 0 if the frame rate is unknown, i.e. for image sequences
+	FPS float64
+}
+
+// fileConfig is the layout of the YAML configuration file
+type fileConfig struct {
+	Detector *DetectorConfig `yaml:"detector"`
+	Overlay  *OverlayConfig  `yaml:"overlay"`
+	MQTT     *MQTTConfig     `yaml:"mqtt"`
+	// Profiles are kept as raw settings, so they can be applied over the detector settings
+	Profiles map[string]yaml.MapSlice `yaml:"profiles"`
+}
+
+// envVars describes environment variables read by the program
+var envVars = []struct {
+	name string
+	desc string
+}{
+	{"CAM_DEVICE_ID", "Camera device ID; used when neither -device nor -input is set"},
+	{"INPUT_FILE", "Path to image or video file; used when neither -device nor -input is set"},
+	{"MQTT_SERVER", "URI address of MQTT server; required by -publish"},
+	{"MQTT_CLIENT_ID", "MQTT client ID; required by -publish"},
+	{"MQTT_USERNAME", "MQTT username"},
+	{"MQTT_PASSWORD", "MQTT password for MQTT_USERNAME"},
+	{"MQTT_CERT", "SSL certificate"},
+	{"MQTT_CERT_KEY", "SSL certificate private key"},
+	{"MQTT_CA_FILE", "Path to SSL CA root certificate"},
+	{"MQTT_CA_ROOT", "Path to SSL CA root certificate; used when MQTT_CA_FILE is not set"},
+	{"MQTT_TLS_SKIP_VERIFY", "Skip SSL TLS verification when not empty"},
+	{"MQTT_TLS_MIN_VERSION", "Minimum accepted TLS version: 1.2 (default) or 1.3"},
+	{"MQTT_TLS_SERVER_NAME", "Name the server certificate is verified against; defaults to the server host name"},
+	{"MQTT_PROTOCOL_VERSION", "MQTT protocol version: 3 for MQTT 3.1 or 4 for MQTT 3.1.1; negotiated if not set"},
+	{"MQTT_STORE_DIR", "Directory unacknowledged messages are persisted to; kept in memory if not set"},
+	{"HTTP_API_TOKEN", "Bearer token which authorizes PUT /config requests; the updates are disabled if not set"},
+	{"OTEL_EXPORTER_OTLP_ENDPOINT", "Base URL of the OTLP/HTTP collector -otel exports traces to; defaults to " + defaultOTLPEndpoint},
+	{"OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "URL traces are exported to; overrides OTEL_EXPORTER_OTLP_ENDPOINT"},
+	{"OTEL_SERVICE_NAME", "Service name traces are reported under; defaults to the program name"},
+	{"CONSUL_ADDR", "Consul HTTP API address; required by -config-backend=consul"},
+	{"CONSUL_PREFIX", "Consul key prefix of the detector settings; required by -config-backend=consul"},
+	{"ETCD_ADDR", "etcd HTTP API address; required by -config-backend=etcd"},
+	{"ETCD_PREFIX", "etcd key prefix of the detector settings; required by -config-backend=etcd"},
+}
+
+// usage prints program usage including the environment variables it reads
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [run|check|bench] [flags]\n", os.Args[0])
+	fmt.Fprintf(flag.CommandLine.Output(), "\nCommands:\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  run\n    \tDetect parts in the configured video sources (default)\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  check\n    \tValidate the configured video sources and MQTT connection\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  bench\n    \tMeasure MQTT publish throughput and latency of the configured MQTT server\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "\nFlags:\n")
+	flag.PrintDefaults()
+	fmt.Fprintf(flag.CommandLine.Output(), "\nEnvironment variables:\n")
+	for _, v := range envVars {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\n    \t%s\n", v.name, v.desc)
+	}
+}
+
+// envOrFlag returns flagVal if it was set, i.e. it's not -1.
+// Otherwise it returns the integer value of envKey environment variable if it's set and valid.
+func envOrFlag(flagVal int, envKey string) int {
+	if flagVal != -1 {
+		return flagVal
+	}
+
+	env := os.Getenv(envKey)
+	if env == "" {
+		return flagVal
+	}
+
+	val, err := strconv.Atoi(env)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Ignoring invalid %s value %q: %v\n", envKey, env, err)
+		return flagVal
+	}
+
+	return val
+}
+
+// stringList is a command line flag which can be repeated to collect multiple string values
+type stringList []string
+
+// String implements flag.Value interface for stringList
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+// Set implements flag.Value interface for stringList
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// intList is a command line flag which can be repeated to collect multiple integer values
+type intList []int
+
+// String implements flag.Value interface for intList
+func (l *intList) String() string {
+	vals := make([]string, len(*l))
+	for i, v := range *l {
+		vals[i] = strconv.Itoa(v)
+	}
+	return strings.Join(vals, ",")
+}
+
+// Set implements flag.Value interface for intList
+func (l *intList) Set(v string) error {
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, i)
+	return nil
+}
+
+// Sources returns video sources configured in c in the order image directories, files and devices.
+// Each source is named after its file, directory or device; repeated names are suffixed with their index.
+func (c *Config) Sources() []Source {
+	var sources []Source
+	for _, dir := range c.InputDirs {
+		sources = append(sources, Source{Name: filepath.Base(dir), InputDir: dir, DeviceID: -1})
+	}
+	for _, input := range c.Inputs {
+		sources = append(sources, Source{Name: filepath.Base(input), Input: input, DeviceID: -1})
+	}
+	for _, id := range c.Devices {
+		sources = append(sources, Source{Name: fmt.Sprintf("device%d", id), DeviceID: id})
+	}
+
+	names := make(map[string]int)
+	for i := range sources {
+		names[sources[i].Name]++
+		if n := names[sources[i].Name]; n > 1 {
+			sources[i].Name = fmt.Sprintf("%s-%d", sources[i].Name, n)
+		}
+	}
+
+	return sources
+}
+
+// RegisterFlags registers command line flags which populate c in flag set fs
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ConfigFile, "config", "", "Path to YAML configuration file; reloaded on SIGHUP")
+	fs.BoolVar(&c.ConfigPrint, "config-print", false, "Print the resolved detector and MQTT settings with their sources as JSON and exit")
+	fs.StringVar(&c.ConfigBackend, "config-backend", BackendEnv, "Remote store detector settings are read from: env (none), consul or etcd")
+	c.DetectorConfig.RegisterFlags(fs)
+	fs.StringVar(&c.Profile, "profile", "", "Name of the config file profile the detection starts with; "+
+		"the detector settings are used if empty")
+	fs.BoolVar(&c.ProfileResetCounters, "profile-reset-counters", false, "Reset the part and defect totals when the profile is switched")
+	fs.Var(&c.Devices, "device", "Camera device ID; can be repeated (default -1)")
+	fs.Var(&c.Inputs, "input", "Path to image or video file; can be repeated")
+	fs.Var(&c.InputDirs, "input-dir", "Path to directory with *.jpg or *.png image sequence; can be repeated")
+	c.Overlay.RegisterFlags(fs)
+	fs.StringVar(&c.Layout, "layout", "windows", "Display layout of multiple sources: windows or grid")
+	fs.BoolVar(&c.Headless, "headless", false, "Don't open display windows")
+	fs.BoolVar(&c.SelectROI, "select-roi", false, "Select region of interest in the first frame with the mouse before the detection starts")
+	fs.BoolVar(&c.SaveROI, "save-roi", false, "Write the region of interest selected by -select-roi to -config file")
+	fs.StringVar(&c.SelectMask, "select-mask", "", "Draw mask polygons excluded from detection in the first frame "+
+		"and save the mask to the given PNG file before the detection starts")
+	fs.BoolVar(&c.Loop, "loop", false, "Restart file or directory input when it reaches its end")
+	fs.IntVar(&c.FramesBuf, "frames-buf", 1, "Buffer size of the channel frames are sent for detection through")
+	fs.IntVar(&c.ResultsBuf, "results-buf", 1, "Buffer size of the channel detection results are displayed from")
+	fs.IntVar(&c.PubBuf, "pub-buf", 1, "Buffer size per source of the channel detection results are published from")
+	fs.BoolVar(&c.Publish, "publish", false, "Publish data analytics to a remote server")
+	fs.StringVar(&c.MQTTEncoding, "mqtt-encoding", detector.EncodingJSON, "Encoding of published analytics: json or proto")
+	fs.StringVar(&c.Sink, "sink", SinkMQTT, "Where analytics are published: mqtt if -publish is set, or stdout "+
+		"to stream JSON line of every processed frame with logs on stderr")
+	fs.BoolVar(&c.EventsOnly, "events-only", false, "Stream only results of the confirmed parts to -sink stdout")
+	fs.BoolVar(&c.FailOnDefect, "fail-on-defect", false, "Exit with code 1 if any defect was confirmed")
+	fs.IntVar(&c.AlertQoS, "mqtt-alert-qos", 2, "MQTT QoS level of published alarms: 0, 1 or 2")
+	fs.IntVar(&c.AnalyticsQoS, "mqtt-analytics-qos", 0, "MQTT QoS level of published analytics: 0, 1 or 2")
+	fs.IntVar(&c.Rate, "rate", 1, "Number of seconds between analytics are sent to a remote server")
+	fs.StringVar(&c.PublishMode, "publish-mode", PublishModeInterval, "When analytics are sent: interval publishes them every -rate seconds, "+
+		"on-change only when the part status or totals change")
+	fs.IntVar(&c.Heartbeat, "publish-heartbeat", 60, "Number of seconds after which unchanged analytics are sent in on-change mode; "+
+		"the MQTT publish statistics are sent to the status topic at the same interval")
+	fs.Float64Var(&c.FastRateThreshold, "fast-rate-threshold", 0.2, "Defect rate above which analytics are sent every -fast-rate-interval")
+	fs.Float64Var(&c.FastRateHysteresis, "fast-rate-hysteresis", 0.1, "Defect rate below which analytics are sent every -rate seconds again")
+	fs.IntVar(&c.FastRateInterval, "fast-rate-interval", 500, "Number of milliseconds between analytics are sent when defect rate is high")
+	fs.IntVar(&c.PublishTimeout, "publish-timeout", 1000, "Number of milliseconds to wait for analytics publish to finish")
+	fs.BoolVar(&c.MQTT.SkipVerify, "mqtt-tls-skip-verify", false, "Skip TLS verification of the MQTT server certificate; "+
+		"same as setting MQTT_TLS_SKIP_VERIFY")
+	fs.UintVar(&c.MQTTDisconnect, "mqtt-disconnect-ms", 100, "Number of milliseconds to wait for pending messages when disconnecting from MQTT server")
+	fs.StringVar(&c.SpoolDir, "spool-dir", "", "Directory messages which failed to publish are spooled to until they're delivered")
+	fs.IntVar(&c.SpoolSize, "spool-size", 10000, "Maximum number of spooled messages; the oldest message is evicted when it's exceeded")
+	fs.Float64Var(&c.Delay, "delay", 5.0, "Video playback delay")
+	fs.Float64Var(&c.FPSOverride, "fps-override", 0, "Frame rate used instead of the one reported by the video source; disabled if 0")
+	fs.IntVar(&c.MaskDevice, "mask-device", -1, "Camera device ID capturing binary foreground mask of the parts, "+
+		"e.g. from a lighting controller; it replaces frame preprocessing. Disabled if -1")
+	fs.StringVar(&c.MaskFile, "mask", "", "Path to binary mask image scaled to the processing frame; "+
+		"parts are only detected where it's white")
+	fs.BoolVar(&c.Realtime, "realtime", false, "Play video files in real time using the capture timestamps of their frames")
+	fs.Float64Var(&c.Speed, "speed", 1.0, "Playback speed multiplier of -realtime playback, e.g. 2 plays twice as fast")
+	fs.StringVar(&c.AlarmWebhook, "alarm-webhook", "", "URL defect alarm events are POSTed to")
+	fs.StringVar(&c.AlarmCmd, "alarm-cmd", "", "Command which receives defect alarm events on its stdin")
+	fs.IntVar(&c.AlarmCooldown, "alarm-cooldown", 2, "Number of seconds after an alarm during which no new alarm is fired for the same part")
+	fs.Float64Var(&c.MaxDwell, "max-dwell", 0, "Number of seconds a part can stay in view before it's reported as stuck; disabled if 0")
+	fs.IntVar(&c.MinDwellMs, "min-dwell-ms", 0, "Number of milliseconds below which the dwell time of a part is a defect, "+
+		"e.g. the part moved too fast; disabled if 0")
+	fs.IntVar(&c.MaxDwellMs, "max-dwell-ms", 0, "Number of milliseconds above which the dwell time of a part is a defect, "+
+		"e.g. the belt slowed down; disabled if 0")
+	fs.IntVar(&c.PresenceTimeout, "presence-timeout-s", 0, "Number of seconds without any part after which the belt is reported as jammed; disabled if 0")
+	fs.IntVar(&c.SceneCheckEvery, "scene-check-every", 10, "Number of frames between the camera blocked and line stalled checks; disabled if 0")
+	fs.Float64Var(&c.MinBrightness, "min-brightness", 20, "Mean gray level of the frame in range [0, 255] below which the camera is reported as blocked")
+	fs.Float64Var(&c.StaticDiff, "static-diff", 2, "Mean gray level difference of the checked frames below which the frame is static")
+	fs.StringVar(&c.ReportAt, "report-at", "", "Comma-separated list of @hourly and times of day in HH:MM format "+
+		"aggregate reports of the parts are published at, e.g. @hourly,06:00,14:00,22:00; disabled if empty")
+	fs.StringVar(&c.FrameValidation, "frame-validation", "on", "Skip detection of corrupt frames, e.g. torn or solid color "+
+		"frames of a glitching camera: on or off")
+	fs.Float64Var(&c.CorruptFlatRows, "corrupt-flat-rows", 0.5, "Fraction of frame rows without texture in range [0, 1] "+
+		"above which the frame is corrupt")
+	fs.Float64Var(&c.CorruptHistJump, "corrupt-hist-jump", 0.6, "Distance in range [0, 1] of the frame gray level "+
+		"histogram from the recent frames above which the frame is corrupt")
+	fs.Float64Var(&c.ExpectedPPM, "expected-ppm", 0, "Expected number of parts per minute; the line is reported as stalled "+
+		"if the frame stays static for 3 part intervals. Disabled if 0")
+	fs.StringVar(&c.BeepCmd, "beep-cmd", "", "Command which plays audible alert when a defect is confirmed, e.g. paplay alarm.wav")
+	fs.IntVar(&c.FrameTimeout, "frame-timeout-ms", 1000, "Number of milliseconds within which a frame must be processed")
+	fs.IntVar(&c.StaleResult, "stale-result-ms", 1000, "Number of milliseconds after which the displayed detection result "+
+		"is faded to show the detection is lagging; disabled if 0")
+	fs.IntVar(&c.FrameTimeoutCount, "frame-timeout-count", 10, "Number of consecutive frame timeouts after which the program stops")
+	fs.BoolVar(&c.Single, "single", false, "Detect part in the single -input image, print the result as JSON and exit "+
+		"with code 0 if it has no defect, 1 if it has a defect or 2 on error")
+	fs.BoolVar(&c.TriggerMode, "trigger", false, "Detect part in a single frame grabbed on every trigger received over "+
+		"HTTP POST /trigger, MQTT trigger command or SIGUSR1 instead of every frame")
+	fs.BoolVar(&c.TriggerImage, "trigger-image", false, "Publish the frame grabbed on every -trigger as JPEG")
+	fs.BoolVar(&c.SelfTest, "self-test", false, "Detect part in a synthetic frame at startup and exit with code 2 if the detection does not work")
+	fs.BoolVar(&c.Bench, "bench", false, "Process the sources as fast as possible without display and print per-stage timing report")
+	fs.StringVar(&c.BenchCSV, "bench-csv", "", "Path to CSV file the -bench report is written to")
+	fs.StringVar(&c.OutVideo, "out-video", "", "Path of the video file annotated frames are recorded to")
+	fs.StringVar(&c.OutCodec, "out-codec", "MJPG", "FourCC code of the recorded video codec")
+	fs.IntVar(&c.OutVideoMaxFrames, "out-video-max-frames", 0, "Number of frames after which a new video file is started; unlimited if 0")
+	fs.StringVar(&c.ExportFramesDir, "export-frames-dir", "", "Directory unannotated frames labeled with detection results are exported to as JPEG")
+	fs.Float64Var(&c.ExportFramesFPS, "export-frames-fps", 1.0, "Number of frames exported per second")
+	fs.IntVar(&c.ExportFramesMaxMB, "export-frames-max-mb", 1024, "Size of -export-frames-dir in megabytes above which no more frames are exported")
+	fs.StringVar(&c.ClipDir, "clip-dir", "", "Directory raw video clips of the confirmed defects are written to; disabled if empty")
+	fs.Float64Var(&c.ClipPre, "clip-pre", 5, "Number of seconds a defect clip spans before the defect")
+	fs.Float64Var(&c.ClipPost, "clip-post", 2, "Number of seconds a defect clip spans after the defect")
+	fs.Float64Var(&c.ClipScale, "clip-scale", 0.5, "Scale factor of the defect clip frames; smaller frames take less memory")
+	fs.IntVar(&c.ClipSkip, "clip-skip", 1, "Number of frames out of which one is kept in the defect clips")
+	fs.IntVar(&c.ClipMaxMB, "clip-max-mb", 1024, "Size of -clip-dir in megabytes above which no more clips are written")
+	fs.StringVar(&c.DebugFramesDir, "debug-frames-dir", "debug", "Directory thresholded and original frames are dumped to")
+	fs.IntVar(&c.DebugEvery, "debug-every", 0, "Number of frames between debug frame dumps; disabled if 0")
+	fs.BoolVar(&c.DebugAnnotate, "debug-annotate", false, "Draw detected part over dumped thresholded frames")
+	fs.StringVar(&c.HTTPAddr, "http-addr", "", "Address the HTTP server listens on, e.g. :8080; disabled if empty")
+	fs.IntVar(&c.SnapshotWidth, "snapshot-width", 0, "Width snapshots served over HTTP are resized to; not resized if 0")
+	fs.IntVar(&c.SnapshotQuality, "snapshot-quality", 90, "JPEG quality of snapshots served over HTTP: 1 to 100")
+	fs.StringVar(&c.StateFile, "state-file", "", "Path to the file result sequence numbers are persisted to across restarts")
+	fs.IntVar(&c.HistorySize, "history-size", 500, "Number of the most recent parts kept in the part history")
+	fs.Float64Var(&c.MaxDefectRate, "max-defect-rate", 1.0, "Rolling defect rate above which the program stops with exit code 1 "+
+		"so the line can be halted; never if 1")
+	fs.IntVar(&c.DefectRateWindow, "defect-rate-window", 100, "Number of the most recent parts -max-defect-rate is checked over")
+	fs.IntVar(&c.HistogramBucket, "histogram-bucket", 500, "Width of the part area histogram buckets")
+	fs.StringVar(&c.Schedule, "schedule", "", "Path to JSON file with shifts of part area ranges, e.g. "+
+		`[{"start":"06:00","end":"14:00","min":10000,"max":20000}]; -min and -max apply outside the shifts`)
+	fs.BoolVar(&c.OTel, "otel", false, "Export per-frame pipeline traces over OTLP to OTEL_EXPORTER_OTLP_ENDPOINT")
+	fs.Float64Var(&c.OTelSample, "otel-sample", 0.1, "Fraction of frames traced by -otel: 0 to 1")
+	fs.IntVar(&c.ProcWidth, "proc-width", 960, "Width of the frame used for detection; height preserves aspect ratio")
+	fs.StringVar(&c.PerspectivePoints, "perspective-points", "", "Belt corners in the camera frame warped to a top-down view: x,y pairs of "+
+		"top-left, top-right, bottom-right and bottom-left corner, e.g. 100,50,540,50,640,480,0,480")
+	fs.IntVar(&c.WarpWidth, "warp-width", 0, "Width of the top-down view of the belt; defaults to camera frame width")
+	fs.IntVar(&c.WarpHeight, "warp-height", 0, "Height of the top-down view of the belt; defaults to camera frame height")
+	fs.StringVar(&c.CalibRes, "calib-res", "", "Frame resolution min and max were calibrated at (WxH); scales min and max when set")
+}
+
+// loadFile reads YAML configuration file path into c.
+// Command line flags explicitly set in flag set fs take precedence over the file.
+func (c *Config) loadFile(fs *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	return withExplicitFlags(fs, func() error {
+		fc := fileConfig{Detector: &c.DetectorConfig, Overlay: &c.Overlay, MQTT: &c.MQTT}
+		if err := yaml.UnmarshalStrict(data, &fc); err != nil {
+			return fmt.Errorf("failed to parse config file %s: %v", path, err)
+		}
+		c.profileSpecs = fc.Profiles
+		c.setFileSources(data)
+		return nil
+	})
+}
+
+// loadProfiles builds detector configuration of every profile by applying its settings over the detector settings
+// and replaces the detector settings with the ones of the startup profile if it's set.
+// It returns error if any profile is invalid or if the startup profile does not exist.
+func (c *Config) loadProfiles() error {
+	c.Profiles = nil
+	for name, spec := range c.profileSpecs {
+		data, err := yaml.Marshal(spec)
+		if err != nil {
+			return fmt.Errorf("invalid profile %q: %v", name, err)
+		}
+		dc := c.DetectorConfig.Clone()
+		if err := yaml.UnmarshalStrict(data, &dc); err != nil {
+			return fmt.Errorf("invalid profile %q: %v", name, err)
+		}
+		dc.Profile = name
+		if err := dc.Validate(); err != nil {
+			return &ErrInvalidThreshold{fmt.Errorf("profile %q: %v", name, err)}
+		}
+		if c.Profiles == nil {
+			c.Profiles = make(map[string]DetectorConfig)
+		}
+		c.Profiles[name] = dc
+	}
+
+	if c.Profile == "" {
+		return nil
+	}
+	dc, ok := c.Profiles[c.Profile]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", c.Profile)
+	}
+	c.DetectorConfig = dc
+	for _, item := range c.profileSpecs[c.Profile] {
+		c.setSource("detector", fmt.Sprint(item.Key), SourceFile)
+	}
+
+	return nil
+}
+
+// withExplicitFlags calls apply and then restores flags of fs which were explicitly set on the command line,
+// so they take precedence over any values set by apply.
+func withExplicitFlags(fs *flag.FlagSet, apply func() error) error {
+	// remember explicitly set flags; repeatable flags are not part of the configuration sources
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		if _, ok := f.Value.(flag.Getter); ok {
+			set[f.Name] = f.Value.String()
+		}
+	})
+
+	if err := apply(); err != nil {
+		return err
+	}
+
+	for name, val := range set {
+		if err := fs.Set(name, val); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadConfig parses command line arguments args using flag set fs and returns the resulting configuration.
+// Detection, overlay and MQTT settings are read from the configuration file if it's set, and detection settings
+// from the remote configuration backend if it's set; the backend overrides the file and command line flags override both.
+// Video source and MQTT settings fall back to environment variables when they're not configured otherwise.
+// It returns error if the arguments or the configuration file can't be parsed or if the configuration is invalid.
+func LoadConfig(fs *flag.FlagSet, args []string) (Config, error) {
+	var c Config
+	c.RegisterFlags(fs)
+	c.MQTT = MQTTConfigFromEnv()
+	for name, key := range mqttEnvSettings {
+		if os.Getenv(name) != "" {
+			c.setSource("mqtt", key, SourceEnv)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return c, err
+	}
+	// the configuration sources set flags too, so the flags set on the command line are remembered now
+	var explicit []*flag.Flag
+	fs.Visit(func(f *flag.Flag) {
+		explicit = append(explicit, f)
+	})
+
+	if c.ConfigFile != "" {
+		if err := c.loadFile(fs, c.ConfigFile); err != nil {
+			return c, err
+		}
+	}
+
+	backend, err := NewConfigBackend(c.ConfigBackend)
+	if err != nil {
+		return c, err
+	}
+	if backend != nil {
+		if err := c.loadRemote(fs, backend); err != nil {
+			return c, err
+		}
+	}
+	c.setFlagSources(explicit)
+
+	// fall back to environment variables when no video source was specified
+	if len(c.Devices) == 0 && len(c.Inputs) == 0 && len(c.InputDirs) == 0 {
+		if input := os.Getenv("INPUT_FILE"); input != "" {
+			c.Inputs = append(c.Inputs, input)
+		} else {
+			c.Devices = append(c.Devices, envOrFlag(-1, "CAM_DEVICE_ID"))
+		}
+	}
+
+	if c.SelectROI || c.SelectMask != "" {
+		switch {
+		case c.Headless:
+			return c, fmt.Errorf("-select-roi and -select-mask need the display; they can't be used with -headless")
+		case len(c.Sources()) != 1:
+			return c, fmt.Errorf("-select-roi and -select-mask require exactly one video source")
+		}
+	}
+
+	if c.SaveROI && (!c.SelectROI || c.ConfigFile == "") {
+		return c, fmt.Errorf("-save-roi requires -select-roi and -config")
+	}
+
+	if c.TriggerMode && len(c.Sources()) != 1 {
+		return c, fmt.Errorf("-trigger requires exactly one video source")
+	}
+
+	if c.MaskDevice >= 0 && len(c.Sources()) != 1 {
+		return c, fmt.Errorf("mask device requires exactly one video source")
+	}
+
+	c.HTTPToken = os.Getenv("HTTP_API_TOKEN")
+	c.OTelEndpoint = otlpTracesEndpoint()
+
+	if err := c.loadProfiles(); err != nil {
+		return c, err
+	}
+
+	if err := c.DetectorConfig.Validate(); err != nil {
+		return c, &ErrInvalidThreshold{err}
+	}
+
+	if c.Schedule != "" {
+		if c.Shifts, err = LoadSchedule(c.Schedule, c.DetectorConfig); err != nil {
+			return c, err
+		}
+	}
+
+	if err := c.Overlay.Validate(); err != nil {
+		return c, err
+	}
+
+	if c.MQTTEncoding != detector.EncodingJSON && c.MQTTEncoding != detector.EncodingProto {
+		return c, fmt.Errorf("invalid MQTT encoding %q: expected %s or %s", c.MQTTEncoding, detector.EncodingJSON, detector.EncodingProto)
+	}
+
+	switch {
+	case c.Sink != SinkMQTT && c.Sink != SinkStdout:
+		return c, fmt.Errorf("invalid sink %q: expected %s or %s", c.Sink, SinkMQTT, SinkStdout)
+	case c.Sink == SinkStdout && c.Publish:
+		return c, fmt.Errorf("-publish can't be used with sink %s", SinkStdout)
+	case c.Sink == SinkStdout && c.MQTTEncoding != detector.EncodingJSON:
+		return c, fmt.Errorf("sink %s requires %s encoding", SinkStdout, detector.EncodingJSON)
+	case c.EventsOnly && c.Sink != SinkStdout:
+		return c, fmt.Errorf("-events-only requires sink %s", SinkStdout)
+	}
+
+	if c.AlertQoS < 0 || c.AlertQoS > 2 {
+		return c, fmt.Errorf("invalid MQTT alert QoS %d: expected 0, 1 or 2", c.AlertQoS)
+	}
+
+	if c.AnalyticsQoS < 0 || c.AnalyticsQoS > 2 {
+		return c, fmt.Errorf("invalid MQTT analytics QoS %d: expected 0, 1 or 2", c.AnalyticsQoS)
+	}
+
+	if c.PublishMode != PublishModeInterval && c.PublishMode != PublishModeOnChange {
+		return c, fmt.Errorf("invalid publish mode %q: expected %s or %s", c.PublishMode, PublishModeInterval, PublishModeOnChange)
+	}
+
+	// the publish tickers panic on non-positive intervals
+	if c.Rate < 1 {
+		return c, fmt.Errorf("invalid publish rate %d: must be at least 1 second", c.Rate)
+	}
+
+	if c.FastRateInterval < 1 {
+		return c, fmt.Errorf("invalid fast publish interval %d: must be at least 1 millisecond", c.FastRateInterval)
+	}
+
+	if c.Heartbeat <= 0 {
+		return c, fmt.Errorf("invalid publish heartbeat: %d", c.Heartbeat)
+	}
+
+	if c.SpoolSize <= 0 {
+		return c, fmt.Errorf("invalid spool size: %d", c.SpoolSize)
+	}
+
+	if c.FastRateHysteresis > c.FastRateThreshold {
+		return c, fmt.Errorf("fast rate hysteresis %g exceeds fast rate threshold %g",
+			c.FastRateHysteresis, c.FastRateThreshold)
+	}
+
+	if c.Layout != "windows" && c.Layout != "grid" {
+		return c, fmt.Errorf("invalid layout %q: expected windows or grid", c.Layout)
+	}
+
+	if c.FramesBuf < 1 || c.ResultsBuf < 1 || c.PubBuf < 1 {
+		return c, fmt.Errorf("invalid channel buffer sizes: frames %d, results %d, publish %d: must be at least 1",
+			c.FramesBuf, c.ResultsBuf, c.PubBuf)
+	}
+
+	if c.ExportFramesDir != "" && (c.ExportFramesFPS <= 0 || c.ExportFramesMaxMB <= 0) {
+		return c, fmt.Errorf("invalid frame export rate %g or size %d: must be positive",
+			c.ExportFramesFPS, c.ExportFramesMaxMB)
+	}
+
+	if c.ClipDir != "" && (c.ClipPre < 0 || c.ClipPost < 0 || c.ClipScale <= 0 || c.ClipScale > 1 || c.ClipSkip <= 0 || c.ClipMaxMB <= 0) {
+		return c, fmt.Errorf("invalid defect clip settings: durations must not be negative, scale must be in (0, 1], " +
+			"frame skip and size must be positive")
+	}
+
+	if c.OutVideo != "" && (len(c.OutCodec) != 4 || c.OutVideoMaxFrames < 0) {
+		return c, fmt.Errorf("invalid video codec %q or maximum number of frames %d", c.OutCodec, c.OutVideoMaxFrames)
+	}
+
+	if c.DebugEvery < 0 {
+		return c, fmt.Errorf("invalid number of frames between debug dumps: %d", c.DebugEvery)
+	}
+
+	if c.MaxDwell < 0 {
+		return c, fmt.Errorf("invalid maximum dwell time: %g", c.MaxDwell)
+	}
+
+	if c.MinDwellMs < 0 || c.MaxDwellMs < 0 || (c.MaxDwellMs > 0 && c.MinDwellMs > c.MaxDwellMs) {
+		return c, fmt.Errorf("invalid dwell time range: [%d - %d] ms", c.MinDwellMs, c.MaxDwellMs)
+	}
+
+	if c.PresenceTimeout < 0 {
+		return c, fmt.Errorf("invalid presence timeout: %d", c.PresenceTimeout)
+	}
+
+	if c.StaleResult < 0 {
+		return c, fmt.Errorf("invalid stale result age: %d", c.StaleResult)
+	}
+
+	if c.SceneCheckEvery < 0 {
+		return c, fmt.Errorf("invalid scene check interval: %d", c.SceneCheckEvery)
+	}
+
+	if c.MinBrightness < 0 || c.MinBrightness > 255 {
+		return c, fmt.Errorf("invalid minimum brightness %g: expected value in range [0, 255]", c.MinBrightness)
+	}
+
+	if c.StaticDiff < 0 {
+		return c, fmt.Errorf("invalid static frame difference: %g", c.StaticDiff)
+	}
+
+	if c.ReportAt != "" {
+		if c.Reports, err = ParseReportSchedule(c.ReportAt); err != nil {
+			return c, err
+		}
+	}
+
+	if c.FrameValidation != "on" && c.FrameValidation != "off" {
+		return c, fmt.Errorf("invalid frame validation %q: expected on or off", c.FrameValidation)
+	}
+
+	if c.CorruptFlatRows < 0 || c.CorruptFlatRows > 1 {
+		return c, fmt.Errorf("invalid corrupt flat rows fraction %g: expected value in range [0, 1]", c.CorruptFlatRows)
+	}
+
+	if c.CorruptHistJump < 0 || c.CorruptHistJump > 1 {
+		return c, fmt.Errorf("invalid corrupt histogram jump %g: expected value in range [0, 1]", c.CorruptHistJump)
+	}
+
+	if c.ExpectedPPM < 0 {
+		return c, fmt.Errorf("invalid expected parts per minute: %g", c.ExpectedPPM)
+	}
+
+	if c.HistorySize < 0 {
+		return c, fmt.Errorf("invalid history size: %d", c.HistorySize)
+	}
+
+	if c.MaxDefectRate < 0 || c.MaxDefectRate > 1 || math.IsNaN(c.MaxDefectRate) {
+		return c, fmt.Errorf("invalid maximum defect rate %g: expected value between 0 and 1", c.MaxDefectRate)
+	}
+
+	if c.DefectRateWindow <= 0 {
+		return c, fmt.Errorf("invalid defect rate window: %d", c.DefectRateWindow)
+	}
+
+	if c.MaxDefectRate < 1 && c.DefectRateWindow > c.HistorySize {
+		return c, fmt.Errorf("defect rate window %d exceeds history size %d", c.DefectRateWindow, c.HistorySize)
+	}
+
+	if c.OTelSample < 0 || c.OTelSample > 1 || math.IsNaN(c.OTelSample) {
+		return c, fmt.Errorf("invalid trace sample rate %g: expected value between 0 and 1", c.OTelSample)
+	}
+
+	if c.HistogramBucket <= 0 {
+		return c, fmt.Errorf("invalid histogram bucket width: %d", c.HistogramBucket)
+	}
+
+	if c.SnapshotWidth < 0 {
+		return c, fmt.Errorf("invalid snapshot width: %d", c.SnapshotWidth)
+	}
+
+	if c.SnapshotQuality < 1 || c.SnapshotQuality > 100 {
+		return c, fmt.Errorf("invalid snapshot quality %d: must be within [1 - 100]", c.SnapshotQuality)
+	}
+
+	if c.FPSOverride < 0 || math.IsNaN(c.FPSOverride) || math.IsInf(c.FPSOverride, 0) {
+		return c, fmt.Errorf("invalid FPS override: %g", c.FPSOverride)
+	}
+
+	if c.Speed <= 0 || math.IsNaN(c.Speed) || math.IsInf(c.Speed, 0) {
+		return c, fmt.Errorf("invalid playback speed: %g", c.Speed)
+	}
+
+	if c.ProcWidth <= 0 {
+		return c, fmt.Errorf("invalid processing width: %d", c.ProcWidth)
+	}
+
+	if c.PerspectivePoints != "" {
+		pts, err := detector.ParsePoints(c.PerspectivePoints, 4)
+		if err != nil {
+			return c, fmt.Errorf("invalid perspective points: %v", err)
+		}
+		c.Perspective = pts
+	}
+
+	if c.WarpWidth < 0 || c.WarpHeight < 0 {
+		return c, fmt.Errorf("invalid warp size: %dx%d", c.WarpWidth, c.WarpHeight)
+	}
+
+	if c.CalibRes != "" {
+		calib, err := parseRes(c.CalibRes)
+		if err != nil {
+			return c, fmt.Errorf("invalid calibration resolution: %v", err)
+		}
+		c.Calib = calib
+	}
+
+	return c, nil
+}
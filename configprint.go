@@ -0,0 +1,169 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// SourceDefault is source of the settings which were not configured
+	SourceDefault = "default"
+	// SourceEnv is source of the settings read from environment variables
+	SourceEnv = "env"
+	// SourceFile is source of the settings read from the configuration file
+	SourceFile = "file"
+	// SourceRemote is source of the settings read from the remote configuration backend
+	SourceRemote = "remote"
+	// SourceFlag is source of the settings set on the command line
+	SourceFlag = "flag"
+)
+
+// mqttEnvSettings maps environment variables to the MQTT settings they configure
+var mqttEnvSettings = map[string]string{
+	"MQTT_SERVER":           "server",
+	"MQTT_CLIENT_ID":        "client_id",
+	"MQTT_USERNAME":         "username",
+	"MQTT_PASSWORD":         "password",
+	"MQTT_CERT":             "cert",
+	"MQTT_CERT_KEY":         "cert_key",
+	"MQTT_CA_FILE":          "ca",
+	"MQTT_CA_ROOT":          "ca",
+	"MQTT_TLS_SKIP_VERIFY":  "tls_skip_verify",
+	"MQTT_TLS_MIN_VERSION":  "tls_min_version",
+	"MQTT_TLS_SERVER_NAME":  "tls_server_name",
+	"MQTT_PROTOCOL_VERSION": "protocol_version",
+	"MQTT_STORE_DIR":        "store_dir",
+}
+
+// setSource records that setting key of configuration file section comes from source
+func (c *Config) setSource(section, key, source string) {
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+	c.sources[section+"."+key] = source
+}
+
+// setFileSources records the detector and MQTT settings present in configuration file data as coming from the file
+func (c *Config) setFileSources(data []byte) {
+	var keys struct {
+		Detector map[string]interface{} `yaml:"detector"`
+		MQTT     map[string]interface{} `yaml:"mqtt"`
+	}
+	// the file has already been parsed strictly
+	yaml.Unmarshal(data, &keys)
+
+	for key := range keys.Detector {
+		c.setSource("detector", key, SourceFile)
+	}
+	for key := range keys.MQTT {
+		c.setSource("mqtt", key, SourceFile)
+	}
+}
+
+// setFlagSources records the detector and MQTT settings populated by flags as coming from the command line
+func (c *Config) setFlagSources(flags []*flag.Flag) {
+	// the flags store their values in the settings, so they're matched by address
+	settings := make(map[uintptr][2]string)
+	for section, v := range map[string]reflect.Value{
+		"detector": reflect.ValueOf(&c.DetectorConfig).Elem(),
+		"mqtt":     reflect.ValueOf(&c.MQTT).Elem(),
+	} {
+		for i := 0; i < v.NumField(); i++ {
+			if key := yamlKey(v.Type().Field(i)); key != "" {
+				settings[v.Field(i).Addr().Pointer()] = [2]string{section, key}
+			}
+		}
+	}
+
+	for _, f := range flags {
+		v := reflect.ValueOf(f.Value)
+		if v.Kind() != reflect.Ptr {
+			continue
+		}
+		if setting, ok := settings[v.Pointer()]; ok {
+			c.setSource(setting[0], setting[1], SourceFlag)
+		}
+	}
+}
+
+// yamlKey returns key of struct field f in the configuration file; it's empty if f is not read from the file
+func yamlKey(f reflect.StructField) string {
+	key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if key == "-" {
+		return ""
+	}
+
+	return key
+}
+
+// configSetting is resolved value of a setting together with its source
+type configSetting struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// printConfig writes the resolved detector and MQTT settings of c together with their sources as JSON to w
+// Settings are named like in the configuration file; MQTT password is redacted.
+func printConfig(w io.Writer, c Config) error {
+	out := make(map[string]map[string]configSetting)
+	for section, v := range map[string]reflect.Value{
+		"detector": reflect.ValueOf(c.DetectorConfig),
+		"mqtt":     reflect.ValueOf(c.MQTT),
+	} {
+		settings := make(map[string]configSetting)
+		for i := 0; i < v.NumField(); i++ {
+			key := yamlKey(v.Type().Field(i))
+			if key == "" {
+				continue
+			}
+			source, ok := c.sources[section+"."+key]
+			if !ok {
+				source = SourceDefault
+			}
+			settings[key] = configSetting{Value: v.Field(i).Interface(), Source: source}
+		}
+		out[section] = settings
+	}
+
+	if s := out["mqtt"]["password"]; s.Value != "" {
+		s.Value = "REDACTED"
+		out["mqtt"]["password"] = s
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		return fmt.Errorf("failed to encode configuration: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,48 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"testing"
+)
+
+func TestLoadConfigFlagSources(t *testing.T) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	cfg, err := LoadConfig(fs, []string{"-input", "belt.mp4", "-min", "100", "-mqtt-tls-skip-verify"})
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	if cfg.Min != 100 {
+		t.Errorf("min = %d, want 100", cfg.Min)
+	}
+	for _, setting := range []string{"detector.min", "mqtt.tls_skip_verify"} {
+		if source := cfg.sources[setting]; source != SourceFlag {
+			t.Errorf("%s source = %q, want %q", setting, source, SourceFlag)
+		}
+	}
+}
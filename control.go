@@ -0,0 +1,302 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// errStopping is returned by configuration updates which arrive when the program is stopping
+var errStopping = errors.New("program is stopping")
+
+// Thresholds are detector settings which can be changed while the program runs
+// Settings which are nil are left unchanged when the thresholds are applied.
+type Thresholds struct {
+	// Min is minimum part area of assembly object
+	Min *int `json:"min,omitempty"`
+	// Max is maximum part area of assembly object
+	Max *int `json:"max,omitempty"`
+	// WarnMargin is percentage of min and max within which a part triggers warning
+	WarnMargin *float64 `json:"warn_margin,omitempty"`
+	// ConfirmFrames is number of consecutive frames after which part status is confirmed
+	ConfirmFrames *int `json:"debounce_frames,omitempty"`
+}
+
+// currentThresholds returns thresholds of detector configuration dc
+func currentThresholds(dc DetectorConfig) Thresholds {
+	return Thresholds{Min: &dc.Min, Max: &dc.Max, WarnMargin: &dc.WarnMargin, ConfirmFrames: &dc.ConfirmFrames}
+}
+
+// String implements fmt.Stringer interface for Thresholds; unset settings are omitted
+func (t Thresholds) String() string {
+	var s []string
+	if t.Min != nil {
+		s = append(s, fmt.Sprintf("min=%d", *t.Min))
+	}
+	if t.Max != nil {
+		s = append(s, fmt.Sprintf("max=%d", *t.Max))
+	}
+	if t.WarnMargin != nil {
+		s = append(s, fmt.Sprintf("warn_margin=%g", *t.WarnMargin))
+	}
+	if t.ConfirmFrames != nil {
+		s = append(s, fmt.Sprintf("debounce_frames=%d", *t.ConfirmFrames))
+	}
+
+	return strings.Join(s, " ")
+}
+
+// applyThresholds applies thresholds t to detector configuration dc and returns the new configuration
+// It returns ErrInvalidThreshold if the new configuration is invalid.
+func applyThresholds(dc DetectorConfig, t Thresholds) (DetectorConfig, error) {
+	if t.Min != nil {
+		dc.Min = *t.Min
+	}
+	if t.Max != nil {
+		dc.Max = *t.Max
+	}
+	if t.WarnMargin != nil {
+		dc.WarnMargin = *t.WarnMargin
+	}
+	if t.ConfirmFrames != nil {
+		dc.ConfirmFrames = *t.ConfirmFrames
+	}
+
+	if dc.Min < 0 || dc.WarnMargin < 0 {
+		return dc, &ErrInvalidThreshold{fmt.Errorf("area range and warning margin must not be negative")}
+	}
+	if err := dc.Validate(); err != nil {
+		return dc, &ErrInvalidThreshold{err}
+	}
+
+	return dc, nil
+}
+
+// ConfigHandler serves the effective detector thresholds and updates them at runtime
+// GET requests are open; PUT requests and the resets wrapped by authorize must carry the bearer token
+// and they are rejected if no token is configured.
+// Updated configuration is applied to the running pipelines through reloadChan.
+type ConfigHandler struct {
+	mu sync.Mutex
+	// cfg is the effective configuration
+	cfg Config
+	// token is bearer token PUT requests are authorized with
+	token string
+	// reloadChan delivers updated configuration to the main goroutine
+	reloadChan chan<- Config
+	// doneChan is closed when the program stops so no updates are applied anymore
+	doneChan <-chan struct{}
+	// state persists the updated thresholds across restarts
+	state *State
+}
+
+// NewConfigHandler creates new handler of the effective configuration cfg which authorizes updates with token
+// and sends them to reloadChan until doneChan is closed; updated thresholds are persisted to state.
+func NewConfigHandler(cfg Config, token string, reloadChan chan<- Config, doneChan <-chan struct{}, state *State) *ConfigHandler {
+	return &ConfigHandler{cfg: cfg, token: token, reloadChan: reloadChan, doneChan: doneChan, state: state}
+}
+
+// Set replaces the effective configuration, e.g. when it's reloaded
+func (h *ConfigHandler) Set(cfg Config) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.cfg = cfg
+}
+
+// Update applies thresholds t to the effective configuration and hands it over to the running pipelines
+// It's shared by all the runtime control paths. It returns error if the updated configuration is invalid
+// or if the program is stopping.
+func (h *ConfigHandler) Update(t Thresholds) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	dc, err := applyThresholds(h.cfg.DetectorConfig, t)
+	if err != nil {
+		return err
+	}
+
+	cfg := h.cfg
+	cfg.DetectorConfig = dc
+	select {
+	case h.reloadChan <- cfg:
+	case <-h.doneChan:
+		return errStopping
+	}
+
+	fmt.Printf("Updated detector thresholds from %v to %v\n", currentThresholds(h.cfg.DetectorConfig), currentThresholds(dc))
+	h.cfg = cfg
+	h.state.SetThresholds(currentThresholds(dc))
+
+	return nil
+}
+
+// SwitchProfile replaces the detector settings with the ones of profile name and hands them over to the running
+// pipelines, which start tracking parts afresh. It returns error if there is no such profile or if the program
+// is stopping; the current profile is kept then.
+func (h *ConfigHandler) SwitchProfile(name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	dc, ok := h.cfg.Profiles[name]
+	if !ok {
+		return fmt.Errorf("unknown profile %q", name)
+	}
+
+	cfg := h.cfg
+	cfg.DetectorConfig = dc
+	select {
+	case h.reloadChan <- cfg:
+	case <-h.doneChan:
+		return errStopping
+	}
+
+	fmt.Printf("Switched detector profile from %q to %q\n", h.cfg.Profile, name)
+	h.cfg = cfg
+
+	return nil
+}
+
+// profileState is the active profile and the names of all the profiles served by serveProfile
+type profileState struct {
+	Profile  string   `json:"profile"`
+	Profiles []string `json:"profiles"`
+}
+
+// serveProfile serves the active profile as JSON on GET requests and switches it on authorized PUT requests
+// with body {"name":"<profile>"}
+func (h *ConfigHandler) serveProfile(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if !h.authorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Name string `json:"name"`
+		}
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid profile request: %v", err), http.StatusBadRequest)
+			return
+		}
+		switch err := h.SwitchProfile(req.Name); {
+		case err == errStopping:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, fmt.Sprintf("rejected profile: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mu.Lock()
+	s := profileState{Profile: h.cfg.Profile, Profiles: make([]string, 0, len(h.cfg.Profiles))}
+	for name := range h.cfg.Profiles {
+		s.Profiles = append(s.Profiles, name)
+	}
+	h.mu.Unlock()
+	sort.Strings(s.Profiles)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s); err != nil {
+		fmt.Printf("Error encoding profile: %v\n", err)
+	}
+}
+
+// authorized reports whether request r carries the configured bearer token
+func (h *ConfigHandler) authorized(r *http.Request) bool {
+	const prefix = "Bearer "
+	auth := r.Header.Get("Authorization")
+	if h.token == "" || !strings.HasPrefix(auth, prefix) {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(auth[len(prefix):]), []byte(h.token)) == 1
+}
+
+// authorize wraps handler of state-changing requests, so they're served only if they carry the bearer token
+func (h *ConfigHandler) authorize(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !h.authorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// ServeHTTP serves the effective thresholds as JSON on GET requests and updates them on authorized PUT requests
+func (h *ConfigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if !h.authorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var t Thresholds
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&t); err != nil {
+			http.Error(w, fmt.Sprintf("invalid thresholds: %v", err), http.StatusBadRequest)
+			return
+		}
+		switch err := h.Update(t); {
+		case err == errStopping:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case err != nil:
+			http.Error(w, fmt.Sprintf("rejected thresholds: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.mu.Lock()
+	t := currentThresholds(h.cfg.DetectorConfig)
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(t); err != nil {
+		fmt.Printf("Error encoding thresholds: %v\n", err)
+	}
+}
@@ -0,0 +1,73 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+	"gocv.io/x/gocv"
+)
+
+// dumpDebugFrames saves thresholded image thresh and original frame orig of frame number n of video source
+// to a directory named after the source in dir. If annotate is set, detected part rect is drawn over thresh.
+// Mask of the suppressed shadows is saved too unless shadows is nil.
+// It returns error if the directory can't be created or the images can't be saved.
+func dumpDebugFrames(dir, source string, n int, orig, thresh gocv.Mat, shadows *gocv.Mat, rect image.Rectangle, annotate bool) error {
+	dir = filepath.Join(dir, source)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	debug := thresh
+	if annotate {
+		// thresholded image is single channel; convert it so the rectangle is drawn in color
+		debug = gocv.NewMat()
+		defer debug.Close()
+		gocv.CvtColor(thresh, &debug, gocv.ColorGrayToBGR)
+		if !rect.Empty() {
+			gocv.Rectangle(&debug, rect, detector.SeverityDefect.Color(), 2)
+		}
+	}
+
+	if ok := gocv.IMWrite(filepath.Join(dir, fmt.Sprintf("debug_%d.jpg", n)), debug); !ok {
+		return fmt.Errorf("failed to save thresholded frame %d", n)
+	}
+
+	if ok := gocv.IMWrite(filepath.Join(dir, fmt.Sprintf("debug_%d_orig.jpg", n)), orig); !ok {
+		return fmt.Errorf("failed to save original frame %d", n)
+	}
+
+	if shadows == nil {
+		return nil
+	}
+	if ok := gocv.IMWrite(filepath.Join(dir, fmt.Sprintf("debug_%d_shadows.jpg", n)), *shadows); !ok {
+		return fmt.Errorf("failed to save shadows of frame %d", n)
+	}
+
+	return nil
+}
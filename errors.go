@@ -0,0 +1,119 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import "fmt"
+
+const (
+	// exitError is exit code of the program when it fails
+	exitError = 1
+	// exitConfig is exit code of the program when its configuration is invalid
+	exitConfig = 2
+	// exitHardware is exit code of the program when a video source can't be opened
+	exitHardware = 3
+)
+
+// ErrCaptureOpen is returned when video source can't be opened
+type ErrCaptureOpen struct {
+	// Source is name of the video source
+	Source string
+	// Err is the original error
+	Err error
+}
+
+// Error implements error interface for ErrCaptureOpen
+func (e *ErrCaptureOpen) Error() string {
+	return fmt.Sprintf("failed to open video source %s: %v", e.Source, e.Err)
+}
+
+// Unwrap returns the original error
+func (e *ErrCaptureOpen) Unwrap() error {
+	return e.Err
+}
+
+// ErrMQTTConnect is returned when connection to MQTT server fails
+type ErrMQTTConnect struct {
+	// Err is the original error
+	Err error
+}
+
+// Error implements error interface for ErrMQTTConnect
+func (e *ErrMQTTConnect) Error() string {
+	return fmt.Sprintf("failed to connect to MQTT server: %v", e.Err)
+}
+
+// Unwrap returns the original error
+func (e *ErrMQTTConnect) Unwrap() error {
+	return e.Err
+}
+
+// ErrMQTTConfig is returned when MQTT client configuration is invalid
+type ErrMQTTConfig struct {
+	// Err is the original error
+	Err error
+}
+
+// Error implements error interface for ErrMQTTConfig
+func (e *ErrMQTTConfig) Error() string {
+	return fmt.Sprintf("invalid MQTT configuration: %v", e.Err)
+}
+
+// Unwrap returns the original error
+func (e *ErrMQTTConfig) Unwrap() error {
+	return e.Err
+}
+
+// ErrInvalidThreshold is returned when detector configuration, such as its area range, is invalid
+type ErrInvalidThreshold struct {
+	// Err is the original error
+	Err error
+}
+
+// Error implements error interface for ErrInvalidThreshold
+func (e *ErrInvalidThreshold) Error() string {
+	return fmt.Sprintf("invalid detector configuration: %v", e.Err)
+}
+
+// Unwrap returns the original error
+func (e *ErrInvalidThreshold) Unwrap() error {
+	return e.Err
+}
+
+// explain returns user-friendly description of error err together with the exit code the program stops with
+// The program is built with Go versions which predate errors.As, so the error types are matched directly.
+func explain(err error) (string, int) {
+	switch e := err.(type) {
+	case *ErrCaptureOpen:
+		return fmt.Sprintf("%s\nCheck the camera is connected, the file exists and the program is allowed to read them.", e.Error()),
+			exitHardware
+	case *ErrMQTTConfig:
+		return fmt.Sprintf("%s\nCheck the MQTT_* environment variables.", e.Error()), exitConfig
+	case *ErrMQTTConnect:
+		return fmt.Sprintf("%s\nCheck MQTT_SERVER is reachable and the credentials are valid.", e.Error()), exitError
+	case *ErrInvalidThreshold:
+		return e.Error(), exitConfig
+	}
+
+	return err.Error(), exitError
+}
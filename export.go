@@ -0,0 +1,167 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+	"gocv.io/x/gocv"
+)
+
+// exportQueueSize is number of frames waiting to be exported before new frames are skipped
+const exportQueueSize = 4
+
+// exportFrame is a frame waiting to be exported
+type exportFrame struct {
+	// img is unannotated frame; it's owned by the exporter
+	img gocv.Mat
+	// name is file name of the exported frame
+	name string
+}
+
+// FrameExporter saves unannotated frames labeled with their detection results as JPEG files for dataset collection
+type FrameExporter struct {
+	// dir is directory the frames are saved to
+	dir string
+	// maxBytes is disk usage of dir above which no more frames are saved
+	maxBytes int64
+	// size is current disk usage of dir
+	size int64
+	// queue contains frames waiting to be saved
+	queue chan exportFrame
+	// skipped counts frames which were not saved
+	skipped uint64
+}
+
+// NewFrameExporter creates new frame exporter which saves frames to directory dir until it exceeds maxMB megabytes.
+// It returns error if the directory can't be created or its disk usage can't be determined.
+func NewFrameExporter(dir string, maxMB int) (*FrameExporter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create export directory: %v", err)
+	}
+
+	// frames exported by previous runs count towards the disk usage
+	size, err := dirSize(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read export directory: %v", err)
+	}
+
+	return &FrameExporter{
+		dir:      dir,
+		maxBytes: int64(maxMB) * 1024 * 1024,
+		size:     size,
+		queue:    make(chan exportFrame, exportQueueSize),
+	}, nil
+}
+
+// dirSize returns total size of the files in directory dir and its subdirectories
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+
+	return size, err
+}
+
+// Export queues a copy of unannotated frame img labeled with detection result r to be saved
+// The frame is skipped if too many frames are already waiting to be saved.
+func (e *FrameExporter) Export(img gocv.Mat, r *detector.Result) {
+	label := "ok"
+	if r.Defect {
+		label = "defect"
+	}
+	f := exportFrame{
+		img:  img.Clone(),
+		name: fmt.Sprintf("%s_area%d_%s.jpg", time.Now().Format("20060102T150405.000000000"), detector.Area(r.Rect), label),
+	}
+
+	select {
+	case e.queue <- f:
+	default:
+		f.img.Close()
+		atomic.AddUint64(&e.skipped, 1)
+	}
+}
+
+// Skipped returns number of frames which were not saved
+func (e *FrameExporter) Skipped() uint64 {
+	return atomic.LoadUint64(&e.skipped)
+}
+
+// Run saves queued frames until it receives a signal on doneChan
+// doneChan is used to receive a signal from the main goroutine to notify the routine to stop and return
+func (e *FrameExporter) Run(doneChan <-chan struct{}) error {
+	for {
+		select {
+		case f := <-e.queue:
+			if err := e.save(f); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to export frame %s: %v\n", f.name, err)
+				atomic.AddUint64(&e.skipped, 1)
+			}
+			f.img.Close()
+		case <-doneChan:
+			fmt.Printf("Stopping frame exporter: received stop signal\n")
+			for {
+				select {
+				case f := <-e.queue:
+					f.img.Close()
+				default:
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// save encodes frame f as JPEG and writes it to the export directory unless it's full
+func (e *FrameExporter) save(f exportFrame) error {
+	if e.size >= e.maxBytes {
+		return fmt.Errorf("export directory exceeds %d MB", e.maxBytes/1024/1024)
+	}
+
+	buf, err := gocv.IMEncode(gocv.JPEGFileExt, f.img)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(e.dir, f.name), buf, 0644); err != nil {
+		return err
+	}
+	e.size += int64(len(buf))
+
+	return nil
+}
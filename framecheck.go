@@ -0,0 +1,138 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// validationWidth is width of the downscaled gray copy of the frame the validation operates on
+	validationWidth = 64
+	// histBins is number of gray level histogram bins compared with the reference histogram
+	histBins = 16
+	// histRebaseFrames is number of consecutive frames with jumped histogram after which their histogram
+	// becomes the reference, e.g. when the lights were switched on
+	histRebaseFrames = 30
+	// histRefWeight is weight of a valid frame in the rolling reference histogram
+	histRefWeight = 0.1
+	// flatRowVariance is gray level variance below which a row is flat, i.e. its pixels are identical as if filled
+	// by the capture with solid color; camera noise keeps the rows of real frames above it
+	flatRowVariance = 0.01
+)
+
+// FrameValidator tells corrupt frames, e.g. torn or solid color frames of a glitching USB camera, from valid ones
+// A frame is corrupt if too many of its rows are flat or if its gray level histogram jumps away from the rolling
+// reference histogram of the recent valid frames.
+type FrameValidator struct {
+	// maxFlatRows is fraction of flat rows above which the frame is corrupt
+	maxFlatRows float64
+	// maxHistJump is histogram distance in range [0, 1] from the reference above which the frame is corrupt
+	maxHistJump float64
+	// minBrightness is mean gray level below which the rows are dark rather than flat; dark frames are left to the
+	// blocked camera check
+	minBrightness float64
+	// ref is rolling reference histogram; it's nil before the first valid frame
+	ref []float64
+	// jumps is number of consecutive frames with jumped histogram
+	jumps int
+}
+
+// NewFrameValidator creates new frame validator according to cfg
+func NewFrameValidator(cfg Config) *FrameValidator {
+	return &FrameValidator{maxFlatRows: cfg.CorruptFlatRows, maxHistJump: cfg.CorruptHistJump, minBrightness: cfg.MinBrightness}
+}
+
+// Check checks frame img and returns error describing why it's corrupt; it returns nil if the frame is valid
+func (v *FrameValidator) Check(img gocv.Mat) error {
+	if img.Empty() {
+		return fmt.Errorf("empty frame")
+	}
+
+	// the checks run on a tiny gray copy of the frame, so they cost next to nothing
+	gray := gocv.NewMat()
+	defer gray.Close()
+	size := image.Point{validationWidth, validationWidth * img.Rows() / img.Cols()}
+	gocv.Resize(img, &gray, size, 0, 0, gocv.InterpolationArea)
+	if gray.Channels() > 1 {
+		gocv.CvtColor(gray, &gray, gocv.ColorBGRToGray)
+	}
+	data := gray.DataPtrUint8()
+	rows, cols := gray.Rows(), gray.Cols()
+	if rows == 0 || len(data) < rows*cols {
+		return fmt.Errorf("frame of %dx%d pixels can't be validated", img.Cols(), img.Rows())
+	}
+
+	// torn and solid color frames have rows without any texture
+	flat := 0
+	hist := make([]float64, histBins)
+	for y := 0; y < rows; y++ {
+		row := data[y*cols : (y+1)*cols]
+		var sum, sq float64
+		for _, px := range row {
+			sum += float64(px)
+			sq += float64(px) * float64(px)
+			hist[int(px)*histBins/256]++
+		}
+		mean := sum / float64(cols)
+		if mean >= v.minBrightness && sq/float64(cols)-mean*mean < flatRowVariance {
+			flat++
+		}
+	}
+	if f := float64(flat) / float64(rows); f > v.maxFlatRows {
+		return fmt.Errorf("%.0f%% of rows are flat", 100*f)
+	}
+
+	for i := range hist {
+		hist[i] /= float64(rows * cols)
+	}
+	if v.ref == nil {
+		v.ref = hist
+		return nil
+	}
+
+	// the histogram distance is half of L1 distance, so it's 1 for histograms which have nothing in common
+	var dist float64
+	for i := range hist {
+		dist += math.Abs(hist[i] - v.ref[i])
+	}
+	dist /= 2
+	if dist > v.maxHistJump {
+		// a lasting change of the scene is not corruption
+		if v.jumps++; v.jumps < histRebaseFrames {
+			return fmt.Errorf("histogram jumped by %.2f", dist)
+		}
+		copy(v.ref, hist)
+	}
+	v.jumps = 0
+
+	for i := range hist {
+		v.ref[i] += histRefWeight * (hist[i] - v.ref[i])
+	}
+	return nil
+}
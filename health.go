@@ -0,0 +1,158 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// HealthOK means the frames go through detection
+	HealthOK = "ok"
+	// HealthDegraded means frames were dropped before detection since the previous check
+	HealthDegraded = "degraded"
+	// HealthUnhealthy means no frame went through detection recently
+	HealthUnhealthy = "unhealthy"
+)
+
+const (
+	// healthFrameTimeout is time without a processed frame after which the program is unhealthy
+	healthFrameTimeout = 5 * time.Second
+	// healthWindow is the shortest period the frame rate and the dropped frames are measured over
+	healthWindow = time.Second
+)
+
+// DetectorHealth is health of the detection served at /healthz
+type DetectorHealth struct {
+	// Status is HealthOK, HealthDegraded or HealthUnhealthy
+	Status string `json:"status"`
+	// LastFrameAt is time when a frame last went through detection; it's zero until the first frame
+	LastFrameAt time.Time `json:"last_frame_at"`
+	// FPS is number of frames of all video sources which went through detection per second
+	FPS float64 `json:"fps"`
+	// DroppedFrames is total number of frames which never reached detection
+	DroppedFrames uint64 `json:"dropped_frames"`
+	// MQTTConnected is true if the MQTT client is connected
+	MQTTConnected bool `json:"mqtt_connected"`
+}
+
+// HealthHandler serves health of the detection for liveness and readiness probes
+// The frame rate and the dropped frames are measured since the previous check, but at least over healthWindow.
+type HealthHandler struct {
+	// status tells whether the MQTT client is connected
+	status *StatusHandler
+	// now returns the current time
+	now func() time.Time
+	// started is time when the handler was created; it stands in for the last frame until the first one
+	started time.Time
+
+	mu sync.Mutex
+	// windowAt is time when the current measurement window started
+	windowAt time.Time
+	// windowFrames and windowDropped are the processed and dropped frames when the window started
+	windowFrames, windowDropped uint64
+	// fps is frame rate measured over the previous window
+	fps float64
+	// dropping is true if frames were dropped in the previous window
+	dropping bool
+}
+
+// NewHealthHandler creates new health handler which reads MQTT connection state from status and gets the current
+// time from now
+func NewHealthHandler(status *StatusHandler, now func() time.Time) *HealthHandler {
+	t := now()
+	return &HealthHandler{status: status, now: now, started: t, windowAt: t,
+		windowFrames: atomic.LoadUint64(&ProcessedFrames), windowDropped: atomic.LoadUint64(&DroppedFrames)}
+}
+
+// Health returns the current health of the detection
+func (h *HealthHandler) Health() DetectorHealth {
+	now := h.now()
+	frames, dropped := atomic.LoadUint64(&ProcessedFrames), atomic.LoadUint64(&DroppedFrames)
+
+	h.mu.Lock()
+	if elapsed := now.Sub(h.windowAt); elapsed >= healthWindow {
+		h.fps = float64(frames-h.windowFrames) / elapsed.Seconds()
+		h.dropping = dropped > h.windowDropped
+		h.windowAt, h.windowFrames, h.windowDropped = now, frames, dropped
+	}
+	health := DetectorHealth{Status: HealthOK, FPS: h.fps, DroppedFrames: dropped, MQTTConnected: h.status.Connected()}
+	dropping := h.dropping
+	h.mu.Unlock()
+
+	last := h.started
+	if ns := atomic.LoadInt64(&LastFrameAt); ns != 0 {
+		health.LastFrameAt = time.Unix(0, ns).UTC()
+		last = health.LastFrameAt
+	}
+
+	switch {
+	case now.Sub(last) > healthFrameTimeout:
+		health.Status = HealthUnhealthy
+	case dropping:
+		health.Status = HealthDegraded
+	}
+
+	return health
+}
+
+// ServeHTTP implements http.Handler interface; it responds with the health as JSON with 200 status code if it's ok,
+// 429 if it's degraded and 503 if it's unhealthy
+func (h *HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	health := h.Health()
+	w.Header().Set("Content-Type", "application/json")
+	switch health.Status {
+	case HealthDegraded:
+		w.WriteHeader(http.StatusTooManyRequests)
+	case HealthUnhealthy:
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		fmt.Printf("Error encoding health: %v\n", err)
+	}
+}
+
+// serveReady responds with 503 status code until the first frame went through detection and with 200 afterwards
+func (h *HealthHandler) serveReady(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if atomic.LoadUint64(&ProcessedFrames) == 0 {
+		http.Error(w, "no frame processed yet", http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ready")
+}
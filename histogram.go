@@ -0,0 +1,270 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"net/http"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// histogramBuckets is number of histogram buckets; bigger areas are counted in the overflow bucket
+	histogramBuckets = 1000
+	// histogramChartWidth is width of the histogram chart image
+	histogramChartWidth = 800
+	// histogramChartHeight is height of the histogram chart image
+	histogramChartHeight = 400
+)
+
+// HistogramBucket is a histogram bucket counting areas within [Min, Max)
+type HistogramBucket struct {
+	// Min is the smallest area counted in the bucket
+	Min int `json:"min"`
+	// Max is the smallest area above the bucket
+	Max int `json:"max"`
+	// Count is number of parts counted in the bucket
+	Count uint64 `json:"count"`
+}
+
+// AreaHistogram is a thread-safe histogram of measured part areas
+// It has a fixed number of buckets so its memory use does not grow with runtime.
+type AreaHistogram struct {
+	mu sync.Mutex
+	// width is width of the buckets in pixels
+	width int
+	// counts contains number of parts in each bucket
+	counts []uint64
+	// overflow is number of parts too big for any bucket
+	overflow uint64
+	// total is number of all counted parts
+	total uint64
+	// minArea and maxArea are the extreme areas any part was measured with; they're 0 until a part is measured
+	minArea, maxArea int
+}
+
+// NewAreaHistogram creates new empty area histogram with buckets of given width and returns it
+func NewAreaHistogram(width int) *AreaHistogram {
+	return &AreaHistogram{
+		width:  width,
+		counts: make([]uint64, histogramBuckets),
+	}
+}
+
+// Add counts part of given area
+func (h *AreaHistogram) Add(area int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.total++
+	if i := area / h.width; i < len(h.counts) {
+		h.counts[i]++
+	} else {
+		h.overflow++
+	}
+}
+
+// AddExtremes records the smallest and the largest area min and max a part was measured with
+func (h *AreaHistogram) AddExtremes(min, max int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if min > 0 && (h.minArea == 0 || min < h.minArea) {
+		h.minArea = min
+	}
+	if max > h.maxArea {
+		h.maxArea = max
+	}
+}
+
+// Extremes returns the smallest and the largest area any part was measured with
+// It returns false if no part has been measured.
+func (h *AreaHistogram) Extremes() (int, int, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.minArea, h.maxArea, h.maxArea > 0
+}
+
+// Reset removes all counted parts from the histogram
+func (h *AreaHistogram) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := range h.counts {
+		h.counts[i] = 0
+	}
+	h.overflow, h.total = 0, 0
+	h.minArea, h.maxArea = 0, 0
+}
+
+// Buckets returns the non-empty buckets ordered by area together with the overflow count
+func (h *AreaHistogram) Buckets() ([]HistogramBucket, uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var buckets []HistogramBucket
+	for i, n := range h.counts {
+		if n > 0 {
+			buckets = append(buckets, HistogramBucket{i * h.width, (i + 1) * h.width, n})
+		}
+	}
+
+	return buckets, h.overflow
+}
+
+// Percentile returns area below which p percent of the counted parts are, rounded up to the bucket bound
+// It returns false if no part has been counted or the percentile falls into the overflow bucket.
+func (h *AreaHistogram) Percentile(p float64) (int, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.total == 0 {
+		return 0, false
+	}
+
+	rank := uint64(p / 100 * float64(h.total))
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen > rank || (seen == h.total && n > 0) {
+			return (i + 1) * h.width, true
+		}
+	}
+
+	return 0, false
+}
+
+// Summary returns p5, p50 and p95 percentiles of the counted areas formatted for the session summary
+func (h *AreaHistogram) Summary() string {
+	s := ""
+	for _, p := range []float64{5, 50, 95} {
+		if v, ok := h.Percentile(p); ok {
+			s += fmt.Sprintf(" p%g: %d", p, v)
+		} else {
+			s += fmt.Sprintf(" p%g: n/a", p)
+		}
+	}
+
+	return s
+}
+
+// ServeHTTP serves GET requests with the histogram buckets as JSON
+func (h *AreaHistogram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	buckets, overflow := h.Buckets()
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		BucketWidth int               `json:"bucket_width"`
+		Buckets     []HistogramBucket `json:"buckets"`
+		Overflow    uint64            `json:"overflow"`
+	}{h.width, buckets, overflow})
+	if err != nil {
+		fmt.Printf("Error encoding area histogram: %v\n", err)
+	}
+}
+
+// serveChart serves GET requests with bar chart of the histogram as PNG image
+func (h *AreaHistogram) serveChart(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	chart := h.render()
+	defer chart.Close()
+
+	buf, err := gocv.IMEncode(gocv.PNGFileExt, chart)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf)
+}
+
+// serveReset resets the histogram on POST requests
+func (h *AreaHistogram) serveReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// render draws bar chart of the non-empty range of the histogram and returns it
+// The returned image must be closed by the caller.
+func (h *AreaHistogram) render() gocv.Mat {
+	chart := gocv.NewMatWithSize(histogramChartHeight, histogramChartWidth, gocv.MatTypeCV8UC3)
+	gocv.Rectangle(&chart, image.Rect(0, 0, histogramChartWidth, histogramChartHeight), color.RGBA{255, 255, 255, 0}, -1)
+
+	buckets, _ := h.Buckets()
+	if len(buckets) == 0 {
+		return chart
+	}
+
+	// chart spans the buckets from the first to the last non-empty one
+	first, last := buckets[0].Min/h.width, buckets[len(buckets)-1].Min/h.width
+	var peak uint64
+	for _, b := range buckets {
+		if b.Count > peak {
+			peak = b.Count
+		}
+	}
+
+	const margin = 20
+	black := color.RGBA{0, 0, 0, 0}
+	plot := image.Rect(margin, margin, histogramChartWidth-margin, histogramChartHeight-2*margin)
+	barWidth := float64(plot.Dx()) / float64(last-first+1)
+	for _, b := range buckets {
+		i := b.Min/h.width - first
+		height := int(float64(plot.Dy()) * float64(b.Count) / float64(peak))
+		bar := image.Rect(plot.Min.X+int(float64(i)*barWidth), plot.Max.Y-height,
+			plot.Min.X+int(float64(i+1)*barWidth), plot.Max.Y)
+		gocv.Rectangle(&chart, bar, color.RGBA{0, 114, 178, 0}, -1)
+	}
+
+	// axis with the area range and the highest bucket count
+	gocv.Line(&chart, image.Point{plot.Min.X, plot.Max.Y}, plot.Max, black, 1)
+	gocv.PutText(&chart, fmt.Sprint(buckets[0].Min), image.Point{plot.Min.X, histogramChartHeight - margin/2},
+		gocv.FontHersheySimplex, 0.4, black, 1)
+	gocv.PutText(&chart, fmt.Sprint(buckets[len(buckets)-1].Max), image.Point{plot.Max.X - 4*margin, histogramChartHeight - margin/2},
+		gocv.FontHersheySimplex, 0.4, black, 1)
+	gocv.PutText(&chart, fmt.Sprintf("max %d", peak), image.Point{plot.Min.X, margin},
+		gocv.FontHersheySimplex, 0.4, black, 1)
+
+	return chart
+}
@@ -0,0 +1,211 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// PartEntry records a single detected part
+type PartEntry struct {
+	// Timestamp is time when the part was detected
+	Timestamp time.Time `json:"timestamp"`
+	// Source is name of the video source the part was detected in
+	Source string `json:"source"`
+	// Area is measured area of the part
+	Area int `json:"area"`
+	// Defect is set if the part area is out of the configured range or if its defect was confirmed later
+	Defect bool `json:"defect"`
+	// Class is severity of the part measurement: ok, warn or defect
+	Class string `json:"class"`
+	// Seq is sequence number of the result the part was counted in
+	Seq uint64 `json:"seq"`
+	// Zone is name of the zone the part was detected in; it's empty if no zones are configured
+	Zone string `json:"zone,omitempty"`
+}
+
+// PartHistory is a thread-safe circular buffer of the most recently detected parts
+type PartHistory struct {
+	mu sync.Mutex
+	// entries stores recorded parts; next entry overwrites entries[next]
+	entries []PartEntry
+	// next is index of the next entry
+	next int
+	// count is number of recorded entries; it never exceeds len(entries)
+	count int
+}
+
+// NewPartHistory creates new part history which keeps size most recent parts and returns it
+func NewPartHistory(size int) *PartHistory {
+	return &PartHistory{entries: make([]PartEntry, size)}
+}
+
+// Add records part entry e overwriting the oldest entry if the history is full
+func (h *PartHistory) Add(e PartEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) == 0 {
+		return
+	}
+
+	h.entries[h.next] = e
+	h.next = (h.next + 1) % len(h.entries)
+	if h.count < len(h.entries) {
+		h.count++
+	}
+}
+
+// Last returns up to n most recent entries ordered from the oldest to the newest
+func (h *PartHistory) Last(n int) []PartEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if n > h.count {
+		n = h.count
+	}
+
+	last := make([]PartEntry, n)
+	for i := range last {
+		last[i] = h.entries[(h.next-n+i+len(h.entries))%len(h.entries)]
+	}
+
+	return last
+}
+
+// MarkDefect marks the most recent part of video source and zone as defected once its defect is confirmed
+func (h *PartHistory) MarkDefect(source, zone string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i := 1; i <= h.count; i++ {
+		e := &h.entries[(h.next-i+len(h.entries))%len(h.entries)]
+		if e.Source == source && e.Zone == zone {
+			e.Defect = true
+			return
+		}
+	}
+}
+
+// DefectRate returns the rate of defected parts among up to n most recent parts together with the number of the parts
+func (h *PartHistory) DefectRate(n int) (float64, int) {
+	last := h.Last(n)
+	if len(last) == 0 {
+		return 0, 0
+	}
+
+	defects := 0
+	for _, e := range last {
+		if e.Defect {
+			defects++
+		}
+	}
+
+	return float64(defects) / float64(len(last)), len(last)
+}
+
+// Reset removes all entries from the history
+func (h *PartHistory) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.next, h.count = 0, 0
+}
+
+// ServeHTTP serves GET requests with the last n entries of the history as JSON array.
+// Number of entries is read from n query parameter; all entries are returned if it's not set.
+func (h *PartHistory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	n := len(h.entries)
+	if q := r.URL.Query().Get("n"); q != "" {
+		var err error
+		if n, err = strconv.Atoi(q); err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid number of entries: %q", q), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(h.Last(n)); err != nil {
+		fmt.Printf("Error encoding part history: %v\n", err)
+	}
+}
+
+// serveReset resets the history on POST requests
+func (h *PartHistory) serveReset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	h.Reset()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// defectRateCheckInterval is how often the rolling defect rate is checked against its maximum
+const defectRateCheckInterval = time.Second
+
+// ErrHighDefectRate is returned when the rolling defect rate of the most recent parts exceeds its maximum
+type ErrHighDefectRate struct {
+	// Rate is the rolling defect rate
+	Rate float64
+	// Max is maximum allowed defect rate
+	Max float64
+	// Window is number of the most recent parts the rate was computed from
+	Window int
+}
+
+// Error implements error interface for ErrHighDefectRate
+func (e *ErrHighDefectRate) Error() string {
+	return fmt.Sprintf("defect rate %.2f of the last %d parts exceeds maximum %.2f: stop the line", e.Rate, e.Window, e.Max)
+}
+
+// defectRateRunner checks the defect rate of the last window parts recorded in history every defectRateCheckInterval
+// It returns ErrHighDefectRate once the rate exceeds max; the rate is only checked when at least window parts are recorded.
+// doneChan is used to receive a signal from the main goroutine to notify the routine to stop and return
+func defectRateRunner(history *PartHistory, max float64, window int, doneChan <-chan struct{}) error {
+	ticker := time.NewTicker(defectRateCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if rate, n := history.DefectRate(window); n >= window && rate > max {
+				return &ErrHighDefectRate{Rate: rate, Max: max, Window: window}
+			}
+		case <-doneChan:
+			fmt.Printf("Stopping defectRateRunner: received stop signal\n")
+			return nil
+		}
+	}
+}
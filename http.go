@@ -0,0 +1,125 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// httpShutdownTimeout is time given to in-flight HTTP requests to finish when the program stops
+const httpShutdownTimeout = 2 * time.Second
+
+// StatusHandler serves status of the program, i.e. the statistics of the active publisher, as JSON
+type StatusHandler struct {
+	mu sync.Mutex
+	// pub is the active publisher; it's nil if publishing is disabled
+	pub Publisher
+}
+
+// SetPublisher replaces the active publisher with p
+func (h *StatusHandler) SetPublisher(p Publisher) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.pub = p
+}
+
+// Connected returns true if the active publisher is connected; it's false if publishing is disabled
+func (h *StatusHandler) Connected() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.pub != nil && h.pub.Connected()
+}
+
+// ServeHTTP implements http.Handler interface; the publisher statistics are null if publishing is disabled
+func (h *StatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := struct {
+		MQTT *PublishStats `json:"mqtt"`
+	}{}
+	h.mu.Lock()
+	if h.pub != nil {
+		stats := h.pub.Stats()
+		status.MQTT = &stats
+	}
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		fmt.Printf("Error encoding status: %v\n", err)
+	}
+}
+
+// newHTTPServer creates new HTTP server listening on addr which serves part history, area histogram,
+// metrics, snapshots of the video sources, the detector thresholds, the program status and health and returns it.
+// Counters are reset by commander. The resets are authorized by config the same way as the threshold updates.
+func newHTTPServer(addr string, history *PartHistory, histogram *AreaHistogram, metrics *Metrics,
+	snapshots *SnapshotHandler, config *ConfigHandler, status *StatusHandler, health *HealthHandler,
+	commander *Commander) *http.Server {
+	mux := http.NewServeMux()
+	mux.Handle("/histogram", histogram)
+	mux.HandleFunc("/histogram.png", histogram.serveChart)
+	mux.HandleFunc("/histogram/reset", config.authorize(histogram.serveReset))
+	mux.Handle("/metrics", metrics)
+	mux.Handle("/snapshot.jpg", snapshots)
+	mux.Handle("/history", history)
+	mux.HandleFunc("/history/reset", config.authorize(history.serveReset))
+	mux.Handle("/config", config)
+	mux.HandleFunc("/profile", config.serveProfile)
+	mux.Handle("/status", status)
+	mux.Handle("/healthz", health)
+	mux.HandleFunc("/readyz", health.serveReady)
+	mux.HandleFunc("/counters/reset", config.authorize(commander.serveResetCounters))
+
+	return &http.Server{Addr: addr, Handler: mux}
+}
+
+// httpRunner runs HTTP server srv until it fails or until it receives a signal on doneChan
+// doneChan is used to receive a signal from the main goroutine to notify the routine to stop and return
+func httpRunner(srv *http.Server, doneChan <-chan struct{}) error {
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errChan:
+		return fmt.Errorf("HTTP server failed: %v", err)
+	case <-doneChan:
+		fmt.Printf("Stopping httpRunner: received stop signal\n")
+		ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+		defer cancel()
+		return srv.Shutdown(ctx)
+	}
+}
@@ -24,16 +24,22 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"image"
-	"image/color"
+	"math"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
 	"gocv.io/x/gocv"
 )
 
@@ -42,286 +48,1004 @@ const (
 	name = "object-size-detector"
 	// topic is MQTT topic
 	topic = "defects/counter"
+	// statusTopic is MQTT topic program status messages are published to
+	statusTopic = "defects/status"
+	// eventsTopic is MQTT topic part events, such as stuck parts, are published to
+	eventsTopic = "defects/events"
+	// alarmsTopic is MQTT topic confirmed defects and belt jam state are published to
+	alarmsTopic = "defects/alarms"
+	// frameSendTimeout is how long the monitor loop waits for frameRunner to accept a frame
+	frameSendTimeout = 5 * time.Millisecond
+	// drainTimeout bounds publishing of the final analytics on shutdown
+	drainTimeout = 2 * time.Second
+	// maxPublishRate is number of seconds between analytics publishes above which a warning is logged
+	maxPublishRate = 60
+	// spoolRetryInterval is interval between attempts to deliver spooled messages
+	spoolRetryInterval = 5 * time.Second
 )
 
-var (
-	// deviceID is camera device ID
-	deviceID int
-	// input is path to image or video file
-	input string
-	// min is minimum part area of assembly object
-	min int
-	// max is maximum part area of assembly object
-	max int
-	// publish is a flag which instructs the program to publish data analytics
-	publish bool
-	// rate is number of seconds between analytics are collected and sent to a remote server
-	rate int
-	// delay is video play delay
-	delay float64
+const (
+	// PublishModeInterval publishes the analytics on every publish tick
+	PublishModeInterval = "interval"
+	// PublishModeOnChange publishes the analytics only when they change or the heartbeat expires
+	PublishModeOnChange = "on-change"
 )
 
-func init() {
-	flag.IntVar(&deviceID, "device", -1, "Camera device ID")
-	flag.StringVar(&input, "input", "", "Path to image or video file")
-	flag.IntVar(&min, "min", 20000, "Minimum part area of assembly object")
-	flag.IntVar(&max, "max", 30000, "Maximum part area of assembly object")
-	flag.BoolVar(&publish, "publish", false, "Publish data analytics to a remote server")
-	flag.IntVar(&rate, "rate", 1, "Number of seconds between analytics are sent to a remote server")
-	flag.Float64Var(&delay, "delay", 5.0, "Video playback delay")
+// mqttMessage is a message published to MQTT topic
+type mqttMessage struct {
+	// topic is MQTT topic the message is published to
+	topic string
+	// payload is message payload
+	payload string
 }
 
-// Status stores assembly line part status
-type Status struct {
-	// Seen means part was detected
-	Seen bool
-	// Defect means part has a defect
-	Defect bool
+// procSize returns size of the processing frame for the original frame size src and processing width.
+// The height is computed so the original aspect ratio is preserved.
+func procSize(src image.Point, width int) image.Point {
+	height := int(math.Round(float64(src.Y) * float64(width) / float64(src.X)))
+	return image.Point{width, height}
 }
 
-// Part is assembly line object
-type Part struct {
-	// now is current status of Part
-	now *Status
-	// prev is previous status of Part
-	prev *Status
-	// defectFrames is number of consecutive frames where part had a defect
-	defectFrames int
-	// okFrames is number of consecutive frames where part was ok
-	okFrames int
+// parseRes parses resolution in WxH format and returns it
+// It returns error if res is not in the WxH format or if either of the dimensions is not positive.
+func parseRes(res string) (image.Point, error) {
+	dims := strings.Split(strings.ToLower(res), "x")
+	if len(dims) != 2 {
+		return image.Point{}, fmt.Errorf("invalid resolution %q: expected WxH", res)
+	}
+
+	w, err := strconv.Atoi(dims[0])
+	if err != nil {
+		return image.Point{}, fmt.Errorf("invalid resolution width %q: %v", dims[0], err)
+	}
+
+	h, err := strconv.Atoi(dims[1])
+	if err != nil {
+		return image.Point{}, fmt.Errorf("invalid resolution height %q: %v", dims[1], err)
+	}
+
+	if w <= 0 || h <= 0 {
+		return image.Point{}, fmt.Errorf("invalid resolution %q: dimensions must be positive", res)
+	}
+
+	return image.Point{w, h}, nil
 }
 
-// Result is computation result returned to main goroutine
-type Result struct {
-	// Defect is used to signal the part defect was found.
-	Defect bool
-	// Rect is detected part rectangle area
-	Rect image.Rectangle
-	// TotalParts contains total number of detected parts
-	TotalParts int
-	// TotalDefects contains total number of defected parts
-	TotalDefects int
+// areaScale returns the factor areas measured at calibration resolution calib
+// must be multiplied by to match areas measured at processing resolution proc
+func areaScale(proc, calib image.Point) float64 {
+	return float64(proc.X) / float64(calib.X) * float64(proc.Y) / float64(calib.Y)
 }
 
-// String implements fmt.Stringer interface for Result
-func (r *Result) String() string {
-	return fmt.Sprintf("Total parts: %d, Total defects: %v", r.TotalParts, r.TotalDefects)
+// scaleArea scales area by factor and rounds it to the nearest integer
+func scaleArea(area int, factor float64) int {
+	return int(math.Round(float64(area) * factor))
 }
 
-// ToMQTTMessage turns result into MQTT message which can be published to MQTT broker
-func (r *Result) ToMQTTMessage() string {
-	return fmt.Sprintf("{\"Defect\":%v}", r.Defect)
+// scaleAreas returns detector configuration dc with its area range and area ranges of its zones scaled by factor
+func scaleAreas(dc DetectorConfig, factor float64) DetectorConfig {
+	dc.Min, dc.Max = scaleArea(dc.Min, factor), scaleArea(dc.Max, factor)
+	if len(dc.Zones) > 0 {
+		// zones are shared with the unscaled configuration
+		zones := make([]detector.Zone, len(dc.Zones))
+		for i, z := range dc.Zones {
+			z.Min, z.Max = scaleArea(z.Min, factor), scaleArea(z.Max, factor)
+			zones[i] = z
+		}
+		dc.Zones = zones
+	}
+
+	return dc
 }
 
-// messageRunner reads data published to pubChan with rate frequency and sends them to remote analytics server
-// doneChan is used to receive a signal from the main goroutine to notify the routine to stop and return
-func messageRunner(doneChan <-chan struct{}, pubChan <-chan *Result, c *MQTTClient, topic string, rate int) error {
-	ticker := time.NewTicker(time.Duration(rate) * time.Second)
+// origRect maps rect from processing frame coordinates back to original frame coordinates
+func origRect(rect image.Rectangle, scale float64) image.Rectangle {
+	if scale == 0 || rect.Empty() {
+		return rect
+	}
+
+	return image.Rect(
+		int(math.Round(float64(rect.Min.X)/scale)),
+		int(math.Round(float64(rect.Min.Y)/scale)),
+		int(math.Round(float64(rect.Max.X)/scale)),
+		int(math.Round(float64(rect.Max.Y)/scale)),
+	)
+}
+
+// messageRunner reads data published to pubChan with cfg.Rate frequency and sends them to remote analytics server
+// When the rolling defect rate of any source exceeds cfg.FastRateThreshold, the publish interval is shortened
+// to cfg.FastRateInterval until the rate falls below cfg.FastRateHysteresis.
+// Each publish is bounded by cfg.PublishTimeout derived from ctx.
+// doneChan is used to receive a signal from the main goroutine to notify the routine to stop;
+// it keeps draining pubChan until it's closed and publishes the final result of every video source before it returns.
+// The latest result of every video source is published on each tick.
+// In PublishModeOnChange a result is only published if it differs from the last result published for its video source
+// or if it was not published for cfg.Heartbeat seconds, so consumers can still tell the program is alive.
+// Messages received on msgChan, such as status messages and part events, are published to their topics.
+// Publisher c is replaced with publishers received on clientChan; messageRunner disconnects publishers it no longer uses.
+// If cfg.SpoolDir is set, messages which fail to publish are spooled to disk and they are published again in order
+// every spoolRetryInterval once the publisher is connected.
+// With stdout sink every result is published as soon as it's received and no closing totals are published.
+// The publish rate and statistics of the active publisher are registered in metrics and set in status, and every
+// cfg.Heartbeat seconds the statistics are published to statusTopic in a heartbeat message.
+// Publishers which can subscribe to topics deliver operator commands to commander.
+// Reports of reporter are published at the times of cfg.Reports and the pending report is flushed on shutdown;
+// reporter can be nil if the reports are disabled.
+// Publishing of results detected in traced frames is traced by tracer in spans linked to the frame spans;
+// tracer can be nil if tracing is disabled.
+func messageRunner(ctx context.Context, cfg Config, doneChan <-chan struct{}, pubChan <-chan *detector.Result,
+	msgChan <-chan mqttMessage, clientChan <-chan Publisher, c Publisher, topic string, metrics *Metrics, status *StatusHandler,
+	commander *Commander, reporter *Reporter, tracer *Tracer) error {
+	interval := time.Duration(cfg.Rate) * time.Second
+	fastInterval := time.Duration(cfg.FastRateInterval) * time.Millisecond
+	ticker := time.NewTicker(interval)
+	timeout := time.Duration(cfg.PublishTimeout) * time.Millisecond
+	fast := false
+	// analytics are outdated by the next tick so by default they're published with QoS 0 and not persisted
+	statsQoS := byte(cfg.AnalyticsQoS)
+
+	// stream publishes every result rather than the latest one every tick
+	stream := cfg.Sink == SinkStdout
+	disconnect := time.Duration(cfg.MQTTDisconnect) * time.Millisecond
+	heartbeat := time.Duration(cfg.Heartbeat) * time.Second
+	registerPublisher(metrics, status, c)
+	subscribeCommands(c, commander)
+
+	// the heartbeat reports the publish statistics, so silently degrading MQTT connection shows before data is missing
+	heartbeatTicker := time.NewTicker(heartbeat)
+	defer func() {
+		ticker.Stop()
+		heartbeatTicker.Stop()
+		closePublisher(c, disconnect)
+	}()
+
+	// reportTimer fires when the next report is due; reportC is nil if the reports are disabled
+	var reportTimer *time.Timer
+	var reportC <-chan time.Time
+	if reporter != nil {
+		now := reporter.now()
+		reportTimer = time.NewTimer(cfg.Reports.Next(now).Sub(now))
+		defer reportTimer.Stop()
+		reportC = reportTimer.C
+	}
+
+	// spool stores messages which failed to publish; retry is nil if spooling is disabled
+	var spool *Spool
+	var retry <-chan time.Time
+	if cfg.SpoolDir != "" {
+		var err error
+		if spool, err = OpenSpool(cfg.SpoolDir, cfg.SpoolSize); err != nil {
+			return err
+		}
+		if spool.Len() > 0 {
+			fmt.Printf("Spool contains %d undelivered messages\n", spool.Len())
+		}
+		retryTicker := time.NewTicker(spoolRetryInterval)
+		defer retryTicker.Stop()
+		retry = retryTicker.C
+	}
+	// spoolFailed spools message id with payload which failed to publish to topic if spooling is enabled
+	spoolFailed := func(id, topic, payload string) {
+		if spool == nil {
+			return
+		}
+		if err := spool.Append(id, topic, payload); err != nil {
+			fmt.Fprintf(os.Stderr, "Error spooling message to %s: %v\n", topic, err)
+		}
+	}
+
+	// latest stores the latest result of each video source and zone received since the last tick
+	latest := make(map[string]*detector.Result)
+	// final stores the latest result of each video source and zone; it's published on shutdown
+	final := make(map[string]*detector.Result)
+	// published stores the last published result of each video source and zone and when it was published
+	published := make(map[string]*detector.Result)
+	publishedAt := make(map[string]time.Time)
 
 	for {
 		select {
 		case <-ticker.C:
-			result := <-pubChan
-			_, err := c.Publish(topic, result.ToMQTTMessage())
-			// TODO: decide whether to return with error and stop program;
-			// For now we just signal there was an error and carry on
+			for key, result := range latest {
+				delete(latest, key)
+				if !publishDue(cfg.PublishMode, result, published[key], time.Since(publishedAt[key]), heartbeat) {
+					continue
+				}
+				result.PublishRate = float64(time.Second) / float64(interval)
+				if spool != nil {
+					result.MessageID = spool.NextID()
+				}
+				payload := result.Payload(cfg.MQTTEncoding)
+				var span *Span
+				if link := ParseTraceParent(result.TraceParent); link.Valid() {
+					span = tracer.Root("publish", time.Now(), link)
+					span.SetString("source", key)
+					span.SetInt("frame.seq", int64(result.Seq))
+				}
+				pubCtx, cancel := context.WithTimeout(ctx, timeout)
+				err := c.PublishQoS(pubCtx, topic, payload, statsQoS)
+				cancel()
+				if err != nil {
+					span.SetString("error", err.Error())
+				}
+				span.End()
+				// TODO: decide whether to return with error and stop program;
+				// For now we just signal there was an error and carry on
+				if err != nil {
+					fmt.Printf("Error publishing message to %s: %v", topic, err)
+					spoolFailed(result.MessageID, topic, payload)
+					continue
+				}
+				published[key], publishedAt[key] = result, time.Now()
+			}
+		case result, ok := <-pubChan:
+			if !ok {
+				// all frameRunners have stopped: publish closing totals unless they were streamed already
+				if !stream {
+					publishFinal(c, topic, cfg.MQTTEncoding, final)
+				}
+				// the parts of the unfinished period would be lost otherwise
+				if reporter != nil {
+					if err := publishReport(context.Background(), c, topic, reporter.Report(true), drainTimeout); err != nil {
+						fmt.Printf("Error publishing final report to %s: %v\n", topic+reportSubtopic, err)
+					}
+				}
+				return nil
+			}
+			if stream {
+				if err := c.PublishContext(ctx, topic, result.Payload(cfg.MQTTEncoding)); err != nil {
+					fmt.Fprintf(os.Stderr, "Error writing result to %s: %v\n", cfg.Sink, err)
+				}
+				continue
+			}
+			// we only keep the latest result in between ticker times
+			key := zoneName(result.Source, result.Zone)
+			latest[key] = result
+			final[key] = result
+
+			// switch publish interval when defect rate crosses the thresholds
+			switch {
+			case !fast && result.DefectRate > cfg.FastRateThreshold:
+				fast, interval = true, fastInterval
+			case fast && maxDefectRate(latest) < cfg.FastRateHysteresis:
+				fast, interval = false, time.Duration(cfg.Rate)*time.Second
+			default:
+				continue
+			}
+			fmt.Printf("Defect rate %.2f: publishing every %v\n", result.DefectRate, interval)
+			ticker.Stop()
+			ticker = time.NewTicker(interval)
+		case msg := <-msgChan:
+			var id string
+			if spool != nil {
+				id = spool.NextID()
+				msg.payload = withMessageID(msg.payload, id)
+			}
+			pubCtx, cancel := context.WithTimeout(ctx, timeout)
+			qos := byte(QOS)
+			if msg.topic == alarmsTopic {
+				qos = byte(cfg.AlertQoS)
+			}
+			err := c.PublishQoS(pubCtx, msg.topic, msg.payload, qos)
+			cancel()
+			if err != nil {
+				fmt.Printf("Error publishing message to %s: %v", msg.topic, err)
+				spoolFailed(id, msg.topic, msg.payload)
+			}
+		case <-reportC:
+			rep := reporter.Report(false)
+			if err := publishReport(ctx, c, topic, rep, timeout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error publishing report to %s: %v\n", topic+reportSubtopic, err)
+			} else {
+				fmt.Printf("Published report of %d parts and %d defects since %s\n", rep.Parts, rep.Defects,
+					rep.PeriodStart.Format("15:04"))
+			}
+			now := reporter.now()
+			reportTimer.Reset(cfg.Reports.Next(now).Sub(now))
+		case <-heartbeatTicker.C:
+			if stream {
+				continue
+			}
+			s := c.Stats()
+			pubCtx, cancel := context.WithTimeout(ctx, timeout)
+			err := c.PublishContext(pubCtx, statusTopic, fmt.Sprintf("{\"Status\":\"heartbeat\",\"Connected\":%v,"+
+				"\"PublishesAttempted\":%d,\"PublishesSucceeded\":%d,\"PublishesFailed\":%d,\"PublishesTimedOut\":%d,"+
+				"\"BytesSent\":%d,\"Reconnects\":%d,\"CorruptFrames\":%d}", s.Connected, s.Attempted, s.Succeeded, s.Failed,
+				s.TimedOut, s.BytesSent, s.Reconnects, atomic.LoadUint64(&CorruptFrames)))
+			cancel()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error publishing heartbeat to %s: %v\n", statusTopic, err)
+			}
+		case <-retry:
+			if spool.Len() == 0 || !c.Connected() {
+				continue
+			}
+			n, err := spool.Flush(ctx, c, timeout)
 			if err != nil {
-				fmt.Printf("Error publishing message to %s: %v", topic, err)
+				fmt.Fprintf(os.Stderr, "Error updating spool: %v\n", err)
 			}
-		case <-pubChan:
-			// we discard messages in between ticker times
+			fmt.Printf("Delivered %d spooled messages; %d remaining\n", n, spool.Len())
+		case newClient := <-clientChan:
+			fmt.Printf("Switching to reconfigured MQTT client\n")
+			closePublisher(c, disconnect)
+			c = newClient
+			registerPublisher(metrics, status, c)
+			subscribeCommands(c, commander)
 		case <-doneChan:
-			fmt.Printf("Stopping messageRunner: received stop signal\n")
-			return nil
+			fmt.Printf("Stopping messageRunner: received stop signal; draining analytics\n")
+			// keep receiving results until frameRunners close pubChan
+			ticker.Stop()
+			doneChan = nil
 		}
 	}
 }
 
-// detectStatus detects part status from the blob and returns it
-func detectStatus(blob *image.Rectangle) *Status {
-	area := blob.Size().X * blob.Size().Y
-	// we assume no part is detected; therefore there is no defect
-	status := &Status{
-		Defect: false,
-		Seen:   false,
-	}
+// publishFinal publishes the final results of every video source encoded using encoding to topic using client c
+// Publishing is bounded by drainTimeout so the shutdown can't hang on unresponsive MQTT server.
+func publishFinal(c Publisher, topic, encoding string, results map[string]*detector.Result) {
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
 
-	if area != 0 {
-		status.Seen = true
-		// defected part
-		if area > max || area < min {
-			status.Defect = true
-			return status
+	for _, result := range results {
+		if err := c.PublishContext(ctx, topic, result.Payload(encoding)); err != nil {
+			fmt.Printf("Error publishing final message to %s: %v\n", topic, err)
 		}
-		// no defect
-		return status
 	}
+}
 
-	// no part detected
-	return status
+// registerPublisher registers the publish rate and statistics of publisher c in metrics
+// and makes it the publisher whose statistics status serves
+func registerPublisher(metrics *Metrics, status *StatusHandler, c Publisher) {
+	metrics.Gauge("osd_mqtt_publish_rate", "Measured number of MQTT messages published per second", c.PublishRate)
+	counters := []struct {
+		name, help string
+		value      func(PublishStats) uint64
+	}{
+		{"osd_mqtt_publishes_attempted_total", "Number of attempted MQTT publishes", func(s PublishStats) uint64 { return s.Attempted }},
+		{"osd_mqtt_publishes_succeeded_total", "Number of successful MQTT publishes", func(s PublishStats) uint64 { return s.Succeeded }},
+		{"osd_mqtt_publishes_failed_total", "Number of MQTT publishes which failed", func(s PublishStats) uint64 { return s.Failed }},
+		{"osd_mqtt_publishes_timed_out_total", "Number of MQTT publishes which timed out", func(s PublishStats) uint64 { return s.TimedOut }},
+		{"osd_mqtt_sent_bytes_total", "Number of payload bytes of the successful MQTT publishes", func(s PublishStats) uint64 { return s.BytesSent }},
+		{"osd_mqtt_reconnects_total", "Number of reconnections to the MQTT server", func(s PublishStats) uint64 { return s.Reconnects }},
+	}
+	for _, m := range counters {
+		value := m.value
+		metrics.Counter(m.name, m.help, func() float64 { return float64(value(c.Stats())) })
+	}
+	metrics.Gauge("osd_mqtt_connected", "1 if the MQTT client is connected, 0 otherwise", func() float64 {
+		if c.Stats().Connected {
+			return 1
+		}
+		return 0
+	})
+
+	status.SetPublisher(c)
+}
+
+// checkScene checks scene of video source named source in frame img and publishes changes of the camera blocked
+// and line stalled state to msgChan; msgChan can be nil if publishing is disabled
+func checkScene(source string, scene *SceneMonitor, img gocv.Mat, msgChan chan<- mqttMessage) {
+	blockedChanged, stalledChanged := scene.Check(img, time.Now())
+	if blockedChanged {
+		if scene.Blocked {
+			fmt.Fprintf(os.Stderr, "Warning: camera of %s is blocked; mean brightness is %.1f\n", source, scene.Brightness)
+		} else {
+			fmt.Printf("Camera of %s is no longer blocked\n", source)
+		}
+		select {
+		case msgChan <- mqttMessage{statusTopic, fmt.Sprintf("{\"Status\":\"camera_blocked\",\"Source\":%q,\"Active\":%v,\"Brightness\":%g}",
+			source, scene.Blocked, scene.Brightness)}:
+		default:
+		}
+	}
+	if stalledChanged {
+		if scene.Stalled {
+			fmt.Fprintf(os.Stderr, "Warning: line seen by %s is stalled; the frame stays static\n", source)
+		} else {
+			fmt.Printf("Line seen by %s moves again\n", source)
+		}
+		select {
+		case msgChan <- mqttMessage{statusTopic, fmt.Sprintf("{\"Status\":\"line_stalled\",\"Source\":%q,\"Active\":%v}",
+			source, scene.Stalled)}:
+		default:
+		}
+	}
 }
 
-// detectBlob detects assembly line part in img image and returns it
-func detectBlob(img *gocv.Mat) image.Rectangle {
-	size := image.Point{3, 3}
+// closePublisher closes publisher c waiting up to timeout for its pending messages and logs any error
+func closePublisher(c Publisher, timeout time.Duration) {
+	if err := c.Close(timeout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error closing MQTT client: %v\n", err)
+	}
+}
 
-	// convert to gray and blur
-	gocv.CvtColor(*img, img, gocv.ColorBGRToGray)
-	gocv.GaussianBlur(*img, img, size, 0, 0, gocv.BorderDefault)
+// maxDefectRate returns the highest defect rate of results
+func maxDefectRate(results map[string]*detector.Result) float64 {
+	rate := 0.0
+	for _, r := range results {
+		if r.DefectRate > rate {
+			rate = r.DefectRate
+		}
+	}
 
-	// Morphology: OPEN -> CLOSE -> OPEN
-	// MORPH_OPEN removes the noise and closes the "holes" in the background
-	// MORPH_CLOSE remove the noise and closes the "holes" in the foreground
-	gocv.MorphologyEx(*img, img, gocv.MorphOpen, gocv.GetStructuringElement(gocv.MorphEllipse, size))
-	gocv.MorphologyEx(*img, img, gocv.MorphClose, gocv.GetStructuringElement(gocv.MorphEllipse, size))
-	gocv.MorphologyEx(*img, img, gocv.MorphOpen, gocv.GetStructuringElement(gocv.MorphEllipse, size))
+	return rate
+}
 
-	// threshold the image to emphasize assembly part
-	gocv.Threshold(*img, img, 200, 255, gocv.ThresholdBinary)
-	// find the contours of assembly part
-	contours := gocv.FindContours(*img, gocv.RetrievalExternal, gocv.ChainApproxNone)
+// publishDue reports whether result is published in publish mode given prev, the last published result of its
+// video source, published elapsed ago: in PublishModeOnChange only changed results are published
+// unless the last publish is older than heartbeat
+func publishDue(mode string, result, prev *detector.Result, elapsed, heartbeat time.Duration) bool {
+	if mode != PublishModeOnChange {
+		return true
+	}
 
-	// part will be the biggest contour area
-	var maxRect image.Rectangle
-	maxArea := 0
+	return result.Changed(prev) || elapsed >= heartbeat
+}
 
-	for i := range contours {
-		rect := gocv.BoundingRect(contours[i])
-		area := rect.Size().X * rect.Size().Y
-		// is large enough, and completely within the camera with no overlapping edges
-		if area > maxArea && rect.In(image.Rect(0, 0, img.Cols(), img.Rows())) && rect.Size().X > 30 {
-			maxArea = area
-			maxRect = rect
-		}
+// frameBudget returns frame period of video source capturing at frame rate fps
+// It's 0, i.e. frames are never late, if the frame rate is unknown.
+func frameBudget(fps float64) time.Duration {
+	if fps <= 0 {
+		return 0
 	}
 
-	return maxRect
+	return time.Duration(float64(time.Second) / fps)
 }
 
-// frameRunner reads image frames from framesChan and performs face and sentiment detections on them
+// frameRunner reads image frames of video source named source from framesChan and detects
+// assembly line parts in them using cfg; results are tagged with the source name
+// If zones are configured, parts are detected, tracked and counted in every zone independently: results of the zones
+// are sent to pubChan tagged with the zone name followed by their rollup, which is also sent to resultsChan.
 // doneChan is used to receive a signal from the main goroutine to notify frameRunner to stop and return
-func frameRunner(framesChan <-chan *frame, doneChan <-chan struct{},
-	resultsChan chan<- *Result, pubChan chan<- *Result) error {
+// alarm is fired whenever a part defect is confirmed; it can be nil if alarms are disabled
+// wd is notified about every processed frame; it can be nil if the watchdog is disabled
+// Detector configuration received on configChan replaces cfg.DetectorConfig before the next frame is processed;
+// it's a snapshot owned by frameRunner, so every frame is processed with a consistent configuration.
+// Detector configuration of another profile resets the part tracking and, with cfg.ProfileResetCounters, the totals.
+// Counter reset commands received on cmdChan reset the totals and defect rates in between frames.
+// Every new part is recorded in history and, together with the confirmed defects, in reporter; reporter can be nil
+// if the reports are disabled
+// beeper is beeped whenever a part defect is confirmed; it can be nil if the beep is disabled
+// Parts staying in view longer than cfg.MaxDwell are reported as stuck to msgChan; it can be nil if publishing is disabled
+// With stdout sink no result is skipped and with cfg.EventsOnly only results of the zones which confirmed a part are sent.
+// Detection and classification of traced frames are recorded as child spans of the frame span
+// and the results carry the frame span context, so publishing can be linked to it.
+func frameRunner(source string, cfg Config, framesChan <-chan *frame, configChan <-chan DetectorConfig, cmdChan <-chan Command,
+	doneChan <-chan struct{}, resultsChan chan<- *detector.Result, pubChan chan<- *detector.Result, msgChan chan<- mqttMessage,
+	alarm *Alarm, wd *Watchdog, history *PartHistory, histogram *AreaHistogram, reporter *Reporter, beeper *Beeper, state *State) error {
 
 	// frame is image frame
 	frame := new(frame)
-	// Result stores detection results
-	result := new(Result)
-	// Part is assembly object part
-	part := new(Part)
-	now, prev := new(Status), new(Status)
-	part.now, part.prev = now, prev
+	// zones detect parts in the zones of the frame; there is a single unnamed zone if no zones are configured
+	zones := newZoneDetectors(source, cfg, state.Restarts(), nil)
+	// total is rollup of the zone results; it's only used if zones are configured
+	total := &detector.Result{Source: source, RestartCount: state.Restarts(), FPS: cfg.FPS, Profile: cfg.Profile}
+	maxDwell := time.Duration(cfg.MaxDwell * float64(time.Second))
+	minDwellDefect, maxDwellDefect := int64(cfg.MinDwellMs), int64(cfg.MaxDwellMs)
+	presenceTimeout := time.Duration(cfg.PresenceTimeout) * time.Second
+	// frameNum is number of processed frames
+	frameNum := 0
+	// lastSeq is sequence number of the last processed frame
+	var lastSeq uint64
+	// budget is frame period; frames processed for longer than that make detection fall behind
+	budget := frameBudget(cfg.FPS)
+	// lateLogged is time when processing exceeding the frame period was last logged
+	var lateLogged time.Time
+	// scene checks whether the camera sees the line and the line moves; it's nil if the checks are disabled
+	var scene *SceneMonitor
+	if cfg.SceneCheckEvery > 0 {
+		scene = NewSceneMonitor(cfg)
+		defer scene.Close()
+	}
+	// validator tells corrupt frames from valid ones; it's nil if the frame validation is disabled
+	var validator *FrameValidator
+	if cfg.FrameValidation != "off" {
+		validator = NewFrameValidator(cfg)
+	}
+	// corrupt is number of consecutive corrupt frames
+	corrupt := 0
+	// shadows labels shadows for bgsub shadow suppression; it's created once the suppression is enabled
+	var shadows *detector.ShadowDetector
+	defer func() {
+		if shadows != nil {
+			shadows.Close()
+		}
+	}()
+
+	// results returns copies of the zone results followed by their rollup if zones are configured
+	// so receivers never see them change
+	results := func() []*detector.Result {
+		if len(cfg.Zones) == 0 {
+			r := *zones[0].result
+			return []*detector.Result{&r}
+		}
+
+		rs := make([]*detector.Result, 0, len(zones)+1)
+		for _, z := range zones {
+			r := *z.result
+			rs = append(rs, &r)
+		}
+		rollup(total, zones)
+		t := *total
+		return append(rs, &t)
+	}
 
 	for {
 		select {
 		case <-doneChan:
 			fmt.Printf("Stopping frameRunner: received stop signal\n")
-			// close results channel
-			close(resultsChan)
-			// close publish channel
-			if pubChan != nil {
-				close(pubChan)
+			// hand the closing totals over to messageRunner which drains pubChan until it's closed
+			if pubChan != nil && !cfg.EventsOnly {
+				for _, r := range results() {
+					pubChan <- r
+				}
 			}
+			// close results channel; publish channel is shared with other sources so main closes it
+			close(resultsChan)
 			return nil
+		case dc := <-configChan:
+			prev := cfg.Profile
+			cfg.DetectorConfig = dc
+			zones = newZoneDetectors(source, cfg, state.Restarts(), zones)
+			if dc.Profile == prev {
+				fmt.Printf("Applied reloaded detector configuration to %s\n", source)
+				break
+			}
+			// parts of the previous product must not be classified with the settings of the new one
+			for _, z := range zones {
+				z.resetPart()
+				if cfg.ProfileResetCounters {
+					z.resetCounters()
+				}
+			}
+			total.Profile = dc.Profile
+			if cfg.ProfileResetCounters {
+				total.TotalParts, total.TotalDefects, total.DefectRate = 0, 0, 0
+			}
+			fmt.Printf("Switched %s from profile %q to %q\n", source, prev, dc.Profile)
+			select {
+			case msgChan <- mqttMessage{eventsTopic, fmt.Sprintf("{\"Event\":\"profile_switched\",\"Source\":%q,\"Profile\":%q,"+
+				"\"PreviousProfile\":%q,\"CountersReset\":%v}", source, dc.Profile, prev, cfg.ProfileResetCounters)}:
+			default:
+			}
+		case cmd := <-cmdChan:
+			for _, z := range zones {
+				z.resetCounters()
+			}
+			total.TotalParts, total.TotalDefects, total.DefectRate = 0, 0, 0
+			fmt.Printf("Reset counters of %s\n", source)
+			select {
+			case msgChan <- mqttMessage{eventsTopic, fmt.Sprintf("{\"Event\":\"counters_reset\",\"Source\":%q,\"Reason\":%q,\"Profile\":%q}",
+				source, cmd.Reason, cfg.Profile)}:
+			default:
+			}
 		case frame = <-framesChan:
 			if frame == nil {
 				continue
 			}
-			// let's make a copy of the original
-			img := gocv.NewMat()
-			frame.img.CopyTo(&img)
+			start := time.Now()
+			// frame owns its image; we can process it in place
+			img := frame.img
+
+			// frames must be processed in the order they were captured
+			if frame.SeqNum <= lastSeq {
+				fmt.Fprintf(os.Stderr, "Warning: %s frame %d arrived after frame %d; skipping it\n",
+					source, frame.SeqNum, lastSeq)
+				frame.close()
+				frame.span.End()
+				continue
+			}
+			// the frames missing in the sequence were discarded by the pipeline, which has already logged them
+			if gap := frame.SeqNum - lastSeq - 1; gap > 0 {
+				atomic.AddUint64(&DroppedFrames, gap)
+			}
+			lastSeq = frame.SeqNum
+
+			// corrupt frames would be measured as bogus parts, so they never reach detection and part tracking
+			if validator != nil {
+				if err := validator.Check(*img); err != nil {
+					atomic.AddUint64(&CorruptFrames, 1)
+					if corrupt++; corrupt == 1 {
+						fmt.Fprintf(os.Stderr, "Warning: skipping corrupt %s frame %d: %v\n", source, frame.SeqNum, err)
+					}
+					if wd != nil {
+						wd.Touch()
+					}
+					frame.close()
+					frame.span.End()
+					continue
+				}
+				if corrupt > 1 {
+					fmt.Fprintf(os.Stderr, "Warning: skipped %d consecutive corrupt %s frames\n", corrupt, source)
+				}
+				corrupt = 0
+			}
 
-			// datect blob on assembly line
-			result.Rect = detectBlob(&img)
+			frameNum++
+			seq := state.Next(source)
+			total.Seq = seq
+			frame.span.SetInt("result.seq", int64(seq))
+			traceParent := frame.span.Context().TraceParent()
+			total.TraceParent = traceParent
+
+			// the scene is checked before detection thresholds the frame in place
+			if scene != nil {
+				checkScene(source, scene, *img, msgChan)
+				for _, z := range zones {
+					z.result.CameraBlocked, z.result.LineStalled = scene.Blocked, scene.Stalled
+				}
+				total.CameraBlocked, total.LineStalled = scene.Blocked, scene.Stalled
+			}
 
-			// detect status of the blob
-			part.now = detectStatus(&result.Rect)
+			// the background model must learn from every frame, so shadows are labeled before any zone is detected
+			var shadowFree *gocv.Mat
+			if cfg.Shadows == detector.ShadowsBGSub {
+				if shadows == nil {
+					shadows = detector.NewShadowDetector()
+				}
+				free := shadows.Apply(*img)
+				shadowFree = &free
+			}
 
-			if part.now.Seen {
-				// if part was detected add it to results
-				// increment part counters
-				if part.now.Defect {
-					part.defectFrames++
-				} else {
-					part.okFrames++
+			// keep the original frame for comparison with the thresholded image DetectBlob leaves in img
+			debug := cfg.DebugEvery > 0 && frameNum%cfg.DebugEvery == 0
+			var orig gocv.Mat
+			// shadowMask shows the effect of the shadow suppression; it's nil if the suppression is disabled
+			var shadowMask *gocv.Mat
+			if debug {
+				orig = img.Clone()
+				switch cfg.Shadows {
+				case detector.ShadowsHSV:
+					m := detector.HSVShadows(orig, cfg.DetectorConfig)
+					shadowMask = &m
+				case detector.ShadowsBGSub:
+					m := shadows.Shadows()
+					m = m.Clone()
+					shadowMask = &m
+				}
+			}
+
+			for _, z := range zones {
+				result, part := z.result, z.part
+				result.Seq = seq
+				result.TraceParent = traceParent
+				detect := frame.span.Child("detect", time.Now())
+				detect.SetString("zone", z.name)
+
+				// only the region of interest is searched if it's set; the region shares the frame data
+				target := img
+				roi, useROI := z.cfg.Region(img)
+				if useROI {
+					region := img.Region(roi)
+					target = &region
+				}
+
+				// datect blob on assembly line; zones outside of the frame never see a part
+				var partial bool
+				result.Rect, result.Centroid = image.Rectangle{}, image.Point{}
+				if useROI || z.name == "" {
+					// thresholded is the binary image the part is detected in
+					var thresholded gocv.Mat
+					if frame.mask != nil {
+						// the foreground mask replaces preprocessing of the frame
+						thresholded = *frame.mask
+						if useROI {
+							thresholded = thresholded.Region(roi)
+						}
+						result.Rect = detector.DetectBlobWithMask(target, &thresholded, z.cfg)
+						result.Centroid = result.Rect.Min.Add(result.Rect.Max).Div(2)
+						partial = detector.Partial(result.Rect, image.Point{thresholded.Cols(), thresholded.Rows()}, z.cfg)
+					} else {
+						zcfg := z.cfg
+						zcfg.ShadowFree = shadowFree
+						result.Rect, result.Centroid, partial = detector.DetectBlob(target, zcfg, z.filter)
+						thresholded = *target
+					}
+
+					if debug {
+						err := dumpDebugFrames(cfg.DebugFramesDir, filepath.Join(source, z.name), frameNum, orig, thresholded,
+							shadowMask, result.Rect, cfg.DebugAnnotate)
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "Failed to dump debug frames: %v\n", err)
+						}
+					}
+					if frame.mask != nil && useROI {
+						thresholded.Close()
+					}
 				}
 
-				if part.prev.Seen {
-					// if the previously seen part has had no defect detected
-					// in 10 previous consecutive frames reset its defetFrames counter
-					if !part.now.Defect && part.okFrames > 10 {
-						part.defectFrames = 0
+				// move the part from region to frame coordinates
+				if useROI {
+					target.Close()
+					if !result.Rect.Empty() {
+						result.Rect = result.Rect.Add(roi.Min)
+						result.Centroid = result.Centroid.Add(roi.Min)
+					}
+				}
+				result.OrigRect = origRect(result.Rect, cfg.Scale)
+				result.Min, result.Max = z.cfg.Min, z.cfg.Max
+				detect.SetInt("area", int64(detector.Area(result.Rect)))
+				detect.End()
+
+				// detect status of the blob
+				classify := frame.span.Child("classify", time.Now())
+				classify.SetString("zone", z.name)
+				part.Now = detector.DetectStatus(&result.Rect, partial, z.cfg)
+				result.Partial = part.Now.Partial
+				// gradual dimensional drift shows in the area extremes of the part across its frames
+				part.Measure(part.Now.Area)
+
+				// track how long the part stays in view
+				now := time.Now()
+				dwell, left := part.UpdateDwell(part.Now.Seen, now)
+				result.DwellMs = int64(dwell / time.Millisecond)
+				if part.Now.Seen {
+					if maxDwell > 0 && dwell > maxDwell && !part.Stuck {
+						part.Stuck = true
+						fmt.Printf("Part stuck in view of %s for %v\n", zoneName(source, z.name), dwell)
+						// part events are only published if messageRunner keeps up
+						select {
+						case msgChan <- mqttMessage{eventsTopic, fmt.Sprintf("{\"Event\":\"stuck\",\"Source\":%q,\"Zone\":%q,\"Dwell\":%g,\"Profile\":%q}",
+							source, z.name, dwell.Seconds(), cfg.Profile)}:
+						default:
+						}
 					}
-					// if previously seen part has had a defect detected
-					// in 10 consecutive frames mark the part as defected
-					if part.now.Defect && part.defectFrames > 10 {
-						// if it didn't have a defect already
-						if !result.Defect {
-							// set defect and increment total defect count
-							result.Defect = true
+				} else if left {
+					result.Dwell = dwell.Seconds()
+					z.dwells.Add(result.Dwell)
+					result.AvgDwell = z.dwells.Mean()
+					select {
+					case msgChan <- mqttMessage{eventsTopic, fmt.Sprintf("{\"Event\":\"left\",\"Source\":%q,\"Zone\":%q,\"Dwell\":%g,\"Profile\":%q}",
+						source, z.name, result.Dwell, cfg.Profile)}:
+					default:
+					}
+
+					// the part moved too fast or too slow; parts with confirmed defect and parts which were never
+					// counted at the trigger line are not counted again
+					if (minDwellDefect > 0 && result.DwellMs < minDwellDefect) ||
+						(maxDwellDefect > 0 && result.DwellMs > maxDwellDefect) {
+						fmt.Printf("Part stayed in view of %s for %d ms; expected [%d - %d] ms\n",
+							zoneName(source, z.name), result.DwellMs, minDwellDefect, maxDwellDefect)
+						if part.Tracker.State() != detector.PartConfirmedDefect && (z.cfg.Trigger == nil || part.Crossing.Crossed) {
 							result.TotalDefects++
+							z.defects.MarkLast()
+							history.MarkDefect(source, z.name)
+							if reporter != nil {
+								reporter.AddDefect(DefectDwell)
+							}
+							select {
+							case msgChan <- mqttMessage{alarmsTopic, fmt.Sprintf("{\"Source\":%q,\"Zone\":%q,\"Defect\":true,\"Reason\":\"dwell\","+
+								"\"DwellMs\":%d,\"Seq\":%d,\"Profile\":%q}", source, z.name, result.DwellMs, result.Seq, cfg.Profile)}:
+							default:
+							}
+							if beeper != nil {
+								beeper.Beep()
+							}
 						}
-						// part as a defect; reset okFrames count
-						part.okFrames = 0
 					}
-				} else {
-					// We havent seen the part before:
-					// increment total count of all detected parts
+				}
+
+				// flag belt jam when no part has been seen for too long and clear it once a part shows up
+				if part.Now.Seen {
+					z.lastPartSeen = now
+				}
+				if jam := presenceTimeout > 0 && now.Sub(z.lastPartSeen) > presenceTimeout; jam != result.BeltJam {
+					result.BeltJam = jam
+					event := "part_present"
+					if jam {
+						event = "part_missing"
+						fmt.Printf("No part seen by %s for %v\n", zoneName(source, z.name), now.Sub(z.lastPartSeen))
+					}
+					for _, msg := range []mqttMessage{
+						{eventsTopic, fmt.Sprintf("{\"Event\":%q,\"Source\":%q,\"Zone\":%q,\"Profile\":%q}",
+							event, source, z.name, cfg.Profile)},
+						{alarmsTopic, fmt.Sprintf("{\"Source\":%q,\"Zone\":%q,\"BeltJam\":%v,\"Profile\":%q}",
+							source, z.name, jam, cfg.Profile)},
+					} {
+						select {
+						case msgChan <- msg:
+						default:
+						}
+					}
+				}
+
+				part.Tracker.Config = z.cfg
+				update := part.Tracker.Update(part.Now)
+				z.confirmed = update.DefectConfirmed || update.OKConfirmed
+				// count increments total count of all detected parts and records the part in view;
+				// confirmed means its defect is already confirmed
+				count := func(confirmed bool) {
 					result.TotalParts++
+					z.defects.Add(confirmed)
+					class := part.Now.Severity
+					if confirmed {
+						class = detector.SeverityDefect
+					}
+					history.Add(PartEntry{
+						Timestamp: time.Now(),
+						Source:    source,
+						Area:      detector.Area(result.Rect),
+						Defect:    part.Now.Defect || confirmed,
+						Class:     class.String(),
+						Seq:       result.Seq,
+						Zone:      z.name,
+					})
+					histogram.Add(detector.Area(result.Rect))
+					if reporter != nil {
+						reporter.AddPart(detector.Area(result.Rect))
+					}
 				}
-			} else {
-				// no part detected -- empty belt: reset counts
-				result.Defect = false
-				part.okFrames = 0
-				part.defectFrames = 0
+				if update.Counted && z.cfg.Trigger == nil {
+					// a new part came fully into view
+					count(false)
+				}
+				if update.DefectConfirmed {
+					// set defect and increment total defect count
+					result.Defect = true
+					result.TotalDefects++
+					id, err := newUUID()
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "Failed to generate defect event ID: %v\n", err)
+					}
+					result.EventID = id
+					result.Confidence = part.Tracker.Confidence(result.Min, result.Max)
+					if reporter != nil {
+						reporter.AddDefect(defectType(detector.Area(result.Rect), result.Min))
+					}
+					// parts counted at the trigger line are recorded with their defect once they cross it
+					if z.cfg.Trigger == nil || part.Crossing.Crossed {
+						z.defects.MarkLast()
+						history.MarkDefect(source, z.name)
+					}
+					// areas of the confirmation window let the decision be audited
+					windowMin, windowMax, windowMean := part.Tracker.WindowAreas()
+					select {
+					case msgChan <- mqttMessage{alarmsTopic, fmt.Sprintf("{\"Source\":%q,\"Zone\":%q,\"Defect\":true,\"EventID\":%q,\"Seq\":%d,\"Confidence\":%g,"+
+						"\"WindowMinArea\":%d,\"WindowMaxArea\":%d,\"WindowMeanArea\":%g,\"Profile\":%q}",
+						source, z.name, id, result.Seq, result.Confidence, windowMin, windowMax, windowMean, cfg.Profile)}:
+					default:
+					}
+					if alarm != nil {
+						event := NewAlarmEvent(result)
+						event.WindowMinArea, event.WindowMaxArea, event.WindowMeanArea = windowMin, windowMax, windowMean
+						alarm.Fire(event)
+					}
+					if beeper != nil {
+						beeper.Beep()
+					}
+				}
+				if update.OKConfirmed {
+					result.Confidence = part.Tracker.Confidence(result.Min, result.Max)
+				}
+				if update.Left {
+					// empty belt: the next part has no defect until it's confirmed
+					result.Defect = false
+					result.EventID = ""
+					result.Confidence = 0
+					histogram.AddExtremes(part.MinArea, part.MaxArea)
+					part.MinArea, part.MaxArea = 0, 0
+					part.Crossing.Reset()
+				}
+				result.PartMinArea, result.PartMaxArea = part.MinArea, part.MaxArea
+
+				// count frames since the defect was confirmed
+				if result.Defect {
+					result.DefectAge++
+				} else {
+					result.DefectAge = 0
+				}
+
+				result.DefectRate = z.defects.Rate()
+
+				// confirmed defect trumps the frame severity; unconfirmed defect is only a warning
+				switch {
+				case result.Defect:
+					result.Severity = detector.SeverityDefect
+				case part.Now.Severity == detector.SeverityDefect:
+					result.Severity = detector.SeverityWarn
+				default:
+					result.Severity = part.Now.Severity
+				}
+				// the part crossing the trigger line is counted with its classification at that moment
+				seen := part.Now.Seen && !result.Rect.Empty()
+				if z.cfg.Trigger != nil && part.Crossing.Update(*z.cfg.Trigger, result.Centroid, seen) {
+					count(result.Defect)
+					select {
+					case msgChan <- mqttMessage{eventsTopic, fmt.Sprintf("{\"Event\":\"crossed\",\"Source\":%q,\"Zone\":%q,\"Seq\":%d,"+
+						"\"Area\":%d,\"Defect\":%v,\"Class\":%q,\"EventID\":%q,\"Profile\":%q}", source, z.name, result.Seq,
+						detector.Area(result.Rect), result.Defect, result.Severity, result.EventID, cfg.Profile)}:
+					default:
+					}
+				}
+
+				classify.SetString("severity", result.Severity.String())
+				classify.End()
+			}
+
+			if debug {
+				orig.Close()
+				if shadowMask != nil {
+					shadowMask.Close()
+				}
+			}
+
+			if wd != nil {
+				wd.Touch()
 			}
 
-			// send data down the channels
-			resultsChan <- result
-			if pubChan != nil {
-				pubChan <- result
+			elapsed := time.Since(start)
+			for _, z := range zones {
+				z.result.ProcessingTimeNs = elapsed.Nanoseconds()
+			}
+			total.ProcessingTimeNs = elapsed.Nanoseconds()
+			ProcessingDuration.Observe(elapsed.Seconds())
+			atomic.AddUint64(&ProcessedFrames, 1)
+			atomic.StoreInt64(&LastFrameAt, time.Now().UnixNano())
+			if budget > 0 && elapsed > budget && time.Since(lateLogged) >= time.Second {
+				fmt.Fprintf(os.Stderr, "Warning: %s frame %d took %v to process; %v over the frame period\n",
+					source, frame.SeqNum, elapsed, elapsed-budget)
+				lateLogged = time.Now()
 			}
 
-			// set prev status to current
-			part.prev = part.now
-			// close image matrices
-			img.Close()
+			// the last result is the result of the whole video source
+			rs := results()
+			resultsChan <- rs[len(rs)-1]
+			switch {
+			case pubChan == nil:
+			case cfg.Sink == SinkStdout:
+				// the stream must not lose results, so it's waited for; the zone results precede their rollup
+				for i, r := range rs {
+					if !cfg.EventsOnly || i < len(zones) && zones[i].confirmed {
+						pubChan <- r
+					}
+				}
+			default:
+				for _, r := range rs {
+					// messageRunner only publishes latest results, so skip it if it's busy
+					select {
+					case pubChan <- r:
+					default:
+					}
+				}
+			}
+
+			// close frame image matrices
+			frame.close()
+			frame.span.End()
 		}
 	}
 }
 
-// NewCapture creates new video capture from input or camera backend if input is empty and returns it.
-// If input is not empty, NewCapture adjusts delay parameter so video playback matches FPS in the video file.
-// It fails with error if it either can't open the input video file or the video device
-func NewCapture(input string, deviceID int, delay *float64) (*gocv.VideoCapture, error) {
-	if input != "" {
-		// open video file
-		vc, err := gocv.VideoCaptureFile(input)
-		if err != nil {
-			return nil, err
-		}
+// zoneName returns name of zone of video source used in log messages; it's the source name if zone is empty
+func zoneName(source, zone string) string {
+	if zone == "" {
+		return source
+	}
 
-		fps := vc.Get(gocv.VideoCaptureFPS)
-		*delay = 1000 / fps
+	return source + "/" + zone
+}
 
-		return vc, nil
+// frameSize returns the size of frames produced by video capture vc.
+// If the capture does not report its frame size, frameSize reads a frame into img and measures it.
+// It returns error if the frame size can't be determined.
+func frameSize(vc Capture, img *gocv.Mat) (image.Point, error) {
+	size := image.Point{int(vc.Get(gocv.VideoCaptureFrameWidth)), int(vc.Get(gocv.VideoCaptureFrameHeight))}
+	if size.X > 0 && size.Y > 0 {
+		return size, nil
 	}
 
-	// open camera device
-	vc, err := gocv.VideoCaptureDevice(deviceID)
-	if err != nil {
-		return nil, err
+	if ok := vc.Read(img); !ok || img.Empty() {
+		return image.Point{}, fmt.Errorf("failed to read frame from video capture")
 	}
 
-	return vc, nil
+	return image.Point{img.Cols(), img.Rows()}, nil
 }
 
 // NewMQTTPublisher creates new MQTT client which collects analytics data and publishes them to remote MQTT server.
-// It attempts to make a connection to the remote server and if successful it return the client handler
+// It attempts to make a connection to the remote server configured by cfg and if successful it return the client handler
 // It returns error if either the connection to the remote server failed or if the client config is invalid.
-func NewMQTTPublisher() (*MQTTClient, error) {
+func NewMQTTPublisher(cfg MQTTConfig) (*MQTTClient, error) {
 	// create MQTT client and connect to MQTT server
-	opts, err := MQTTClientOptions()
+	opts, err := NewMQTTClientOptions(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -337,92 +1061,485 @@ func NewMQTTPublisher() (*MQTTClient, error) {
 
 // frame ise used to send video frames and program configuration to upstream goroutines
 type frame struct {
-	// img is image frame
+	// img is image frame; it's owned by the frame receiver which must close it
 	img *gocv.Mat
+	// SeqNum is sequence number of the frame within its video source; it starts at 1
+	SeqNum uint64
+	// span is root span of the frame; it's nil if the frame is not traced
+	span *Span
+	// mask is binary foreground mask captured with the frame; it's nil if the mask camera is disabled
+	mask *gocv.Mat
 }
 
-func main() {
+// close closes the frame images
+func (f *frame) close() {
+	f.img.Close()
+	if f.mask != nil {
+		f.mask.Close()
+	}
+}
+
+// CorruptFrames counts frames of all video sources which were skipped by detection because they were corrupt
+var CorruptFrames uint64
+
+// DroppedFrames counts frames of all video sources which never reached their frameRunner
+// It must be accessed atomically.
+var DroppedFrames uint64
+
+// ProcessedFrames counts frames of all video sources which went through detection
+// It must be accessed atomically.
+var ProcessedFrames uint64
+
+// LastFrameAt is Unix time in nanoseconds when a frame of any video source last went through detection;
+// it's 0 until the first frame. It must be accessed atomically.
+var LastFrameAt int64
+
+// ProcessingDuration is histogram of seconds frameRunners of all video sources took to process a frame
+var ProcessingDuration = NewHistogram(0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1)
+
+// run runs the detection of video sources configured by command line arguments args
+// It returns program exit code.
+func run(args []string) int {
 	// parse cli flags
-	flag.Parse()
-	// create new video capture
-	vc, err := NewCapture(input, deviceID, &delay)
+	flag.Usage = usage
+	cfg, err := LoadConfig(flag.CommandLine, args)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating new video capture: %v\n", err)
-		os.Exit(1)
+		// single image mode reserves exit code 1 for defects, so all configuration errors exit with exitConfig
+		msg, _ := explain(err)
+		fmt.Fprintf(os.Stderr, "Invalid configuration: %s\n", msg)
+		return exitConfig
 	}
-	defer vc.Close()
 
-	// frames channel provides the source of images to process
-	framesChan := make(chan *frame, 1)
+	if cfg.ConfigPrint {
+		if err := printConfig(os.Stdout, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	// stdout sink owns stdout, so the logs written to stdout go to stderr instead
+	stdout := os.Stdout
+	if cfg.Sink == SinkStdout {
+		os.Stdout = os.Stderr
+	}
+
+	if cfg.SelfTest {
+		if err := checkSynthetic(cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "Self-test FAILED: %v\n", err)
+			return selfTestFailed
+		}
+		fmt.Println("Self-test passed")
+	}
+
+	if cfg.Bench {
+		return bench(cfg)
+	}
+
+	if cfg.Single {
+		return single(cfg)
+	}
+
+	if cfg.TriggerMode {
+		return triggered(cfg, stdout)
+	}
+
+	// create processing pipeline for every video source
+	var pipes []*pipeline
+	for _, src := range cfg.Sources() {
+		p, err := newPipeline(src, cfg)
+		if err != nil {
+			msg, code := explain(err)
+			fmt.Fprintf(os.Stderr, "%s\n", msg)
+			return code
+		}
+		pipes = append(pipes, p)
+	}
+
+	if cfg.SelectROI || cfg.SelectMask != "" {
+		if err := selectRegions(&cfg, pipes[0]); err != nil {
+			msg, code := explain(err)
+			fmt.Fprintf(os.Stderr, "Selection failed: %s\n", msg)
+			return code
+		}
+	}
+
+	// record annotated frames of every source; multiple sources are recorded into separate files
+	if cfg.OutVideo != "" {
+		for _, p := range pipes {
+			path := cfg.OutVideo
+			if len(pipes) > 1 {
+				ext := filepath.Ext(path)
+				path = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(path, ext), p.src.Name, ext)
+			}
+			// image sequences have no frame rate, so they're recorded at the rate they're played at
+			fps := p.cfg.FPS
+			if fps <= 0 {
+				fps = 1000 / p.delay
+			}
+			p.rec = NewRecorder(path, cfg.OutCodec, fps, p.size, cfg.OutVideoMaxFrames)
+		}
+	}
 
 	// errChan is a channel used to capture program errors
-	errChan := make(chan error, 2)
+	// there are at most two goroutines per pipeline and eleven more shared goroutines
+	errChan := make(chan error, 2*len(pipes)+11)
 
 	// doneChan is used to signal goroutines they need to stop
 	doneChan := make(chan struct{})
 
-	// resultsChan is used for detection distribution
-	resultsChan := make(chan *Result, 1)
-
 	// sigChan is used as a handler to stop all the goroutines
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, os.Kill, syscall.SIGTERM)
 
+	// hupChan is used to reload the program configuration
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+
+	// reloadChan delivers reloaded configuration to the main goroutine
+	reloadChan := make(chan Config)
+
+	// msgChan and clientChan are used to publish status messages and part events and to replace the MQTT client
+	var msgChan chan mqttMessage
+	var clientChan chan Publisher
+
 	// pubChan is used for publishing data analytics stats
-	var pubChan chan *Result
+	var pubChan chan *detector.Result
+
+	// metrics are served by the HTTP server
+	metrics := NewMetrics()
+	metrics.Counter("osd_dropped_frames_total", "Number of frames which never reached detection", func() float64 {
+		return float64(atomic.LoadUint64(&DroppedFrames))
+	})
+	metrics.Counter("osd_corrupt_frames_total", "Number of corrupt frames skipped by detection", func() float64 {
+		return float64(atomic.LoadUint64(&CorruptFrames))
+	})
+	metrics.Histogram("osd_frame_processing_duration_seconds", "Time it took to process a frame", ProcessingDuration)
+	// status serves statistics of the active publisher
+	status := new(StatusHandler)
+	// health reports whether the frames keep going through detection
+	health := NewHealthHandler(status, time.Now)
+
+	// history records the most recently detected parts of all the sources
+	history := NewPartHistory(cfg.HistorySize)
+	// histogram collects measured areas of all the parts of all the sources
+	histogram := NewAreaHistogram(cfg.HistogramBucket)
 
 	// waitgroup to synchronize all goroutines
 	var wg sync.WaitGroup
+	// frameWg is used to wait for all frameRunner goroutines
+	var frameWg sync.WaitGroup
 
-	if publish {
-		p, err := NewMQTTPublisher()
+	// state persists the result sequence numbers across restarts
+	state, err := LoadState(cfg.StateFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load state: %v\n", err)
+		return 1
+	}
+	if cfg.StateFile != "" {
+		// start state saver goroutine
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errChan <- state.Run(doneChan)
+		}()
+	}
+
+	// outside the scheduled shifts the configured area range applies, not the one updated at runtime
+	defaultMin, defaultMax := cfg.Min, cfg.Max
+
+	// thresholds updated at runtime survive restarts
+	if state.Thresholds != nil {
+		dc, err := applyThresholds(cfg.DetectorConfig, *state.Thresholds)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to create MQTT publisher: %v\n", err)
-			os.Exit(1)
+			fmt.Fprintf(os.Stderr, "Ignoring invalid persisted thresholds: %v\n", err)
+		} else {
+			fmt.Printf("Restored detector thresholds %v\n", *state.Thresholds)
+			cfg.DetectorConfig = dc
+			for _, p := range pipes {
+				p.reconfigure(dc)
+			}
+		}
+	}
+
+	// control updates the detector thresholds and switches the profiles at runtime
+	var control *ConfigHandler
+	if cfg.HTTPAddr != "" || len(cfg.Shifts) > 0 || len(cfg.Profiles) > 0 {
+		control = NewConfigHandler(cfg, cfg.HTTPToken, reloadChan, doneChan, state)
+	}
+
+	// commander executes operator commands; they're acknowledged over MQTT if publishing is enabled
+	cmdChans := make([]chan<- Command, len(pipes))
+	for i, p := range pipes {
+		cmdChans[i] = p.cmdChan
+	}
+	commander := NewCommander(cmdChans, history, control, nil)
+
+	// ctx is session context; it's cancelled when the program is shutting down
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// tracer exports traces of the sampled frames; it's nil if tracing is disabled
+	var tracer *Tracer
+	if cfg.OTel {
+		tracer = NewTracer(cfg.OTelEndpoint, cfg.OTelSample)
+		for _, p := range pipes {
+			p.tracer = tracer
+		}
+		fmt.Printf("Exporting traces of %g%% of frames to %s\n", cfg.OTelSample*100, cfg.OTelEndpoint)
+		// start tracer goroutine
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errChan <- tracer.Run(doneChan)
+		}()
+	}
+
+	// reporter aggregates the parts into periodic reports; it's nil if the reports are disabled
+	var reporter *Reporter
+
+	if cfg.Publish || cfg.Sink == SinkStdout {
+		var p Publisher = NewStreamPublisher(stdout, topic)
+		if cfg.Publish {
+			c, err := NewMQTTPublisher(cfg.MQTT)
+			if err != nil {
+				msg, code := explain(err)
+				fmt.Fprintf(os.Stderr, "Failed to create MQTT publisher: %s\n", msg)
+				return code
+			}
+			p = c
+		}
+		// only the latest results are published every cfg.Rate seconds, so buffering more results than a source
+		// detects in between is pointless; the stream publishes every result, so it keeps the configured buffer
+		pubBuf := cfg.PubBuf
+		for _, p := range pipes {
+			perRate := int(math.Ceil(float64(cfg.Rate) * p.cfg.FPS))
+			if cfg.Sink != SinkStdout && perRate >= 1 && perRate < pubBuf {
+				fmt.Printf("Capping publish buffer of %d results to %d detected by %s every %d s\n", pubBuf, perRate,
+					p.src.Name, cfg.Rate)
+				pubBuf = perRate
+			}
+			if cfg.Rate > maxPublishRate && p.cfg.FPS > 0 {
+				fmt.Fprintf(os.Stderr, "Warning: analytics are published every %d s; only the latest of the %.0f results "+
+					"%s detects in between is published\n", cfg.Rate, float64(cfg.Rate)*p.cfg.FPS, p.src.Name)
+			}
+		}
+		// every zone result is published besides the result of the whole video source
+		pubChan = make(chan *detector.Result, pubBuf*len(pipes)*(len(cfg.Zones)+1))
+		msgChan = make(chan mqttMessage, len(pipes)+1)
+		commander.msgChan = msgChan
+		if cfg.ReportAt != "" {
+			reporter = NewReporter(time.Now)
 		}
-		pubChan = make(chan *Result, 1)
+		clientChan = make(chan Publisher)
 		// start MQTT worker goroutine
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			errChan <- messageRunner(doneChan, pubChan, p, topic, rate)
+			errChan <- messageRunner(ctx, cfg, doneChan, pubChan, msgChan, clientChan, p, topic, metrics, status, commander,
+				reporter, tracer)
 		}()
-		defer p.Disconnect(100)
 	}
 
-	// start frameRunner goroutine
+	// start configuration reload goroutine
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		errChan <- frameRunner(framesChan, doneChan, resultsChan, pubChan)
+		errChan <- reloadRunner(args, cfg, hupChan, doneChan, reloadChan, clientChan, msgChan)
 	}()
 
-	// open display window
-	window := gocv.NewWindow(name)
-	window.SetWindowProperty(gocv.WindowPropertyAutosize, gocv.WindowAutosize)
-	defer window.Close()
+	// remote configuration changes are reloaded the same way as the configuration file on SIGHUP
+	if backend, _ := NewConfigBackend(cfg.ConfigBackend); backend != nil {
+		// start remote configuration watch goroutine
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errChan <- configWatchRunner(backend, hupChan, doneChan)
+		}()
+	}
+
+	// alarm delivers defect alarms to external outputs
+	var alarm *Alarm
+
+	if cfg.AlarmWebhook != "" || cfg.AlarmCmd != "" {
+		alarm = NewAlarm(cfg.AlarmWebhook, cfg.AlarmCmd, time.Duration(cfg.AlarmCooldown)*time.Second)
+		// start alarm worker goroutine
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errChan <- alarm.Run(doneChan)
+		}()
+	}
 
-	// prepare input image matrix
-	img := gocv.NewMat()
-	defer img.Close()
+	// beeper plays audible alert when a defect is confirmed
+	var beeper *Beeper
+	if cfg.BeepCmd != "" {
+		beeper = NewBeeper(cfg.BeepCmd)
+	}
 
-	// initialize the result pointer
-	result := new(Result)
+	// exporter exports unannotated frames for dataset collection
+	var exporter *FrameExporter
+	exportInterval := time.Duration(float64(time.Second) / cfg.ExportFramesFPS)
 
-monitor:
-	for {
-		if ok := vc.Read(&img); !ok {
-			fmt.Printf("Cannot read image source %v\n", deviceID)
-			break
+	if cfg.ExportFramesDir != "" {
+		exporter, err = NewFrameExporter(cfg.ExportFramesDir, cfg.ExportFramesMaxMB)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create frame exporter: %v\n", err)
+			return 1
 		}
-		if img.Empty() {
-			continue
+		// start frame exporter goroutine
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errChan <- exporter.Run(doneChan)
+		}()
+	}
+
+	// clipper writes raw video clips of the confirmed defects
+	var clipper *ClipRecorder
+	if cfg.ClipDir != "" {
+		clipper, err = NewClipRecorder(cfg.ClipDir, cfg.ClipMaxMB)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to create clip recorder: %v\n", err)
+			return 1
+		}
+		for _, p := range pipes {
+			p.clips = NewClipBuffer(time.Duration(cfg.ClipPre*float64(time.Second)),
+				time.Duration(cfg.ClipPost*float64(time.Second)), cfg.ClipScale, cfg.ClipSkip)
+		}
+		// start clip recorder goroutine
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errChan <- clipper.Run(doneChan)
+		}()
+	}
+
+	if cfg.MaxDefectRate < 1 {
+		// start defect rate goroutine which stops the program when the defects are too frequent
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errChan <- defectRateRunner(history, cfg.MaxDefectRate, cfg.DefectRateWindow, doneChan)
+		}()
+	}
+
+	if len(cfg.Shifts) > 0 {
+		// start schedule goroutine which applies thresholds of the active shift
+		defaults := Thresholds{Min: &defaultMin, Max: &defaultMax}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errChan <- scheduleRunner(cfg.Shifts, defaults, control, doneChan)
+		}()
+	}
+
+	if cfg.HTTPAddr != "" {
+		// keep the latest annotated frame of every source for snapshot requests
+		snapshots := NewSnapshotHandler(cfg.SnapshotWidth, cfg.SnapshotQuality)
+		for _, p := range pipes {
+			p.snap = snapshots.Add(p.src.Name)
+		}
+		srv := newHTTPServer(cfg.HTTPAddr, history, histogram, metrics, snapshots, control, status, health, commander)
+		// start HTTP server goroutine
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errChan <- httpRunner(srv, doneChan)
+		}()
+	}
+
+	// delay is the shortest video play delay of all the sources which are not paced in real time
+	delay := math.Inf(1)
+
+	for _, p := range pipes {
+		p := p
+
+		if cfg.FrameTimeout > 0 {
+			p.wd = NewWatchdog(time.Duration(cfg.FrameTimeout)*time.Millisecond, cfg.FrameTimeoutCount)
+			// start watchdog goroutine
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errChan <- p.wd.Run(doneChan)
+			}()
+		}
+
+		// start frameRunner goroutine
+		wg.Add(1)
+		frameWg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer frameWg.Done()
+			errChan <- frameRunner(p.src.Name, p.cfg, p.framesChan, p.configChan, p.cmdChan, doneChan,
+				p.resultsChan, pubChan, msgChan, alarm, p.wd, history, histogram, reporter, beeper, state)
+		}()
+
+		if p.pacer == nil {
+			delay = math.Min(delay, p.delay)
+		}
+	}
+	// paced sources wait for their frames in pace, so the display only polls the keyboard
+	if math.IsInf(delay, 1) {
+		delay = 1
+	}
+
+	// close publish channel once all frameRunners have stopped so messageRunner can drain it
+	if pubChan != nil {
+		go func() {
+			frameWg.Wait()
+			close(pubChan)
+		}()
+	}
+
+	// open display windows; the monitoring goes on headless if the display fails
+	disp := newHeadlessDisplay()
+	if !cfg.Headless {
+		disp = newDisplay(cfg.Layout, pipes, func(err error) {
+			select {
+			case msgChan <- mqttMessage{statusTopic, fmt.Sprintf("{\"Status\":%q,\"Display\":\"headless\"}",
+				fmt.Sprintf("display failed: %v", err))}:
+			default:
+			}
+		})
+	}
+	defer disp.close()
+	metrics.Gauge("osd_display_headless", "1 if the display failed and the program runs headless, 0 otherwise", func() float64 {
+		if disp.Headless() {
+			return 1
 		}
+		return 0
+	})
 
-		// resize frame image to smaller size
-		gocv.Resize(img, &img, image.Point{960, 540}, 0, 0, gocv.InterpolationLinear)
-		screen := img.Clone()
-		framesChan <- &frame{img: &img}
+	// code is program exit code
+	code := 0
+
+	// watcher applies the reloaded configuration in between the frames, as the pipelines are owned by this goroutine
+	watcher := NewConfigWatcher(cfg, reloadChan, func(newCfg Config) {
+		for _, p := range pipes {
+			p.reconfigure(newCfg.DetectorConfig)
+			p.cfg.Overlay = newCfg.Overlay
+		}
+		if control != nil {
+			control.Set(newCfg)
+		}
+	})
+
+monitor:
+	for {
+		for _, p := range pipes {
+			if ok := p.read(); !ok {
+				fmt.Printf("Cannot read image source %s\n", p.src.Name)
+				// let detection finish the frames already read so their results are not lost
+				for _, p := range pipes {
+					p.drain(drainTimeout)
+				}
+				break monitor
+			}
+			p.pace()
+			p.send()
+		}
+		watcher.Poll()
 
 		select {
 		case sig := <-sigChan:
@@ -430,45 +1547,103 @@ monitor:
 			break monitor
 		case err = <-errChan:
 			fmt.Printf("Shutting down. Encountered error: %s\n", err)
+			if _, ok := err.(*ErrHighDefectRate); ok {
+				// process supervisor halts the production line on non-zero exit code
+				code = 1
+			}
 			break monitor
-		case result = <-resultsChan:
-			// do nothing here
 		default:
 			// do nothing; just display latest results
 		}
 
-		// display detected measurements
-		gocv.PutText(&screen, fmt.Sprintf("Measurement: %d Expected range: [%d - %d] Defect: %v",
-			result.Rect.Size().X*result.Rect.Size().Y, min, max, result.Defect), image.Point{0, 15},
-			gocv.FontHersheySimplex, 0.5, color.RGBA{0, 255, 0, 0}, 2)
-
-		// defect detection results
-		gocv.PutText(&screen, fmt.Sprintf("%s", result), image.Point{0, 40},
-			gocv.FontHersheySimplex, 0.5, color.RGBA{0, 255, 0, 0}, 2)
-
-		// if defect then draw red rectangle; otherwise draw green
-		if result.Defect {
-			gocv.Rectangle(&screen, result.Rect, color.RGBA{255, 0, 0, 0}, 2)
-		} else if !result.Rect.Empty() {
-			gocv.Rectangle(&screen, result.Rect, color.RGBA{0, 255, 0, 0}, 2)
+		screens := make([]gocv.Mat, len(pipes))
+		for i, p := range pipes {
+			p.update()
+			if exporter != nil {
+				p.export(exporter, exportInterval)
+			}
+			if clipper != nil {
+				p.clip(clipper)
+			}
+			screens[i] = p.render()
+			p.record(screens[i])
+			if p.snap != nil {
+				p.snap.Update(screens[i])
+			}
 		}
-
-		// show the image in the window, and wait 1 millisecond
-		window.IMShow(screen)
+		disp.show(screens)
 
 		// press ESC key to exit
-		if window.WaitKey(int(delay)) == 27 {
+		if disp.waitKey(int(delay)) == 27 {
 			break monitor
 		}
 	}
 
 	// signal all goroutines to finish
-	close(framesChan)
 	close(doneChan)
-	for range resultsChan {
-		// collect any outstanding results
+	cancel()
+	for _, p := range pipes {
+		// collect any outstanding results; the last one has the final totals
+		for result := range p.resultsChan {
+			p.display.set(result, time.Now())
+		}
 	}
 
 	// wait for all goroutines to finish
 	wg.Wait()
+
+	if err := state.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save state: %v\n", err)
+	}
+
+	for _, p := range pipes {
+		p.close()
+		fmt.Printf("Frames of %s dropped while detection was busy: %d\n", p.src.Name, p.dropped)
+	}
+	fmt.Printf("Frames dropped before detection: %d\n", atomic.LoadUint64(&DroppedFrames))
+	fmt.Printf("Corrupt frames skipped: %d\n", atomic.LoadUint64(&CorruptFrames))
+	fmt.Printf("Part area percentiles:%s\n", histogram.Summary())
+	if min, max, ok := histogram.Extremes(); ok {
+		fmt.Printf("Part area extremes: min %d, max %d\n", min, max)
+	}
+	if alarm != nil {
+		fmt.Printf("Alarm failures: %d, dropped alarms: %d\n", alarm.Failures(), alarm.Dropped())
+	}
+	if exporter != nil {
+		fmt.Printf("Frames skipped by frame export: %d\n", exporter.Skipped())
+	}
+	if clipper != nil {
+		fmt.Printf("Defect clips skipped: %d\n", clipper.Skipped())
+	}
+	if tracer != nil {
+		fmt.Printf("Spans dropped by tracing: %d\n", tracer.Dropped())
+	}
+	if cfg.FailOnDefect && code == 0 {
+		for _, p := range pipes {
+			if p.display.result.TotalDefects > 0 {
+				fmt.Printf("Defects confirmed in %s: %d\n", p.src.Name, p.display.result.TotalDefects)
+				code = exitError
+			}
+		}
+	}
+
+	return code
+}
+
+// commands are program subcommands; run is the default one
+var commands = map[string]func(args []string) int{
+	"run":   run,
+	"check": check,
+	"bench": benchMQTT,
+}
+
+func main() {
+	cmd, args := "run", os.Args[1:]
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			cmd, args = args[0], args[1:]
+		}
+	}
+
+	os.Exit(commands[cmd](args))
 }
@@ -0,0 +1,252 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"image"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+)
+
+func TestParseRes(t *testing.T) {
+	tests := []struct {
+		res  string
+		want image.Point
+		err  bool
+	}{
+		{"960x540", image.Point{960, 540}, false},
+		{"1280X720", image.Point{1280, 720}, false},
+		{"640", image.Point{}, true},
+		{"640x480x3", image.Point{}, true},
+		{"ax480", image.Point{}, true},
+		{"640xb", image.Point{}, true},
+		{"0x480", image.Point{}, true},
+		{"640x-1", image.Point{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRes(tt.res)
+		if (err != nil) != tt.err {
+			t.Errorf("parseRes(%q) error = %v, want error %v", tt.res, err, tt.err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseRes(%q) = %v, want %v", tt.res, got, tt.want)
+		}
+	}
+}
+
+func TestProcSize(t *testing.T) {
+	tests := []struct {
+		src   image.Point
+		width int
+		want  image.Point
+	}{
+		{image.Point{1920, 1080}, 960, image.Point{960, 540}},
+		{image.Point{640, 480}, 960, image.Point{960, 720}},
+		{image.Point{1280, 1024}, 640, image.Point{640, 512}},
+	}
+
+	for _, tt := range tests {
+		if got := procSize(tt.src, tt.width); got != tt.want {
+			t.Errorf("procSize(%v, %d) = %v, want %v", tt.src, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestAreaScale(t *testing.T) {
+	tests := []struct {
+		proc, calib image.Point
+		want        float64
+	}{
+		{image.Point{960, 540}, image.Point{960, 540}, 1},
+		{image.Point{960, 540}, image.Point{1920, 1080}, 0.25},
+		{image.Point{1920, 1080}, image.Point{960, 540}, 4},
+		{image.Point{960, 720}, image.Point{640, 480}, 2.25},
+	}
+
+	for _, tt := range tests {
+		if got := areaScale(tt.proc, tt.calib); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("areaScale(%v, %v) = %g, want %g", tt.proc, tt.calib, got, tt.want)
+		}
+	}
+}
+
+func TestScaleAreas(t *testing.T) {
+	zones := []detector.Zone{{Name: "left", Min: 1000, Max: 2000}}
+	dc := DetectorConfig{Min: 20000, Max: 30000, Zones: zones}
+
+	got := scaleAreas(dc, 0.25)
+	if got.Min != 5000 || got.Max != 7500 {
+		t.Errorf("scaled area range = [%d - %d], want [5000 - 7500]", got.Min, got.Max)
+	}
+	if got.Zones[0].Min != 250 || got.Zones[0].Max != 500 {
+		t.Errorf("scaled zone area range = [%d - %d], want [250 - 500]", got.Zones[0].Min, got.Zones[0].Max)
+	}
+	// the unscaled configuration shares the zones, so they must be left untouched
+	if zones[0].Min != 1000 || zones[0].Max != 2000 {
+		t.Errorf("original zone area range changed to [%d - %d]", zones[0].Min, zones[0].Max)
+	}
+
+	// areas are rounded to the nearest integer
+	if got := scaleArea(3, 0.5); got != 2 {
+		t.Errorf("scaleArea(3, 0.5) = %d, want 2", got)
+	}
+}
+
+func TestOrigRect(t *testing.T) {
+	tests := []struct {
+		rect  image.Rectangle
+		scale float64
+		want  image.Rectangle
+	}{
+		{image.Rect(10, 20, 110, 70), 0.5, image.Rect(20, 40, 220, 140)},
+		{image.Rect(10, 20, 110, 70), 1, image.Rect(10, 20, 110, 70)},
+		{image.Rect(10, 20, 110, 70), 0, image.Rect(10, 20, 110, 70)},
+		{image.Rectangle{}, 0.5, image.Rectangle{}},
+	}
+
+	for _, tt := range tests {
+		if got := origRect(tt.rect, tt.scale); got != tt.want {
+			t.Errorf("origRect(%v, %g) = %v, want %v", tt.rect, tt.scale, got, tt.want)
+		}
+	}
+}
+
+func TestPublishDue(t *testing.T) {
+	heartbeat := time.Minute
+	prev := &detector.Result{TotalParts: 3, Rect: image.Rect(0, 0, 10, 10)}
+	same := &detector.Result{TotalParts: 3, Rect: image.Rect(5, 5, 20, 20), FPS: 30}
+	changed := &detector.Result{TotalParts: 4, Rect: image.Rect(0, 0, 10, 10)}
+
+	tests := []struct {
+		name    string
+		mode    string
+		result  *detector.Result
+		prev    *detector.Result
+		elapsed time.Duration
+		want    bool
+	}{
+		{"interval publishes unchanged result", PublishModeInterval, same, prev, time.Second, true},
+		{"first result", PublishModeOnChange, same, nil, 0, true},
+		{"unchanged result", PublishModeOnChange, same, prev, time.Second, false},
+		{"changed result", PublishModeOnChange, changed, prev, time.Second, true},
+		{"unchanged result at heartbeat", PublishModeOnChange, same, prev, heartbeat, true},
+	}
+
+	for _, tt := range tests {
+		if got := publishDue(tt.mode, tt.result, tt.prev, tt.elapsed, heartbeat); got != tt.want {
+			t.Errorf("%s: publishDue = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMessageRunnerPublishesFinalTotals(t *testing.T) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	cfg, err := LoadConfig(fs, []string{"-input", "belt.mp4"})
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	// only the final results are published within the test
+	cfg.Rate = 3600
+
+	broker := &fakeBroker{}
+	doneChan := make(chan struct{})
+	pubChan := make(chan *detector.Result)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- messageRunner(context.Background(), cfg, doneChan, pubChan, nil, nil, broker, topic,
+			NewMetrics(), &StatusHandler{}, nil, nil, nil)
+	}()
+
+	for i := 1; i <= 3; i++ {
+		pubChan <- &detector.Result{Source: "cam0", TotalParts: i, Seq: uint64(i)}
+	}
+	close(doneChan)
+	// frameRunners keep sending results until they stop, so the results sent after the stop signal count too
+	pubChan <- &detector.Result{Source: "cam0", TotalParts: 4, TotalDefects: 2, Seq: 4}
+	pubChan <- &detector.Result{Source: "cam1", TotalParts: 7, TotalDefects: 1, Seq: 9}
+	close(pubChan)
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Fatalf("messageRunner error: %v", err)
+		}
+	case <-time.After(2 * drainTimeout):
+		t.Fatalf("messageRunner did not stop")
+	}
+
+	final := make(map[string]jsonTotals)
+	for _, payload := range broker.delivered {
+		var msg jsonTotals
+		if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+			t.Fatalf("invalid message %s: %v", payload, err)
+		}
+		final[msg.Source] = msg
+	}
+
+	want := map[string]jsonTotals{
+		"cam0": {"cam0", 4, 2, 4},
+		"cam1": {"cam1", 7, 1, 9},
+	}
+	if !reflect.DeepEqual(final, want) {
+		t.Errorf("final messages %+v, want %+v", final, want)
+	}
+}
+
+// jsonTotals are the totals of JSON analytics message
+type jsonTotals struct {
+	Source       string
+	TotalParts   int
+	TotalDefects int
+	Seq          uint64
+}
+
+func TestFrameBudget(t *testing.T) {
+	tests := []struct {
+		fps  float64
+		want time.Duration
+	}{
+		{25, 40 * time.Millisecond},
+		{50, 20 * time.Millisecond},
+		// the frame rate of image sequences is unknown, so their frames are never late
+		{0, 0},
+	}
+
+	for _, tt := range tests {
+		if got := frameBudget(tt.fps); got != tt.want {
+			t.Errorf("frameBudget(%g) = %v, want %v", tt.fps, got, tt.want)
+		}
+	}
+}
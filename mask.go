@@ -0,0 +1,61 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"gocv.io/x/gocv"
+)
+
+// maskAspectTolerance is relative difference of the mask and processing frame aspect ratios
+// within which the mask is scaled to the frame
+const maskAspectTolerance = 0.01
+
+// loadMask loads binary mask image from path, scales it to processing frame size and returns it.
+// Parts are only detected where the mask is white; antialiased edges are split at mid-gray.
+// It returns error if the image can't be read or its aspect ratio doesn't match the frame.
+// The returned mask must be closed by the caller.
+func loadMask(path string, size image.Point) (*gocv.Mat, error) {
+	mask := gocv.IMRead(path, gocv.IMReadGrayScale)
+	if mask.Empty() {
+		mask.Close()
+		return nil, fmt.Errorf("failed to read mask image %s", path)
+	}
+
+	aspect := float64(size.X) / float64(size.Y)
+	if math.Abs(float64(mask.Cols())/float64(mask.Rows())-aspect)/aspect > maskAspectTolerance {
+		err := fmt.Errorf("mask image %s is %dx%d: aspect ratio doesn't match %dx%d processing frame",
+			path, mask.Cols(), mask.Rows(), size.X, size.Y)
+		mask.Close()
+		return nil, err
+	}
+
+	gocv.Resize(mask, &mask, size, 0, 0, gocv.InterpolationNearestNeighbor)
+	gocv.Threshold(mask, &mask, 127, 255, gocv.ThresholdBinary)
+
+	return &mask, nil
+}
@@ -0,0 +1,153 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// metric is a metric exposed in Prometheus text format
+type metric struct {
+	// help describes the metric
+	help string
+	// kind is Prometheus metric type: gauge, counter or histogram
+	kind string
+	// value returns current value of the metric; it's nil for histograms
+	value func() float64
+	// hist is the histogram of histogram metrics
+	hist *Histogram
+}
+
+// Histogram counts observed values in buckets with upper bounds
+type Histogram struct {
+	mu sync.Mutex
+	// bounds are upper bounds of the buckets in increasing order; the last bucket is unbounded
+	bounds []float64
+	// counts contains number of values in every bucket including the unbounded one
+	counts []uint64
+	// sum is sum of all observed values
+	sum float64
+}
+
+// NewHistogram creates new histogram with buckets with upper bounds in increasing order
+func NewHistogram(bounds ...float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+// Observe adds value v to the histogram
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	i := sort.SearchFloat64s(h.bounds, v)
+	h.counts[i]++
+	h.sum += v
+}
+
+// write writes the histogram samples of metric name to w in Prometheus text format
+func (h *Histogram) write(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Prometheus buckets are cumulative
+	var count uint64
+	for i, bound := range h.bounds {
+		count += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, bound, count)
+	}
+	count += h.counts[len(h.bounds)]
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", name, count, name, h.sum, name, count)
+}
+
+// Metrics is a registry of program metrics served in Prometheus text exposition format
+type Metrics struct {
+	mu sync.Mutex
+	// metrics maps metric names to metrics
+	metrics map[string]metric
+}
+
+// NewMetrics creates new empty metrics registry and returns it
+func NewMetrics() *Metrics {
+	return &Metrics{metrics: make(map[string]metric)}
+}
+
+// Gauge registers gauge name described by help whose current value is returned by value
+// Registering a metric again replaces it.
+func (m *Metrics) Gauge(name, help string, value func() float64) {
+	m.register(name, metric{help, "gauge", value, nil})
+}
+
+// Counter registers counter name described by help whose current value is returned by value
+// Registering a metric again replaces it.
+func (m *Metrics) Counter(name, help string, value func() float64) {
+	m.register(name, metric{help, "counter", value, nil})
+}
+
+// Histogram registers histogram name described by help
+// Registering a metric again replaces it.
+func (m *Metrics) Histogram(name, help string, h *Histogram) {
+	m.register(name, metric{help, "histogram", nil, h})
+}
+
+// register registers metric mt under name
+func (m *Metrics) register(name string, mt metric) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.metrics[name] = mt
+}
+
+// ServeHTTP implements http.Handler interface; it writes all the metrics sorted by name
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// copy the registry so the metric values are not read under the lock
+	m.mu.Lock()
+	metrics := make(map[string]metric, len(m.metrics))
+	names := make([]string, 0, len(m.metrics))
+	for name, mt := range m.metrics {
+		metrics[name] = mt
+		names = append(names, name)
+	}
+	m.mu.Unlock()
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, name := range names {
+		mt := metrics[name]
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, mt.help, name, mt.kind)
+		if mt.hist != nil {
+			mt.hist.write(w, name)
+			continue
+		}
+		fmt.Fprintf(w, "%s %g\n", name, mt.value())
+	}
+}
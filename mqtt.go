@@ -24,11 +24,15 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"strconv"
+	"sync"
 	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
@@ -39,30 +43,141 @@ const (
 	TIMEOUT = 1 * time.Second
 	// QOS is Quality Of Service
 	QOS = 1
+	// publishRateTau is time constant of the measured publish rate
+	publishRateTau = 10 * time.Second
 )
 
+// Publisher publishes messages to MQTT server
+// It allows the publish path to be implemented by MQTT clients of different protocol versions.
+type Publisher interface {
+	// PublishContext publishes message to topic with default Quality Of Service until ctx is done
+	PublishContext(ctx context.Context, topic, message string) error
+	// PublishQoS publishes message to topic with qos Quality Of Service until ctx is done
+	PublishQoS(ctx context.Context, topic, message string, qos byte) error
+	// Close closes the connection to MQTT server, waiting up to timeout for pending messages
+	Close(timeout time.Duration) error
+	// Connected returns true if the connection to MQTT server is established
+	Connected() bool
+	// PublishRate returns the measured number of messages published per second
+	PublishRate() float64
+	// Stats returns snapshot of the publish statistics
+	Stats() PublishStats
+}
+
+// PublishStats are statistics of the publishes and the connection of a publisher
+// Attempted publishes are the sum of the succeeded, failed and timed out ones.
+type PublishStats struct {
+	// Attempted is number of attempted publishes
+	Attempted uint64 `json:"publishes_attempted"`
+	// Succeeded is number of successful publishes
+	Succeeded uint64 `json:"publishes_succeeded"`
+	// Failed is number of publishes which failed for other reason than a timeout
+	Failed uint64 `json:"publishes_failed"`
+	// TimedOut is number of publishes which did not finish in time
+	TimedOut uint64 `json:"publishes_timed_out"`
+	// BytesSent is number of payload bytes of the successful publishes
+	BytesSent uint64 `json:"bytes_sent"`
+	// Reconnects is number of times the connection to the server was re-established
+	Reconnects uint64 `json:"reconnects"`
+	// Connected means the connection to the server is established
+	Connected bool `json:"connected"`
+}
+
+// record records publish of payload of n bytes which finished with err; timedOut means it did not finish in time
+func (s *PublishStats) record(n int, err error, timedOut bool) {
+	s.Attempted++
+	switch {
+	case timedOut:
+		s.TimedOut++
+	case err != nil:
+		s.Failed++
+	default:
+		s.Succeeded++
+		s.BytesSent += uint64(n)
+	}
+}
+
 // MQTTClient is MQTT client
 type MQTTClient struct {
 	// MQTT.Client implements MQTT client
 	client MQTT.Client
+	// mu protects subs
+	mu sync.Mutex
+	// subs contains active subscriptions which are re-established on reconnect
+	subs map[string]subscription
+	// rate measures the rate of finished publishes
+	rate *EWMARate
+	// statsMu protects stats and connects, so the stats snapshot is consistent
+	statsMu sync.Mutex
+	// stats are the publish statistics; Connected is only set in snapshots
+	stats PublishStats
+	// connects is number of times the client connected to the server
+	connects uint64
+}
+
+// subscription is active MQTT topic subscription
+type subscription struct {
+	// qos is subscription Quality Of Service
+	qos byte
+	// handler handles messages received on the subscribed topic
+	handler MQTT.MessageHandler
 }
 
-// MQTTNewTLSConfig creates MQTT TLS configuration and returns it
-// It returns error if it can't read TLS certificate files in provided paths.
-func MQTTNewTLSConfig(crtPath, keyPath string, skipVerify bool) (*tls.Config, error) {
-	// Import trusted certificates from CAfile.pem.
-	// Alternatively, manually add CA certificates to
-	// default openssl CA bundle.
-	certpool := x509.NewCertPool()
-	pemCerts, err := ioutil.ReadFile("samplecerts/CAfile.pem")
-	if err == nil {
-		certpool.AppendCertsFromPEM(pemCerts)
+// tlsVersions maps supported TLS minimum version settings to TLS versions
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// MQTTNewTLSConfig creates MQTT TLS configuration from MQTT client configuration c and returns it
+// Server certificates are verified against the system certificate pool if CA certificate is not configured.
+// It returns error naming the environment variable at fault if it can't read TLS certificate files
+// in provided paths or if the TLS configuration is invalid.
+func MQTTNewTLSConfig(c MQTTConfig) (*tls.Config, error) {
+	minVersion := c.TLSMinVersion
+	if minVersion == "" {
+		minVersion = "1.2"
+	}
+	version, ok := tlsVersions[minVersion]
+	if !ok {
+		return nil, fmt.Errorf("MQTT_TLS_MIN_VERSION: unsupported TLS version %q: expected 1.2 or 1.3", minVersion)
+	}
+
+	// Import trusted certificates from CA file or fall back to system certificates
+	var certpool *x509.CertPool
+	if c.CA != "" {
+		certpool = x509.NewCertPool()
+		pemCerts, err := ioutil.ReadFile(c.CA)
+		if err != nil {
+			return nil, fmt.Errorf("MQTT_CA_FILE: failed to read CA certificate: %v", err)
+		}
+		if ok := certpool.AppendCertsFromPEM(pemCerts); !ok {
+			return nil, fmt.Errorf("MQTT_CA_FILE: no valid CA certificates found in %s", c.CA)
+		}
+	} else {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("MQTT_CA_FILE: not set and system certificates can't be loaded: %v", err)
+		}
+		certpool = pool
 	}
 
 	// Import client certificate/key pair
-	cert, err := tls.LoadX509KeyPair(crtPath, keyPath)
-	if err != nil {
-		return nil, err
+	var certs []tls.Certificate
+	if c.Cert != "" || c.CertKey != "" {
+		if c.Cert == "" || c.CertKey == "" {
+			return nil, fmt.Errorf("MQTT_CERT and MQTT_CERT_KEY: both must be set")
+		}
+		cert, err := tls.LoadX509KeyPair(c.Cert, c.CertKey)
+		if err != nil {
+			return nil, fmt.Errorf("MQTT_CERT or MQTT_CERT_KEY: %v", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if c.SkipVerify {
+		fmt.Fprintf(os.Stderr, "WARNING: MQTT_TLS_SKIP_VERIFY or -mqtt-tls-skip-verify is set: certificate of MQTT server %s "+
+			"is NOT verified; the connection is vulnerable to man-in-the-middle attacks\n", c.Server)
 	}
 
 	// Create tls.Config with desired tls properties
@@ -77,65 +192,155 @@ func MQTTNewTLSConfig(crtPath, keyPath string, skipVerify bool) (*tls.Config, er
 		ClientCAs: nil,
 		// InsecureSkipVerify = verify that cert contents
 		// match server. IP matches what is in cert etc.
-		InsecureSkipVerify: skipVerify,
+		InsecureSkipVerify: c.SkipVerify,
 		// Certificates = list of certs client sends to server.
-		Certificates: []tls.Certificate{cert},
+		Certificates: certs,
+		// MinVersion = minimum accepted TLS version.
+		MinVersion: version,
+		// ServerName = name the server cert is verified against, e.g. when the server is behind a load balancer.
+		ServerName: c.TLSServerName,
 	}, nil
 }
 
-// MQTTClientOptions creates new MQTT client options and returns it
-// It reads the following environment variables to populate the options:
+// MQTTConfig is MQTT client configuration
+type MQTTConfig struct {
+	// Server is URI address of MQTT server
+	Server string `yaml:"server"`
+	// ClientID is MQTT client ID
+	ClientID string `yaml:"client_id"`
+	// Username is MQTT username
+	Username string `yaml:"username"`
+	// Password is MQTT password for Username
+	Password string `yaml:"password"`
+	// Cert is path to SSL certificate
+	Cert string `yaml:"cert"`
+	// CertKey is path to SSL certificate private key
+	CertKey string `yaml:"cert_key"`
+	// CA is path to SSL CA root certificate
+	CA string `yaml:"ca"`
+	// SkipVerify disables SSL TLS verification
+	SkipVerify bool `yaml:"tls_skip_verify"`
+	// TLSMinVersion is minimum accepted TLS version: 1.2 or 1.3; it defaults to 1.2
+	TLSMinVersion string `yaml:"tls_min_version"`
+	// TLSServerName is name the server certificate is verified against; it defaults to the server host name
+	TLSServerName string `yaml:"tls_server_name"`
+	// ProtocolVersion is MQTT protocol version: 3 for MQTT 3.1 or 4 for MQTT 3.1.1; it's negotiated if zero
+	ProtocolVersion int `yaml:"protocol_version"`
+	// StoreDir is directory unacknowledged messages are persisted to; they're kept in memory if it's empty
+	StoreDir string `yaml:"store_dir"`
+}
+
+// TLSEnabled returns true if the MQTT server connection is secured with TLS,
+// i.e. either the server URI uses a TLS scheme or any of the TLS settings is configured
+func (c MQTTConfig) TLSEnabled() bool {
+	if u, err := url.Parse(c.Server); err == nil {
+		switch u.Scheme {
+		case "ssl", "tls", "tcps", "wss":
+			return true
+		}
+	}
+
+	return c.Cert != "" || c.CertKey != "" || c.CA != "" || c.TLSServerName != ""
+}
+
+// MQTTConfigFromEnv creates new MQTT client configuration and returns it
+// It reads the following environment variables to populate the configuration:
 // MQTT_SERVER: URI address of MQTT server; required parameter
 // MQTT_CLIENT_ID: MQTT client ID; required parameter
 // MQTT_USERNAME: MQTT username; not required
 // MQTT_PASSWORD: MQTT password for MQTT_USERNAME; not required
 // MQTT_CERT: SSL certificate; not required
 // MQTT_CERT_KEY: SSL certificate private key; not required
-// MQTT_CA_ROOT: SSL CA root certificate; not required
+// MQTT_CA_FILE: path to SSL CA root certificate; not required
+// MQTT_CA_ROOT: path to SSL CA root certificate used if MQTT_CA_FILE is not set; not required
 // MQTT_TLS_SKIP_VERIFY: SSL TLS verification; not required
+// MQTT_TLS_MIN_VERSION: minimum accepted TLS version, 1.2 or 1.3; not required
+// MQTT_TLS_SERVER_NAME: name the server certificate is verified against; not required
+// MQTT_PROTOCOL_VERSION: MQTT protocol version, 3 or 4; not required
+// MQTT_STORE_DIR: directory unacknowledged messages are persisted to; not required
+func MQTTConfigFromEnv() MQTTConfig {
+	c := MQTTConfig{
+		Server:        os.Getenv("MQTT_SERVER"),
+		ClientID:      os.Getenv("MQTT_CLIENT_ID"),
+		Username:      os.Getenv("MQTT_USERNAME"),
+		Password:      os.Getenv("MQTT_PASSWORD"),
+		Cert:          os.Getenv("MQTT_CERT"),
+		CertKey:       os.Getenv("MQTT_CERT_KEY"),
+		CA:            os.Getenv("MQTT_CA_FILE"),
+		SkipVerify:    os.Getenv("MQTT_TLS_SKIP_VERIFY") != "",
+		TLSMinVersion: os.Getenv("MQTT_TLS_MIN_VERSION"),
+		TLSServerName: os.Getenv("MQTT_TLS_SERVER_NAME"),
+		StoreDir:      os.Getenv("MQTT_STORE_DIR"),
+	}
+
+	if v := os.Getenv("MQTT_PROTOCOL_VERSION"); v != "" {
+		// invalid values are reported by NewMQTTClientOptions
+		c.ProtocolVersion = -1
+		if pv, err := strconv.Atoi(v); err == nil {
+			c.ProtocolVersion = pv
+		}
+	}
+
+	if c.CA == "" {
+		c.CA = os.Getenv("MQTT_CA_ROOT")
+	}
+
+	return c
+}
+
+// MQTTClientOptions creates new MQTT client options from environment variables and returns it
+// See MQTTConfigFromEnv for the list of environment variables.
 // It returns error if either MQTT server was not specified or if
 // the MQTT client ID is missing in the client configuration options.
 func MQTTClientOptions() (*MQTT.ClientOptions, error) {
-	// read config options from environment variables
-	server := os.Getenv("MQTT_SERVER")
-	clientID := os.Getenv("MQTT_CLIENT_ID")
-	username := os.Getenv("MQTT_USERNAME")
-	password := os.Getenv("MQTT_PASSWORD")
-	tlsCert := os.Getenv("MQTT_CERT")
-	tlsKey := os.Getenv("MQTT_CERT_KEY")
-	tlsCA := os.Getenv("MQTT_CA_ROOT")
-	tlsSkipVerify := os.Getenv("MQTT_TLS_SKIP_VERIFY")
+	return NewMQTTClientOptions(MQTTConfigFromEnv())
+}
 
-	if server == "" {
-		return nil, fmt.Errorf("MQTT server is empty")
+// NewMQTTClientOptions creates new MQTT client options from configuration c and returns it
+// It returns error if either MQTT server was not specified or if
+// the MQTT client ID is missing in the client configuration options.
+func NewMQTTClientOptions(c MQTTConfig) (*MQTT.ClientOptions, error) {
+	if c.Server == "" {
+		return nil, &ErrMQTTConfig{fmt.Errorf("MQTT server is empty")}
 	}
 
-	if clientID == "" {
-		return nil, fmt.Errorf("MQTT clientID is empty")
+	if c.ClientID == "" {
+		return nil, &ErrMQTTConfig{fmt.Errorf("MQTT clientID is empty")}
 	}
 
 	opts := MQTT.NewClientOptions()
-	opts.AddBroker(server)
-	opts.SetClientID(clientID)
+	opts.AddBroker(c.Server)
+	opts.SetClientID(c.ClientID)
 	opts.SetKeepAlive(20 * time.Second)
 	opts.CleanSession = true
 	opts.SetPingTimeout(1 * time.Second)
 	opts.SetDefaultPublishHandler(msgHandler)
 
-	if username != "" && password != "" {
-		opts.SetUsername(username)
-		opts.SetPassword(password)
+	switch c.ProtocolVersion {
+	case 0:
+	case 3, 4:
+		opts.SetProtocolVersion(uint(c.ProtocolVersion))
+	case 5:
+		return nil, &ErrMQTTConfig{fmt.Errorf("MQTT_PROTOCOL_VERSION: MQTT 5 is not supported by the MQTT client")}
+	default:
+		return nil, &ErrMQTTConfig{fmt.Errorf("MQTT_PROTOCOL_VERSION: invalid protocol version: expected 3 or 4")}
+	}
+
+	// persisted messages are only resumed if the server keeps the session
+	if c.StoreDir != "" {
+		opts.SetStore(MQTT.NewFileStore(c.StoreDir))
+		opts.SetCleanSession(false)
 	}
 
-	var skipVerify bool
-	if tlsSkipVerify != "" {
-		skipVerify = true
+	if c.Username != "" && c.Password != "" {
+		opts.SetUsername(c.Username)
+		opts.SetPassword(c.Password)
 	}
 
-	if tlsCert != "" && tlsKey != "" && tlsCA != "" {
-		tlsConfig, err := MQTTNewTLSConfig(tlsCert, tlsKey, skipVerify)
+	if c.TLSEnabled() {
+		tlsConfig, err := MQTTNewTLSConfig(c)
 		if err != nil {
-			return nil, fmt.Errorf("Invalid TLS configuration: %s", err)
+			return nil, &ErrMQTTConfig{fmt.Errorf("invalid TLS configuration: %s", err)}
 		}
 		opts.SetTLSConfig(tlsConfig)
 	}
@@ -144,51 +349,198 @@ func MQTTClientOptions() (*MQTT.ClientOptions, error) {
 }
 
 // MQTTConnect attempts to connect to MQTT server and returns MQTT client
-// It returns error if it fails to connect to the MQTT server.
+// Active subscriptions of the returned client are re-established whenever it reconnects.
+// It returns ErrMQTTConnect if it fails to connect to the MQTT server.
 func MQTTConnect(opts *MQTT.ClientOptions) (*MQTTClient, error) {
-	c := MQTT.NewClient(opts)
+	c := &MQTTClient{
+		subs: make(map[string]subscription),
+		rate: NewEWMARate(publishRateTau),
+	}
+	opts.SetOnConnectHandler(func(MQTT.Client) {
+		c.statsMu.Lock()
+		if c.connects++; c.connects > 1 {
+			c.stats.Reconnects++
+		}
+		c.statsMu.Unlock()
+		c.resubscribe()
+	})
+	c.client = MQTT.NewClient(opts)
 
-	if token := c.Connect(); token.Wait() && token.Error() != nil {
-		return nil, token.Error()
+	if token := c.client.Connect(); token.Wait() && token.Error() != nil {
+		return nil, &ErrMQTTConnect{token.Error()}
 	}
 
-	return &MQTTClient{
-		client: c,
-	}, nil
+	return c, nil
 }
 
-// Publish publishes message to topic
-// It returns MQTT connection Token
-func (c *MQTTClient) Publish(topic, message string) (MQTT.Token, error) {
-	token := c.client.Publish(topic, QOS, false, message)
+// Publish publishes message to topic waiting at most TIMEOUT for the publish to finish
+// It returns error if the publish fails or times out
+func (c *MQTTClient) Publish(topic, message string) error {
+	_, err := c.PublishWithQoS(topic, message, QOS)
+	return err
+}
 
-	// wait for publish to finish
-	if ok := token.WaitTimeout(TIMEOUT); ok && token.Error() != nil {
-		return nil, token.Error()
+// PublishWithQoS publishes message to topic with qos and waits at most TIMEOUT for the publish to finish
+// It returns the publish token along with error if the publish fails or times out
+func (c *MQTTClient) PublishWithQoS(topic, message string, qos byte) (MQTT.Token, error) {
+	token := c.client.Publish(topic, qos, false, message)
+	if ok := token.WaitTimeout(TIMEOUT); !ok {
+		c.record(message, nil, true)
+		return token, fmt.Errorf("publish to %s timed out", topic)
+	}
+	if err := token.Error(); err != nil {
+		c.record(message, err, false)
+		return token, err
 	}
+	c.record(message, nil, false)
+	c.rate.Mark()
 
 	return token, nil
 }
 
-// msgHandler for MQTT subscription for any desired control channel topic
+// PublishContext publishes message to topic with QOS and waits for the publish to finish or ctx to be done
+// It returns error if the publish fails or if ctx is done before the publish finishes
+func (c *MQTTClient) PublishContext(ctx context.Context, topic, message string) error {
+	return c.PublishQoS(ctx, topic, message, QOS)
+}
+
+// PublishQoS publishes message to topic with qos and waits for the publish to finish or ctx to be done
+// It returns error if the publish fails or if ctx is done before the publish finishes.
+// The publish is waited for at most until the ctx deadline, or TIMEOUT if ctx has none.
+func (c *MQTTClient) PublishQoS(ctx context.Context, topic, message string, qos byte) error {
+	token := c.client.Publish(topic, qos, false, message)
+
+	wait := TIMEOUT
+	if deadline, ok := ctx.Deadline(); ok {
+		wait = time.Until(deadline)
+	}
+
+	// MQTT token can't be cancelled so we wait for it in a separate goroutine; the wait is bounded,
+	// so the goroutines of publishes abandoned during a broker outage don't pile up
+	done := make(chan bool, 1)
+	go func() {
+		done <- token.WaitTimeout(wait)
+	}()
+
+	select {
+	case ok := <-done:
+		if !ok {
+			c.record(message, nil, true)
+			return fmt.Errorf("publish to %s timed out", topic)
+		}
+		if err := token.Error(); err != nil {
+			c.record(message, err, false)
+			return err
+		}
+		c.record(message, nil, false)
+		c.rate.Mark()
+		return nil
+	case <-ctx.Done():
+		c.record(message, ctx.Err(), ctx.Err() == context.DeadlineExceeded)
+		return ctx.Err()
+	}
+}
+
+// record records publish of message which finished with err; timedOut means it did not finish in time
+func (c *MQTTClient) record(message string, err error, timedOut bool) {
+	c.statsMu.Lock()
+	defer c.statsMu.Unlock()
+
+	c.stats.record(len(message), err, timedOut)
+}
+
+// Stats implements Publisher interface
+func (c *MQTTClient) Stats() PublishStats {
+	c.statsMu.Lock()
+	s := c.stats
+	c.statsMu.Unlock()
+	s.Connected = c.Connected()
+
+	return s
+}
+
+// PublishRate returns exponentially weighted moving average of messages published per second
+func (c *MQTTClient) PublishRate() float64 {
+	return c.rate.Rate()
+}
+
+// msgHandler prints messages received on any subscribed topic; it's handy for debugging subscriptions
 func msgHandler(c MQTT.Client, msg MQTT.Message) {
 	fmt.Printf("MQTT message received. Topic: %s Message: %s", msg.Topic(), msg.Payload())
 }
 
-// Subscribe subscribes to specified topic
-// It returns MQTT connection Token
-func (c *MQTTClient) Subscribe(topic string) (MQTT.Token, error) {
-	token := c.client.Subscribe(topic, QOS, msgHandler)
+// Subscribe subscribes to specified topic with qos Quality Of Service; messages are handled by handler
+// The subscription is re-established whenever the client reconnects until it's unsubscribed.
+// It returns error if the subscription fails or times out.
+func (c *MQTTClient) Subscribe(topic string, qos byte, handler MQTT.MessageHandler) error {
+	token := c.client.Subscribe(topic, qos, handler)
 
 	// wait for the subscription to finish
-	if ok := token.WaitTimeout(TIMEOUT); ok && token.Error() != nil {
-		return nil, token.Error()
+	if ok := token.WaitTimeout(TIMEOUT); !ok {
+		return fmt.Errorf("subscription to %s timed out", topic)
+	}
+	if err := token.Error(); err != nil {
+		return err
 	}
 
-	return token, nil
+	c.mu.Lock()
+	c.subs[topic] = subscription{qos: qos, handler: handler}
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Unsubscribe unsubscribes from specified topic
+// It returns error if unsubscribing fails or times out.
+func (c *MQTTClient) Unsubscribe(topic string) error {
+	c.mu.Lock()
+	delete(c.subs, topic)
+	c.mu.Unlock()
+
+	token := c.client.Unsubscribe(topic)
+	if ok := token.WaitTimeout(TIMEOUT); !ok {
+		return fmt.Errorf("unsubscribing from %s timed out", topic)
+	}
+
+	return token.Error()
+}
+
+// resubscribe re-establishes all active subscriptions
+func (c *MQTTClient) resubscribe() {
+	c.mu.Lock()
+	subs := make(map[string]subscription, len(c.subs))
+	for topic, sub := range c.subs {
+		subs[topic] = sub
+	}
+	c.mu.Unlock()
+
+	for topic, sub := range subs {
+		token := c.client.Subscribe(topic, sub.qos, sub.handler)
+		if ok := token.WaitTimeout(TIMEOUT); !ok {
+			fmt.Fprintf(os.Stderr, "Resubscription to %s timed out\n", topic)
+		} else if err := token.Error(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to resubscribe to %s: %v\n", topic, err)
+		}
+	}
 }
 
 // Disconnect closes the connection to MQTT broker, waiting for pending ms.
 func (c *MQTTClient) Disconnect(pending uint) {
 	c.client.Disconnect(pending)
 }
+
+// Connected returns true if the client is connected to MQTT broker
+func (c *MQTTClient) Connected() bool {
+	return c.client.IsConnected()
+}
+
+// Close closes the connection to MQTT broker, waiting up to timeout for pending messages to be sent.
+// It returns error if the client was not connected, i.e. the pending messages could not be sent.
+func (c *MQTTClient) Close(timeout time.Duration) error {
+	if !c.client.IsConnected() {
+		return fmt.Errorf("MQTT client is not connected")
+	}
+	c.client.Disconnect(uint(timeout / time.Millisecond))
+
+	return nil
+}
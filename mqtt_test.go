@@ -0,0 +1,273 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setenv sets environment variable key to value, or unsets it if value is empty, and returns function restoring it
+func setenv(t *testing.T, key, value string) func() {
+	t.Helper()
+
+	old, ok := os.LookupEnv(key)
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// writeCA writes self-signed CA certificate in PEM format to file in dir and returns its path
+func writeCA(t *testing.T, dir string) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+
+	path := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatalf("failed to write certificate: %v", err)
+	}
+
+	return path
+}
+
+func TestMQTTNewTLSConfigCA(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osd-mqtt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	invalid := filepath.Join(dir, "invalid.pem")
+	if err := ioutil.WriteFile(invalid, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ca   string
+		err  bool
+	}{
+		{"valid CA", writeCA(t, dir), false},
+		{"missing CA file", filepath.Join(dir, "missing.pem"), true},
+		{"no certificates in CA file", invalid, true},
+	}
+
+	for _, tt := range tests {
+		cfg, err := MQTTNewTLSConfig(MQTTConfig{Server: "ssl://localhost:8883", CA: tt.ca})
+		if (err != nil) != tt.err {
+			t.Errorf("%s: MQTTNewTLSConfig() error = %v, want error %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && cfg.RootCAs == nil {
+			t.Errorf("%s: CA certificate was not loaded", tt.name)
+		}
+	}
+
+	// the client options must not be created with a CA which can't be read
+	opts, err := NewMQTTClientOptions(MQTTConfig{Server: "ssl://localhost:8883", ClientID: "test", CA: filepath.Join(dir, "missing.pem")})
+	if err == nil {
+		t.Errorf("NewMQTTClientOptions() = %v, want error", opts)
+	}
+}
+
+func TestMQTTNewTLSConfigMinVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		err     bool
+	}{
+		{"", tls.VersionTLS12, false},
+		{"1.2", tls.VersionTLS12, false},
+		{"1.3", tls.VersionTLS13, false},
+		{"1.1", 0, true},
+		{"tls13", 0, true},
+	}
+
+	for _, tt := range tests {
+		cfg, err := MQTTNewTLSConfig(MQTTConfig{Server: "ssl://localhost:8883", TLSMinVersion: tt.version})
+		if (err != nil) != tt.err {
+			t.Errorf("MQTTNewTLSConfig(%q) error = %v, want error %v", tt.version, err, tt.err)
+			continue
+		}
+		if err == nil && cfg.MinVersion != tt.want {
+			t.Errorf("MQTTNewTLSConfig(%q) MinVersion = %#x, want %#x", tt.version, cfg.MinVersion, tt.want)
+		}
+	}
+}
+
+func TestMQTTNewTLSConfigSystemPool(t *testing.T) {
+	if _, err := x509.SystemCertPool(); err != nil {
+		t.Skipf("system certificates are not available: %v", err)
+	}
+
+	cfg, err := MQTTNewTLSConfig(MQTTConfig{Server: "ssl://localhost:8883"})
+	if err != nil {
+		t.Fatalf("MQTTNewTLSConfig() error = %v", err)
+	}
+	if cfg.RootCAs == nil {
+		t.Errorf("system certificates were not loaded when CA is not set")
+	}
+}
+
+func TestMQTTConfigFromEnvCA(t *testing.T) {
+	tests := []struct {
+		file, root string
+		want       string
+	}{
+		{"", "", ""},
+		{"/certs/file.pem", "", "/certs/file.pem"},
+		{"", "/certs/root.pem", "/certs/root.pem"},
+		{"/certs/file.pem", "/certs/root.pem", "/certs/file.pem"},
+	}
+
+	for _, tt := range tests {
+		restoreFile := setenv(t, "MQTT_CA_FILE", tt.file)
+		restoreRoot := setenv(t, "MQTT_CA_ROOT", tt.root)
+		if got := MQTTConfigFromEnv().CA; got != tt.want {
+			t.Errorf("MQTT_CA_FILE=%q MQTT_CA_ROOT=%q: CA = %q, want %q", tt.file, tt.root, got, tt.want)
+		}
+		restoreRoot()
+		restoreFile()
+	}
+}
+
+func TestMQTTSkipVerifyFlagAndEnv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osd-mqtt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := writeCA(t, dir)
+
+	tests := []struct {
+		name string
+		env  string
+		flag bool
+		want bool
+	}{
+		{"neither", "", false, false},
+		{"env", "1", false, true},
+		{"flag", "", true, true},
+		{"both", "1", true, true},
+	}
+
+	for _, tt := range tests {
+		restore := setenv(t, "MQTT_TLS_SKIP_VERIFY", tt.env)
+		args := []string{"-input", "belt.mp4"}
+		if tt.flag {
+			args = append(args, "-mqtt-tls-skip-verify")
+		}
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		fs.SetOutput(ioutil.Discard)
+		cfg, err := LoadConfig(fs, args)
+		restore()
+		if err != nil {
+			t.Fatalf("%s: failed to load configuration: %v", tt.name, err)
+		}
+
+		// the CA is set so the test doesn't depend on the system certificates
+		cfg.MQTT.Server, cfg.MQTT.CA = "ssl://localhost:8883", ca
+		tlsCfg, err := MQTTNewTLSConfig(cfg.MQTT)
+		if err != nil {
+			t.Fatalf("%s: MQTTNewTLSConfig() error = %v", tt.name, err)
+		}
+		if tlsCfg.InsecureSkipVerify != tt.want {
+			t.Errorf("%s: InsecureSkipVerify = %v, want %v", tt.name, tlsCfg.InsecureSkipVerify, tt.want)
+		}
+	}
+}
+
+func TestPublishStatsRecord(t *testing.T) {
+	type publish struct {
+		n        int
+		err      error
+		timedOut bool
+	}
+
+	tests := []struct {
+		name      string
+		publishes []publish
+		want      PublishStats
+	}{
+		{"success", []publish{{10, nil, false}, {20, nil, false}},
+			PublishStats{Attempted: 2, Succeeded: 2, BytesSent: 30}},
+		{"failure", []publish{{10, errors.New("not connected"), false}},
+			PublishStats{Attempted: 1, Failed: 1}},
+		// timed out publishes count as timeouts even if they failed too
+		{"timeout", []publish{{10, nil, true}, {10, errors.New("timed out"), true}},
+			PublishStats{Attempted: 2, TimedOut: 2}},
+		{"mixed", []publish{{10, nil, false}, {20, errors.New("not connected"), false}, {30, nil, true}},
+			PublishStats{Attempted: 3, Succeeded: 1, Failed: 1, TimedOut: 1, BytesSent: 10}},
+	}
+
+	for _, tt := range tests {
+		var stats PublishStats
+		for _, p := range tt.publishes {
+			stats.record(p.n, p.err, p.timedOut)
+		}
+		if stats != tt.want {
+			t.Errorf("%s: stats %+v, want %+v", tt.name, stats, tt.want)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+)
+
+// benchTopic is MQTT topic the publish benchmark publishes to, so the fake results don't reach the consumers
+const benchTopic = "defects/bench"
+
+// benchPayload returns fake result message of size bytes; size 0 returns the message as it is
+func benchPayload(size int) string {
+	r := &detector.Result{
+		Source:   "bench",
+		Severity: detector.SeverityOK,
+		OrigRect: image.Rect(240, 180, 400, 300),
+		Seq:      1,
+		FPS:      30,
+	}
+	msg := r.ToMQTTMessage()
+	if size <= 0 {
+		return msg
+	}
+
+	return strings.Repeat(msg, size/len(msg)+1)[:size]
+}
+
+// benchMQTT publishes fake results to the MQTT server configured by the environment variables as fast as possible
+// and prints publish throughput and latency percentiles. It returns program exit code.
+func benchMQTT(args []string) int {
+	fs := flag.NewFlagSet(name+" bench", flag.ExitOnError)
+	n := fs.Int("n", 10000, "Number of messages to publish")
+	concurrency := fs.Int("concurrency", 1, "Number of goroutines publishing the messages")
+	size := fs.Int("payload-size", 0, "Size of the published messages in bytes; defaults to the size of a result message")
+	benchTo := fs.String("topic", benchTopic, "MQTT topic the messages are published to")
+	fs.Parse(args)
+
+	if *n <= 0 || *concurrency <= 0 || *size < 0 {
+		fmt.Fprintf(os.Stderr, "Invalid benchmark parameters: -n and -concurrency must be positive and -payload-size non-negative\n")
+		return 1
+	}
+
+	opts, err := MQTTClientOptions()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create MQTT client options: %v\n", err)
+		return 1
+	}
+	// the benchmark must not take over the session of a running detector
+	opts.SetClientID(opts.ClientID + "-bench")
+	c, err := MQTTConnect(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to connect to MQTT server: %v\n", err)
+		return 1
+	}
+	defer c.Disconnect(100)
+
+	payload := benchPayload(*size)
+	fmt.Printf("Publishing %d messages of %d bytes to %s using %d goroutines\n", *n, len(payload), *benchTo, *concurrency)
+
+	// every goroutine records its own latencies which are merged once all of them finish
+	jobs := make(chan struct{}, *n)
+	for i := 0; i < *n; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+
+	timers := make([]*stageTimer, *concurrency)
+	failed := make([]int, *concurrency)
+	var wg sync.WaitGroup
+	begin := time.Now()
+	for i := range timers {
+		timers[i] = newStageTimer()
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for range jobs {
+				start := time.Now()
+				if _, err := c.PublishWithQoS(*benchTo, payload, QOS); err != nil {
+					failed[i]++
+					continue
+				}
+				timers[i].record("publish", time.Since(start))
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(begin)
+
+	t := newStageTimer()
+	failures := 0
+	for i, ti := range timers {
+		for _, d := range ti.durations["publish"] {
+			t.record("publish", d)
+		}
+		failures += failed[i]
+	}
+	published := len(t.durations["publish"])
+
+	ms := func(d time.Duration) string {
+		return fmt.Sprintf("%.3f", float64(d)/float64(time.Millisecond))
+	}
+	fmt.Printf("\n%-10s %10s %12s %12s %10s %10s %10s\n", "PUBLISHED", "FAILED", "ELAPSED s", "MSG/s", "P50 ms", "P95 ms", "P99 ms")
+	fmt.Printf("%-10d %10d %12.3f %12.1f %10s %10s %10s\n", published, failures, elapsed.Seconds(),
+		float64(published)/elapsed.Seconds(), ms(t.percentile("publish", 0.5)), ms(t.percentile("publish", 0.95)),
+		ms(t.percentile("publish", 0.99)))
+
+	if failures > 0 {
+		return 1
+	}
+
+	return 0
+}
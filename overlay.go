@@ -0,0 +1,449 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+	"gocv.io/x/gocv"
+)
+
+const (
+	// OverlayNone skips all the annotation, including the defect flash and warning banners, for maximum throughput
+	OverlayNone = "none"
+	// OverlayOff disables the overlay; only the defect flash and warning banners are drawn
+	OverlayOff = "off"
+	// OverlayMinimal only draws the detected part rectangle
+	OverlayMinimal = "minimal"
+	// OverlayFull draws the detected part rectangle together with the measurements and totals
+	OverlayFull = "full"
+)
+
+const (
+	// PaletteDefault draws ok parts green, warnings yellow and defects red
+	PaletteDefault = "default"
+	// PaletteColorblind uses colors which can be told apart with red-green color blindness
+	PaletteColorblind = "colorblind"
+	// PaletteCustom uses colors configured by the user on top of the default palette
+	PaletteCustom = "custom"
+)
+
+// Palette maps part statuses to display colors
+type Palette struct {
+	// OK is color of parts within the expected range
+	OK color.RGBA
+	// Warn is color of parts close to the range bounds or with unconfirmed defect
+	Warn color.RGBA
+	// Defect is color of parts with confirmed defect
+	Defect color.RGBA
+	// Partial is color of parts which are only partially in view
+	Partial color.RGBA
+}
+
+// palettes contains the built-in palettes
+var palettes = map[string]Palette{
+	PaletteDefault: {
+		OK:      detector.SeverityOK.Color(),
+		Warn:    detector.SeverityWarn.Color(),
+		Defect:  detector.SeverityDefect.Color(),
+		Partial: detector.PartialColor,
+	},
+	// Okabe-Ito colors: blue, yellow and vermillion
+	PaletteColorblind: {
+		OK:      color.RGBA{0, 114, 178, 0},
+		Warn:    color.RGBA{240, 228, 66, 0},
+		Defect:  color.RGBA{213, 94, 0, 0},
+		Partial: detector.PartialColor,
+	},
+}
+
+// Color returns display color of part with severity s; partial parts have their own color
+func (p Palette) Color(s detector.Severity, partial bool) color.RGBA {
+	switch {
+	case partial:
+		return p.Partial
+	case s == detector.SeverityDefect:
+		return p.Defect
+	case s == detector.SeverityWarn:
+		return p.Warn
+	}
+
+	return p.OK
+}
+
+// OverlayConfig configures rendering of detection results over displayed frames
+type OverlayConfig struct {
+	// Mode is overlay mode: none, off, minimal or full
+	Mode string `yaml:"mode"`
+	// Scale is overlay font scale factor
+	Scale float64 `yaml:"scale"`
+	// Color is hex RGB color of the overlay text; text follows the result severity if it's empty
+	Color string `yaml:"color"`
+	// RectThickness is line thickness of the detected part rectangle
+	RectThickness int `yaml:"rect_thickness"`
+	// FlashFrames is number of frames the frame border flashes after a defect is confirmed
+	FlashFrames int `yaml:"flash_frames"`
+	// Palette is palette of the part status colors: default, colorblind or custom
+	Palette string `yaml:"palette"`
+	// ColorOK is hex RGB color of ok parts in the custom palette
+	ColorOK string `yaml:"color_ok"`
+	// ColorWarn is hex RGB color of warnings in the custom palette
+	ColorWarn string `yaml:"color_warn"`
+	// ColorDefect is hex RGB color of defects in the custom palette
+	ColorDefect string `yaml:"color_defect"`
+	// ColorPartial is hex RGB color of partially visible parts in the custom palette
+	ColorPartial string `yaml:"color_partial"`
+	// textColor is parsed Color; it's nil if Color is empty
+	textColor *color.RGBA
+	// palette is parsed Palette
+	palette Palette
+}
+
+// RegisterFlags registers command line flags which populate c in flag set fs
+func (c *OverlayConfig) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.Mode, "overlay", OverlayFull, "Overlay of detection results: none, off, minimal or full")
+	fs.StringVar(&c.Mode, "annotate-mode", OverlayFull, "Alias of -overlay")
+	fs.Float64Var(&c.Scale, "overlay-scale", 1.0, "Overlay font scale factor")
+	fs.StringVar(&c.Color, "overlay-color", "", "Hex RGB color of overlay text, e.g. ffffff; follows result severity if empty")
+	fs.IntVar(&c.RectThickness, "rect-thickness", 2, "Line thickness of the detected part rectangle")
+	fs.IntVar(&c.FlashFrames, "flash-frames", 0, "Number of frames the frame border flashes after a defect is confirmed")
+	fs.StringVar(&c.Palette, "palette", PaletteDefault, "Palette of part status colors: default, colorblind or custom")
+	fs.StringVar(&c.ColorOK, "color-ok", "", "Hex RGB color of ok parts in the custom palette")
+	fs.StringVar(&c.ColorWarn, "color-warn", "", "Hex RGB color of warnings in the custom palette")
+	fs.StringVar(&c.ColorDefect, "color-defect", "", "Hex RGB color of defects in the custom palette")
+	fs.StringVar(&c.ColorPartial, "color-partial", "", "Hex RGB color of partially visible parts in the custom palette")
+}
+
+// Validate validates overlay configuration and parses its color
+// It returns error if the configuration is invalid.
+func (c *OverlayConfig) Validate() error {
+	if c.Mode != OverlayNone && c.Mode != OverlayOff && c.Mode != OverlayMinimal && c.Mode != OverlayFull {
+		return fmt.Errorf("invalid overlay %q: expected none, off, minimal or full", c.Mode)
+	}
+
+	if c.Scale <= 0 {
+		return fmt.Errorf("invalid overlay scale: %g", c.Scale)
+	}
+
+	if c.RectThickness <= 0 {
+		return fmt.Errorf("invalid rectangle thickness: %d", c.RectThickness)
+	}
+
+	if c.FlashFrames < 0 {
+		return fmt.Errorf("invalid number of flash frames: %d", c.FlashFrames)
+	}
+
+	if c.Color != "" {
+		clr, err := parseHexColor(c.Color)
+		if err != nil {
+			return err
+		}
+		c.textColor = &clr
+	}
+
+	return c.parsePalette()
+}
+
+// parsePalette parses the palette and its custom colors
+// Custom palette starts from the default one; custom colors are rejected with the built-in palettes.
+func (c *OverlayConfig) parsePalette() error {
+	custom := []struct {
+		name string
+		hex  string
+		clr  *color.RGBA
+	}{
+		{"ok", c.ColorOK, &c.palette.OK},
+		{"warning", c.ColorWarn, &c.palette.Warn},
+		{"defect", c.ColorDefect, &c.palette.Defect},
+		{"partial", c.ColorPartial, &c.palette.Partial},
+	}
+
+	if c.Palette != PaletteCustom {
+		p, ok := palettes[c.Palette]
+		if !ok {
+			return fmt.Errorf("invalid palette %q: expected %s, %s or %s", c.Palette, PaletteDefault, PaletteColorblind, PaletteCustom)
+		}
+		for _, cc := range custom {
+			if cc.hex != "" {
+				return fmt.Errorf("%s color requires %s palette", cc.name, PaletteCustom)
+			}
+		}
+		c.palette = p
+		return nil
+	}
+
+	c.palette = palettes[PaletteDefault]
+	for _, cc := range custom {
+		if cc.hex == "" {
+			continue
+		}
+		clr, err := parseHexColor(cc.hex)
+		if err != nil {
+			return fmt.Errorf("invalid %s color: %v", cc.name, err)
+		}
+		*cc.clr = clr
+	}
+
+	return nil
+}
+
+// parseHexColor parses RGB color in hex format, optionally prefixed with #, and returns it
+// It returns error if s is not a valid hex RGB color.
+func parseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: expected RRGGBB", s)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: %v", s, err)
+	}
+
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0}, nil
+}
+
+// flashAlpha is opacity of the defect flash
+const flashAlpha = 0.5
+
+// renderFlash blends translucent border and banner of color clr into screen image
+func renderFlash(screen *gocv.Mat, clr color.RGBA) {
+	flash := screen.Clone()
+	defer flash.Close()
+
+	border := screen.Rows() / 20
+	gocv.Rectangle(&flash, image.Rect(0, 0, screen.Cols(), screen.Rows()), clr, 2*border)
+	gocv.Rectangle(&flash, image.Rect(0, screen.Rows()-3*border, screen.Cols(), screen.Rows()), clr, -1)
+	gocv.PutText(&flash, "DEFECT", image.Point{border, screen.Rows() - border}, gocv.FontHersheySimplex,
+		float64(border)/15, color.RGBA{255, 255, 255, 0}, 2)
+	gocv.AddWeighted(flash, flashAlpha, *screen, 1-flashAlpha, 0, screen)
+}
+
+// warningBlinkPeriod is period of the blinking warning banner
+const warningBlinkPeriod = time.Second
+
+// renderWarning draws blinking warning banner with text of color clr at the top of screen image
+func renderWarning(screen *gocv.Mat, text string, clr color.RGBA) {
+	if time.Now().UnixNano()/int64(warningBlinkPeriod/2)%2 == 1 {
+		return
+	}
+
+	border := screen.Rows() / 20
+	gocv.Rectangle(screen, image.Rect(0, 0, screen.Cols(), 3*border), clr, -1)
+	gocv.PutText(screen, text, image.Point{border, 2 * border}, gocv.FontHersheySimplex,
+		float64(border)/15, color.RGBA{255, 255, 255, 0}, 2)
+}
+
+// renderStale labels screen image with age of the stale detection result drawn over it in color clr
+func renderStale(screen *gocv.Mat, age time.Duration, clr color.RGBA) {
+	border := screen.Rows() / 20
+	gocv.PutText(screen, fmt.Sprintf("DETECTION LAGGING: RESULT %.1fs OLD", age.Seconds()),
+		image.Point{border, screen.Rows() - 4*border}, gocv.FontHersheySimplex, float64(border)/30, clr, 1)
+}
+
+// crosshairSize is length of the centroid crosshair arms
+const crosshairSize = 6
+
+// renderCrosshair draws small crosshair of color clr and thickness at point p of screen image
+func renderCrosshair(screen *gocv.Mat, p image.Point, clr color.RGBA, thickness int) {
+	gocv.Line(screen, p.Sub(image.Point{crosshairSize, 0}), p.Add(image.Point{crosshairSize, 0}), clr, thickness)
+	gocv.Line(screen, p.Sub(image.Point{0, crosshairSize}), p.Add(image.Point{0, crosshairSize}), clr, thickness)
+}
+
+// renderLabel draws area, defect status and class of part result r inside its rectangle in color clr
+// The text is drawn over black background so it stays readable over the part.
+func renderLabel(screen *gocv.Mat, r detector.Result, clr color.RGBA, scale float64) {
+	text := fmt.Sprintf("%d %s", detector.Area(r.Rect), r.Severity)
+	if r.Defect {
+		text += " DEFECT"
+	}
+	pos := r.Rect.Min.Add(image.Point{5, int(15 * scale)})
+	size := gocv.GetTextSize(text, gocv.FontHersheySimplex, 0.4*scale, 1)
+	bg := image.Rect(pos.X-2, pos.Y-size.Y-3, pos.X+size.X+2, pos.Y+4)
+	gocv.Rectangle(screen, bg, color.RGBA{0, 0, 0, 0}, -1)
+	gocv.PutText(screen, text, pos, gocv.FontHersheySimplex, 0.4*scale, clr, 1)
+}
+
+// renderTriggerLine draws trigger line l across screen with an arrow in the direction the parts cross it
+func renderTriggerLine(screen *gocv.Mat, l detector.TriggerLine, clr color.RGBA) {
+	from, to := l.Ends(image.Point{screen.Cols(), screen.Rows()})
+	gocv.Line(screen, from, to, clr, 1)
+
+	// the arrow starts at the middle of the line
+	mid := from.Add(to).Div(2)
+	tip := mid.Add(image.Point{0, 20 * l.Dir})
+	if l.Vertical {
+		tip = mid.Add(image.Point{20 * l.Dir, 0})
+	}
+	gocv.ArrowedLine(screen, mid, tip, clr, 1)
+}
+
+// FrameAnnotator draws detection results over displayed frames
+type FrameAnnotator interface {
+	// Annotate draws result measured against area range [min, max] over screen image
+	Annotate(screen *gocv.Mat, result *detector.Result, min, max int)
+}
+
+// NewFrameAnnotator returns frame annotator of overlay configuration cfg
+func NewFrameAnnotator(cfg OverlayConfig) FrameAnnotator {
+	switch cfg.Mode {
+	case OverlayNone:
+		return NoneAnnotator{}
+	case OverlayOff:
+		return AlertAnnotator{cfg}
+	case OverlayMinimal:
+		return MinimalAnnotator{cfg}
+	}
+
+	return DefaultAnnotator{cfg}
+}
+
+// NoneAnnotator draws nothing
+type NoneAnnotator struct{}
+
+// Annotate implements FrameAnnotator interface; it leaves screen untouched
+func (NoneAnnotator) Annotate(screen *gocv.Mat, result *detector.Result, min, max int) {}
+
+// AlertAnnotator only flashes the frame for confirmed defects and warns about belt jams, blocked camera and stalled line
+type AlertAnnotator struct {
+	// Config is overlay configuration
+	Config OverlayConfig
+}
+
+// Annotate implements FrameAnnotator interface
+// Confirmed defects flash the frame for Config.FlashFrames frames.
+func (a AlertAnnotator) Annotate(screen *gocv.Mat, result *detector.Result, min, max int) {
+	if result.DefectAge > 0 && result.DefectAge <= a.Config.FlashFrames {
+		renderFlash(screen, a.Config.palette.Defect)
+	}
+
+	// blocked camera and stalled line explain the jam, so they take its place
+	switch {
+	case result.CameraBlocked:
+		renderWarning(screen, "CAMERA BLOCKED: CHECK LENS AND LIGHTS", a.Config.palette.Defect)
+	case result.LineStalled:
+		renderWarning(screen, "LINE STALLED: NO MOVEMENT", a.Config.palette.Defect)
+	case result.BeltJam:
+		renderWarning(screen, "BELT JAM: NO PART IN VIEW", a.Config.palette.Defect)
+	}
+}
+
+// MinimalAnnotator draws the alerts and the detected part rectangles without any text
+type MinimalAnnotator struct {
+	// Config is overlay configuration
+	Config OverlayConfig
+}
+
+// Annotate implements FrameAnnotator interface
+// If the result has zone results, every zone is outlined and labeled with its name in the color of its severity.
+func (a MinimalAnnotator) Annotate(screen *gocv.Mat, result *detector.Result, min, max int) {
+	AlertAnnotator(a).Annotate(screen, result, min, max)
+
+	cfg := a.Config
+	for _, r := range resultParts(result) {
+		// overlay color follows result severity in the configured palette
+		clr := cfg.palette.Color(r.Severity, r.Partial)
+
+		if r.Zone != "" {
+			gocv.Rectangle(screen, r.ZoneRect, clr, 1)
+			pos := r.ZoneRect.Min.Add(image.Point{4, int(15 * cfg.Scale)})
+			gocv.PutText(screen, r.Zone, pos, gocv.FontHersheySimplex, 0.5*cfg.Scale, clr, 1)
+		}
+
+		// draw part rectangle: grey for partial part, red for defect, yellow for warning, green otherwise by default
+		if !r.Rect.Empty() {
+			gocv.Rectangle(screen, r.Rect, clr, cfg.RectThickness)
+			renderCrosshair(screen, r.Centroid, clr, cfg.RectThickness)
+		}
+	}
+}
+
+// DefaultAnnotator draws the part rectangles together with the measurements, totals and defect rate
+type DefaultAnnotator struct {
+	// Config is overlay configuration
+	Config OverlayConfig
+}
+
+// Annotate implements FrameAnnotator interface
+// Zone results are measured against their own area range; the whole frame is measured against [min, max].
+// Every detected part is labeled with its measurements next to the part, in the color of its severity.
+func (a DefaultAnnotator) Annotate(screen *gocv.Mat, result *detector.Result, min, max int) {
+	MinimalAnnotator(a).Annotate(screen, result, min, max)
+
+	cfg := a.Config
+	for _, r := range resultParts(result) {
+		if !r.Rect.Empty() {
+			renderLabel(screen, r, cfg.palette.Color(r.Severity, r.Partial), cfg.Scale)
+		}
+	}
+
+	clr := cfg.palette.Color(result.Severity, result.Partial)
+	if cfg.textColor != nil {
+		clr = *cfg.textColor
+	}
+
+	var lines []string
+	for _, r := range resultParts(result) {
+		if r.Zone == "" {
+			r.Min, r.Max = min, max
+		}
+		// detected measurements
+		line := fmt.Sprintf("Measurement: %d Expected range: [%d - %d] Defect: %v", detector.Area(r.Rect), r.Min, r.Max, r.Defect)
+		if r.Confidence > 0 {
+			line = fmt.Sprintf("%s (%.0f%%)", line, 100*r.Confidence)
+		}
+		if r.Zone != "" {
+			line = fmt.Sprintf("%s: %s %s", r.Zone, line, r.String())
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines,
+		// defect detection results
+		result.String(),
+		// rolling defect rate
+		fmt.Sprintf("Defect rate: %.1f%%", 100*result.DefectRate),
+	)
+
+	scale := 0.5 * cfg.Scale
+	for i, line := range lines {
+		pos := image.Point{0, int(float64(15+25*i) * cfg.Scale)}
+		gocv.PutText(screen, line, pos, gocv.FontHersheySimplex, scale, clr, 2)
+	}
+}
+
+// resultParts returns zone results of result, or result itself if it has no zones
+func resultParts(result *detector.Result) []detector.Result {
+	if len(result.Zones) > 0 {
+		return result.Zones
+	}
+
+	return []detector.Result{*result}
+}
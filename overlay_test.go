@@ -0,0 +1,124 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"image/color"
+	"testing"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+)
+
+func TestParseHexColor(t *testing.T) {
+	tests := []struct {
+		s     string
+		want  color.RGBA
+		valid bool
+	}{
+		{"ff8000", color.RGBA{255, 128, 0, 0}, true},
+		{"#0072B2", color.RGBA{0, 114, 178, 0}, true},
+		{"000000", color.RGBA{}, true},
+		{"", color.RGBA{}, false},
+		{"fff", color.RGBA{}, false},
+		{"ff80001", color.RGBA{}, false},
+		{"gg8000", color.RGBA{}, false},
+		{"0x8000", color.RGBA{}, false},
+		{"+f8000", color.RGBA{}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := parseHexColor(tt.s)
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("parseHexColor(%q) error = %v, want valid %v", tt.s, err, tt.valid)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseHexColor(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestPaletteColor(t *testing.T) {
+	p := Palette{
+		OK:      color.RGBA{1, 0, 0, 0},
+		Warn:    color.RGBA{2, 0, 0, 0},
+		Defect:  color.RGBA{3, 0, 0, 0},
+		Partial: color.RGBA{4, 0, 0, 0},
+	}
+
+	tests := []struct {
+		severity detector.Severity
+		partial  bool
+		want     color.RGBA
+	}{
+		{detector.SeverityOK, false, p.OK},
+		{detector.SeverityWarn, false, p.Warn},
+		{detector.SeverityDefect, false, p.Defect},
+		// partial parts are neither measured nor classified
+		{detector.SeverityOK, true, p.Partial},
+		{detector.SeverityDefect, true, p.Partial},
+	}
+
+	for _, tt := range tests {
+		if got := p.Color(tt.severity, tt.partial); got != tt.want {
+			t.Errorf("Color(%v, %v) = %v, want %v", tt.severity, tt.partial, got, tt.want)
+		}
+	}
+}
+
+func TestOverlayPalette(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  OverlayConfig
+		want Palette
+		// valid means the configuration is expected to be valid
+		valid bool
+	}{
+		{"default", OverlayConfig{Palette: PaletteDefault}, palettes[PaletteDefault], true},
+		{"colorblind", OverlayConfig{Palette: PaletteColorblind}, palettes[PaletteColorblind], true},
+		{"custom without colors", OverlayConfig{Palette: PaletteCustom}, palettes[PaletteDefault], true},
+		{"custom", OverlayConfig{Palette: PaletteCustom, ColorOK: "0000ff", ColorPartial: "#ffffff"}, Palette{
+			OK:      color.RGBA{0, 0, 255, 0},
+			Warn:    palettes[PaletteDefault].Warn,
+			Defect:  palettes[PaletteDefault].Defect,
+			Partial: color.RGBA{255, 255, 255, 0},
+		}, true},
+		{"invalid custom color", OverlayConfig{Palette: PaletteCustom, ColorDefect: "red"}, Palette{}, false},
+		{"custom color with built-in palette", OverlayConfig{Palette: PaletteColorblind, ColorWarn: "ffff00"}, Palette{}, false},
+		{"unknown palette", OverlayConfig{Palette: "pastel"}, Palette{}, false},
+	}
+
+	for _, tt := range tests {
+		cfg := tt.cfg
+		cfg.Mode, cfg.Scale, cfg.RectThickness = OverlayFull, 1, 2
+		err := cfg.Validate()
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("%s: Validate error = %v, want valid %v", tt.name, err, tt.valid)
+			continue
+		}
+		if tt.valid && cfg.palette != tt.want {
+			t.Errorf("%s: palette %+v, want %+v", tt.name, cfg.palette, tt.want)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import "time"
+
+// Pacer paces playback of a video file to the capture timestamps of its frames,
+// so the file plays in real time regardless of how long the frames take to process
+type Pacer struct {
+	// speed is playback speed multiplier; 2 plays the file twice as fast
+	speed float64
+	// started is set once the first frame was paced
+	started bool
+	// start is time when the first frame was due
+	start time.Time
+	// origin is capture timestamp of the first frame in milliseconds
+	origin float64
+	// last is capture timestamp of the last frame in milliseconds
+	last float64
+}
+
+// NewPacer creates new pacer which plays video file speed times faster than real time and returns it
+func NewPacer(speed float64) *Pacer {
+	return &Pacer{speed: speed}
+}
+
+// Next returns how long to wait until frame with capture timestamp pos milliseconds is due.
+// It's negative if the frame is late, i.e. processing of the previous frames exceeded their frame budget.
+// The first frame is due immediately; pacing starts over whenever the timestamps go back, e.g. when the file loops.
+func (p *Pacer) Next(pos float64) time.Duration {
+	now := time.Now()
+	if !p.started || pos < p.last {
+		p.started, p.start, p.origin = true, now, pos
+	}
+	p.last = pos
+
+	due := p.start.Add(time.Duration((pos - p.origin) / p.speed * float64(time.Millisecond)))
+	return due.Sub(now)
+}
@@ -0,0 +1,656 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+	"gocv.io/x/gocv"
+)
+
+// staleAlpha is opacity of the annotation of stale detection results
+const staleAlpha = 0.4
+
+// displayState is the detection result displayed over the live frames of a video source
+// Results arrive whenever frameRunner finishes a frame while the frames are displayed as they're read,
+// so the displayed result may lag behind the frame it's drawn over.
+type displayState struct {
+	// result is copy of the latest detection result
+	result detector.Result
+	// updated is time when the result arrived; it's zero until the first result arrives
+	updated time.Time
+	// stale means the result is older than the staleness limit, i.e. the detection lags behind the display
+	stale bool
+}
+
+// set replaces the displayed result with result which arrived at now
+func (s *displayState) set(result *detector.Result, now time.Time) {
+	s.result, s.updated, s.stale = *result, now, false
+}
+
+// refresh marks the displayed result stale if it's older than maxAge at now; it's never stale if maxAge is 0
+func (s *displayState) refresh(now time.Time, maxAge time.Duration) {
+	s.stale = maxAge > 0 && !s.updated.IsZero() && now.Sub(s.updated) > maxAge
+}
+
+// age returns age of the displayed result at now
+func (s *displayState) age(now time.Time) time.Duration {
+	return now.Sub(s.updated)
+}
+
+// pipeline captures frames from a single video source and hands them over to its frameRunner
+type pipeline struct {
+	// src is video source of the pipeline
+	src Source
+	// cfg is pipeline configuration; scale and area range are specific to the source
+	cfg Config
+	// vc is video capture of the source
+	vc Capture
+	// delay is video play delay of the source
+	delay float64
+	// size is processing frame size
+	size image.Point
+	// img is the last frame read from the source
+	img gocv.Mat
+	// pending is set when frameSize had to read the first frame to measure it
+	pending bool
+	// warp is perspective transformation to the top-down view of the belt; it's nil if disabled
+	warp *gocv.Mat
+	// warpSize is size of the top-down view of the belt
+	warpSize image.Point
+	// framesChan provides the source of images to process
+	framesChan chan *frame
+	// resultsChan is used for detection distribution
+	resultsChan chan *detector.Result
+	// configChan delivers reloaded detector configuration to frameRunner
+	configChan chan DetectorConfig
+	// cmdChan delivers operator commands to frameRunner
+	cmdChan chan Command
+	// display is the latest detection result drawn over the frames
+	display displayState
+	// wd monitors frame processing of the pipeline; it's nil if the watchdog is disabled
+	wd *Watchdog
+	// rec records annotated frames; it's nil if recording is disabled
+	rec *Recorder
+	// snap keeps the latest annotated frame for snapshot requests; it's nil if snapshots are disabled
+	snap *Snapshot
+	// clips buffers raw frames for defect clips; it's nil if the clips are disabled
+	clips *ClipBuffer
+	// exported is time when the last frame was exported
+	exported time.Time
+	// dropped counts frames not sent for detection because frameRunner was busy
+	dropped int
+	// busy counts consecutive frames not sent for detection; busyLogged is time when they were last logged
+	busy       int
+	busyLogged time.Time
+	// seq is sequence number of the last frame sent for detection
+	seq uint64
+	// pacer paces video file playback in real time; it's nil if pacing is disabled
+	pacer *Pacer
+	// mask excludes fixed structures of the processing frame from detection; it's nil if disabled
+	mask *gocv.Mat
+	// lateLogged is time when frame processing falling behind real time was last logged
+	lateLogged time.Time
+	// tracer traces the sampled frames; it's nil if tracing is disabled
+	tracer *Tracer
+	// fgVC is video capture of the foreground mask camera; it's nil if disabled
+	fgVC Capture
+	// fg is the last foreground mask read from fgVC
+	fg gocv.Mat
+	// readAt is time when reading of the last frame started
+	readAt time.Time
+}
+
+// newPipeline opens video capture for source src and creates new pipeline for it.
+// Processing frame size preserves the aspect ratio of the source; area range is scaled
+// to processing resolution if calibration resolution is configured.
+// It fails with ErrCaptureOpen if the video capture can't be opened or its frame size can't be determined.
+func newPipeline(src Source, cfg Config) (*pipeline, error) {
+	// create new video capture
+	vc, delay, fps, err := NewCapture(src, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &pipeline{
+		src:         src,
+		cfg:         cfg,
+		vc:          vc,
+		delay:       delay,
+		img:         gocv.NewMat(),
+		framesChan:  make(chan *frame, cfg.FramesBuf),
+		resultsChan: make(chan *detector.Result, cfg.ResultsBuf),
+		configChan:  make(chan DetectorConfig, 1),
+		cmdChan:     make(chan Command, 1),
+		fg:          gocv.NewMat(),
+	}
+
+	// compute processing frame size preserving the aspect ratio of the input
+	origSize, err := frameSize(vc, &p.img)
+	if err != nil {
+		p.close()
+		return nil, &ErrCaptureOpen{src.Name, fmt.Errorf("error reading video capture frame size: %v", err)}
+	}
+	p.pending = !p.img.Empty()
+
+	// compute perspective transformation of the belt corners to the top-down view
+	if p.warp, p.warpSize = perspectiveWarp(cfg, origSize); p.warp != nil {
+		fmt.Printf("Warping %s belt to %dx%d top-down view\n", src.Name, p.warpSize.X, p.warpSize.Y)
+		origSize = p.warpSize
+	}
+
+	if p.cfg.FPS = fps; fps > 0 {
+		fmt.Printf("Capturing %s at %.1f FPS\n", src.Name, fps)
+	} else {
+		fmt.Printf("Capturing %s at unknown frame rate\n", src.Name)
+	}
+
+	// only video files have capture timestamps to pace the playback by
+	if cfg.Realtime && src.Input != "" {
+		p.pacer = NewPacer(cfg.Speed)
+		fmt.Printf("Playing %s in real time at %gx speed\n", src.Name, cfg.Speed)
+	}
+
+	p.size = procSize(origSize, cfg.ProcWidth)
+	p.cfg.Scale = float64(p.size.X) / float64(origSize.X)
+	fmt.Printf("Processing %s frames at %dx%d (input %dx%d, scale factor %.3f)\n",
+		src.Name, p.size.X, p.size.Y, origSize.X, origSize.Y, p.cfg.Scale)
+
+	if cfg.MaskFile != "" {
+		if p.mask, err = loadMask(cfg.MaskFile, p.size); err != nil {
+			p.close()
+			return nil, err
+		}
+	}
+
+	if cfg.MaskDevice >= 0 {
+		if p.fgVC, err = gocv.VideoCaptureDevice(cfg.MaskDevice); err != nil {
+			p.close()
+			return nil, &ErrCaptureOpen{fmt.Sprintf("mask device %d", cfg.MaskDevice), err}
+		}
+		fmt.Printf("Detecting %s parts in foreground mask of device %d\n", src.Name, cfg.MaskDevice)
+	}
+
+	// scale min and max areas from calibration resolution to processing resolution
+	p.cfg.DetectorConfig = p.detectorConfig(cfg.DetectorConfig)
+	p.display.result.Min, p.display.result.Max = p.cfg.Min, p.cfg.Max
+
+	return p, nil
+}
+
+// perspectiveWarp returns perspective transformation of the belt corners configured in cfg to the top-down view
+// of frames of size origSize together with the size of the view; it returns nil if the warp is disabled.
+// The returned transformation must be closed by the caller.
+func perspectiveWarp(cfg Config, origSize image.Point) (*gocv.Mat, image.Point) {
+	if cfg.Perspective == nil {
+		return nil, origSize
+	}
+
+	size := image.Point{cfg.WarpWidth, cfg.WarpHeight}
+	if size.X == 0 {
+		size.X = origSize.X
+	}
+	if size.Y == 0 {
+		size.Y = origSize.Y
+	}
+	dst := []image.Point{{0, 0}, {size.X, 0}, {size.X, size.Y}, {0, size.Y}}
+	warp := gocv.GetPerspectiveTransform(cfg.Perspective, dst)
+
+	return &warp, size
+}
+
+// detectorConfig returns detector configuration dc with its area ranges scaled
+// from calibration resolution to processing resolution of the pipeline and with the pipeline mask
+func (p *pipeline) detectorConfig(dc DetectorConfig) DetectorConfig {
+	dc.Mask = p.mask
+	if p.cfg.CalibRes == "" {
+		return dc
+	}
+
+	factor := areaScale(p.size, p.cfg.Calib)
+	dc = scaleAreas(dc, factor)
+	fmt.Printf("Scaled %s area range by %.3f to [%d - %d]\n", p.src.Name, factor, dc.Min, dc.Max)
+
+	return dc
+}
+
+// reconfigure scales detector configuration dc to the pipeline and hands it over to its frameRunner
+// frameRunner gets its own snapshot of the configuration and applies it before processing the next frame,
+// so the configuration is never shared between the goroutines while it changes.
+func (p *pipeline) reconfigure(dc DetectorConfig) {
+	p.cfg.DetectorConfig = p.detectorConfig(dc)
+
+	// replace any configuration frameRunner has not picked up yet
+	select {
+	case <-p.configChan:
+	default:
+	}
+	p.configChan <- p.cfg.DetectorConfig.Clone()
+}
+
+// read reads the next non-empty frame from the source and resizes it to processing frame size
+// It returns false if no more frames can be read from the source
+func (p *pipeline) read() bool {
+	p.readAt = time.Now()
+	for {
+		// frameSize may have already read the first frame
+		if p.pending {
+			p.pending = false
+		} else if ok := p.vc.Read(&p.img); !ok {
+			return false
+		}
+
+		if !p.img.Empty() {
+			break
+		}
+	}
+
+	p.transform()
+
+	// the mask camera is read in lockstep with the frames
+	if p.fgVC != nil {
+		if ok := p.fgVC.Read(&p.fg); !ok || p.fg.Empty() {
+			fmt.Fprintf(os.Stderr, "Failed to read foreground mask of %s\n", p.src.Name)
+			return false
+		}
+		p.transformMask()
+	}
+
+	return true
+}
+
+// transform warps the last frame to the top-down view of the belt if enabled
+// and resizes it to processing frame size
+func (p *pipeline) transform() {
+	if p.warp != nil {
+		gocv.WarpPerspective(p.img, &p.img, *p.warp, p.warpSize)
+	}
+
+	// resize frame image to smaller size
+	gocv.Resize(p.img, &p.img, p.size, 0, 0, gocv.InterpolationLinear)
+}
+
+// transformMask warps and resizes the last foreground mask the same way as the frames
+// and binarizes it, so it can replace the thresholded frame
+func (p *pipeline) transformMask() {
+	if p.warp != nil {
+		gocv.WarpPerspective(p.fg, &p.fg, *p.warp, p.warpSize)
+	}
+	gocv.Resize(p.fg, &p.fg, p.size, 0, 0, gocv.InterpolationNearestNeighbor)
+	if p.fg.Channels() > 1 {
+		gocv.CvtColor(p.fg, &p.fg, gocv.ColorBGRToGray)
+	}
+	gocv.Threshold(p.fg, &p.fg, 127, 255, gocv.ThresholdBinary)
+}
+
+// pace waits until the last frame is due in real time if pacing is enabled
+// Time spent processing the previous frame counts against the wait; when the frame is already late,
+// a warning is logged at most once a second.
+func (p *pipeline) pace() {
+	if p.pacer == nil {
+		return
+	}
+
+	wait := p.pacer.Next(p.vc.Get(gocv.VideoCapturePosMsec))
+	if wait > 0 {
+		time.Sleep(wait)
+		return
+	}
+
+	if time.Since(p.lateLogged) >= time.Second {
+		fmt.Fprintf(os.Stderr, "Warning: %s processing exceeds the frame budget; %v behind real time\n", p.src.Name, -wait)
+		p.lateLogged = time.Now()
+	}
+}
+
+// send sends a copy of the last frame for detection unless frameRunner is still busy
+func (p *pipeline) send() {
+	fimg := p.img.Clone()
+	// frames which are not sent leave a gap in the sequence numbers frameRunner detects
+	seq := atomic.AddUint64(&p.seq, 1)
+	// the frame span covers the frame from capture until its results are handed over
+	var span *Span
+	if p.tracer.Sample() {
+		span = p.tracer.Root("frame", p.readAt)
+		span.SetString("source", p.src.Name)
+		span.SetInt("frame.seq", int64(seq))
+		span.Child("capture", p.readAt).End()
+	}
+	f := &frame{img: &fimg, SeqNum: seq, span: span}
+	if p.fgVC != nil {
+		fg := p.fg.Clone()
+		f.mask = &fg
+	}
+	select {
+	case p.framesChan <- f:
+		if p.busy > 1 {
+			fmt.Fprintf(os.Stderr, "Warning: discarded %d consecutive %s frames while detection was busy\n", p.busy, p.src.Name)
+		}
+		p.busy = 0
+	case <-time.After(frameSendTimeout):
+		// busy detection discards every captured frame, so only the first one and a count once a second are logged
+		if p.busy++; p.busy == 1 {
+			fmt.Fprintf(os.Stderr, "Warning: %s detection is busy; discarding frames\n", p.src.Name)
+			p.busyLogged = time.Now()
+		} else if time.Since(p.busyLogged) >= time.Second {
+			fmt.Fprintf(os.Stderr, "Warning: %s detection is still busy; discarded %d frames\n", p.src.Name, p.busy)
+			p.busyLogged = time.Now()
+		}
+		f.close()
+		p.dropped++
+		span.SetString("error", "detection busy")
+		span.End()
+	}
+}
+
+// drain waits up to timeout until frameRunner has received all the frames sent for detection
+func (p *pipeline) drain(timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for len(p.framesChan) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// update replaces the displayed detection result if frameRunner produced new ones and checks its staleness
+func (p *pipeline) update() {
+	defer func() {
+		p.display.refresh(time.Now(), time.Duration(p.cfg.StaleResult)*time.Millisecond)
+	}()
+
+	for {
+		select {
+		case result := <-p.resultsChan:
+			if result == nil {
+				return
+			}
+			p.display.set(result, time.Now())
+		default:
+			// do nothing; just display latest results
+			return
+		}
+	}
+}
+
+// export exports the last frame labeled with the latest detection result
+// unless the previous frame was exported less than interval ago
+func (p *pipeline) export(e *FrameExporter, interval time.Duration) {
+	if time.Since(p.exported) < interval {
+		return
+	}
+
+	e.Export(p.img, &p.display.result)
+	p.exported = time.Now()
+}
+
+// clip buffers the last frame for defect clips and cuts clips for the new defects of the latest result
+// Complete clips are handed over to clip recorder r.
+func (p *pipeline) clip(r *ClipRecorder) {
+	p.clips.Add(p.img, r)
+	p.clips.Cut(&p.display.result)
+}
+
+// record records annotated frame screen if recording is enabled
+// Recording is stopped if the frame can't be recorded.
+func (p *pipeline) record(screen gocv.Mat) {
+	if p.rec == nil {
+		return
+	}
+
+	if err := p.rec.Write(screen); err != nil {
+		fmt.Fprintf(os.Stderr, "Stopping %s recording: %v\n", p.src.Name, err)
+		p.rec.Close()
+		p.rec = nil
+	}
+}
+
+// render returns a copy of the last frame with detection results drawn over it
+// Stale results are faded and labeled with their age, so operators can see the detection is lagging.
+// The returned image must be closed by the caller.
+func (p *pipeline) render() gocv.Mat {
+	screen := p.img.Clone()
+	if p.display.stale && p.cfg.Overlay.Mode != OverlayNone {
+		annotated := p.img.Clone()
+		NewFrameAnnotator(p.cfg.Overlay).Annotate(&annotated, &p.display.result, p.cfg.Min, p.cfg.Max)
+		gocv.AddWeighted(annotated, staleAlpha, screen, 1-staleAlpha, 0, &screen)
+		annotated.Close()
+		renderStale(&screen, p.display.age(time.Now()), p.cfg.Overlay.palette.Warn)
+	} else {
+		NewFrameAnnotator(p.cfg.Overlay).Annotate(&screen, &p.display.result, p.cfg.Min, p.cfg.Max)
+	}
+	if p.cfg.Trigger != nil && p.cfg.Overlay.Mode != OverlayNone {
+		renderTriggerLine(&screen, *p.cfg.Trigger, p.cfg.Overlay.palette.Warn)
+	}
+
+	return screen
+}
+
+// close releases the pipeline video capture and frames which were never processed
+// It must only be called once frameRunner of the pipeline has stopped.
+func (p *pipeline) close() {
+	close(p.framesChan)
+	for f := range p.framesChan {
+		f.close()
+	}
+	p.img.Close()
+	p.fg.Close()
+	if p.fgVC != nil {
+		p.fgVC.Close()
+	}
+	if p.rec != nil {
+		p.rec.Close()
+	}
+	if p.warp != nil {
+		p.warp.Close()
+	}
+	if p.snap != nil {
+		p.snap.Close()
+	}
+	if p.clips != nil {
+		p.clips.Close()
+	}
+	if p.mask != nil {
+		p.mask.Close()
+	}
+	p.vc.Close()
+}
+
+// displayProbeTimeout is time within which display windows must show a probe frame; the program runs headless otherwise
+const displayProbeTimeout = 5 * time.Second
+
+func init() {
+	// HighGUI must be driven from the main OS thread on some window systems; the display runs in the main goroutine
+	runtime.LockOSThread()
+}
+
+// display shows rendered pipeline frames either in separate windows or in a single grid window
+// When the windows fail, the display falls back to headless mode: frames are no longer shown,
+// but waitKey keeps throttling the main loop the same way so the processing throughput does not change.
+// The display must only be used from the main goroutine.
+type display struct {
+	// windows contains display windows
+	windows []*gocv.Window
+	// grid composes all frames into a single window
+	grid bool
+	// headless is 1 once the display failed; it must be accessed atomically
+	headless int32
+	// notify is called with the error the display failed with; it can be nil
+	notify func(error)
+}
+
+// newDisplay opens display windows for pipelines pipes using layout and shows a probe frame in them
+// Layout grid shows all the frames in a single window; otherwise each pipeline gets its own window.
+// If the windows fail to show the probe frame or it takes longer than displayProbeTimeout, the display is headless
+// from the start. notify is called if the display fails; it can be nil. It must be called from the main goroutine.
+func newDisplay(layout string, pipes []*pipeline, notify func(error)) *display {
+	d := &display{grid: layout == "grid", notify: notify}
+
+	var titles []string
+	switch {
+	case d.grid || len(pipes) == 1:
+		titles = []string{name}
+	default:
+		for _, p := range pipes {
+			titles = append(titles, fmt.Sprintf("%s - %s", name, p.src.Name))
+		}
+	}
+
+	start := time.Now()
+	windows, err := openWindows(titles)
+	d.windows = windows
+	switch elapsed := time.Since(start); {
+	case err != nil:
+		d.fallback(err)
+	case elapsed > displayProbeTimeout:
+		d.fallback(fmt.Errorf("probe frame took %v to show, more than %v", elapsed, displayProbeTimeout))
+	}
+
+	return d
+}
+
+// newHeadlessDisplay creates display which shows no frames; it only throttles the main loop
+func newHeadlessDisplay() *display {
+	return &display{headless: 1}
+}
+
+// openWindows opens display windows titled titles and shows a black probe frame in them
+// It returns the opened windows and error if the window system panics.
+func openWindows(titles []string) (windows []*gocv.Window, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("display panicked: %v", r)
+		}
+	}()
+
+	probe := gocv.NewMatWithSize(32, 32, gocv.MatTypeCV8UC3)
+	defer probe.Close()
+
+	for _, title := range titles {
+		// open display window
+		window := gocv.NewWindow(title)
+		windows = append(windows, window)
+		window.SetWindowProperty(gocv.WindowPropertyAutosize, gocv.WindowAutosize)
+		window.IMShow(probe)
+	}
+	windows[0].WaitKey(1)
+
+	return windows, nil
+}
+
+// fallback switches the display to headless mode because of error err and releases the windows
+func (d *display) fallback(err error) {
+	fmt.Fprintf(os.Stderr, "Display failed, continuing headless: %v\n", err)
+	atomic.StoreInt32(&d.headless, 1)
+	d.close()
+	d.windows = nil
+	if d.notify != nil {
+		d.notify(err)
+	}
+}
+
+// recoverPanic switches the display to headless mode if the window system panicked; it must be deferred
+func (d *display) recoverPanic() {
+	if r := recover(); r != nil {
+		d.fallback(fmt.Errorf("display panicked: %v", r))
+	}
+}
+
+// Headless reports whether the display failed and frames are no longer shown
+func (d *display) Headless() bool {
+	return atomic.LoadInt32(&d.headless) == 1
+}
+
+// show shows screens in display windows; screens are closed once they're shown
+func (d *display) show(screens []gocv.Mat) {
+	defer func() {
+		for i := range screens {
+			screens[i].Close()
+		}
+	}()
+
+	if d.Headless() {
+		return
+	}
+	defer d.recoverPanic()
+
+	if d.grid && len(screens) > 1 {
+		composite := gridComposite(screens)
+		d.windows[0].IMShow(composite)
+		composite.Close()
+	} else {
+		for i := range screens {
+			d.windows[i].IMShow(screens[i])
+		}
+	}
+}
+
+// waitKey waits delay milliseconds for a pressed key and returns it
+// Headless display just sleeps for delay milliseconds and returns -1.
+func (d *display) waitKey(delay int) (key int) {
+	if !d.Headless() {
+		key = -1
+		defer d.recoverPanic()
+		return d.windows[0].WaitKey(delay)
+	}
+
+	time.Sleep(time.Duration(delay) * time.Millisecond)
+	return -1
+}
+
+// close closes all display windows; failing window system may panic while closing them, so panics are recovered
+func (d *display) close() {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "Error closing display windows: %v\n", r)
+		}
+	}()
+
+	for _, window := range d.windows {
+		window.Close()
+	}
+}
+
+// gridComposite composes screens into a grid and returns it
+// All the cells have the size of the first screen; the other screens are resized to fit.
+func gridComposite(screens []gocv.Mat) gocv.Mat {
+	cols := int(math.Ceil(math.Sqrt(float64(len(screens)))))
+	rows := (len(screens) + cols - 1) / cols
+	cell := image.Point{screens[0].Cols(), screens[0].Rows()}
+
+	composite := gocv.NewMatWithSize(rows*cell.Y, cols*cell.X, screens[0].Type())
+	for i := range screens {
+		origin := image.Point{(i % cols) * cell.X, (i / cols) * cell.Y}
+		region := composite.Region(image.Rectangle{origin, origin.Add(cell)})
+		gocv.Resize(screens[i], &region, cell, 0, 0, gocv.InterpolationLinear)
+		region.Close()
+	}
+
+	return composite
+}
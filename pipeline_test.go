@@ -0,0 +1,159 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"image"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/synthetic"
+)
+
+func TestDisplayStateStaleness(t *testing.T) {
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		// set means a result arrived at start
+		set     bool
+		elapsed time.Duration
+		maxAge  time.Duration
+		stale   bool
+	}{
+		{"fresh result", true, 500 * time.Millisecond, time.Second, false},
+		{"result at the limit", true, time.Second, time.Second, false},
+		{"lagging result", true, 1500 * time.Millisecond, time.Second, true},
+		{"staleness disabled", true, time.Hour, 0, false},
+		// there is nothing to fade before the first result arrives
+		{"no result", false, time.Hour, time.Second, false},
+	}
+
+	for _, tt := range tests {
+		var s displayState
+		if tt.set {
+			s.set(&detector.Result{TotalParts: 1}, start)
+		}
+		s.refresh(start.Add(tt.elapsed), tt.maxAge)
+		if s.stale != tt.stale {
+			t.Errorf("%s: stale = %v, want %v", tt.name, s.stale, tt.stale)
+		}
+		if tt.set && s.age(start.Add(tt.elapsed)) != tt.elapsed {
+			t.Errorf("%s: age %v, want %v", tt.name, s.age(start.Add(tt.elapsed)), tt.elapsed)
+		}
+	}
+}
+
+func TestDisplayStateSet(t *testing.T) {
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	var s displayState
+	s.set(&detector.Result{TotalParts: 1}, start)
+	s.refresh(start.Add(2*time.Second), time.Second)
+
+	// new result is fresh again
+	result := &detector.Result{TotalParts: 2, Rect: image.Rect(10, 10, 50, 50)}
+	s.set(result, start.Add(2*time.Second))
+	if s.stale {
+		t.Errorf("new result displayed as stale")
+	}
+
+	// the displayed result is a copy, so reusing the result doesn't change what's drawn
+	result.TotalParts, result.Rect = 3, image.Rectangle{}
+	if s.result.TotalParts != 2 || s.result.Rect != image.Rect(10, 10, 50, 50) {
+		t.Errorf("displayed result changed with the received one: %+v", s.result)
+	}
+}
+
+// TestFrameRunnerConfigSwaps swaps detector configuration while frames are processed; run it with -race
+func TestFrameRunnerConfigSwaps(t *testing.T) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	cfg, err := LoadConfig(fs, []string{"-input", "belt.mp4"})
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+	state, err := LoadState("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const frames = 50
+	p := &pipeline{src: Source{Name: "cam0"}, cfg: cfg, configChan: make(chan DetectorConfig, 1)}
+	framesChan := make(chan *frame)
+	resultsChan := make(chan *detector.Result, frames+1)
+	doneChan := make(chan struct{})
+	errChan := make(chan error, 1)
+	go func(cfg Config) {
+		errChan <- frameRunner(p.src.Name, cfg, framesChan, p.configChan, nil, doneChan, resultsChan, nil, nil,
+			nil, nil, NewPartHistory(10), NewAreaHistogram(1000), nil, nil, state)
+	}(p.cfg)
+
+	// every swapped configuration shifts both bounds of the area range, so each result must have the same range width
+	swapDone := make(chan struct{})
+	swapped := make(chan struct{})
+	go func() {
+		defer close(swapped)
+		for i := 1; ; i++ {
+			select {
+			case <-swapDone:
+				return
+			default:
+			}
+			dc := cfg.DetectorConfig
+			dc.Min, dc.Max = cfg.Min+i, cfg.Max+i
+			p.reconfigure(dc)
+		}
+	}()
+
+	part := image.Rect(400, 200, 560, 350)
+	for i := 1; i <= frames; i++ {
+		framesChan <- &frame{img: synthetic.GenerateFrame(960, 540, part, 8), SeqNum: uint64(i)}
+	}
+	close(swapDone)
+	<-swapped
+	close(doneChan)
+
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Fatalf("frameRunner error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("frameRunner did not stop")
+	}
+
+	n := 0
+	for r := range resultsChan {
+		if r.Max-r.Min != cfg.Max-cfg.Min {
+			t.Errorf("result of frame %d detected with inconsistent area range [%d, %d]", r.Seq, r.Min, r.Max)
+		}
+		n++
+	}
+	if n != frames {
+		t.Errorf("got %d results of %d frames", n, frames)
+	}
+}
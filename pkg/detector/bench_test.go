@@ -0,0 +1,82 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+import (
+	"image"
+	"path/filepath"
+	"testing"
+
+	"gocv.io/x/gocv"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/synthetic"
+)
+
+// benchmarkDetectBlob benchmarks detection of part in frame with the default configuration;
+// DetectBlob blurs the frame in place so every iteration detects in a fresh copy of it
+func benchmarkDetectBlob(b *testing.B, frame gocv.Mat) {
+	cfg := testConfig(b)
+	img := gocv.NewMat()
+	defer img.Close()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		frame.CopyTo(&img)
+		b.StartTimer()
+		DetectBlob(&img, cfg, nil)
+	}
+}
+
+func BenchmarkDetectBlobFixtures(b *testing.B) {
+	for _, name := range []string{"good.png", "empty.png", "edge.png", "two_parts.png"} {
+		frame := gocv.IMRead(filepath.Join("testdata", name), gocv.IMReadColor)
+		if frame.Empty() {
+			b.Fatalf("failed to read %s", name)
+		}
+		b.Run(name, func(b *testing.B) { benchmarkDetectBlob(b, frame) })
+		frame.Close()
+	}
+}
+
+func BenchmarkDetectBlobFullFrame(b *testing.B) {
+	frame := synthetic.GenerateFrame(frameSize.X, frameSize.Y, image.Rect(400, 200, 560, 360), 8)
+	defer frame.Close()
+
+	benchmarkDetectBlob(b, *frame)
+}
+
+func BenchmarkStructuringElement(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		structuringElement(gocv.MorphEllipse, 3)
+	}
+}
+
+// BenchmarkStructuringElementPerFrame creates the kernel for every frame as detection did before they were shared
+func BenchmarkStructuringElementPerFrame(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		m := gocv.GetStructuringElement(gocv.MorphEllipse, image.Point{3, 3})
+		m.Close()
+	}
+}
@@ -0,0 +1,558 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// DetectModeGray detects parts by thresholding the blurred grayscale frame
+	DetectModeGray = "gray"
+	// DetectModeCanny detects parts by finding their edges using Canny algorithm
+	DetectModeCanny = "canny"
+)
+
+// retrievalModes maps contour retrieval flag values to contour retrieval modes
+var retrievalModes = map[string]gocv.RetrievalMode{
+	"external": gocv.RetrievalExternal,
+	"list":     gocv.RetrievalList,
+	"ccomp":    gocv.RetrievalCComp,
+	"tree":     gocv.RetrievalTree,
+}
+
+// approxModes maps contour approximation flag values to contour approximation modes
+var approxModes = map[string]gocv.ContourApproximationMode{
+	"none":   gocv.ChainApproxNone,
+	"simple": gocv.ChainApproxSimple,
+}
+
+// Config configures part detection
+type Config struct {
+	// Min is minimum part area of assembly object
+	Min int `yaml:"min"`
+	// Max is maximum part area of assembly object
+	Max int `yaml:"max"`
+	// WarnMargin is percentage of min and max within which a part triggers warning
+	WarnMargin float64 `yaml:"warn_margin"`
+	// ConfirmFrames is number of consecutive frames after which part status is confirmed; DefaultConfirmFrames if 0
+	ConfirmFrames int `yaml:"confirm_frames"`
+	// ConsensusMode is how the part status is confirmed: consecutive, majority or quantile; consecutive if empty
+	ConsensusMode string `yaml:"consensus"`
+	// ConsensusQuantile is quantile of the areas measured in the confirmation window classified by quantile consensus
+	ConsensusQuantile float64 `yaml:"consensus_quantile"`
+	// Blur enables Gaussian blur of the frame before detection: on or off
+	Blur string `yaml:"blur"`
+	// BlurSize is size of Gaussian blur kernel
+	BlurSize int `yaml:"blur_kernel"`
+	// BlurSigma is standard deviation of Gaussian blur; it's computed from BlurSize if 0
+	BlurSigma float64 `yaml:"blur_sigma"`
+	// MorphSize is size of morphology structuring element
+	MorphSize int `yaml:"morph_kernel"`
+	// Threshold is gray level separating parts from the belt; Canny mode uses it as upper edge threshold
+	Threshold int `yaml:"threshold"`
+	// DetectMode is part detection algorithm: gray or canny
+	DetectMode string `yaml:"detect_mode"`
+	// CannyDilate is size of the rectangular kernel the Canny edges are dilated with; they're not dilated if 0
+	CannyDilate int `yaml:"canny_dilate"`
+	// Invert detects parts darker than the assembly line belt
+	Invert bool `yaml:"invert"`
+	// ContourRetrieval is contour retrieval mode: external, list, ccomp or tree
+	ContourRetrieval string `yaml:"contour_retrieval"`
+	// ContourApprox is contour approximation mode: none or simple
+	ContourApprox string `yaml:"contour_approx"`
+	// EdgeMargin is distance from the frame edge within which a part is considered partially in view
+	EdgeMargin int `yaml:"edge_margin"`
+	// MinContourWidth is width a contour must exceed to be considered a part
+	MinContourWidth int `yaml:"min_contour_width"`
+	// MinContourHeight is height a contour must exceed to be considered a part
+	MinContourHeight int `yaml:"min_contour_height"`
+	// MinContourArea is area enclosed by a contour below which it is discarded before measuring it
+	MinContourArea int `yaml:"min_contour_area"`
+	// MinBlobArea is area a contour must exceed to be considered a part
+	MinBlobArea int `yaml:"min_blob_area"`
+	// ROI is region of interest of the processing frame as x,y,width,height; the whole frame is processed if empty
+	ROI string `yaml:"roi"`
+	// UseROI means only ROIRect of the frame is processed; it's set by Validate
+	UseROI bool `yaml:"-"`
+	// ROIRect is parsed ROI; it's set by Validate
+	ROIRect image.Rectangle `yaml:"-"`
+	// Zones are regions of the processing frame with their own area range; Min and Max apply if there are none
+	Zones []Zone `yaml:"zones"`
+	// TriggerLine is line of the processing frame as x=N or y=N; parts are counted when their centroid crosses it
+	// rather than when they come into view. It's disabled if empty
+	TriggerLine string `yaml:"trigger_line"`
+	// TriggerDirection is direction the parts cross the trigger line in: forward or backward
+	TriggerDirection string `yaml:"trigger_direction"`
+	// TriggerHysteresis is distance from the trigger line the part centroid must move to on both sides to cross it
+	TriggerHysteresis int `yaml:"trigger_hysteresis"`
+	// Trigger is parsed TriggerLine; it's set by Validate and it's nil if the trigger line is disabled
+	Trigger *TriggerLine `yaml:"-"`
+	// Mask is binary mask of the processing frame ANDed with the thresholded frame to exclude fixed structures
+	// from the detection; nothing is excluded if it's nil
+	Mask *gocv.Mat `yaml:"-"`
+	// Shadows is shadow suppression: hsv, bgsub or off
+	Shadows string `yaml:"shadows"`
+	// ShadowValue is HSV value below which unsaturated pixels are suppressed as shadows by hsv shadow suppression
+	ShadowValue int `yaml:"shadow_value"`
+	// ShadowSaturation is HSV saturation below which dark pixels are suppressed as shadows by hsv shadow suppression
+	ShadowSaturation int `yaml:"shadow_saturation"`
+	// ShadowFree is binary mask of the processing frame pixels which are not shadows; it's ANDed with the thresholded
+	// frame like Mask. It's set for every frame by bgsub shadow suppression and nothing is excluded if it's nil
+	ShadowFree *gocv.Mat `yaml:"-"`
+	// Profile is name of the configuration profile the settings come from; it's empty if no profile is used
+	Profile string `yaml:"-"`
+}
+
+// RegisterFlags registers command line flags which populate c in flag set fs
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.IntVar(&c.Min, "min", 20000, "Minimum part area of assembly object")
+	fs.IntVar(&c.Max, "max", 30000, "Maximum part area of assembly object")
+	fs.Float64Var(&c.WarnMargin, "warn-margin", 5.0, "Percentage of min and max within which a part triggers warning")
+	fs.IntVar(&c.ConfirmFrames, "confirm-frames", DefaultConfirmFrames, "Number of consecutive frames after which part status is confirmed")
+	fs.StringVar(&c.ConsensusMode, "consensus", ConsensusModeMajority, "How part status is confirmed over the last "+
+		"-confirm-frames+1 frames: consecutive requires the same status in all of them, majority takes majority vote "+
+		"and quantile classifies -consensus-quantile of the measured areas")
+	fs.Float64Var(&c.ConsensusQuantile, "consensus-quantile", 0.5, "Quantile of the measured areas in range [0, 1] "+
+		"classified by quantile consensus; 0.5 is the median")
+	fs.StringVar(&c.Blur, "blur", "on", "Gaussian blur of the frame before detection: on or off; "+
+		"turn it off for clean cameras where it only softens part edges")
+	fs.IntVar(&c.BlurSize, "blur-kernel", 3, "Size of Gaussian blur kernel; must be odd")
+	fs.Float64Var(&c.BlurSigma, "blur-sigma", 0, "Standard deviation of Gaussian blur; computed from -blur-kernel if 0")
+	fs.IntVar(&c.MorphSize, "morph-kernel", 3, "Size of morphology structuring element")
+	fs.IntVar(&c.Threshold, "threshold", 200, "Gray level separating parts from the belt")
+	fs.StringVar(&c.DetectMode, "detect-mode", DetectModeGray, "Part detection algorithm: gray or canny")
+	fs.IntVar(&c.CannyDilate, "canny-dilate", 1, "Size of the kernel the edges found by -detect-mode canny are dilated with "+
+		"to join fragmented contours; disabled if 0")
+	fs.BoolVar(&c.Invert, "invert", false, "Detect parts darker than the assembly line belt")
+	fs.StringVar(&c.Shadows, "shadows", ShadowsOff, "Shadow suppression: hsv suppresses dark unsaturated pixels, "+
+		"bgsub excludes shadows labeled by background subtraction, off disables it")
+	fs.IntVar(&c.ShadowValue, "shadow-value", 80, "HSV value [0 - 255] below which unsaturated pixels are shadows with -shadows=hsv")
+	fs.IntVar(&c.ShadowSaturation, "shadow-saturation", 60, "HSV saturation [0 - 255] below which dark pixels are shadows with -shadows=hsv")
+	fs.StringVar(&c.ContourRetrieval, "contour-retrieval", "external", "Contour retrieval mode: external, list, ccomp or tree; "+
+		"use list for hollow parts whose inner contour must be measured")
+	fs.StringVar(&c.ContourApprox, "contour-approx", "none", "Contour approximation mode: none or simple")
+	fs.IntVar(&c.EdgeMargin, "edge-margin", 0, "Distance from the frame edge within which a part is considered partially in view")
+	fs.IntVar(&c.MinContourWidth, "min-contour-width", 30, "Width a contour must exceed to be considered a part")
+	fs.IntVar(&c.MinContourHeight, "min-contour-height", 0, "Height a contour must exceed to be considered a part")
+	fs.IntVar(&c.MinContourArea, "min-contour-area", 0, "Area enclosed by a contour below which it is discarded before measuring it")
+	fs.IntVar(&c.MinBlobArea, "min-blob-area", 0, "Area a contour must exceed to be considered a part")
+	fs.StringVar(&c.ROI, "roi", "", "Region of interest of the processing frame as x,y,width,height; "+
+		"only the region is searched for parts; the whole frame is processed if empty")
+	fs.StringVar(&c.TriggerLine, "trigger-line", "", "Line of the processing frame as x=N for horizontal belts or y=N for vertical ones; "+
+		"parts are counted when their centroid crosses it. Parts are counted when they come into view if empty")
+	fs.StringVar(&c.TriggerDirection, "trigger-direction", TriggerForward, "Direction parts cross -trigger-line in: "+
+		"forward towards increasing coordinate or backward")
+	fs.IntVar(&c.TriggerHysteresis, "trigger-hysteresis", 10, "Distance from -trigger-line the part centroid must move to "+
+		"on both sides to cross it, so parts oscillating near the line are counted once")
+}
+
+// Validate checks the detection configuration and returns error if it's invalid
+// It also parses the region of interest, the zone rectangles and the trigger line.
+func (c *Config) Validate() error {
+	c.UseROI, c.ROIRect = false, image.Rectangle{}
+	if c.ROI != "" {
+		pts, err := ParsePoints(c.ROI, 2)
+		if err != nil {
+			return fmt.Errorf("invalid region of interest: %v", err)
+		}
+		if pts[0].X < 0 || pts[0].Y < 0 || pts[1].X <= 0 || pts[1].Y <= 0 {
+			return fmt.Errorf("invalid region of interest %q: expected non-negative origin and positive size", c.ROI)
+		}
+		c.UseROI, c.ROIRect = true, image.Rectangle{pts[0], pts[0].Add(pts[1])}
+	}
+
+	// zones may be shared with a configuration in use by another goroutine, so their bounds are set in a copy
+	c.Zones = c.Clone().Zones
+	if err := validateZones(c.Zones); err != nil {
+		return err
+	}
+
+	if c.UseROI && len(c.Zones) > 0 {
+		return fmt.Errorf("region of interest can't be combined with zones")
+	}
+
+	c.Trigger = nil
+	if c.TriggerLine != "" {
+		l, err := ParseTriggerLine(c.TriggerLine, c.TriggerDirection, c.TriggerHysteresis)
+		if err != nil {
+			return err
+		}
+		c.Trigger = &l
+	}
+
+	if c.Min > c.Max {
+		return fmt.Errorf("minimum area %d exceeds maximum area %d", c.Min, c.Max)
+	}
+
+	if c.ConfirmFrames < 0 {
+		return fmt.Errorf("invalid number of confirmation frames %d: must not be negative", c.ConfirmFrames)
+	}
+
+	switch c.ConsensusMode {
+	case "", ConsensusModeConsecutive, ConsensusModeMajority, ConsensusModeQuantile:
+	default:
+		return fmt.Errorf("invalid consensus %q: expected %s, %s or %s", c.ConsensusMode,
+			ConsensusModeConsecutive, ConsensusModeMajority, ConsensusModeQuantile)
+	}
+
+	if c.ConsensusQuantile < 0 || c.ConsensusQuantile > 1 {
+		return fmt.Errorf("invalid consensus quantile %g: must be within [0, 1]", c.ConsensusQuantile)
+	}
+
+	if c.Blur != "on" && c.Blur != "off" {
+		return fmt.Errorf("invalid blur %q: expected on or off", c.Blur)
+	}
+
+	if c.BlurSize <= 0 || c.BlurSize%2 == 0 {
+		return fmt.Errorf("invalid blur kernel size %d: must be positive and odd", c.BlurSize)
+	}
+
+	if c.BlurSigma < 0 {
+		return fmt.Errorf("invalid blur sigma %g: must not be negative", c.BlurSigma)
+	}
+
+	if c.MorphSize <= 0 {
+		return fmt.Errorf("invalid morphology kernel size %d: must be positive", c.MorphSize)
+	}
+
+	if c.Threshold < 0 || c.Threshold > 255 {
+		return fmt.Errorf("invalid threshold %d: must be within [0 - 255]", c.Threshold)
+	}
+
+	if c.DetectMode != DetectModeGray && c.DetectMode != DetectModeCanny {
+		return fmt.Errorf("invalid detect mode %q: expected %s or %s", c.DetectMode, DetectModeGray, DetectModeCanny)
+	}
+
+	switch c.Shadows {
+	case "", ShadowsOff, ShadowsHSV, ShadowsBGSub:
+	default:
+		return fmt.Errorf("invalid shadows %q: expected %s, %s or %s", c.Shadows, ShadowsHSV, ShadowsBGSub, ShadowsOff)
+	}
+
+	if c.ShadowValue < 0 || c.ShadowValue > 255 || c.ShadowSaturation < 0 || c.ShadowSaturation > 255 {
+		return fmt.Errorf("invalid shadow value %d or saturation %d: must be within [0 - 255]", c.ShadowValue, c.ShadowSaturation)
+	}
+
+	if c.CannyDilate < 0 {
+		return fmt.Errorf("invalid Canny dilation kernel size %d: must not be negative", c.CannyDilate)
+	}
+
+	if _, ok := retrievalModes[c.ContourRetrieval]; !ok {
+		return fmt.Errorf("invalid contour retrieval %q: expected external, list, ccomp or tree", c.ContourRetrieval)
+	}
+
+	if _, ok := approxModes[c.ContourApprox]; !ok {
+		return fmt.Errorf("invalid contour approximation %q: expected none or simple", c.ContourApprox)
+	}
+
+	return nil
+}
+
+// Region returns the region of interest clipped to the bounds of frame img
+// It returns false if the whole frame must be processed, i.e. no region is configured or it's outside the frame.
+func (c *Config) Region(img *gocv.Mat) (image.Rectangle, bool) {
+	if !c.UseROI {
+		return image.Rectangle{}, false
+	}
+
+	roi := c.ROIRect.Intersect(image.Rect(0, 0, img.Cols(), img.Rows()))
+	return roi, !roi.Empty()
+}
+
+// Clone returns copy of c which shares no mutable state with c, so it can be handed over to another goroutine
+// while c keeps changing. The mask is shared since it's never modified.
+func (c *Config) Clone() Config {
+	dc := *c
+	if c.Zones != nil {
+		dc.Zones = append([]Zone(nil), c.Zones...)
+	}
+
+	return dc
+}
+
+// ContourFilter returns contour filter built from the minimum contour size options of the configuration
+func (c *Config) ContourFilter() ContourFilter {
+	return CompositeFilter{
+		AreaFilter{Min: c.MinContourArea},
+		WidthFilter{Min: c.MinContourWidth},
+		HeightFilter{Min: c.MinContourHeight},
+	}
+}
+
+// Status stores assembly line part status
+type Status struct {
+	// Seen means part was detected
+	Seen bool
+	// Defect means part has a defect
+	Defect bool
+	// Partial means part touches the frame edge so it can't be measured
+	Partial bool
+	// Severity is severity of the part status
+	Severity Severity
+	// Area is measured area of the part; it's 0 if the part is not measured
+	Area int
+}
+
+// Area returns area of rectangle r
+func Area(r image.Rectangle) int {
+	return r.Size().X * r.Size().Y
+}
+
+// DetectStatus detects part status from the blob using area range configured in cfg and returns it
+// partial blob touches the frame edge; such part is seen but neither measured nor counted
+func DetectStatus(blob *image.Rectangle, partial bool, cfg Config) *Status {
+	area := blob.Size().X * blob.Size().Y
+	// we assume no part is detected; therefore there is no defect
+	status := &Status{
+		Defect: false,
+		Seen:   false,
+	}
+
+	// tiny blobs are noise rather than parts
+	if area != 0 && area > cfg.MinBlobArea {
+		status.Seen = true
+		// part is not fully in view
+		if partial {
+			status.Partial = true
+			return status
+		}
+		status.Area = area
+		// defected part
+		if area > cfg.Max || area < cfg.Min {
+			status.Defect = true
+			status.Severity = SeverityDefect
+			return status
+		}
+		// no defect but close to the range bounds
+		margin := cfg.WarnMargin / 100
+		if float64(area) < float64(cfg.Min)*(1+margin) || float64(area) > float64(cfg.Max)*(1-margin) {
+			status.Severity = SeverityWarn
+		}
+		return status
+	}
+
+	// no part detected
+	return status
+}
+
+// contourCentroid returns centroid of the area enclosed by contour computed from its spatial moments
+// The moments are integrated along the contour the same way OpenCV computes contour moments;
+// the center of the bounding rectangle is returned for degenerate contours enclosing no area.
+func contourCentroid(contour []image.Point) image.Point {
+	var m00, m10, m01 float64
+	for i := range contour {
+		p, q := contour[i], contour[(i+1)%len(contour)]
+		cross := float64(p.X*q.Y - q.X*p.Y)
+		m00 += cross
+		m10 += cross * float64(p.X+q.X)
+		m01 += cross * float64(p.Y+q.Y)
+	}
+
+	// m00 is twice the enclosed area; the first moments are six times theirs
+	if m00 == 0 {
+		r := gocv.BoundingRect(contour)
+		return r.Min.Add(r.Max).Div(2)
+	}
+
+	return image.Point{int(math.Round(m10 / (3 * m00))), int(math.Round(m01 / (3 * m00)))}
+}
+
+// DetectBlob detects assembly line part in img image using detection options in cfg and returns it
+// together with the centroid of the part contour, which is more accurate than the rectangle center for irregular parts.
+// The part is the biggest of the contours passing filter; cfg.ContourFilter is used if filter is nil.
+// It also reports whether the part touches the frame edge within cfg.EdgeMargin, i.e. it's not fully in view.
+// Contours outside of cfg.Mask and cfg.ShadowFree are ignored; img is either the whole processing frame
+// or its region of interest.
+func DetectBlob(img *gocv.Mat, cfg Config, filter ContourFilter) (image.Rectangle, image.Point, bool) {
+	blur := image.Point{cfg.BlurSize, cfg.BlurSize}
+
+	// convert to gray and blur; shadows are told apart by their color, so they're suppressed before
+	if cfg.Shadows == ShadowsHSV {
+		suppressShadows(*img, img, cfg)
+	} else {
+		gocv.CvtColor(*img, img, gocv.ColorBGRToGray)
+	}
+	if cfg.Blur != "off" {
+		gocv.GaussianBlur(*img, img, blur, cfg.BlurSigma, cfg.BlurSigma, gocv.BorderDefault)
+	}
+
+	switch cfg.DetectMode {
+	case DetectModeCanny:
+		// find the edges of assembly part; the part outline becomes its contour
+		gocv.Canny(*img, img, float32(cfg.Threshold)/2, float32(cfg.Threshold))
+		// thin edges break up into fragments of the outline; thickening them joins the fragments
+		if cfg.CannyDilate > 0 {
+			gocv.Dilate(*img, img, structuringElement(gocv.MorphRect, cfg.CannyDilate))
+		}
+	default:
+		morph := structuringElement(gocv.MorphEllipse, cfg.MorphSize)
+
+		// Morphology: OPEN -> CLOSE -> OPEN
+		// MORPH_OPEN removes the noise and closes the "holes" in the background
+		// MORPH_CLOSE remove the noise and closes the "holes" in the foreground
+		gocv.MorphologyEx(*img, img, gocv.MorphOpen, morph)
+		gocv.MorphologyEx(*img, img, gocv.MorphClose, morph)
+		gocv.MorphologyEx(*img, img, gocv.MorphOpen, morph)
+
+		// threshold the image to emphasize assembly part;
+		// dark parts need inverse threshold so the part rather than the belt ends up white
+		thresh := gocv.ThresholdBinary
+		if cfg.Invert {
+			thresh = gocv.ThresholdBinaryInv
+		}
+		gocv.Threshold(*img, img, float32(cfg.Threshold), 255, thresh)
+	}
+
+	// exclude fixed structures and shadows
+	if cfg.Mask != nil {
+		applyMask(img, *cfg.Mask, cfg)
+	}
+	if cfg.ShadowFree != nil {
+		applyMask(img, *cfg.ShadowFree, cfg)
+	}
+
+	return largestBlob(img, cfg, filter)
+}
+
+// applyMask ANDs binary image img with mask; the mask covers the whole frame, so it's cropped to cfg.ROIRect
+// if img is the region of interest
+func applyMask(img *gocv.Mat, mask gocv.Mat, cfg Config) {
+	if cfg.UseROI && (img.Cols() != mask.Cols() || img.Rows() != mask.Rows()) {
+		mask = mask.Region(cfg.ROIRect.Intersect(image.Rect(0, 0, mask.Cols(), mask.Rows())))
+		defer mask.Close()
+	}
+	gocv.BitwiseAnd(*img, mask, img)
+}
+
+// kernel identifies structuring element of shape and size
+type kernel struct {
+	shape gocv.MorphShape
+	size  int
+}
+
+var (
+	// kernelsMu guards kernels
+	kernelsMu sync.Mutex
+	// kernels are structuring elements created so far; there are only a few sizes in use, so they're kept for good
+	kernels = make(map[kernel]gocv.Mat)
+)
+
+// structuringElement returns square structuring element of shape and size
+// The elements are created once and shared, so no kernel is allocated per frame; they must not be closed.
+func structuringElement(shape gocv.MorphShape, size int) gocv.Mat {
+	kernelsMu.Lock()
+	defer kernelsMu.Unlock()
+
+	k := kernel{shape, size}
+	m, ok := kernels[k]
+	if !ok {
+		m = gocv.GetStructuringElement(shape, image.Point{size, size})
+		kernels[k] = m
+	}
+
+	return m
+}
+
+// DetectBlobWithMask detects assembly line part in img using binary foreground mask provided by an external source,
+// e.g. a lighting controller, instead of preprocessing img; mask must have the size of img and it's left unchanged.
+// The part is the biggest of the mask contours passing cfg.ContourFilter. No part is detected if the sizes differ.
+func DetectBlobWithMask(img *gocv.Mat, mask *gocv.Mat, cfg Config) image.Rectangle {
+	if img.Cols() != mask.Cols() || img.Rows() != mask.Rows() {
+		return image.Rectangle{}
+	}
+
+	rect, _, _ := largestBlob(mask, cfg, nil)
+	return rect
+}
+
+// Partial returns true if part rect touches the edge of frame of size within cfg.EdgeMargin, i.e. it's not fully in view
+func Partial(rect image.Rectangle, size image.Point, cfg Config) bool {
+	m := cfg.EdgeMargin
+	inner := image.Rect(m+1, m+1, size.X-m-1, size.Y-m-1)
+
+	return !rect.Empty() && !rect.In(inner)
+}
+
+// largestBlob returns bounding rectangle and centroid of the biggest contour of binary image img passing filter
+// and whether it's partial; cfg.ContourFilter is used if filter is nil.
+func largestBlob(img *gocv.Mat, cfg Config, filter ContourFilter) (image.Rectangle, image.Point, bool) {
+	// find the contours of assembly part and discard those which can't be parts
+	contours := gocv.FindContours(*img, retrievalModes[cfg.ContourRetrieval], approxModes[cfg.ContourApprox])
+	if filter == nil {
+		filter = cfg.ContourFilter()
+	}
+	contours = filter.Filter(contours, img)
+
+	// part will be the biggest contour area
+	var maxRect image.Rectangle
+	var centroid image.Point
+	maxArea := 0
+
+	for i := range contours {
+		rect := gocv.BoundingRect(contours[i])
+		area := rect.Size().X * rect.Size().Y
+		// is large enough
+		if area > maxArea && area > cfg.MinBlobArea {
+			maxArea = area
+			maxRect = rect
+			centroid = contourCentroid(contours[i])
+		}
+	}
+
+	// part overlapping frame edges is not completely within the camera
+	return maxRect, centroid, Partial(maxRect, image.Point{img.Cols(), img.Rows()}, cfg)
+}
+
+// ParsePoints parses comma-separated list of x,y coordinates and returns the points
+// It returns error if pts does not contain exactly n pairs of integer coordinates.
+func ParsePoints(pts string, n int) ([]image.Point, error) {
+	coords := strings.Split(pts, ",")
+	if len(coords) != 2*n {
+		return nil, fmt.Errorf("invalid points %q: expected %d comma-separated x,y pairs", pts, n)
+	}
+
+	points := make([]image.Point, n)
+	for i := range points {
+		x, err := strconv.Atoi(strings.TrimSpace(coords[2*i]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate %q: %v", coords[2*i], err)
+		}
+		y, err := strconv.Atoi(strings.TrimSpace(coords[2*i+1]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate %q: %v", coords[2*i+1], err)
+		}
+		points[i] = image.Point{x, y}
+	}
+
+	return points, nil
+}
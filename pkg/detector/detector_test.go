@@ -0,0 +1,320 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+import (
+	"flag"
+	"image"
+	"image/color"
+	"testing"
+
+	"gocv.io/x/gocv"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/synthetic"
+)
+
+// frameSize is size of the synthetic test frames
+var frameSize = image.Point{960, 540}
+
+// testConfig returns validated detection configuration with the default flag values
+func testConfig(t testing.TB) Config {
+	t.Helper()
+
+	var cfg Config
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	cfg.RegisterFlags(fs)
+	if err := fs.Parse(nil); err != nil {
+		t.Fatalf("failed to parse default flags: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("invalid default configuration: %v", err)
+	}
+
+	return cfg
+}
+
+// speckles returns small squares of side size scattered over the frame like dust on an empty belt
+func speckles(size int) []image.Rectangle {
+	var specks []image.Rectangle
+	for y := 40; y < frameSize.Y-40; y += 90 {
+		for x := 40; x < frameSize.X-40; x += 110 {
+			specks = append(specks, image.Rect(x, y, x+size, y+size))
+		}
+	}
+
+	return specks
+}
+
+func TestDetectBlobIgnoresSpeckles(t *testing.T) {
+	tests := []struct {
+		name string
+		// configure changes the default configuration
+		configure func(cfg *Config)
+		// seen means a blob is expected to be detected
+		seen bool
+	}{
+		{"default contour width", func(cfg *Config) {}, false},
+		{"minimum blob area", func(cfg *Config) { cfg.MinContourWidth, cfg.MinBlobArea = 0, 200 }, false},
+		{"minimum contour area", func(cfg *Config) { cfg.MinContourWidth, cfg.MinContourArea = 0, 200 }, false},
+		// the speckles are detected if nothing filters them, so the cases above do filter them
+		{"no filters", func(cfg *Config) { cfg.MinContourWidth = 0 }, true},
+	}
+
+	for _, tt := range tests {
+		cfg := testConfig(t)
+		tt.configure(&cfg)
+
+		img := synthetic.GenerateMultipartFrame(frameSize.X, frameSize.Y, speckles(10), 8)
+		rect, _, _ := DetectBlob(img, cfg, nil)
+		img.Close()
+
+		if seen := !rect.Empty(); seen != tt.seen {
+			t.Errorf("%s: detected blob %v on empty belt, want detected %v", tt.name, rect, tt.seen)
+		}
+		if status := DetectStatus(&rect, false, cfg); status.Seen != tt.seen {
+			t.Errorf("%s: status seen = %v, want %v", tt.name, status.Seen, tt.seen)
+		}
+	}
+}
+
+func TestDetectBlobPartAmongSpeckles(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MinContourWidth, cfg.MinBlobArea = 0, 200
+
+	part := image.Rect(400, 200, 560, 360)
+	img := synthetic.GenerateMultipartFrame(frameSize.X, frameSize.Y, append(speckles(10), part), 8)
+	defer img.Close()
+
+	rect, _, partial := DetectBlob(img, cfg, nil)
+	if !rectNear(rect, part, 2) {
+		t.Errorf("detected %v, want %v", rect, part)
+	}
+	if partial {
+		t.Errorf("part %v reported partially in view", rect)
+	}
+}
+
+func TestDetectSyntheticFrames(t *testing.T) {
+	cfg := testConfig(t)
+
+	tests := []struct {
+		name string
+		// blobs are the parts drawn in the frame
+		blobs []image.Rectangle
+		// want is the expected part; it's empty if no part is expected
+		want    image.Rectangle
+		partial bool
+		defect  bool
+	}{
+		{"good part", []image.Rectangle{image.Rect(400, 200, 560, 350)}, image.Rect(400, 200, 560, 350), false, false},
+		{"oversized part", []image.Rectangle{image.Rect(380, 170, 580, 370)}, image.Rect(380, 170, 580, 370), false, true},
+		{"undersized part", []image.Rectangle{image.Rect(430, 220, 530, 320)}, image.Rect(430, 220, 530, 320), false, true},
+		{"empty belt", nil, image.Rectangle{}, false, false},
+		{"part entering", []image.Rectangle{image.Rect(0, 200, 120, 350)}, image.Rect(0, 200, 120, 350), true, false},
+		{"two parts", []image.Rectangle{image.Rect(100, 200, 260, 350), image.Rect(600, 220, 700, 320)},
+			image.Rect(100, 200, 260, 350), false, false},
+	}
+
+	for _, tt := range tests {
+		img := synthetic.GenerateMultipartFrame(frameSize.X, frameSize.Y, tt.blobs, 8)
+		rect, _, partial := DetectBlob(img, cfg, nil)
+		img.Close()
+
+		if !rectNear(rect, tt.want, 2) {
+			t.Errorf("%s: detected %v, want %v", tt.name, rect, tt.want)
+		}
+		status := DetectStatus(&rect, partial, cfg)
+		if status.Seen != !tt.want.Empty() || status.Partial != tt.partial || status.Defect != tt.defect {
+			t.Errorf("%s: status seen %v, partial %v, defect %v, want seen %v, partial %v, defect %v",
+				tt.name, status.Seen, status.Partial, status.Defect, !tt.want.Empty(), tt.partial, tt.defect)
+		}
+	}
+}
+
+func TestDetectDefectFrame(t *testing.T) {
+	cfg := testConfig(t)
+
+	img := synthetic.GenerateDefectFrame(frameSize.X, frameSize.Y, cfg.Min, cfg.Max, 8)
+	defer img.Close()
+
+	rect, _, partial := DetectBlob(img, cfg, nil)
+	if status := DetectStatus(&rect, partial, cfg); !status.Seen || !status.Defect {
+		t.Errorf("part %v of area %d within [%d, %d] not detected as defect", rect, Area(rect), cfg.Min, cfg.Max)
+	}
+}
+
+func TestDetectBlobPolarity(t *testing.T) {
+	part := image.Rect(400, 200, 560, 350)
+
+	tests := []struct {
+		name string
+		// dark means the part is darker than the belt
+		dark   bool
+		invert bool
+		// found means the part is expected to be detected
+		found bool
+	}{
+		{"light part", false, false, true},
+		{"dark part on light belt", true, true, true},
+		// with mismatched polarity the belt rather than the part is taken for the foreground
+		{"dark part without invert", true, false, false},
+		{"light part with invert", false, true, false},
+	}
+
+	for _, tt := range tests {
+		cfg := testConfig(t)
+		cfg.Invert = tt.invert
+
+		img := synthetic.GenerateFrame(frameSize.X, frameSize.Y, part, 8)
+		if tt.dark {
+			gocv.BitwiseNot(*img, img)
+		}
+		rect, _, partial := DetectBlob(img, cfg, nil)
+		img.Close()
+
+		if found := rectNear(rect, part, 2) && !partial; found != tt.found {
+			t.Errorf("%s: detected %v partial %v, want part %v found %v", tt.name, rect, partial, part, tt.found)
+		}
+	}
+}
+
+func TestDetectBlobBlur(t *testing.T) {
+	part := image.Rect(400, 200, 560, 350)
+
+	tests := []struct {
+		name string
+		blur string
+		size int
+	}{
+		{"default blur", "on", 3},
+		{"9x9 blur", "on", 9},
+		{"blur off", "off", 3},
+	}
+
+	for _, tt := range tests {
+		cfg := testConfig(t)
+		cfg.Blur, cfg.BlurSize = tt.blur, tt.size
+		if err := cfg.Validate(); err != nil {
+			t.Fatalf("%s: invalid configuration: %v", tt.name, err)
+		}
+
+		img := synthetic.GenerateFrame(frameSize.X, frameSize.Y, part, 8)
+		rect, _, partial := DetectBlob(img, cfg, nil)
+		img.Close()
+
+		if !rectNear(rect, part, 3) || partial {
+			t.Errorf("%s: detected %v partial %v, want %v", tt.name, rect, partial, part)
+		}
+	}
+}
+
+func TestDetectBlobMask(t *testing.T) {
+	part := image.Rect(500, 200, 660, 350)
+	// the L-shaped bracket is bigger than the part, so it's taken for the part unless it's masked
+	bracket := []image.Rectangle{image.Rect(40, 40, 320, 100), image.Rect(40, 40, 100, 460)}
+
+	// the mask excludes the bracket with some margin
+	mask := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(255, 0, 0, 0), frameSize.Y, frameSize.X, gocv.MatTypeCV8U)
+	defer mask.Close()
+	for _, r := range bracket {
+		gocv.Rectangle(&mask, r.Inset(-10), color.RGBA{0, 0, 0, 0}, -1)
+	}
+
+	tests := []struct {
+		name string
+		mask *gocv.Mat
+		want image.Rectangle
+	}{
+		{"without mask", nil, image.Rect(40, 40, 320, 460)},
+		{"with mask", &mask, part},
+	}
+
+	for _, tt := range tests {
+		cfg := testConfig(t)
+		cfg.Mask = tt.mask
+
+		img := synthetic.GenerateMultipartFrame(frameSize.X, frameSize.Y, append(bracket, part), 8)
+		rect, _, _ := DetectBlob(img, cfg, nil)
+		img.Close()
+
+		if !rectNear(rect, tt.want, 2) {
+			t.Errorf("%s: detected %v, want %v", tt.name, rect, tt.want)
+		}
+	}
+}
+
+func TestDetectBlobCannyDilate(t *testing.T) {
+	outline := image.Rect(400, 200, 560, 350)
+
+	prev := 0
+	for _, dilate := range []int{0, 3, 7} {
+		cfg := testConfig(t)
+		cfg.DetectMode, cfg.CannyDilate = DetectModeCanny, dilate
+
+		// edge image: thin outline of the part on empty belt
+		img := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), frameSize.Y, frameSize.X, gocv.MatTypeCV8UC3)
+		gocv.Rectangle(&img, outline, color.RGBA{255, 255, 255, 0}, 1)
+		rect, _, _ := DetectBlob(&img, cfg, nil)
+		img.Close()
+
+		if !rectNear(rect, outline, 2+dilate/2) {
+			t.Errorf("canny dilate %d: detected %v, want %v", dilate, rect, outline)
+		}
+		if area := Area(rect); area <= prev {
+			t.Errorf("canny dilate %d: contour area %d, want more than %d with less dilation", dilate, area, prev)
+		} else {
+			prev = area
+		}
+	}
+}
+
+func TestDetectStatusMinBlobArea(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.MinBlobArea = 100
+
+	tests := []struct {
+		rect image.Rectangle
+		seen bool
+	}{
+		{image.Rectangle{}, false},
+		{image.Rect(0, 0, 10, 10), false},
+		{image.Rect(0, 0, 10, 11), true},
+	}
+
+	for _, tt := range tests {
+		if status := DetectStatus(&tt.rect, false, cfg); status.Seen != tt.seen {
+			t.Errorf("DetectStatus(%v) seen = %v, want %v", tt.rect, status.Seen, tt.seen)
+		}
+	}
+}
+
+// rectNear returns true if every edge of got is within tolerance of the same edge of want
+func rectNear(got, want image.Rectangle, tolerance int) bool {
+	near := func(a, b int) bool {
+		return a-b <= tolerance && b-a <= tolerance
+	}
+
+	return near(got.Min.X, want.Min.X) && near(got.Min.Y, want.Min.Y) &&
+		near(got.Max.X, want.Max.X) && near(got.Max.Y, want.Max.Y)
+}
@@ -0,0 +1,43 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package detector detects assembly line parts in video frames and measures them against the expected area range.
+//
+// DetectBlob finds the biggest part contour in a frame preprocessed according to Config and DetectStatus classifies
+// the part by its area. Tracker follows the part across frames so every part is counted once and its defect is only
+// confirmed after it's been seen in several consecutive frames. Result carries the detection result of a video source
+// and encodes it as JSON or protobuf message published to MQTT server.
+//
+// A minimal program detecting a part in a single image:
+//
+//	cfg := detector.Config{Min: 20000, Max: 30000, Blur: "on", BlurSize: 3, MorphSize: 3, Threshold: 200,
+//		DetectMode: detector.DetectModeGray, ContourRetrieval: "external", ContourApprox: "none"}
+//	if err := cfg.Validate(); err != nil {
+//		log.Fatal(err)
+//	}
+//	img := gocv.IMRead("part.jpg", gocv.IMReadColor)
+//	defer img.Close()
+//	rect, _, partial := detector.DetectBlob(&img, cfg, nil)
+//	status := detector.DetectStatus(&rect, partial, cfg)
+//	fmt.Println(detector.Area(rect), status.Severity)
+package detector
@@ -0,0 +1,117 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+import (
+	"image"
+
+	"gocv.io/x/gocv"
+)
+
+// ContourFilter selects contours which can be assembly line parts
+// Custom filters can be chained with the built-in ones using CompositeFilter.
+type ContourFilter interface {
+	// Filter returns contours of thresholded image img which pass the filter
+	Filter(contours [][]image.Point, img *gocv.Mat) [][]image.Point
+}
+
+// CompositeFilter chains contour filters; contours pass it if they pass all of its filters in order
+type CompositeFilter []ContourFilter
+
+// Filter implements ContourFilter interface
+func (f CompositeFilter) Filter(contours [][]image.Point, img *gocv.Mat) [][]image.Point {
+	for _, filter := range f {
+		contours = filter.Filter(contours, img)
+	}
+
+	return contours
+}
+
+// filterContours returns contours for which keep returns true
+func filterContours(contours [][]image.Point, keep func(contour []image.Point) bool) [][]image.Point {
+	var kept [][]image.Point
+	for _, contour := range contours {
+		if keep(contour) {
+			kept = append(kept, contour)
+		}
+	}
+
+	return kept
+}
+
+// AreaFilter passes contours enclosing area of at least Min and at most Max; zero bounds are not checked
+type AreaFilter struct {
+	Min, Max int
+}
+
+// Filter implements ContourFilter interface
+func (f AreaFilter) Filter(contours [][]image.Point, img *gocv.Mat) [][]image.Point {
+	if f.Min <= 0 && f.Max <= 0 {
+		return contours
+	}
+
+	return filterContours(contours, func(contour []image.Point) bool {
+		area := gocv.ContourArea(contour)
+		return area >= float64(f.Min) && (f.Max <= 0 || area <= float64(f.Max))
+	})
+}
+
+// EdgeFilter passes contours which don't touch the frame edge within Margin
+// Detection keeps such contours and reports them as partially in view; use EdgeFilter to ignore them instead.
+type EdgeFilter struct {
+	Margin int
+}
+
+// Filter implements ContourFilter interface
+func (f EdgeFilter) Filter(contours [][]image.Point, img *gocv.Mat) [][]image.Point {
+	inner := image.Rect(f.Margin+1, f.Margin+1, img.Cols()-f.Margin-1, img.Rows()-f.Margin-1)
+
+	return filterContours(contours, func(contour []image.Point) bool {
+		return gocv.BoundingRect(contour).In(inner)
+	})
+}
+
+// WidthFilter passes contours whose bounding rectangle is wider than Min
+type WidthFilter struct {
+	Min int
+}
+
+// Filter implements ContourFilter interface
+func (f WidthFilter) Filter(contours [][]image.Point, img *gocv.Mat) [][]image.Point {
+	return filterContours(contours, func(contour []image.Point) bool {
+		return gocv.BoundingRect(contour).Dx() > f.Min
+	})
+}
+
+// HeightFilter passes contours whose bounding rectangle is taller than Min
+type HeightFilter struct {
+	Min int
+}
+
+// Filter implements ContourFilter interface
+func (f HeightFilter) Filter(contours [][]image.Point, img *gocv.Mat) [][]image.Point {
+	return filterContours(contours, func(contour []image.Point) bool {
+		return gocv.BoundingRect(contour).Dy() > f.Min
+	})
+}
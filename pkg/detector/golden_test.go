@@ -0,0 +1,116 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+//go:generate go test -run TestGoldenFrames -update-golden
+
+import (
+	"encoding/json"
+	"flag"
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// updateGolden rewrites the expected results of the golden frames with the detected ones;
+// run it via go generate after approving changed frames or detection
+var updateGolden = flag.Bool("update-golden", false, "rewrite testdata/golden.json with the detected results")
+
+// goldenPath is the path of the expected results of the approved frames in testdata
+var goldenPath = filepath.Join("testdata", "golden.json")
+
+// goldenFrame is the expected detection result of an approved frame
+type goldenFrame struct {
+	Image   string `json:"image"`
+	Rect    [4]int `json:"rect"`
+	Seen    bool   `json:"seen"`
+	Partial bool   `json:"partial"`
+	Defect  bool   `json:"defect"`
+}
+
+// golden is the approved frames with the area range they are classified with
+type golden struct {
+	Min       int           `json:"min"`
+	Max       int           `json:"max"`
+	Tolerance int           `json:"tolerance"`
+	Frames    []goldenFrame `json:"frames"`
+}
+
+func TestGoldenFrames(t *testing.T) {
+	data, err := ioutil.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("failed to read golden results: %v", err)
+	}
+	var g golden
+	if err := json.Unmarshal(data, &g); err != nil {
+		t.Fatalf("failed to parse %s: %v", goldenPath, err)
+	}
+
+	cfg := testConfig(t)
+	cfg.Min, cfg.Max = g.Min, g.Max
+
+	for i, want := range g.Frames {
+		img := gocv.IMRead(filepath.Join("testdata", want.Image), gocv.IMReadColor)
+		if img.Empty() {
+			t.Errorf("%s: failed to read frame", want.Image)
+			continue
+		}
+		rect, _, partial := DetectBlob(&img, cfg, nil)
+		img.Close()
+		status := DetectStatus(&rect, partial, cfg)
+
+		if *updateGolden {
+			g.Frames[i] = goldenFrame{
+				Image:   want.Image,
+				Rect:    [4]int{rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y},
+				Seen:    status.Seen,
+				Partial: status.Partial,
+				Defect:  status.Defect,
+			}
+			continue
+		}
+
+		wantRect := image.Rect(want.Rect[0], want.Rect[1], want.Rect[2], want.Rect[3])
+		if !rectNear(rect, wantRect, g.Tolerance) {
+			t.Errorf("%s: detected %v, want %v within %d pixels", want.Image, rect, wantRect, g.Tolerance)
+		}
+		if status.Seen != want.Seen || status.Partial != want.Partial || status.Defect != want.Defect {
+			t.Errorf("%s: status seen %v, partial %v, defect %v, want seen %v, partial %v, defect %v",
+				want.Image, status.Seen, status.Partial, status.Defect, want.Seen, want.Partial, want.Defect)
+		}
+	}
+
+	if *updateGolden {
+		data, err := json.MarshalIndent(g, "", "  ")
+		if err != nil {
+			t.Fatalf("failed to encode golden results: %v", err)
+		}
+		if err := ioutil.WriteFile(goldenPath, append(data, '\n'), 0644); err != nil {
+			t.Fatalf("failed to write golden results: %v", err)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// DefaultConfirmFrames is default number of consecutive frames after which part status is confirmed
+const DefaultConfirmFrames = 10
+
+// confidentMargin is relative distance of the mean part area from the nearest bound of the expected
+// area range beyond which the measurement is considered clear-cut
+const confidentMargin = 0.1
+
+const (
+	// ConsensusModeConsecutive confirms the part status once it's the same in more than ConfirmFrames consecutive frames
+	ConsensusModeConsecutive = "consecutive"
+	// ConsensusModeMajority confirms the part status by majority vote of the frames in the confirmation window
+	ConsensusModeMajority = "majority"
+	// ConsensusModeQuantile confirms the part status by classifying ConsensusQuantile of the areas measured
+	// in the confirmation window
+	ConsensusModeQuantile = "quantile"
+)
+
+// PartState is state of the part in view of the camera
+type PartState int
+
+const (
+	// PartEmpty means there is no part in view
+	PartEmpty PartState = iota
+	// PartTracking means the part was counted but its status has not been confirmed yet
+	PartTracking
+	// PartConfirmedOK means the part had no defect in more than ConfirmFrames consecutive frames
+	PartConfirmedOK
+	// PartConfirmedDefect means the part had a defect in more than ConfirmFrames consecutive frames
+	PartConfirmedDefect
+)
+
+// TrackerUpdate reports what happened to the tracked part in the last frame
+type TrackerUpdate struct {
+	// Counted means a new part came fully into view and must be counted
+	Counted bool
+	// DefectConfirmed means the part defect has just been confirmed
+	DefectConfirmed bool
+	// OKConfirmed means the part has just been confirmed to have no defect
+	OKConfirmed bool
+	// Left means the tracked part has left the view
+	Left bool
+}
+
+// Tracker tracks the part in view of the camera through its states from frame statuses
+// A new part is counted when it's first fully in view. Its status is decided according to Config.ConsensusMode:
+// either once it's been the same in more than ConfirmFrames consecutive frames, or by consensus of the confirmation
+// window of the last ConfirmFrames+1 measurements, so borderline parts oscillating around a bound get a stable status.
+// Confirmed defect stays confirmed until the part leaves.
+type Tracker struct {
+	// Config configures the confirmation: ConfirmFrames, ConsensusMode, ConsensusQuantile and the area range
+	Config Config
+	// state is state of the tracked part
+	state PartState
+	// defectFrames is number of consecutive frames where the part had a defect
+	defectFrames int
+	// okFrames is number of consecutive frames where the part was ok
+	okFrames int
+	// measured is number of frames the part was measured in
+	measured int
+	// defects is number of frames the part had a defect in
+	defects int
+	// areaSum is sum of the part areas measured in all frames
+	areaSum int
+	// window holds the measurements of the confirmation window, the oldest first
+	window []Status
+}
+
+// Update advances the tracker with part status s detected in the next frame and reports what happened
+func (t *Tracker) Update(s *Status) TrackerUpdate {
+	var u TrackerUpdate
+
+	switch {
+	case !s.Seen:
+		// empty belt: the next part starts from scratch
+		u.Left = t.state != PartEmpty
+		*t = Tracker{Config: t.Config}
+		return u
+	case s.Partial:
+		// part is entering or leaving the view: keep tracking it without measuring or counting it
+		return u
+	}
+
+	if t.state == PartEmpty {
+		t.state = PartTracking
+		u.Counted = true
+	}
+
+	t.measured++
+	t.areaSum += s.Area
+	if s.Defect {
+		t.defects++
+		t.defectFrames++
+		t.okFrames = 0
+	} else {
+		t.okFrames++
+		t.defectFrames = 0
+	}
+
+	confirm := t.Config.ConfirmFrames
+	if confirm == 0 {
+		confirm = DefaultConfirmFrames
+	}
+
+	// the window spans as many frames as consecutive frames confirm the status
+	t.window = append(t.window, *s)
+	if n := len(t.window) - (confirm + 1); n > 0 {
+		t.window = append(t.window[:0], t.window[n:]...)
+	}
+
+	var decided, defect bool
+	switch t.Config.ConsensusMode {
+	case ConsensusModeMajority, ConsensusModeQuantile:
+		decided, defect = len(t.window) > confirm, t.windowDefect()
+	default:
+		decided, defect = t.defectFrames > confirm || t.okFrames > confirm, t.defectFrames > confirm
+	}
+
+	switch {
+	case decided && defect && t.state != PartConfirmedDefect:
+		t.state = PartConfirmedDefect
+		u.DefectConfirmed = true
+	case decided && !defect && t.state == PartTracking:
+		t.state = PartConfirmedOK
+		u.OKConfirmed = true
+	}
+
+	return u
+}
+
+// windowDefect returns true if consensus of the confirmation window is that the part has a defect
+// Majority consensus needs more than half of the frames to have a defect; quantile consensus needs
+// Config.ConsensusQuantile of the measured areas to be outside of the area range.
+func (t *Tracker) windowDefect() bool {
+	if t.Config.ConsensusMode == ConsensusModeQuantile {
+		areas := make([]int, len(t.window))
+		for i, s := range t.window {
+			areas[i] = s.Area
+		}
+		sort.Ints(areas)
+		area := areas[int(math.Max(math.Ceil(t.Config.ConsensusQuantile*float64(len(areas)))-1, 0))]
+		return area < t.Config.Min || area > t.Config.Max
+	}
+
+	defects := 0
+	for _, s := range t.window {
+		if s.Defect {
+			defects++
+		}
+	}
+
+	return 2*defects > len(t.window)
+}
+
+// WindowAreas returns the smallest, the largest and the mean area measured in the confirmation window
+// They're all 0 if the part has not been measured.
+func (t *Tracker) WindowAreas() (min, max int, mean float64) {
+	if len(t.window) == 0 {
+		return 0, 0, 0
+	}
+
+	min, sum := t.window[0].Area, 0
+	for _, s := range t.window {
+		if s.Area < min {
+			min = s.Area
+		}
+		if s.Area > max {
+			max = s.Area
+		}
+		sum += s.Area
+	}
+
+	return min, max, float64(sum) / float64(len(t.window))
+}
+
+// State returns state of the tracked part
+func (t *Tracker) State() PartState {
+	return t.state
+}
+
+// Confidence returns confidence of the confirmed part classification in range [0, 1]; it's 0 until the part is confirmed
+// It's the fraction of the measured frames which agreed with the classification, scaled down when the mean
+// part area is closer than confidentMargin to the nearest bound of the expected area range [min, max].
+func (t *Tracker) Confidence(min, max int) float64 {
+	if t.measured == 0 || (t.state != PartConfirmedOK && t.state != PartConfirmedDefect) {
+		return 0
+	}
+
+	agreed := t.measured - t.defects
+	if t.state == PartConfirmedDefect {
+		agreed = t.defects
+	}
+
+	mean := float64(t.areaSum) / float64(t.measured)
+	margin := math.Min(relDistance(mean, min), relDistance(mean, max))
+
+	return float64(agreed) / float64(t.measured) * math.Min(margin/confidentMargin, 1)
+}
+
+// relDistance returns distance of area from bound relative to the bound; non-positive bounds are infinitely far
+func relDistance(area float64, bound int) float64 {
+	if bound <= 0 {
+		return math.Inf(1)
+	}
+
+	return math.Abs(area-float64(bound)) / float64(bound)
+}
+
+// Part is assembly line object
+type Part struct {
+	// Now is current status of Part
+	Now *Status
+	// Tracker tracks the part state across frames
+	Tracker Tracker
+	// FirstSeen is time when the part was first seen
+	FirstSeen time.Time
+	// LastSeen is time when the part was last seen
+	LastSeen time.Time
+	// Stuck means the part has stayed in view longer than the maximum dwell time
+	Stuck bool
+	// MinArea is the smallest area the part in view was measured with; it's 0 until the part is measured
+	MinArea int
+	// MaxArea is the largest area the part in view was measured with; it's 0 until the part is measured
+	MaxArea int
+	// Crossing follows the part in view across the trigger line
+	Crossing LineCrossing
+}
+
+// UpdateDwell tracks how long the part stays in view given whether it's seen in the frame processed at now
+// It returns how long the part in view has stayed in view and, in the first frame without the part, how long
+// the part which has just left stayed in view together with left set. The dwell time is 0 while there is no part.
+func (p *Part) UpdateDwell(seen bool, now time.Time) (dwell time.Duration, left bool) {
+	switch {
+	case seen:
+		if p.FirstSeen.IsZero() {
+			p.FirstSeen = now
+		}
+		p.LastSeen = now
+		return now.Sub(p.FirstSeen), false
+	case !p.FirstSeen.IsZero():
+		dwell = p.LastSeen.Sub(p.FirstSeen)
+		p.FirstSeen, p.Stuck = time.Time{}, false
+		return dwell, true
+	}
+
+	return 0, false
+}
+
+// Measure updates the running area extremes of the part in view with area measured in the current frame
+// Unmeasured area, i.e. 0 for partial or missing parts, leaves them unchanged.
+func (p *Part) Measure(area int) {
+	if area <= 0 {
+		return
+	}
+	if p.MinArea == 0 || area < p.MinArea {
+		p.MinArea = area
+	}
+	if area > p.MaxArea {
+		p.MaxArea = area
+	}
+}
@@ -0,0 +1,157 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// frameStatus returns part status of a frame described by c: o is good part, b is good part of area close
+// to the maximum, d is defected part, p is part partially in view and _ is empty belt
+func frameStatus(c byte) *Status {
+	switch c {
+	case 'o':
+		return &Status{Seen: true, Area: 25000}
+	case 'b':
+		return &Status{Seen: true, Area: 29000}
+	case 'd':
+		return &Status{Seen: true, Defect: true, Area: 40000, Severity: SeverityDefect}
+	case 'p':
+		return &Status{Seen: true, Partial: true}
+	}
+
+	return &Status{}
+}
+
+// updateEvent describes tracker update u by a letter: C is counted part, K is confirmed good part,
+// D is confirmed defect, L is part which left and . is none of them
+func updateEvent(u TrackerUpdate) byte {
+	switch {
+	case u.Counted:
+		return 'C'
+	case u.OKConfirmed:
+		return 'K'
+	case u.DefectConfirmed:
+		return 'D'
+	case u.Left:
+		return 'L'
+	}
+
+	return '.'
+}
+
+func TestTrackerUpdate(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		// frames are the frame statuses as described by frameStatus
+		frames string
+		// events are the expected update of every frame as described by updateEvent
+		events string
+		state  PartState
+	}{
+		{"enters defective", ConsensusModeConsecutive, "ddd", "C.D", PartConfirmedDefect},
+		{"enters defective from the edge", ConsensusModeMajority, "pddd", ".C.D", PartConfirmedDefect},
+		{"becomes defective", ConsensusModeConsecutive, "oooddd", "C.K..D", PartConfirmedDefect},
+		{"becomes defective by majority", ConsensusModeMajority, "oooddd", "C.K.D.", PartConfirmedDefect},
+		{"defect clears", ConsensusModeConsecutive, "ddooo", "C...K", PartConfirmedOK},
+		{"defect clears by majority", ConsensusModeMajority, "dooo", "C.K.", PartConfirmedOK},
+		{"back to back parts", ConsensusModeConsecutive, "ooo_ddd", "C.KLC.D", PartConfirmedDefect},
+		{"back to back parts by majority", ConsensusModeMajority, "ddd_ooo", "C.DLC.K", PartConfirmedOK},
+		{"part leaves", ConsensusModeConsecutive, "ooop__", "C.K.L.", PartEmpty},
+	}
+
+	for _, tt := range tests {
+		tr := Tracker{Config: Config{ConfirmFrames: 2, ConsensusMode: tt.mode}}
+		events := make([]byte, len(tt.frames))
+		for i := range tt.frames {
+			events[i] = updateEvent(tr.Update(frameStatus(tt.frames[i])))
+		}
+
+		if string(events) != tt.events {
+			t.Errorf("%s: frames %s updated %s, want %s", tt.name, tt.frames, events, tt.events)
+		}
+		if tr.State() != tt.state {
+			t.Errorf("%s: state %d, want %d", tt.name, tr.State(), tt.state)
+		}
+	}
+}
+
+func TestTrackerConfidence(t *testing.T) {
+	tests := []struct {
+		name string
+		mode string
+		// frames are the frame statuses as described by frameStatus
+		frames string
+		want   float64
+	}{
+		{"unanimous good part", ConsensusModeConsecutive, "ooo", 1},
+		{"unanimous defect", ConsensusModeConsecutive, "ddd", 1},
+		{"not confirmed", ConsensusModeConsecutive, "oo", 0},
+		// the agreeing fraction is scaled down by distance of the mean area from the maximum 30000
+		// relative to confidentMargin, e.g. mean area 32500 is 5/6 of the margin away
+		{"defect after good frames", ConsensusModeConsecutive, "oooddd", 0.5 * 5 / 6},
+		{"good part after a defect frame", ConsensusModeMajority, "dooo", 0.75 * 1250 / 3000},
+		{"borderline area", ConsensusModeConsecutive, "bbb", 1.0 / 3},
+		{"jittery borderline part", ConsensusModeMajority, "bdbb", 0.75 * 1750 / 3000},
+	}
+
+	for _, tt := range tests {
+		tr := Tracker{Config: Config{ConfirmFrames: 2, ConsensusMode: tt.mode}}
+		for i := range tt.frames {
+			tr.Update(frameStatus(tt.frames[i]))
+		}
+
+		if got := tr.Confidence(20000, 30000); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("%s: frames %s confidence %g, want %g", tt.name, tt.frames, got, tt.want)
+		}
+	}
+}
+
+func TestPartUpdateDwell(t *testing.T) {
+	start := time.Date(2018, 10, 15, 12, 0, 0, 0, time.UTC)
+	interval := 40 * time.Millisecond
+
+	// the part is in view for 5 frames, then the belt is empty and the next part arrives
+	frames := "_ooooo__oo"
+	wantDwell := []time.Duration{0, 0, 40, 80, 120, 160, 160, 0, 0, 40}
+	wantLeft := "......L..."
+
+	var p Part
+	p.Stuck = true
+	for i := range frames {
+		dwell, left := p.UpdateDwell(frames[i] == 'o', start.Add(time.Duration(i)*interval))
+		if want := wantDwell[i] * time.Millisecond; dwell != want {
+			t.Errorf("frame %d: dwell %v, want %v", i, dwell, want)
+		}
+		if left != (wantLeft[i] == 'L') {
+			t.Errorf("frame %d: left = %v, want %v", i, left, wantLeft[i] == 'L')
+		}
+		if left && p.Stuck {
+			t.Errorf("frame %d: part which left is still stuck", i)
+		}
+	}
+}
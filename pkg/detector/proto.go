@@ -0,0 +1,329 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+)
+
+// Result is encoded in protobuf wire format according to proto/result.proto.
+// The encoding is implemented here so the program does not depend on generated code.
+
+const (
+	// protoVarint is protobuf varint wire type
+	protoVarint = 0
+	// protoFixed64 is protobuf 64-bit wire type
+	protoFixed64 = 1
+	// protoBytes is protobuf length-delimited wire type
+	protoBytes = 2
+)
+
+// errProtoTruncated is returned when protobuf message ends unexpectedly
+var errProtoTruncated = errors.New("truncated protobuf message")
+
+// protoKey appends key of field number n of wire type t to b
+func protoKey(b []byte, n, t int) []byte {
+	return protoUvarint(b, uint64(n<<3|t))
+}
+
+// protoUvarint appends varint v to b
+func protoUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// protoInt appends int32 field number n with value v to b; zero values are omitted
+func protoInt(b []byte, n int, v int) []byte {
+	if v == 0 {
+		return b
+	}
+	// negative int32 values are sign extended to 64 bits
+	return protoUvarint(protoKey(b, n, protoVarint), uint64(int64(int32(v))))
+}
+
+// protoUint appends uint64 field number n with value v to b; zero values are omitted
+func protoUint(b []byte, n int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return protoUvarint(protoKey(b, n, protoVarint), v)
+}
+
+// protoBool appends bool field number n with value v to b; false values are omitted
+func protoBool(b []byte, n int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return protoUvarint(protoKey(b, n, protoVarint), 1)
+}
+
+// protoDouble appends double field number n with value v to b; zero values are omitted
+func protoDouble(b []byte, n int, v float64) []byte {
+	if v == 0 {
+		return b
+	}
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(v))
+	return append(protoKey(b, n, protoFixed64), buf[:]...)
+}
+
+// protoString appends length-delimited field number n with value v to b; empty values are omitted
+func protoString(b []byte, n int, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	return append(protoUvarint(protoKey(b, n, protoBytes), uint64(len(v))), v...)
+}
+
+// protoRect encodes rectangle r as Result.Rect message
+func protoRect(r image.Rectangle) []byte {
+	var b []byte
+	b = protoInt(b, 1, r.Min.X)
+	b = protoInt(b, 2, r.Min.Y)
+	b = protoInt(b, 3, r.Max.X)
+	return protoInt(b, 4, r.Max.Y)
+}
+
+// protoPoint encodes point p as Result.Point message
+func protoPoint(p image.Point) []byte {
+	var b []byte
+	b = protoInt(b, 1, p.X)
+	return protoInt(b, 2, p.Y)
+}
+
+// MarshalProto encodes the result as protobuf Result message defined in proto/result.proto
+// Zones and TraceParent are not encoded: zone results are published as separate messages.
+func (r *Result) MarshalProto() []byte {
+	var b []byte
+	b = protoString(b, 1, []byte(r.Source))
+	b = protoBool(b, 2, r.Defect)
+	b = protoString(b, 3, protoRect(r.Rect))
+	b = protoInt(b, 4, r.TotalParts)
+	b = protoInt(b, 5, r.TotalDefects)
+	b = protoString(b, 6, protoRect(r.OrigRect))
+	b = protoInt(b, 7, int(r.Severity))
+	b = protoBool(b, 8, r.Partial)
+	b = protoInt(b, 9, r.Min)
+	b = protoInt(b, 10, r.Max)
+	b = protoInt(b, 11, r.DefectAge)
+	b = protoDouble(b, 12, r.DefectRate)
+	b = protoDouble(b, 13, r.Dwell)
+	b = protoDouble(b, 14, r.AvgDwell)
+	b = protoDouble(b, 15, r.PublishRate)
+	b = protoUint(b, 16, r.Seq)
+	b = protoString(b, 17, []byte(r.EventID))
+	b = protoInt(b, 18, r.RestartCount)
+	b = protoDouble(b, 19, r.FPS)
+	b = protoBool(b, 20, r.BeltJam)
+	b = protoString(b, 21, []byte(r.Zone))
+	b = protoDouble(b, 22, r.Confidence)
+	b = protoUint(b, 23, uint64(r.ProcessingTimeNs))
+	b = protoInt(b, 24, r.PartMinArea)
+	b = protoInt(b, 25, r.PartMaxArea)
+	b = protoBool(b, 26, r.CameraBlocked)
+	b = protoBool(b, 27, r.LineStalled)
+	b = protoString(b, 28, []byte(r.Profile))
+	b = protoUint(b, 29, uint64(r.DwellMs))
+	b = protoString(b, 30, []byte(r.MessageID))
+	b = protoString(b, 31, protoPoint(r.Centroid))
+	return protoString(b, 32, protoRect(r.ZoneRect))
+}
+
+// protoField is a decoded protobuf field
+type protoField struct {
+	// n is field number
+	n int
+	// v is value of varint and 64-bit fields
+	v uint64
+	// data is value of length-delimited fields
+	data []byte
+}
+
+// protoFields decodes protobuf message b into its fields
+// It returns error if the message is malformed or uses unsupported wire types.
+func protoFields(b []byte) ([]protoField, error) {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errProtoTruncated
+		}
+		b = b[n:]
+
+		f := protoField{n: int(key >> 3)}
+		switch key & 7 {
+		case protoVarint:
+			if f.v, n = binary.Uvarint(b); n <= 0 {
+				return nil, errProtoTruncated
+			}
+			b = b[n:]
+		case protoFixed64:
+			if len(b) < 8 {
+				return nil, errProtoTruncated
+			}
+			f.v, b = binary.LittleEndian.Uint64(b), b[8:]
+		case protoBytes:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return nil, errProtoTruncated
+			}
+			f.data, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			return nil, fmt.Errorf("unsupported protobuf wire type %d", key&7)
+		}
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+// unmarshalProtoRect decodes Result.Rect message b
+func unmarshalProtoRect(b []byte) (image.Rectangle, error) {
+	fields, err := protoFields(b)
+	if err != nil {
+		return image.Rectangle{}, err
+	}
+
+	var c [4]int
+	for _, f := range fields {
+		if f.n >= 1 && f.n <= 4 {
+			c[f.n-1] = int(int32(f.v))
+		}
+	}
+
+	return image.Rect(c[0], c[1], c[2], c[3]), nil
+}
+
+// unmarshalProtoPoint decodes Result.Point message b
+func unmarshalProtoPoint(b []byte) (image.Point, error) {
+	fields, err := protoFields(b)
+	if err != nil {
+		return image.Point{}, err
+	}
+
+	var p image.Point
+	for _, f := range fields {
+		switch f.n {
+		case 1:
+			p.X = int(int32(f.v))
+		case 2:
+			p.Y = int(int32(f.v))
+		}
+	}
+
+	return p, nil
+}
+
+// UnmarshalResultProto decodes protobuf Result message b defined in proto/result.proto and returns the result
+// Unknown fields are ignored. It returns error if the message is malformed.
+func UnmarshalResultProto(b []byte) (*Result, error) {
+	fields, err := protoFields(b)
+	if err != nil {
+		return nil, err
+	}
+
+	r := new(Result)
+	for _, f := range fields {
+		switch f.n {
+		case 1:
+			r.Source = string(f.data)
+		case 2:
+			r.Defect = f.v != 0
+		case 3:
+			if r.Rect, err = unmarshalProtoRect(f.data); err != nil {
+				return nil, err
+			}
+		case 4:
+			r.TotalParts = int(int32(f.v))
+		case 5:
+			r.TotalDefects = int(int32(f.v))
+		case 6:
+			if r.OrigRect, err = unmarshalProtoRect(f.data); err != nil {
+				return nil, err
+			}
+		case 7:
+			r.Severity = Severity(int32(f.v))
+		case 8:
+			r.Partial = f.v != 0
+		case 9:
+			r.Min = int(int32(f.v))
+		case 10:
+			r.Max = int(int32(f.v))
+		case 11:
+			r.DefectAge = int(int32(f.v))
+		case 12:
+			r.DefectRate = math.Float64frombits(f.v)
+		case 13:
+			r.Dwell = math.Float64frombits(f.v)
+		case 14:
+			r.AvgDwell = math.Float64frombits(f.v)
+		case 15:
+			r.PublishRate = math.Float64frombits(f.v)
+		case 16:
+			r.Seq = f.v
+		case 17:
+			r.EventID = string(f.data)
+		case 18:
+			r.RestartCount = int(int32(f.v))
+		case 19:
+			r.FPS = math.Float64frombits(f.v)
+		case 20:
+			r.BeltJam = f.v != 0
+		case 21:
+			r.Zone = string(f.data)
+		case 22:
+			r.Confidence = math.Float64frombits(f.v)
+		case 23:
+			r.ProcessingTimeNs = int64(f.v)
+		case 24:
+			r.PartMinArea = int(int32(f.v))
+		case 25:
+			r.PartMaxArea = int(int32(f.v))
+		case 26:
+			r.CameraBlocked = f.v != 0
+		case 27:
+			r.LineStalled = f.v != 0
+		case 28:
+			r.Profile = string(f.data)
+		case 29:
+			r.DwellMs = int64(f.v)
+		case 30:
+			r.MessageID = string(f.data)
+		case 31:
+			if r.Centroid, err = unmarshalProtoPoint(f.data); err != nil {
+				return nil, err
+			}
+		case 32:
+			if r.ZoneRect, err = unmarshalProtoRect(f.data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,194 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// Severity is severity of detected part status
+type Severity int
+
+const (
+	// SeverityOK means the part area is within the expected range
+	SeverityOK Severity = iota
+	// SeverityWarn means the part area is close to the expected range bounds
+	// or the part is out of the range but the defect has not been confirmed yet
+	SeverityWarn
+	// SeverityDefect means the part has a confirmed defect
+	SeverityDefect
+)
+
+// String implements fmt.Stringer interface for Severity
+func (s Severity) String() string {
+	switch s {
+	case SeverityOK:
+		return "ok"
+	case SeverityWarn:
+		return "warn"
+	case SeverityDefect:
+		return "defect"
+	}
+
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// Color returns display color of the severity: green for OK, yellow for warning and red for defect
+func (s Severity) Color() color.RGBA {
+	switch s {
+	case SeverityWarn:
+		return color.RGBA{255, 255, 0, 0}
+	case SeverityDefect:
+		return color.RGBA{255, 0, 0, 0}
+	}
+
+	return color.RGBA{0, 255, 0, 0}
+}
+
+// PartialColor is display color of parts which are only partially in view
+var PartialColor = color.RGBA{128, 128, 128, 0}
+
+// Result is computation result returned to main goroutine
+type Result struct {
+	// Source is name of the video source the result was detected in
+	Source string
+	// Defect is used to signal the part defect was found.
+	Defect bool
+	// Rect is detected part rectangle area
+	Rect image.Rectangle
+	// Centroid is centroid of the detected part contour
+	Centroid image.Point
+	// Min is minimum part area the part was measured against
+	Min int
+	// Max is maximum part area the part was measured against
+	Max int
+	// TotalParts contains total number of detected parts
+	TotalParts int
+	// TotalDefects contains total number of defected parts
+	TotalDefects int
+	// OrigRect is detected part rectangle area in original frame coordinates
+	OrigRect image.Rectangle
+	// Severity is severity of the detected part status
+	Severity Severity
+	// Partial means the detected part is only partially in view and it's not measured
+	Partial bool
+	// DefectAge is number of frames processed since the part defect was confirmed; it's 0 if there is no defect
+	DefectAge int
+	// DefectRate is rate of defected parts among the recent parts
+	DefectRate float64
+	// Dwell is number of seconds the last part which left the view stayed in view
+	Dwell float64
+	// AvgDwell is average number of seconds the recent parts stayed in view
+	AvgDwell float64
+	// DwellMs is number of milliseconds the part in view has stayed in view; when the part leaves the view
+	// it's its final dwell time and it's 0 while there is no part
+	DwellMs int64
+	// PublishRate is number of analytics messages published per second when the result was published
+	PublishRate float64
+	// Seq is sequence number of the result within its video source; consumers use it to detect lost messages
+	Seq uint64
+	// EventID is unique identifier of the confirmed part defect; it's empty if there is no defect
+	EventID string
+	// MessageID identifies the published message, so consumers can drop messages delivered again from the spool;
+	// it's empty if the messages are not spooled
+	MessageID string
+	// RestartCount is number of program restarts the sequence numbers survived
+	RestartCount int
+	// FPS is effective frame rate of the video source
+	FPS float64
+	// BeltJam means no part has been seen for longer than the presence timeout
+	BeltJam bool
+	// Confidence is confidence of the confirmed part classification in range [0, 1]; it's 0 until the part is confirmed
+	Confidence float64
+	// ProcessingTimeNs is number of nanoseconds the detection of the frame took
+	ProcessingTimeNs int64
+	// PartMinArea is the smallest area the part in view was measured with; it's 0 if no part was measured
+	PartMinArea int
+	// PartMaxArea is the largest area the part in view was measured with; it's 0 if no part was measured
+	PartMaxArea int
+	// CameraBlocked means the frames of the video source are too dark to see the line
+	CameraBlocked bool
+	// LineStalled means the frames of the video source stayed static for longer than parts are expected to arrive
+	LineStalled bool
+	// Profile is name of the configuration profile the result was detected with; it's empty if no profile is used
+	Profile string
+	// Zone is name of the zone the result was detected in; it's empty for the whole video source
+	Zone string
+	// ZoneRect is the zone rectangle in processing frame coordinates
+	ZoneRect image.Rectangle
+	// Zones are results of the zones of the video source; they are published as separate messages
+	Zones []Result
+	// TraceParent is W3C traceparent of the span of the frame the result was detected in; it's empty if the frame
+	// was not traced. It's not published.
+	TraceParent string
+}
+
+// String implements fmt.Stringer interface for Result
+func (r *Result) String() string {
+	return fmt.Sprintf("Total parts: %d, Total defects: %v", r.TotalParts, r.TotalDefects)
+}
+
+// ToMQTTMessage turns result into MQTT message which can be published to MQTT broker
+// Rect is reported in original frame coordinates
+func (r *Result) ToMQTTMessage() string {
+	rect := r.OrigRect
+	return fmt.Sprintf("{\"Source\":%q,\"Defect\":%v,\"Severity\":%q,\"Partial\":%v,\"Rect\":[%d,%d,%d,%d],"+
+		"\"DefectRate\":%g,\"Dwell\":%g,\"AvgDwell\":%g,\"PublishRate\":%g,\"Seq\":%d,\"EventID\":%q,\"RestartCount\":%d,\"FPS\":%g,\"BeltJam\":%v,"+
+		"\"Zone\":%q,\"TotalParts\":%d,\"TotalDefects\":%d,\"Confidence\":%g,\"processing_time_ns\":%d,"+
+		"\"PartMinArea\":%d,\"PartMaxArea\":%d,\"CameraBlocked\":%v,\"LineStalled\":%v,\"Profile\":%q,\"DwellMs\":%d,\"MessageID\":%q}",
+		r.Source, r.Defect, r.Severity, r.Partial, rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y,
+		r.DefectRate, r.Dwell, r.AvgDwell, r.PublishRate, r.Seq, r.EventID, r.RestartCount, r.FPS, r.BeltJam,
+		r.Zone, r.TotalParts, r.TotalDefects, r.Confidence, r.ProcessingTimeNs, r.PartMinArea, r.PartMaxArea,
+		r.CameraBlocked, r.LineStalled, r.Profile, r.DwellMs, r.MessageID)
+}
+
+// Changed reports whether result r differs from previously published result prev
+// in part defect classification, totals or whether a part is seen; nil prev always differs.
+func (r *Result) Changed(prev *Result) bool {
+	if prev == nil {
+		return true
+	}
+
+	return r.Defect != prev.Defect || r.Severity != prev.Severity || r.Partial != prev.Partial ||
+		r.TotalParts != prev.TotalParts || r.TotalDefects != prev.TotalDefects ||
+		r.Rect.Empty() != prev.Rect.Empty()
+}
+
+const (
+	// EncodingJSON encodes MQTT messages as JSON
+	EncodingJSON = "json"
+	// EncodingProto encodes MQTT messages as protobuf Result messages defined in proto/result.proto
+	EncodingProto = "proto"
+)
+
+// Payload encodes result as MQTT message payload using encoding: json or proto
+func (r *Result) Payload(encoding string) string {
+	if encoding == EncodingProto {
+		return string(r.MarshalProto())
+	}
+
+	return r.ToMQTTMessage()
+}
@@ -0,0 +1,232 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+import (
+	"encoding/json"
+	"image"
+	"reflect"
+	"testing"
+)
+
+// testResult returns result with every field set to a value different from its zero value
+func testResult() *Result {
+	return &Result{
+		Source:           "cam0",
+		Defect:           true,
+		Rect:             image.Rect(10, 20, 110, 220),
+		Centroid:         image.Pt(60, 120),
+		Min:              20000,
+		Max:              30000,
+		TotalParts:       42,
+		TotalDefects:     3,
+		OrigRect:         image.Rect(20, 40, 220, 440),
+		Severity:         SeverityDefect,
+		Partial:          true,
+		DefectAge:        7,
+		DefectRate:       0.125,
+		Dwell:            1.5,
+		AvgDwell:         1.25,
+		DwellMs:          1500,
+		PublishRate:      9.5,
+		Seq:              1 << 40,
+		EventID:          "cam0-42",
+		MessageID:        "1539600000000000000-7",
+		RestartCount:     2,
+		FPS:              29.97,
+		BeltJam:          true,
+		Confidence:       0.9,
+		ProcessingTimeNs: 12345678,
+		PartMinArea:      19000,
+		PartMaxArea:      31000,
+		CameraBlocked:    true,
+		LineStalled:      true,
+		Profile:          "small",
+		Zone:             "left",
+		ZoneRect:         image.Rect(0, 0, 480, 540),
+		Zones:            []Result{{Source: "cam0", Zone: "left"}},
+		TraceParent:      "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01",
+	}
+}
+
+func TestTestResultSetsEveryField(t *testing.T) {
+	v := reflect.ValueOf(*testResult())
+	for i := 0; i < v.NumField(); i++ {
+		if reflect.DeepEqual(v.Field(i).Interface(), reflect.Zero(v.Field(i).Type()).Interface()) {
+			t.Errorf("testResult() does not set %s, so the round trip tests don't cover it", v.Type().Field(i).Name)
+		}
+	}
+}
+
+// jsonResult is the JSON MQTT message of Result
+type jsonResult struct {
+	Source           string
+	Defect           bool
+	Severity         string
+	Partial          bool
+	Rect             [4]int
+	DefectRate       float64
+	Dwell            float64
+	AvgDwell         float64
+	PublishRate      float64
+	Seq              uint64
+	EventID          string
+	RestartCount     int
+	FPS              float64
+	BeltJam          bool
+	Zone             string
+	TotalParts       int
+	TotalDefects     int
+	Confidence       float64
+	ProcessingTimeNs int64 `json:"processing_time_ns"`
+	PartMinArea      int
+	PartMaxArea      int
+	CameraBlocked    bool
+	LineStalled      bool
+	Profile          string
+	DwellMs          int64
+	MessageID        string
+}
+
+// result returns the result the JSON message was encoded from; the message rect is the original frame rect
+func (j *jsonResult) result() *Result {
+	r := &Result{
+		Source:           j.Source,
+		Defect:           j.Defect,
+		Partial:          j.Partial,
+		OrigRect:         image.Rect(j.Rect[0], j.Rect[1], j.Rect[2], j.Rect[3]),
+		DefectRate:       j.DefectRate,
+		Dwell:            j.Dwell,
+		AvgDwell:         j.AvgDwell,
+		PublishRate:      j.PublishRate,
+		Seq:              j.Seq,
+		EventID:          j.EventID,
+		RestartCount:     j.RestartCount,
+		FPS:              j.FPS,
+		BeltJam:          j.BeltJam,
+		Zone:             j.Zone,
+		TotalParts:       j.TotalParts,
+		TotalDefects:     j.TotalDefects,
+		Confidence:       j.Confidence,
+		ProcessingTimeNs: j.ProcessingTimeNs,
+		PartMinArea:      j.PartMinArea,
+		PartMaxArea:      j.PartMaxArea,
+		CameraBlocked:    j.CameraBlocked,
+		LineStalled:      j.LineStalled,
+		Profile:          j.Profile,
+		DwellMs:          j.DwellMs,
+		MessageID:        j.MessageID,
+	}
+	for _, s := range []Severity{SeverityOK, SeverityWarn, SeverityDefect} {
+		if s.String() == j.Severity {
+			r.Severity = s
+		}
+	}
+
+	return r
+}
+
+func TestResultJSONRoundTrip(t *testing.T) {
+	r := testResult()
+
+	var j jsonResult
+	if err := json.Unmarshal([]byte(r.Payload(EncodingJSON)), &j); err != nil {
+		t.Fatalf("invalid JSON message %s: %v", r.Payload(EncodingJSON), err)
+	}
+
+	// the in-process fields are not published in JSON messages
+	want := *r
+	want.Rect, want.Min, want.Max, want.DefectAge = image.Rectangle{}, 0, 0, 0
+	want.Centroid, want.ZoneRect, want.Zones, want.TraceParent = image.Point{}, image.Rectangle{}, nil, ""
+	if got := j.result(); !reflect.DeepEqual(*got, want) {
+		t.Errorf("JSON round trip:\n got %+v\nwant %+v", *got, want)
+	}
+}
+
+func TestResultProtoRoundTrip(t *testing.T) {
+	tests := []*Result{
+		testResult(),
+		// zero values and negative numbers must survive as well
+		{Rect: image.Rect(-5, -5, 0, 0), Centroid: image.Pt(-3, 0), Severity: SeverityOK},
+	}
+
+	for _, r := range tests {
+		got, err := UnmarshalResultProto([]byte(r.Payload(EncodingProto)))
+		if err != nil {
+			t.Fatalf("failed to decode proto message of %+v: %v", *r, err)
+		}
+		// zone results are published as separate messages and the trace is not published
+		want := *r
+		want.Zones, want.TraceParent = nil, ""
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("proto round trip:\n got %+v\nwant %+v", *got, want)
+		}
+	}
+}
+
+func TestUnmarshalResultProtoMalformed(t *testing.T) {
+	b := testResult().MarshalProto()
+
+	if _, err := UnmarshalResultProto(b[:len(b)-1]); err == nil {
+		t.Errorf("truncated message decoded without error")
+	}
+}
+
+func TestResultChanged(t *testing.T) {
+	prev := &Result{TotalParts: 10, TotalDefects: 2, Rect: image.Rect(10, 10, 50, 50)}
+
+	tests := []struct {
+		name   string
+		change func(r *Result)
+		want   bool
+	}{
+		{"identical", func(r *Result) {}, false},
+		// fields which are not compared don't make the result change
+		{"rect moved", func(r *Result) { r.Rect = r.Rect.Add(image.Pt(5, 5)) }, false},
+		{"statistics", func(r *Result) { r.FPS, r.DefectRate, r.Seq = 30, 0.5, 7 }, false},
+		{"defect", func(r *Result) { r.Defect = true }, true},
+		{"severity", func(r *Result) { r.Severity = SeverityWarn }, true},
+		{"partial", func(r *Result) { r.Partial = true }, true},
+		{"total parts", func(r *Result) { r.TotalParts++ }, true},
+		{"total defects", func(r *Result) { r.TotalDefects++ }, true},
+		{"part left", func(r *Result) { r.Rect = image.Rectangle{} }, true},
+	}
+
+	for _, tt := range tests {
+		r := *prev
+		tt.change(&r)
+		if got := r.Changed(prev); got != tt.want {
+			t.Errorf("%s: Changed = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	// part appearing is a change as well
+	empty := &Result{TotalParts: 10, TotalDefects: 2}
+	if !prev.Changed(empty) {
+		t.Errorf("part appearing: Changed = false, want true")
+	}
+	if !prev.Changed(nil) {
+		t.Errorf("nil previous result: Changed = false, want true")
+	}
+}
@@ -0,0 +1,111 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+import (
+	"gocv.io/x/gocv"
+)
+
+const (
+	// ShadowsOff disables shadow suppression
+	ShadowsOff = "off"
+	// ShadowsHSV suppresses dark unsaturated pixels of the frame before it's converted to gray
+	ShadowsHSV = "hsv"
+	// ShadowsBGSub excludes pixels labeled as shadows by MOG2 background subtraction from the thresholded frame
+	ShadowsBGSub = "bgsub"
+)
+
+// HSVShadows returns binary mask of the pixels of BGR image img whose value and saturation are below
+// cfg.ShadowValue and cfg.ShadowSaturation, i.e. which are likely shadows; the caller must close the mask.
+func HSVShadows(img gocv.Mat, cfg Config) gocv.Mat {
+	hsv := gocv.NewMat()
+	defer hsv.Close()
+	gocv.CvtColor(img, &hsv, gocv.ColorBGRToHSV)
+
+	mask := gocv.NewMat()
+	gocv.InRangeWithScalar(hsv, gocv.NewScalar(0, 0, 0, 0),
+		gocv.NewScalar(180, float64(cfg.ShadowSaturation), float64(cfg.ShadowValue), 0), &mask)
+
+	return mask
+}
+
+// suppressShadows replaces HSV shadows of BGR image img with the belt level in its gray conversion dst,
+// so they don't merge with the part; dark parts are detected on bright belt if cfg.Invert is set.
+func suppressShadows(img gocv.Mat, dst *gocv.Mat, cfg Config) {
+	shadows := HSVShadows(img, cfg)
+	defer shadows.Close()
+
+	gocv.CvtColor(img, dst, gocv.ColorBGRToGray)
+	if cfg.Invert {
+		gocv.BitwiseOr(*dst, shadows, dst)
+		return
+	}
+	gocv.BitwiseNot(shadows, &shadows)
+	gocv.BitwiseAnd(*dst, shadows, dst)
+}
+
+// ShadowDetector labels shadows of the moving parts using MOG2 background subtraction
+// It learns the background from every frame, so it must see all the frames of the video source in order.
+type ShadowDetector struct {
+	mog2 gocv.BackgroundSubtractorMOG2
+	// fg is foreground mask of the last frame; MOG2 labels shadows 127, foreground 255 and background 0
+	fg gocv.Mat
+	// shadows is binary mask of the shadows of the last frame
+	shadows gocv.Mat
+	// free is binary mask of the pixels of the last frame which are not shadows
+	free gocv.Mat
+}
+
+// NewShadowDetector creates new shadow detector and returns it
+func NewShadowDetector() *ShadowDetector {
+	return &ShadowDetector{
+		mog2:    gocv.NewBackgroundSubtractorMOG2(),
+		fg:      gocv.NewMat(),
+		shadows: gocv.NewMat(),
+		free:    gocv.NewMat(),
+	}
+}
+
+// Apply updates the background model with BGR frame img and returns binary mask of its pixels which are not shadows
+// The mask is owned by d and it's valid until the next call.
+func (d *ShadowDetector) Apply(img gocv.Mat) gocv.Mat {
+	d.mog2.Apply(img, &d.fg)
+	gocv.InRangeWithScalar(d.fg, gocv.NewScalar(127, 0, 0, 0), gocv.NewScalar(127, 0, 0, 0), &d.shadows)
+	gocv.BitwiseNot(d.shadows, &d.free)
+
+	return d.free
+}
+
+// Shadows returns binary mask of the shadows of the last frame; it's owned by d and it's valid until the next Apply
+func (d *ShadowDetector) Shadows() gocv.Mat {
+	return d.shadows
+}
+
+// Close releases resources of d
+func (d *ShadowDetector) Close() error {
+	d.free.Close()
+	d.shadows.Close()
+	d.fg.Close()
+	return d.mog2.Close()
+}
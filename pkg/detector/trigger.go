@@ -0,0 +1,127 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+const (
+	// TriggerForward counts parts crossing the trigger line towards increasing coordinate
+	TriggerForward = "forward"
+	// TriggerBackward counts parts crossing the trigger line towards decreasing coordinate
+	TriggerBackward = "backward"
+)
+
+// TriggerLine is a virtual line across the belt; parts are counted when their centroid crosses it
+type TriggerLine struct {
+	// Vertical means the line is at X = Pos and the parts move horizontally; otherwise it's at Y = Pos
+	Vertical bool
+	// Pos is coordinate of the line in the processing frame
+	Pos int
+	// Dir is 1 if the parts cross the line towards increasing coordinate and -1 otherwise
+	Dir int
+	// Hysteresis is distance from the line the centroid must move to on both sides for the crossing to count
+	Hysteresis int
+}
+
+// ParseTriggerLine parses trigger line s in x=N or y=N format crossed in direction dir with hysteresis
+func ParseTriggerLine(s, dir string, hysteresis int) (TriggerLine, error) {
+	l := TriggerLine{Dir: 1, Hysteresis: hysteresis}
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 || (kv[0] != "x" && kv[0] != "y") {
+		return l, fmt.Errorf("invalid trigger line %q: expected x=N or y=N", s)
+	}
+	pos, err := strconv.Atoi(kv[1])
+	if err != nil || pos < 0 {
+		return l, fmt.Errorf("invalid trigger line %q: expected non-negative coordinate", s)
+	}
+	l.Vertical, l.Pos = kv[0] == "x", pos
+
+	switch dir {
+	case TriggerForward:
+	case TriggerBackward:
+		l.Dir = -1
+	default:
+		return l, fmt.Errorf("invalid trigger direction %q: expected %s or %s", dir, TriggerForward, TriggerBackward)
+	}
+
+	if hysteresis < 0 {
+		return l, fmt.Errorf("invalid trigger hysteresis %d: must not be negative", hysteresis)
+	}
+
+	return l, nil
+}
+
+// Ends returns the end points of the line within frame of given size
+func (l TriggerLine) Ends(size image.Point) (image.Point, image.Point) {
+	if l.Vertical {
+		return image.Point{l.Pos, 0}, image.Point{l.Pos, size.Y}
+	}
+
+	return image.Point{0, l.Pos}, image.Point{size.X, l.Pos}
+}
+
+// LineCrossing follows the centroid of the part in view across the trigger line, so every part is counted once
+// The part must be seen Hysteresis before the line and then Hysteresis past it, so a part oscillating
+// near the line, or a detection flickering there, crosses it only once.
+type LineCrossing struct {
+	// armed is set once the centroid was seen before the line
+	armed bool
+	// Crossed means the part in view has crossed the line
+	Crossed bool
+}
+
+// Update moves the part in view to centroid in the current frame and returns true if it has just crossed line l
+// Frames without the part, i.e. seen is false, don't move it. The part crosses the line at most once until Reset,
+// even if it moves back before the line and past it again.
+func (c *LineCrossing) Update(l TriggerLine, centroid image.Point, seen bool) bool {
+	if !seen {
+		return false
+	}
+
+	coord := centroid.Y
+	if l.Vertical {
+		coord = centroid.X
+	}
+	// signed distance past the line in the crossing direction
+	d := (coord - l.Pos) * l.Dir
+	switch {
+	case d < -l.Hysteresis && !c.Crossed:
+		c.armed = true
+	case d >= l.Hysteresis && c.armed:
+		c.armed, c.Crossed = false, true
+		return true
+	}
+
+	return false
+}
+
+// Reset forgets the part which has left the view; the next part must be seen before the line to cross it
+func (c *LineCrossing) Reset() {
+	c.armed, c.Crossed = false, false
+}
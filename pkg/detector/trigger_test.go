@@ -0,0 +1,138 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+func TestParseTriggerLine(t *testing.T) {
+	tests := []struct {
+		s, dir     string
+		hysteresis int
+		want       TriggerLine
+		valid      bool
+	}{
+		{"x=480", TriggerForward, 10, TriggerLine{Vertical: true, Pos: 480, Dir: 1, Hysteresis: 10}, true},
+		{"y=300", TriggerBackward, 0, TriggerLine{Pos: 300, Dir: -1}, true},
+		{"z=300", TriggerForward, 10, TriggerLine{}, false},
+		{"x300", TriggerForward, 10, TriggerLine{}, false},
+		{"x=-1", TriggerForward, 10, TriggerLine{}, false},
+		{"x=abc", TriggerForward, 10, TriggerLine{}, false},
+		{"x=480", "sideways", 10, TriggerLine{}, false},
+		{"x=480", TriggerForward, -1, TriggerLine{}, false},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseTriggerLine(tt.s, tt.dir, tt.hysteresis)
+		if valid := err == nil; valid != tt.valid {
+			t.Errorf("ParseTriggerLine(%q, %q, %d) error = %v, want valid %v", tt.s, tt.dir, tt.hysteresis, err, tt.valid)
+			continue
+		}
+		if tt.valid && got != tt.want {
+			t.Errorf("ParseTriggerLine(%q, %q, %d) = %+v, want %+v", tt.s, tt.dir, tt.hysteresis, got, tt.want)
+		}
+	}
+}
+
+// trajectory returns centroid coordinates moving from start by step in n frames
+func trajectory(start, step, n int) []int {
+	coords := make([]int, n)
+	for i := range coords {
+		coords[i] = start + i*step
+	}
+
+	return coords
+}
+
+func TestLineCrossingUpdate(t *testing.T) {
+	vertical := TriggerLine{Vertical: true, Pos: 480, Dir: 1, Hysteresis: 10}
+	backward := TriggerLine{Pos: 300, Dir: -1, Hysteresis: 10}
+
+	tests := []struct {
+		name string
+		line TriggerLine
+		// coords are centroid coordinates across the line in consecutive frames; negative coordinate
+		// is a frame without the part
+		coords []int
+		// crossed are the frames in which the part is expected to cross the line
+		crossed []int
+	}{
+		{"part moving forward", vertical, trajectory(300, 25, 16), []int{8}},
+		{"part moving backward", backward, trajectory(500, -30, 10), []int{7}},
+		{"part moving against the direction", vertical, trajectory(700, -25, 16), nil},
+		{"part entering past the line", vertical, trajectory(500, 25, 8), nil},
+		{"part stopping on the line", vertical, []int{440, 460, 475, 485, 489, 489, 489}, nil},
+		{"part oscillating near the line", vertical, []int{440, 465, 495, 470, 500, 465, 505, 520}, []int{2}},
+		{"part pushed back after crossing", vertical, []int{440, 500, 440, 500}, []int{1}},
+		{"detection flickering", vertical, []int{440, -1, 465, -1, -1, 495, -1, 520}, []int{5}},
+	}
+
+	for _, tt := range tests {
+		var c LineCrossing
+		var crossed []int
+		for i, coord := range tt.coords {
+			// the part moves across the belt only, so the centroid doesn't move along the line
+			centroid := image.Point{coord, 200}
+			if !tt.line.Vertical {
+				centroid = image.Point{200, coord}
+			}
+			if c.Update(tt.line, centroid, coord >= 0) {
+				crossed = append(crossed, i)
+			}
+		}
+
+		if !reflect.DeepEqual(crossed, tt.crossed) {
+			t.Errorf("%s: crossed in frames %v, want %v", tt.name, crossed, tt.crossed)
+		}
+		if c.Crossed != (len(tt.crossed) > 0) {
+			t.Errorf("%s: Crossed = %v, want %v", tt.name, c.Crossed, len(tt.crossed) > 0)
+		}
+	}
+}
+
+func TestLineCrossingReset(t *testing.T) {
+	l := TriggerLine{Vertical: true, Pos: 480, Dir: 1, Hysteresis: 10}
+
+	// two parts following each other both cross the line once the first one has left
+	crossings := 0
+	var c LineCrossing
+	for part := 0; part < 2; part++ {
+		for _, x := range trajectory(300, 40, 10) {
+			if c.Update(l, image.Point{x, 200}, true) {
+				crossings++
+			}
+		}
+		c.Reset()
+		if c.Crossed {
+			t.Errorf("part %d: Crossed after Reset", part)
+		}
+	}
+
+	if crossings != 2 {
+		t.Errorf("%d crossings of 2 parts", crossings)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package detector
+
+import (
+	"fmt"
+	"image"
+)
+
+// Zone is named region of the processing frame with its own part area range, e.g. a lane of the assembly line belt
+// Parts are detected, tracked and counted in every zone independently.
+type Zone struct {
+	// Name identifies the zone in results, overlay and MQTT messages
+	Name string `yaml:"name"`
+	// Rect is region of the processing frame covered by the zone as x,y,width,height
+	Rect string `yaml:"rect"`
+	// Min is minimum part area in the zone
+	Min int `yaml:"min"`
+	// Max is maximum part area in the zone
+	Max int `yaml:"max"`
+	// Bounds is parsed Rect; it's set by Config.Validate
+	Bounds image.Rectangle `yaml:"-"`
+}
+
+// validateZones parses the rectangles of zones and returns error if any zone is invalid or two zones overlap
+func validateZones(zones []Zone) error {
+	names := make(map[string]bool)
+	for i := range zones {
+		z := &zones[i]
+		if z.Name == "" {
+			return fmt.Errorf("zone %d has no name", i+1)
+		}
+		if names[z.Name] {
+			return fmt.Errorf("duplicate zone %q", z.Name)
+		}
+		names[z.Name] = true
+
+		pts, err := ParsePoints(z.Rect, 2)
+		if err != nil {
+			return fmt.Errorf("invalid rectangle of zone %q: %v", z.Name, err)
+		}
+		if pts[0].X < 0 || pts[0].Y < 0 || pts[1].X <= 0 || pts[1].Y <= 0 {
+			return fmt.Errorf("invalid rectangle of zone %q: expected non-negative origin and positive size", z.Name)
+		}
+		z.Bounds = image.Rectangle{pts[0], pts[0].Add(pts[1])}
+
+		if z.Min > z.Max {
+			return fmt.Errorf("minimum area %d of zone %q exceeds its maximum area %d", z.Min, z.Name, z.Max)
+		}
+
+		// a part in overlapping zones would be counted twice
+		for _, prev := range zones[:i] {
+			if z.Bounds.Overlaps(prev.Bounds) {
+				return fmt.Errorf("zone %q overlaps zone %q", z.Name, prev.Name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ZoneConfig returns detector configuration of zone z: only the zone is searched for parts and its area range applies
+// The unnamed zone stands for the whole frame, so its configuration is c.
+func (c *Config) ZoneConfig(z Zone) Config {
+	dc := *c
+	if z.Name == "" {
+		return dc
+	}
+
+	dc.Min, dc.Max = z.Min, z.Max
+	dc.ROI, dc.UseROI, dc.ROIRect = z.Rect, true, z.Bounds
+	dc.Zones = nil
+
+	return dc
+}
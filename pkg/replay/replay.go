@@ -0,0 +1,169 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package replay replays logged detection results through the part tracking using different detector thresholds,
+// so "what if" questions about the thresholds can be answered without the original video.
+//
+// The log is a JSON Lines file of results as published by the monitor, e.g. streamed with -sink=stdout. Parts are
+// re-measured from the logged rectangles, so the area range must be given in the original frame coordinates.
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+)
+
+// event is a logged detection result; only the fields the replay needs are decoded
+type event struct {
+	// Source is name of the video source the result was detected in
+	Source string
+	// Zone is name of the zone the result was detected in; it's empty for the whole video source
+	Zone string
+	// Partial means the part was not fully in view
+	Partial bool
+	// Rect is the detected part rectangle in original frame coordinates
+	Rect [4]int
+	// DwellMs is number of milliseconds the part in view has stayed in view
+	DwellMs int64
+}
+
+// SourceSummary is summary of the replayed results of a single video source or zone
+type SourceSummary struct {
+	// Source is name of the video source
+	Source string
+	// Zone is name of the zone; it's empty for the whole video source
+	Zone string
+	// TotalParts is number of counted parts
+	TotalParts int
+	// TotalDefects is number of parts with confirmed defect
+	TotalDefects int
+	// DwellParts is number of parts which left the view with known dwell time
+	DwellParts int
+	// AvgDwellMs is average number of milliseconds the parts stayed in view
+	AvgDwellMs float64
+	// MaxDwellMs is the longest number of milliseconds a part stayed in view
+	MaxDwellMs int64
+}
+
+// SessionSummary is summary of the replayed session
+type SessionSummary struct {
+	// Results is number of replayed results
+	Results int
+	// Sources are summaries of the video sources and zones in the order they were first seen
+	Sources []SourceSummary
+}
+
+// Replayer replays logged detection results through part tracking using detector configuration
+type Replayer struct {
+	// r reads the log
+	r io.Reader
+	// cfg is detector configuration the results are replayed with
+	cfg detector.Config
+}
+
+// NewReplayer creates new replayer of the results logged in r using detector configuration cfg
+// The configuration must be validated.
+func NewReplayer(r io.Reader, cfg detector.Config) *Replayer {
+	return &Replayer{r: r, cfg: cfg}
+}
+
+// Run replays all the logged results and returns the revised totals of every video source and zone
+// Parts are counted when they come fully into view and their defects are confirmed by the Tracker the monitor uses;
+// zones are replayed with their own area range if the configuration has zones of the same names.
+// It returns error if the log can't be read or if ctx is done before the replay finishes.
+func (r *Replayer) Run(ctx context.Context) (*SessionSummary, error) {
+	summary := new(SessionSummary)
+	// parts tracks the part in view of every video source and zone; index maps them to their summaries
+	parts := make(map[string]*detector.Part)
+	index := make(map[string]int)
+	// dwells keeps the longest logged dwell time of the part in view of every video source and zone, so the dwell
+	// is known even if the result of the frame the part left the view in was not logged
+	dwells := make(map[string]int64)
+
+	dec := json.NewDecoder(r.r)
+	for {
+		select {
+		case <-ctx.Done():
+			return summary, ctx.Err()
+		default:
+		}
+
+		var e event
+		if err := dec.Decode(&e); err == io.EOF {
+			return summary, nil
+		} else if err != nil {
+			return summary, fmt.Errorf("failed to read result %d: %v", summary.Results+1, err)
+		}
+		summary.Results++
+
+		key := e.Source + "/" + e.Zone
+		part, ok := parts[key]
+		if !ok {
+			part = &detector.Part{Now: new(detector.Status)}
+			parts[key] = part
+			index[key] = len(summary.Sources)
+			summary.Sources = append(summary.Sources, SourceSummary{Source: e.Source, Zone: e.Zone})
+		}
+		s := &summary.Sources[index[key]]
+
+		cfg := r.zoneConfig(e.Zone)
+		rect := image.Rect(e.Rect[0], e.Rect[1], e.Rect[2], e.Rect[3])
+		part.Now = detector.DetectStatus(&rect, e.Partial, cfg)
+		part.Tracker.Config = cfg
+		update := part.Tracker.Update(part.Now)
+		if update.Counted {
+			s.TotalParts++
+		}
+		if update.DefectConfirmed {
+			s.TotalDefects++
+		}
+
+		if e.DwellMs > dwells[key] {
+			dwells[key] = e.DwellMs
+		}
+		if update.Left && dwells[key] > 0 {
+			s.DwellParts++
+			s.AvgDwellMs += (float64(dwells[key]) - s.AvgDwellMs) / float64(s.DwellParts)
+			if dwells[key] > s.MaxDwellMs {
+				s.MaxDwellMs = dwells[key]
+			}
+			dwells[key] = 0
+		}
+	}
+}
+
+// zoneConfig returns detector configuration of zone named name; it's the whole configuration if there is no such zone
+func (r *Replayer) zoneConfig(name string) detector.Config {
+	for _, z := range r.cfg.Zones {
+		if z.Name == name {
+			return r.cfg.ZoneConfig(z)
+		}
+	}
+
+	return r.cfg
+}
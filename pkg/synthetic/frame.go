@@ -0,0 +1,84 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+// Package synthetic generates reproducible video frames with parts of controlled size and position,
+// so the detection can be exercised without sample videos.
+//
+// Parts are white filled rectangles on black background; camera noise is simulated by Gaussian noise added
+// to every pixel. The noise is generated from a fixed seed, so the same arguments always produce the same frame.
+package synthetic
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"math/rand"
+
+	"gocv.io/x/gocv"
+)
+
+// seed seeds the noise of every generated frame
+const seed = 1
+
+// GenerateFrame returns BGR frame of width and height with white filled blob rectangle on black background
+// noise is standard deviation of the Gaussian noise added to every pixel in gray levels; no noise is added if it's 0.
+// The caller must close the frame.
+func GenerateFrame(width, height int, blob image.Rectangle, noise float64) *gocv.Mat {
+	return GenerateMultipartFrame(width, height, []image.Rectangle{blob}, noise)
+}
+
+// GenerateDefectFrame returns frame like GenerateFrame with a blob centered in it whose area is outside of the valid
+// area range [min, max]. The blob is half again as big as max if it fits into the frame, otherwise it's half of min.
+func GenerateDefectFrame(width, height, min, max int, noise float64) *gocv.Mat {
+	side := int(math.Ceil(math.Sqrt(1.5 * float64(max))))
+	if side >= width || side >= height {
+		side = int(math.Sqrt(float64(min) / 2))
+	}
+	center := image.Point{width / 2, height / 2}
+	blob := image.Rect(center.X-side/2, center.Y-side/2, center.X-side/2+side, center.Y-side/2+side)
+
+	return GenerateFrame(width, height, blob, noise)
+}
+
+// GenerateMultipartFrame returns frame like GenerateFrame with all blobs drawn in it
+func GenerateMultipartFrame(width, height int, blobs []image.Rectangle, noise float64) *gocv.Mat {
+	img := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(0, 0, 0, 0), height, width, gocv.MatTypeCV8UC3)
+	for _, blob := range blobs {
+		gocv.Rectangle(&img, blob, color.RGBA{255, 255, 255, 0}, -1)
+	}
+
+	if noise > 0 {
+		addNoise(&img, noise)
+	}
+
+	return &img
+}
+
+// addNoise adds Gaussian noise of standard deviation stddev to every pixel of img saturating at the gray level bounds
+func addNoise(img *gocv.Mat, stddev float64) {
+	r := rand.New(rand.NewSource(seed))
+	data := img.DataPtrUint8()
+	for i, v := range data {
+		data[i] = uint8(math.Max(0, math.Min(255, float64(v)+r.NormFloat64()*stddev)))
+	}
+}
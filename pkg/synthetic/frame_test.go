@@ -0,0 +1,121 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package synthetic
+
+import (
+	"bytes"
+	"image"
+	"testing"
+
+	"gocv.io/x/gocv"
+)
+
+// whitePixels returns number of the white pixels of BGR frame img and their bounds
+func whitePixels(img *gocv.Mat) (int, image.Rectangle) {
+	var r image.Rectangle
+	n := 0
+	data, cols := img.ToBytes(), img.Cols()
+	for i := 0; i+2 < len(data); i += 3 {
+		if data[i] == 255 && data[i+1] == 255 && data[i+2] == 255 {
+			p := image.Point{i / 3 % cols, i / 3 / cols}
+			r = r.Union(image.Rectangle{p, p.Add(image.Point{1, 1})})
+			n++
+		}
+	}
+
+	return n, r
+}
+
+func TestGenerateFrame(t *testing.T) {
+	blob := image.Rect(100, 50, 220, 130)
+	img := GenerateFrame(320, 240, blob, 0)
+	defer img.Close()
+
+	if img.Cols() != 320 || img.Rows() != 240 || img.Channels() != 3 {
+		t.Fatalf("frame is %dx%d with %d channels, want 320x240 with 3", img.Cols(), img.Rows(), img.Channels())
+	}
+	n, r := whitePixels(img)
+	if r != blob || n != blob.Dx()*blob.Dy() {
+		t.Errorf("%d white pixels within %v, want filled %v", n, r, blob)
+	}
+	// everything but the blob is black
+	if n := len(img.ToBytes()) - bytes.Count(img.ToBytes(), []byte{0}); n != 3*blob.Dx()*blob.Dy() {
+		t.Errorf("%d non-zero channel values, want %d of the blob", n, 3*blob.Dx()*blob.Dy())
+	}
+}
+
+func TestGenerateFrameNoise(t *testing.T) {
+	blob := image.Rect(100, 50, 220, 130)
+	clean := GenerateFrame(320, 240, blob, 0)
+	defer clean.Close()
+	noisy := GenerateFrame(320, 240, blob, 8)
+	defer noisy.Close()
+	again := GenerateFrame(320, 240, blob, 8)
+	defer again.Close()
+
+	if bytes.Equal(noisy.ToBytes(), clean.ToBytes()) {
+		t.Errorf("noise was not added")
+	}
+	if !bytes.Equal(noisy.ToBytes(), again.ToBytes()) {
+		t.Errorf("frames generated with the same arguments differ")
+	}
+}
+
+func TestGenerateDefectFrame(t *testing.T) {
+	tests := []struct {
+		width, height, min, max int
+	}{
+		// the blob is bigger than max
+		{640, 480, 20000, 30000},
+		// the blob bigger than max doesn't fit so it's smaller than min
+		{160, 120, 20000, 30000},
+	}
+
+	for _, tt := range tests {
+		img := GenerateDefectFrame(tt.width, tt.height, tt.min, tt.max, 0)
+		_, r := whitePixels(img)
+		img.Close()
+
+		if area := r.Dx() * r.Dy(); area >= tt.min && area <= tt.max {
+			t.Errorf("%dx%d frame: blob %v of area %d within [%d, %d]", tt.width, tt.height, r, area, tt.min, tt.max)
+		}
+		if !r.In(image.Rect(0, 0, tt.width, tt.height)) {
+			t.Errorf("%dx%d frame: blob %v out of the frame", tt.width, tt.height, r)
+		}
+	}
+}
+
+func TestGenerateMultipartFrame(t *testing.T) {
+	blobs := []image.Rectangle{image.Rect(10, 10, 60, 60), image.Rect(200, 100, 300, 180)}
+	img := GenerateMultipartFrame(320, 240, blobs, 0)
+	defer img.Close()
+
+	n, r := whitePixels(img)
+	if want := blobs[0].Union(blobs[1]); r != want {
+		t.Errorf("blobs drawn within %v, want %v", r, want)
+	}
+	if want := blobs[0].Dx()*blobs[0].Dy() + blobs[1].Dx()*blobs[1].Dy(); n != want {
+		t.Errorf("%d white pixels, want %d of the blobs", n, want)
+	}
+}
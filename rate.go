@@ -0,0 +1,117 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+// defectRateWindow is number of the most recent parts the rolling defect rate is computed from
+const defectRateWindow = 50
+
+// rollingRate computes the rate of defected parts among the most recent parts
+type rollingRate struct {
+	// defects is a circular buffer of the most recent parts; true marks a defected part
+	defects []bool
+	// next is index of the buffer slot the next part is stored in
+	next int
+	// count is number of parts in the buffer
+	count int
+}
+
+// newRollingRate creates new rolling rate computed from the last size parts and returns it
+func newRollingRate(size int) *rollingRate {
+	return &rollingRate{
+		defects: make([]bool, size),
+	}
+}
+
+// Add records a new part; defect marks it as defected
+func (r *rollingRate) Add(defect bool) {
+	r.defects[r.next] = defect
+	r.next = (r.next + 1) % len(r.defects)
+	if r.count < len(r.defects) {
+		r.count++
+	}
+}
+
+// MarkLast marks the most recently added part as defected
+func (r *rollingRate) MarkLast() {
+	if r.count == 0 {
+		return
+	}
+	r.defects[(r.next+len(r.defects)-1)%len(r.defects)] = true
+}
+
+// Rate returns the rate of defected parts among the recorded parts
+func (r *rollingRate) Rate() float64 {
+	if r.count == 0 {
+		return 0
+	}
+
+	defects := 0
+	for i := 0; i < r.count; i++ {
+		if r.defects[i] {
+			defects++
+		}
+	}
+
+	return float64(defects) / float64(r.count)
+}
+
+// rollingMean computes the mean of the most recent values
+type rollingMean struct {
+	// values is a circular buffer of the most recent values
+	values []float64
+	// next is index of the buffer slot the next value is stored in
+	next int
+	// count is number of values in the buffer
+	count int
+}
+
+// newRollingMean creates new rolling mean computed from the last size values and returns it
+func newRollingMean(size int) *rollingMean {
+	return &rollingMean{
+		values: make([]float64, size),
+	}
+}
+
+// Add records a new value v
+func (m *rollingMean) Add(v float64) {
+	m.values[m.next] = v
+	m.next = (m.next + 1) % len(m.values)
+	if m.count < len(m.values) {
+		m.count++
+	}
+}
+
+// Mean returns the mean of the recorded values
+func (m *rollingMean) Mean() float64 {
+	if m.count == 0 {
+		return 0
+	}
+
+	sum := 0.0
+	for i := 0; i < m.count; i++ {
+		sum += m.values[i]
+	}
+
+	return sum / float64(m.count)
+}
@@ -0,0 +1,125 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+// Recorder records annotated frames into video files
+// When the current file reaches the maximum number of frames, a new sequentially numbered file is started.
+type Recorder struct {
+	// path is path of the first video file
+	path string
+	// codec is FourCC code of the video codec
+	codec string
+	// fps is frame rate of the recorded video
+	fps float64
+	// size is frame size of the recorded video
+	size image.Point
+	// maxFrames is number of frames after which a new file is started; it's unlimited if zero
+	maxFrames int
+	// vw writes the current video file; it's nil until the first frame is written
+	vw *gocv.VideoWriter
+	// frames is number of frames written to the current file
+	frames int
+	// seq is sequence number of the current file
+	seq int
+}
+
+// NewRecorder creates new recorder which writes size frames at fps frame rate encoded with codec
+// into video file path and returns it. A new file is started every maxFrames frames if maxFrames is positive.
+func NewRecorder(path, codec string, fps float64, size image.Point, maxFrames int) *Recorder {
+	return &Recorder{
+		path:      path,
+		codec:     codec,
+		fps:       fps,
+		size:      size,
+		maxFrames: maxFrames,
+	}
+}
+
+// filePath returns path of the video file with sequence number seq
+// Existing files are never overwritten: a timestamp is appended to the name of an existing file.
+func (r *Recorder) filePath(seq int) string {
+	ext := filepath.Ext(r.path)
+	base := strings.TrimSuffix(r.path, ext)
+	if seq > 0 {
+		base = fmt.Sprintf("%s-%d", base, seq)
+	}
+
+	path := base + ext
+	if _, err := os.Stat(path); err == nil {
+		path = fmt.Sprintf("%s-%s%s", base, time.Now().Format("20060102T150405"), ext)
+	}
+
+	return path
+}
+
+// Write appends frame img to the current video file starting a new file if needed
+// It returns error if the video file can't be opened or the frame can't be written.
+func (r *Recorder) Write(img gocv.Mat) error {
+	if r.vw != nil && r.maxFrames > 0 && r.frames >= r.maxFrames {
+		if err := r.Close(); err != nil {
+			return err
+		}
+		r.seq++
+	}
+
+	if r.vw == nil {
+		path := r.filePath(r.seq)
+		vw, err := gocv.VideoWriterFile(path, r.codec, r.fps, r.size.X, r.size.Y, true)
+		if err != nil {
+			return fmt.Errorf("failed to open video file %s: %v", path, err)
+		}
+		fmt.Printf("Recording annotated video to %s\n", path)
+		r.vw, r.frames = vw, 0
+	}
+
+	if err := r.vw.Write(img); err != nil {
+		return err
+	}
+	r.frames++
+
+	return nil
+}
+
+// Close closes the current video file
+func (r *Recorder) Close() error {
+	if r.vw == nil {
+		return nil
+	}
+
+	err := r.vw.Close()
+	r.vw = nil
+
+	return err
+}
@@ -0,0 +1,143 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// ConfigWatcher keeps the running configuration and applies the configurations received on its channel
+// using a callback, e.g. the configurations reloaded by reloadRunner
+type ConfigWatcher struct {
+	// updates delivers the new configurations
+	updates <-chan Config
+	// apply applies new configuration to the running program
+	apply func(Config)
+	// mu guards cfg
+	mu sync.Mutex
+	// cfg is the running configuration
+	cfg Config
+}
+
+// NewConfigWatcher returns watcher of running configuration cfg which applies configurations received
+// on updates using apply
+func NewConfigWatcher(cfg Config, updates <-chan Config, apply func(Config)) *ConfigWatcher {
+	return &ConfigWatcher{updates: updates, apply: apply, cfg: cfg}
+}
+
+// Config returns the running configuration
+func (w *ConfigWatcher) Config() Config {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.cfg
+}
+
+// update applies configuration cfg and makes it the running configuration
+func (w *ConfigWatcher) update(cfg Config) {
+	w.apply(cfg)
+
+	w.mu.Lock()
+	w.cfg = cfg
+	w.mu.Unlock()
+}
+
+// Poll applies the received configuration if there is any without blocking; it returns true if it was applied
+func (w *ConfigWatcher) Poll() bool {
+	select {
+	case cfg := <-w.updates:
+		w.update(cfg)
+		return true
+	default:
+		return false
+	}
+}
+
+// Run applies the received configurations until doneChan is closed
+func (w *ConfigWatcher) Run(doneChan <-chan struct{}) error {
+	for {
+		select {
+		case cfg := <-w.updates:
+			w.update(cfg)
+		case <-doneChan:
+			return nil
+		}
+	}
+}
+
+// reloadRunner reloads program configuration whenever a signal is received on hupChan.
+// args are command line arguments the configuration is loaded from; cfg is the active configuration.
+// Valid configuration is sent to reloadChan; if MQTT settings changed and cfg.Publish is set, a new MQTT client
+// is connected before it is sent to clientChan so no analytics are lost while the publisher is rebuilt.
+// Invalid configuration is rejected, the active configuration is kept and the error is sent to msgChan.
+// clientChan and msgChan can be nil if publishing is disabled.
+// doneChan is used to receive a signal from the main goroutine to notify the routine to stop and return
+func reloadRunner(args []string, cfg Config, hupChan <-chan os.Signal, doneChan <-chan struct{},
+	reloadChan chan<- Config, clientChan chan<- Publisher, msgChan chan<- mqttMessage) error {
+	for {
+		select {
+		case <-hupChan:
+			fmt.Printf("Reloading configuration\n")
+			fs := flag.NewFlagSet(name, flag.ContinueOnError)
+			fs.SetOutput(ioutil.Discard)
+			newCfg, err := LoadConfig(fs, args)
+			if err == nil && newCfg.MQTT != cfg.MQTT && clientChan != nil {
+				var c *MQTTClient
+				if c, err = NewMQTTPublisher(newCfg.MQTT); err == nil {
+					select {
+					case clientChan <- c:
+					case <-doneChan:
+						closePublisher(c, time.Duration(newCfg.MQTTDisconnect)*time.Millisecond)
+						return nil
+					}
+				}
+			}
+
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Rejected configuration reload, keeping active configuration: %v\n", err)
+				select {
+				case msgChan <- mqttMessage{statusTopic, fmt.Sprintf("{\"Status\":%q}",
+					fmt.Sprintf("configuration reload failed: %v", err))}:
+				default:
+				}
+				continue
+			}
+
+			select {
+			case reloadChan <- newCfg:
+			case <-doneChan:
+				return nil
+			}
+			cfg = newCfg
+		case <-doneChan:
+			fmt.Printf("Stopping reloadRunner: received stop signal\n")
+			return nil
+		}
+	}
+}
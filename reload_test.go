@@ -0,0 +1,105 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeConfig writes YAML configuration with detector area range min and max to path
+func writeConfig(t *testing.T, path string, min, max int) {
+	t.Helper()
+
+	data := fmt.Sprintf("detector:\n  min: %d\n  max: %d\n", min, max)
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("failed to write configuration: %v", err)
+	}
+}
+
+func TestConfigReloadOnSIGHUP(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osd-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yaml")
+	writeConfig(t, path, 20000, 30000)
+	args := []string{"-input", "belt.mp4", "-config", path}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	cfg, err := LoadConfig(fs, args)
+	if err != nil {
+		t.Fatalf("failed to load configuration: %v", err)
+	}
+
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	defer signal.Stop(hupChan)
+	doneChan := make(chan struct{})
+	defer close(doneChan)
+	reloadChan := make(chan Config)
+	applied := make(chan Config, 1)
+	watcher := NewConfigWatcher(cfg, reloadChan, func(c Config) { applied <- c })
+	go reloadRunner(args, cfg, hupChan, doneChan, reloadChan, nil, nil)
+	go watcher.Run(doneChan)
+
+	// reload sends SIGHUP to the program and returns the applied configuration or nil if none was applied
+	reload := func() *Config {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+			t.Fatalf("failed to send SIGHUP: %v", err)
+		}
+		select {
+		case c := <-applied:
+			return &c
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+
+	writeConfig(t, path, 15000, 25000)
+	if c := reload(); c == nil || c.Min != 15000 || c.Max != 25000 {
+		t.Fatalf("reloaded configuration not applied: %+v", c)
+	}
+	if c := watcher.Config(); c.Min != 15000 || c.Max != 25000 {
+		t.Errorf("running area range %d-%d, want 15000-25000", c.Min, c.Max)
+	}
+
+	// invalid configuration is rejected and the running configuration is kept
+	writeConfig(t, path, 25000, 15000)
+	if c := reload(); c != nil {
+		t.Errorf("invalid configuration applied: area range %d-%d", c.Min, c.Max)
+	}
+	if c := watcher.Config(); c.Min != 15000 || c.Max != 25000 {
+		t.Errorf("running area range %d-%d after invalid reload, want 15000-25000", c.Min, c.Max)
+	}
+}
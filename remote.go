@@ -0,0 +1,348 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// BackendEnv reads configuration from command line flags, configuration file and environment variables only
+	BackendEnv = "env"
+	// BackendConsul also reads detector settings from Consul KV store
+	BackendConsul = "consul"
+	// BackendEtcd also reads detector settings from etcd
+	BackendEtcd = "etcd"
+)
+
+const (
+	// remoteTimeout bounds fetching of the remote configuration on startup and reload
+	remoteTimeout = 5 * time.Second
+	// consulWait is how long Consul blocking query waits for the settings to change
+	consulWait = 5 * time.Minute
+	// etcdPollInterval is how often etcd settings are checked for changes
+	etcdPollInterval = 5 * time.Second
+	// remoteRetryInterval is how long the watcher waits before it retries a failed query
+	remoteRetryInterval = 10 * time.Second
+)
+
+// ConfigBackend is remote key-value store detector settings are read from
+type ConfigBackend interface {
+	// Fetch returns settings stored under the configured prefix keyed by their names and version of the settings.
+	// If version is not 0, it waits until the settings differ from version or until the backend wait times out.
+	Fetch(ctx context.Context, version uint64) (map[string]string, uint64, error)
+}
+
+// NewConfigBackend creates new configuration backend of kind backend from environment variables and returns it
+// It returns nil backend for BackendEnv.
+// CONSUL_ADDR and CONSUL_PREFIX configure Consul; ETCD_ADDR and ETCD_PREFIX configure etcd.
+func NewConfigBackend(backend string) (ConfigBackend, error) {
+	switch backend {
+	case BackendEnv:
+		return nil, nil
+	case BackendConsul:
+		addr, prefix, err := backendEnv("CONSUL_ADDR", "CONSUL_PREFIX")
+		if err != nil {
+			return nil, err
+		}
+		return &consulBackend{addr: addr, prefix: prefix}, nil
+	case BackendEtcd:
+		addr, prefix, err := backendEnv("ETCD_ADDR", "ETCD_PREFIX")
+		if err != nil {
+			return nil, err
+		}
+		return &etcdBackend{addr: addr, prefix: prefix}, nil
+	}
+
+	return nil, fmt.Errorf("invalid config backend %q: expected %s, %s or %s", backend, BackendEnv, BackendConsul, BackendEtcd)
+}
+
+// backendEnv reads backend address and key prefix from environment variables addrKey and prefixKey
+// Address defaults to http scheme; the prefix is stripped of slashes.
+func backendEnv(addrKey, prefixKey string) (string, string, error) {
+	addr := os.Getenv(addrKey)
+	if addr == "" {
+		return "", "", fmt.Errorf("%s is not set", addrKey)
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	prefix := strings.Trim(os.Getenv(prefixKey), "/")
+	if prefix == "" {
+		return "", "", fmt.Errorf("%s is not set", prefixKey)
+	}
+
+	return strings.TrimSuffix(addr, "/"), prefix, nil
+}
+
+// settingKey returns setting name of key stored under prefix
+// It returns false if the key is not a setting, e.g. it's the prefix itself or a nested key.
+func settingKey(key, prefix string) (string, bool) {
+	name := strings.TrimPrefix(key, prefix+"/")
+	if name == key || name == "" || strings.Contains(name, "/") {
+		return "", false
+	}
+
+	return name, true
+}
+
+// consulBackend reads settings from Consul KV store
+type consulBackend struct {
+	// addr is Consul HTTP API address
+	addr string
+	// prefix is key prefix of the settings
+	prefix string
+}
+
+// Fetch implements ConfigBackend interface using Consul blocking queries; version is Consul index
+func (b *consulBackend) Fetch(ctx context.Context, version uint64) (map[string]string, uint64, error) {
+	q := url.Values{"recurse": {"true"}}
+	if version != 0 {
+		q.Set("index", strconv.FormatUint(version, 10))
+		q.Set("wait", consulWait.String())
+	}
+
+	req, err := http.NewRequest(http.MethodGet, b.addr+"/v1/kv/"+b.prefix+"/?"+q.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	// index goes backwards when the store is restored; the query must start over then
+	if index < version {
+		index = 0
+	}
+	settings := make(map[string]string)
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// no settings stored under the prefix
+		return settings, index, nil
+	default:
+		return nil, 0, fmt.Errorf("consul responded %s", resp.Status)
+	}
+
+	var kvs []struct {
+		Key   string
+		Value []byte
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&kvs); err != nil {
+		return nil, 0, fmt.Errorf("invalid consul response: %v", err)
+	}
+	for _, kv := range kvs {
+		if name, ok := settingKey(kv.Key, b.prefix); ok {
+			settings[name] = string(kv.Value)
+		}
+	}
+
+	return settings, index, nil
+}
+
+// etcdBackend reads settings from etcd using its v3 JSON API
+type etcdBackend struct {
+	// addr is etcd gRPC gateway address
+	addr string
+	// prefix is key prefix of the settings
+	prefix string
+}
+
+// Fetch implements ConfigBackend interface by polling etcd; version is hash of the settings
+func (b *etcdBackend) Fetch(ctx context.Context, version uint64) (map[string]string, uint64, error) {
+	for {
+		settings, err := b.fetch(ctx)
+		if err != nil {
+			return nil, 0, err
+		}
+		if v := settingsVersion(settings); v != version {
+			return settings, v, nil
+		}
+
+		select {
+		case <-time.After(etcdPollInterval):
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+}
+
+// fetch reads all the settings stored under the prefix
+func (b *etcdBackend) fetch(ctx context.Context) (map[string]string, error) {
+	// range end of a prefix is the prefix with its last byte incremented
+	key := []byte(b.prefix + "/")
+	end := append([]byte(nil), key...)
+	end[len(end)-1]++
+
+	body, err := json.Marshal(struct {
+		Key      []byte `json:"key"`
+		RangeEnd []byte `json:"range_end"`
+	}{key, end})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, b.addr+"/v3/kv/range", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("etcd responded %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var rr struct {
+		Kvs []struct {
+			Key   []byte `json:"key"`
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&rr); err != nil {
+		return nil, fmt.Errorf("invalid etcd response: %v", err)
+	}
+
+	settings := make(map[string]string)
+	for _, kv := range rr.Kvs {
+		if name, ok := settingKey(string(kv.Key), b.prefix); ok {
+			settings[name] = string(kv.Value)
+		}
+	}
+
+	return settings, nil
+}
+
+// settingsVersion returns non-zero hash of settings which changes whenever any of them changes
+func settingsVersion(settings map[string]string) uint64 {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := fnv.New64a()
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\x00", name, settings[name])
+	}
+
+	return h.Sum64() | 1
+}
+
+// loadRemote reads detector settings from backend into c
+// Settings are named like the detector settings in the configuration file and they're parsed
+// the same way as the corresponding command line flags in fs, which take precedence over them.
+func (c *Config) loadRemote(fs *flag.FlagSet, backend ConfigBackend) error {
+	ctx, cancel := context.WithTimeout(context.Background(), remoteTimeout)
+	defer cancel()
+
+	settings, _, err := backend.Fetch(ctx, 0)
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s configuration: %v", c.ConfigBackend, err)
+	}
+
+	// only detector settings can be configured remotely
+	detectorFlags := flag.NewFlagSet("", flag.ContinueOnError)
+	new(DetectorConfig).RegisterFlags(detectorFlags)
+
+	return withExplicitFlags(fs, func() error {
+		for key, val := range settings {
+			name := strings.Replace(key, "_", "-", -1)
+			if detectorFlags.Lookup(name) == nil {
+				return fmt.Errorf("unknown %s setting %q", c.ConfigBackend, key)
+			}
+			if err := fs.Set(name, val); err != nil {
+				return fmt.Errorf("invalid %s setting %s: %v", c.ConfigBackend, key, err)
+			}
+			c.setSource("detector", key, SourceRemote)
+		}
+		return nil
+	})
+}
+
+// configWatchRunner watches backend for changes of the settings and signals hupChan to reload the configuration
+// Failed queries are retried after remoteRetryInterval.
+// doneChan is used to receive a signal from the main goroutine to notify the routine to stop and return
+func configWatchRunner(backend ConfigBackend, hupChan chan<- os.Signal, doneChan <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-doneChan
+		cancel()
+	}()
+
+	var version uint64
+	for {
+		_, v, err := backend.Fetch(ctx, version)
+		switch {
+		case ctx.Err() != nil:
+			fmt.Printf("Stopping configWatchRunner: received stop signal\n")
+			return nil
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Failed to watch remote configuration: %v\n", err)
+			select {
+			case <-time.After(remoteRetryInterval):
+			case <-doneChan:
+			}
+			continue
+		case version != 0 && v != version:
+			fmt.Printf("Remote configuration changed\n")
+			// reload is already pending if hupChan is full
+			select {
+			case hupChan <- syscall.SIGHUP:
+			default:
+			}
+		}
+		version = v
+		// backend without versions can't be queried for changes right away
+		if version == 0 {
+			select {
+			case <-time.After(remoteRetryInterval):
+			case <-doneChan:
+			}
+		}
+	}
+}
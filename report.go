@@ -0,0 +1,226 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// reportHourly schedules a report at the start of every hour
+	reportHourly = "@hourly"
+	// reportSubtopic is subtopic of the analytics topic the reports are published to
+	reportSubtopic = "/report"
+	// DefectUndersize is type of defects of parts smaller than the area range
+	DefectUndersize = "undersize"
+	// DefectOversize is type of defects of parts bigger than the area range
+	DefectOversize = "oversize"
+	// DefectDwell is type of defects of parts which stayed in view too short or too long
+	DefectDwell = "dwell"
+)
+
+// ReportSchedule is schedule of the aggregate reports
+type ReportSchedule struct {
+	// hourly schedules a report at the start of every hour
+	hourly bool
+	// minutes are minutes since midnight reports are scheduled at
+	minutes []int
+}
+
+// ParseReportSchedule parses comma-separated list of @hourly and times of day in HH:MM format, e.g. @hourly,06:00
+// It returns error if any entry is invalid.
+func ParseReportSchedule(s string) (ReportSchedule, error) {
+	var rs ReportSchedule
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == reportHourly {
+			rs.hourly = true
+			continue
+		}
+		m, err := parseTimeOfDay(entry)
+		if err != nil {
+			return rs, fmt.Errorf("invalid report schedule: %v", err)
+		}
+		rs.minutes = append(rs.minutes, m)
+	}
+
+	return rs, nil
+}
+
+// Next returns the first scheduled report time after t; it's zero if no report is scheduled
+func (s ReportSchedule) Next(t time.Time) time.Time {
+	var next time.Time
+	consider := func(c time.Time) {
+		if c.After(t) && (next.IsZero() || c.Before(next)) {
+			next = c
+		}
+	}
+
+	if s.hourly {
+		consider(time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location()))
+	}
+	for _, m := range s.minutes {
+		consider(time.Date(t.Year(), t.Month(), t.Day(), 0, m, 0, 0, t.Location()))
+		consider(time.Date(t.Year(), t.Month(), t.Day()+1, 0, m, 0, 0, t.Location()))
+	}
+
+	return next
+}
+
+// ShiftReport is aggregate of the parts of all the video sources detected in a reporting period
+type ShiftReport struct {
+	// PeriodStart is time the period started at
+	PeriodStart time.Time
+	// PeriodEnd is time the period ended at
+	PeriodEnd time.Time
+	// Parts is number of parts counted in the period
+	Parts int
+	// Defects is number of defects confirmed in the period
+	Defects int
+	// DefectsByType is number of defects confirmed in the period by their type: undersize or oversize
+	DefectsByType map[string]int
+	// DefectRate is rate of defects among the parts of the period
+	DefectRate float64
+	// MeanArea is mean area of the parts of the period
+	MeanArea float64
+	// P95Area is 95th percentile of the areas of the parts of the period
+	P95Area int
+	// UptimeSeconds is number of seconds the program has been running for
+	UptimeSeconds float64
+	// DroppedFrames is number of frames dropped before detection in the period
+	DroppedFrames uint64
+	// Final means the report was flushed when the program stopped before the period was over
+	Final bool
+}
+
+// Reporter aggregates parts and defects of all the video sources into periodic reports
+// It's safe for concurrent use. The period accumulators are reset by every report; the part counters are not.
+type Reporter struct {
+	mu sync.Mutex
+	// now returns the current time; it can be replaced to control the time the reports are taken at
+	now func() time.Time
+	// started is time the program started at
+	started time.Time
+	// periodStart is time the current period started at
+	periodStart time.Time
+	// dropped is number of the dropped frames when the current period started
+	dropped uint64
+	// parts is number of parts counted in the current period
+	parts int
+	// defects is number of defects confirmed in the current period by their type
+	defects map[string]int
+	// areas are areas of the parts counted in the current period
+	areas []int
+}
+
+// NewReporter creates new reporter which tells the current time using now and returns it
+func NewReporter(now func() time.Time) *Reporter {
+	t := now()
+	return &Reporter{now: now, started: t, periodStart: t, dropped: atomic.LoadUint64(&DroppedFrames),
+		defects: make(map[string]int)}
+}
+
+// AddPart records part of area counted in the current period
+func (r *Reporter) AddPart(area int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.parts++
+	r.areas = append(r.areas, area)
+}
+
+// AddDefect records defect of type kind confirmed in the current period
+func (r *Reporter) AddDefect(kind string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.defects[kind]++
+}
+
+// Report returns report of the current period and starts a new period; final marks the report flushed on shutdown
+func (r *Reporter) Report(final bool) ShiftReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	dropped := atomic.LoadUint64(&DroppedFrames)
+	rep := ShiftReport{
+		PeriodStart:   r.periodStart,
+		PeriodEnd:     now,
+		Parts:         r.parts,
+		DefectsByType: r.defects,
+		UptimeSeconds: now.Sub(r.started).Seconds(),
+		DroppedFrames: dropped - r.dropped,
+		Final:         final,
+	}
+	for _, n := range r.defects {
+		rep.Defects += n
+	}
+	if r.parts > 0 {
+		rep.DefectRate = float64(rep.Defects) / float64(r.parts)
+	}
+	if len(r.areas) > 0 {
+		sort.Ints(r.areas)
+		var sum float64
+		for _, a := range r.areas {
+			sum += float64(a)
+		}
+		rep.MeanArea = sum / float64(len(r.areas))
+		rep.P95Area = r.areas[int(math.Ceil(0.95*float64(len(r.areas))))-1]
+	}
+
+	r.periodStart, r.dropped = now, dropped
+	r.parts, r.defects, r.areas = 0, make(map[string]int), nil
+
+	return rep
+}
+
+// publishReport publishes report rep to the report subtopic of topic using publisher c within timeout
+func publishReport(ctx context.Context, c Publisher, topic string, rep ShiftReport, timeout time.Duration) error {
+	data, err := json.Marshal(rep)
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %v", err)
+	}
+
+	pubCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	return c.PublishContext(pubCtx, topic+reportSubtopic, string(data))
+}
+
+// defectType returns type of defect of part of area measured against area range starting at min
+func defectType(area, min int) string {
+	if area < min {
+		return DefectUndersize
+	}
+
+	return DefectOversize
+}
@@ -0,0 +1,128 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"image"
+	"time"
+
+	"gocv.io/x/gocv"
+)
+
+const (
+	// sceneWidth is width of the downscaled gray copy of the frame the scene checks operate on
+	sceneWidth = 64
+	// stallIntervals is number of expected part intervals the scene must stay static for the line to be stalled
+	stallIntervals = 3
+)
+
+// SceneMonitor checks every few frames of a video source whether the camera can see the line and the line moves
+// The camera is blocked if the frame is too dark, e.g. the lens is covered or the lights failed. The line is stalled
+// if the frame doesn't change for stallIntervals expected part intervals.
+type SceneMonitor struct {
+	// every is number of frames between the checks
+	every int
+	// minBrightness is mean gray level below which the camera is blocked
+	minBrightness float64
+	// staticDiff is mean absolute difference of gray levels of checked frames below which the scene is static
+	staticDiff float64
+	// stallTimeout is time the scene must stay static for the line to be stalled; stalls are not checked if zero
+	stallTimeout time.Duration
+	// frames is number of frames since the last check
+	frames int
+	// prev is gray copy of the last checked frame; it's empty before the first check
+	prev gocv.Mat
+	// staticSince is time since the scene has been static; it's zero if the scene moves
+	staticSince time.Time
+	// Brightness is mean gray level of the last checked frame
+	Brightness float64
+	// Blocked means the camera is blocked
+	Blocked bool
+	// Stalled means the line is stalled
+	Stalled bool
+}
+
+// NewSceneMonitor creates new scene monitor of video source checking every Nth frame according to cfg
+func NewSceneMonitor(cfg Config) *SceneMonitor {
+	m := &SceneMonitor{
+		every:         cfg.SceneCheckEvery,
+		minBrightness: cfg.MinBrightness,
+		staticDiff:    cfg.StaticDiff,
+		prev:          gocv.NewMat(),
+	}
+	if cfg.ExpectedPPM > 0 {
+		m.stallTimeout = time.Duration(stallIntervals * float64(time.Minute) / cfg.ExpectedPPM)
+	}
+
+	return m
+}
+
+// Check checks frame img captured at time now if it's due and reports whether Blocked or Stalled changed
+func (m *SceneMonitor) Check(img gocv.Mat, now time.Time) (blockedChanged, stalledChanged bool) {
+	if m.frames++; m.frames < m.every || img.Empty() {
+		return false, false
+	}
+	m.frames = 0
+
+	// the checks run on a tiny gray copy of the frame, so they cost next to nothing
+	gray := gocv.NewMat()
+	defer gray.Close()
+	size := image.Point{sceneWidth, sceneWidth * img.Rows() / img.Cols()}
+	gocv.Resize(img, &gray, size, 0, 0, gocv.InterpolationArea)
+	if gray.Channels() > 1 {
+		gocv.CvtColor(gray, &gray, gocv.ColorBGRToGray)
+	}
+
+	m.Brightness = gray.Mean().Val1
+	blocked := m.Brightness < m.minBrightness
+
+	// a blocked camera sees a static scene, so the line is only stalled if the camera sees it
+	stalled := false
+	if !m.prev.Empty() && m.stallTimeout > 0 && !blocked {
+		diff := gocv.NewMat()
+		gocv.AbsDiff(gray, m.prev, &diff)
+		static := diff.Mean().Val1 < m.staticDiff
+		diff.Close()
+
+		switch {
+		case !static:
+			m.staticSince = time.Time{}
+		case m.staticSince.IsZero():
+			m.staticSince = now
+		}
+		stalled = !m.staticSince.IsZero() && now.Sub(m.staticSince) >= m.stallTimeout
+	} else {
+		m.staticSince = time.Time{}
+	}
+	gray.CopyTo(&m.prev)
+
+	blockedChanged, stalledChanged = blocked != m.Blocked, stalled != m.Stalled
+	m.Blocked, m.Stalled = blocked, stalled
+	return blockedChanged, stalledChanged
+}
+
+// Close releases the resources of the monitor
+func (m *SceneMonitor) Close() {
+	m.prev.Close()
+}
@@ -0,0 +1,171 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// scheduleInterval is interval between checks of the active shift
+const scheduleInterval = time.Minute
+
+// Shift is a daily time range with its own part area range
+type Shift struct {
+	// Start is time of day the shift starts at in HH:MM format
+	Start string `json:"start"`
+	// End is time of day the shift ends at in HH:MM format; the shift continues past midnight if it's before Start
+	End string `json:"end"`
+	// Min is minimum part area of the shift
+	Min int `json:"min"`
+	// Max is maximum part area of the shift
+	Max int `json:"max"`
+	// start and end are minutes since midnight of Start and End
+	start, end int
+}
+
+// String implements fmt.Stringer interface for Shift
+func (s Shift) String() string {
+	return s.Start + "-" + s.End
+}
+
+// contains returns true if minute of the day m falls within the shift
+func (s Shift) contains(m int) bool {
+	if s.start < s.end {
+		return m >= s.start && m < s.end
+	}
+
+	return m >= s.start || m < s.end
+}
+
+// Thresholds returns thresholds of the shift
+func (s Shift) Thresholds() Thresholds {
+	return Thresholds{Min: &s.Min, Max: &s.Max}
+}
+
+// Schedule is list of shifts; the first shift containing the time of day is active
+type Schedule []Shift
+
+// parseTimeOfDay parses time of day s in HH:MM format and returns minutes since midnight
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: expected HH:MM", s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// LoadSchedule reads shift schedule from JSON file path and validates its shifts against detector configuration dc
+// It returns error if the file can't be read or if any shift is invalid.
+func LoadSchedule(path string, dc DetectorConfig) (Schedule, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schedule: %v", err)
+	}
+
+	var s Schedule
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse schedule %s: %v", path, err)
+	}
+
+	for i := range s {
+		shift := &s[i]
+		if shift.start, err = parseTimeOfDay(shift.Start); err != nil {
+			return nil, fmt.Errorf("shift %d: %v", i+1, err)
+		}
+		if shift.end, err = parseTimeOfDay(shift.End); err != nil {
+			return nil, fmt.Errorf("shift %d: %v", i+1, err)
+		}
+		if shift.start == shift.end {
+			return nil, fmt.Errorf("shift %d: start and end are the same", i+1)
+		}
+		if _, err := applyThresholds(dc, shift.Thresholds()); err != nil {
+			return nil, fmt.Errorf("shift %s: %v", shift, err)
+		}
+	}
+
+	return s, nil
+}
+
+// Active returns index of the shift active at time t; it's -1 if no shift is active
+func (s Schedule) Active(t time.Time) int {
+	m := t.Hour()*60 + t.Minute()
+	for i, shift := range s {
+		if shift.contains(m) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// scheduleRunner applies thresholds of the active shift of schedule s through control whenever the active shift changes.
+// Thresholds defaults are applied when no shift is active. The active shift is checked every scheduleInterval.
+// doneChan is used to receive a signal from the main goroutine to notify the routine to stop and return
+func scheduleRunner(s Schedule, defaults Thresholds, control *ConfigHandler, doneChan <-chan struct{}) error {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+
+	// active is index of the active shift; the thresholds are applied on the first check
+	active := -2
+	check := func() error {
+		i := s.Active(time.Now())
+		if i == active {
+			return nil
+		}
+
+		t := defaults
+		if i >= 0 {
+			fmt.Printf("Shift %s started\n", s[i])
+			t = s[i].Thresholds()
+		} else {
+			fmt.Printf("No shift scheduled: using default thresholds\n")
+		}
+		if err := control.Update(t); err != nil {
+			return err
+		}
+		active = i
+		return nil
+	}
+
+	for {
+		switch err := check(); {
+		case err == errStopping:
+			return nil
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Failed to apply shift thresholds: %v\n", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-doneChan:
+			fmt.Printf("Stopping scheduleRunner: received stop signal\n")
+			return nil
+		}
+	}
+}
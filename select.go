@@ -0,0 +1,221 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"io/ioutil"
+
+	"gocv.io/x/gocv"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// keys used to draw the mask polygons
+const (
+	keyEnter     = 13
+	keyEsc       = 27
+	keySpace     = 32
+	keyBackspace = 8
+)
+
+// selectRegions lets the user select region of interest and mask in the next frame of pipeline p if cfg asks for it,
+// and applies them to cfg and p. The region is printed as -roi flag value and it's written to cfg.ConfigFile
+// if cfg.SaveROI is set; the mask is saved to cfg.SelectMask. It returns error if a selection is cancelled.
+func selectRegions(cfg *Config, p *pipeline) (err error) {
+	// the window system may panic rather than fail
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("display panicked: %v", r)
+		}
+	}()
+
+	if ok := p.read(); !ok {
+		return fmt.Errorf("failed to read frame of %s", p.src.Name)
+	}
+
+	if cfg.SelectROI {
+		roi, err := selectROI(p.img)
+		if err != nil {
+			return err
+		}
+		cfg.ROI = fmt.Sprintf("%d,%d,%d,%d", roi.Min.X, roi.Min.Y, roi.Dx(), roi.Dy())
+		fmt.Printf("Selected region of interest: -roi=%s\n", cfg.ROI)
+		if cfg.SaveROI {
+			if err := saveROI(cfg.ConfigFile, cfg.ROI); err != nil {
+				return err
+			}
+			fmt.Printf("Saved region of interest to %s\n", cfg.ConfigFile)
+		}
+		if err := cfg.DetectorConfig.Validate(); err != nil {
+			return &ErrInvalidThreshold{err}
+		}
+	}
+
+	if cfg.SelectMask != "" {
+		mask, err := selectMask(p.img)
+		if err != nil {
+			return err
+		}
+		ok := gocv.IMWrite(cfg.SelectMask, mask)
+		mask.Close()
+		if !ok {
+			return fmt.Errorf("failed to write mask image %s", cfg.SelectMask)
+		}
+		fmt.Printf("Saved mask to %s; use it with -mask=%s\n", cfg.SelectMask, cfg.SelectMask)
+		cfg.MaskFile = cfg.SelectMask
+		if p.mask != nil {
+			p.mask.Close()
+		}
+		if p.mask, err = loadMask(cfg.MaskFile, p.size); err != nil {
+			return err
+		}
+	}
+
+	p.reconfigure(cfg.DetectorConfig)
+	return nil
+}
+
+// selectROI lets the user drag region of interest over img and returns it
+// The selection is confirmed with Space or Enter; it returns error if it's cancelled.
+func selectROI(img gocv.Mat) (image.Rectangle, error) {
+	fmt.Printf("Drag the region of interest and press Enter; press c to cancel\n")
+	roi := gocv.SelectROI("Select region of interest", img)
+	// SelectROI leaves its window open
+	gocv.WaitKey(1)
+	if roi.Empty() {
+		return roi, fmt.Errorf("region of interest selection cancelled")
+	}
+
+	return roi.Intersect(image.Rect(0, 0, img.Cols(), img.Rows())), nil
+}
+
+// selectMask lets the user draw polygons over img which are excluded from detection and returns the mask image:
+// white where parts are detected and black within the polygons.
+// gocv has no mouse callbacks, so the cursor is moved with the x and y trackbars; Space adds vertex at the cursor,
+// Backspace removes the last one, Enter closes the polygon and Esc finishes the mask.
+func selectMask(img gocv.Mat) (gocv.Mat, error) {
+	const title = "Select mask"
+	window := gocv.NewWindow(title)
+	defer window.Close()
+	x := window.CreateTrackbar("x", img.Cols()-1)
+	y := window.CreateTrackbar("y", img.Rows()-1)
+	x.SetPos(img.Cols() / 2)
+	y.SetPos(img.Rows() / 2)
+	fmt.Printf("Move the cursor with the trackbars: Space adds vertex, Backspace removes it, " +
+		"Enter closes the polygon, Esc finishes the mask\n")
+
+	var polygons [][]image.Point
+	var vertices []image.Point
+	red := color.RGBA{255, 0, 0, 0}
+	green := color.RGBA{0, 255, 0, 0}
+
+	for {
+		cursor := image.Point{x.GetPos(), y.GetPos()}
+
+		screen := img.Clone()
+		if len(polygons) > 0 {
+			gocv.FillPoly(&screen, polygons, red)
+		}
+		for i, v := range vertices {
+			gocv.Circle(&screen, v, 3, green, -1)
+			if i > 0 {
+				gocv.Line(&screen, vertices[i-1], v, green, 1)
+			}
+		}
+		if len(vertices) > 0 {
+			gocv.Line(&screen, vertices[len(vertices)-1], cursor, green, 1)
+		}
+		gocv.Line(&screen, cursor.Sub(image.Point{10, 0}), cursor.Add(image.Point{10, 0}), green, 1)
+		gocv.Line(&screen, cursor.Sub(image.Point{0, 10}), cursor.Add(image.Point{0, 10}), green, 1)
+		window.IMShow(screen)
+		screen.Close()
+
+		switch window.WaitKey(20) {
+		case keySpace:
+			vertices = append(vertices, cursor)
+		case keyBackspace:
+			if len(vertices) > 0 {
+				vertices = vertices[:len(vertices)-1]
+			}
+		case keyEnter:
+			if len(vertices) < 3 {
+				fmt.Printf("Polygon needs at least 3 vertices\n")
+				continue
+			}
+			polygons = append(polygons, vertices)
+			vertices = nil
+		case keyEsc:
+			if len(polygons) == 0 {
+				return gocv.Mat{}, fmt.Errorf("mask selection cancelled: no polygon drawn")
+			}
+			mask := gocv.NewMatWithSizeFromScalar(gocv.NewScalar(255, 0, 0, 0), img.Rows(), img.Cols(), gocv.MatTypeCV8U)
+			gocv.FillPoly(&mask, polygons, color.RGBA{0, 0, 0, 0})
+			return mask, nil
+		}
+	}
+}
+
+// saveROI sets region of interest roi in YAML configuration file path
+// The order of the settings is preserved but the comments are not.
+func saveROI(path, roi string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+
+	// setKey sets key of map m to value, appending it if it's not set
+	setKey := func(m yaml.MapSlice, key string, value interface{}) yaml.MapSlice {
+		for i := range m {
+			if m[i].Key == key {
+				m[i].Value = value
+				return m
+			}
+		}
+		return append(m, yaml.MapItem{Key: key, Value: value})
+	}
+
+	var detector yaml.MapSlice
+	for _, item := range doc {
+		if item.Key == "detector" {
+			detector, _ = item.Value.(yaml.MapSlice)
+		}
+	}
+	doc = setKey(doc, "detector", setKey(detector, "roi", roi))
+
+	if data, err = yaml.Marshal(doc); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write config file: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+	"gocv.io/x/gocv"
+)
+
+const (
+	// singleOK is exit code of single image mode when the part has no defect
+	singleOK = 0
+	// singleDefect is exit code of single image mode when the part has a defect
+	singleDefect = 1
+	// singleError is exit code of single image mode when the image can't be processed
+	singleError = 2
+)
+
+// single detects part in the single image given by -input using configuration cfg and prints the result as JSON;
+// if zones are configured, a result is printed for every zone.
+// The image is transformed the same way as video frames, but no display, MQTT connection or other output is opened.
+// It returns singleDefect exit code if any part has a defect, singleError if the image can't be processed
+// and singleOK otherwise.
+func single(cfg Config) int {
+	if len(cfg.Inputs) != 1 || len(cfg.InputDirs) != 0 || len(cfg.Devices) != 0 {
+		fmt.Fprintf(os.Stderr, "Single image mode requires exactly one -input image\n")
+		return singleError
+	}
+	src := cfg.Sources()[0]
+
+	img := gocv.IMRead(src.Input, gocv.IMReadColor)
+	defer img.Close()
+	if img.Empty() {
+		fmt.Fprintf(os.Stderr, "Failed to read image %s\n", src.Input)
+		return singleError
+	}
+
+	// warp and resize the image to processing frame size like the pipeline does
+	origSize := image.Point{img.Cols(), img.Rows()}
+	if warp, size := perspectiveWarp(cfg, origSize); warp != nil {
+		gocv.WarpPerspective(img, &img, *warp, size)
+		warp.Close()
+		origSize = size
+	}
+	size := procSize(origSize, cfg.ProcWidth)
+	scale := float64(size.X) / float64(origSize.X)
+	gocv.Resize(img, &img, size, 0, 0, gocv.InterpolationLinear)
+
+	if cfg.MaskFile != "" {
+		mask, err := loadMask(cfg.MaskFile, size)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid mask: %v\n", err)
+			return singleError
+		}
+		defer mask.Close()
+		cfg.Mask = mask
+	}
+
+	if cfg.CalibRes != "" {
+		cfg.DetectorConfig = scaleAreas(cfg.DetectorConfig, areaScale(size, cfg.Calib))
+	}
+
+	code := singleOK
+	for _, result := range detectZones(&img, cfg, src.Name, scale) {
+		fmt.Println(result.ToMQTTMessage())
+		if result.Defect {
+			code = singleDefect
+		}
+	}
+
+	return code
+}
+
+// detectZones detects part in every zone of processing frame img of video source named source using cfg
+// and returns the result of every zone; the whole frame is a single unnamed zone if no zones are configured.
+// No debounce is applied since a single frame can't confirm a defect over several frames: the measurement decides.
+// Result rectangles are scaled back to the source frame by scale.
+func detectZones(img *gocv.Mat, cfg Config, source string, scale float64) []*detector.Result {
+	zones := []detector.Zone{{}}
+	if len(cfg.Zones) > 0 {
+		zones = cfg.Zones
+	}
+
+	var results []*detector.Result
+	for _, zone := range zones {
+		dc := cfg.ZoneConfig(zone)
+		result := &detector.Result{Source: source, Zone: zone.Name, Min: dc.Min, Max: dc.Max}
+		var partial bool
+		// zones outside of the image never see a part
+		if roi, ok := dc.Region(img); ok {
+			region := img.Region(roi)
+			result.Rect, result.Centroid, partial = detector.DetectBlob(&region, dc, nil)
+			region.Close()
+			if !result.Rect.Empty() {
+				result.Rect = result.Rect.Add(roi.Min)
+				result.Centroid = result.Centroid.Add(roi.Min)
+			}
+		} else if zone.Name == "" {
+			result.Rect, result.Centroid, partial = detector.DetectBlob(img, dc, nil)
+		}
+		result.OrigRect = origRect(result.Rect, scale)
+
+		status := detector.DetectStatus(&result.Rect, partial, dc)
+		result.Defect, result.Severity, result.Partial = status.Defect, status.Severity, status.Partial
+		results = append(results, result)
+	}
+
+	return results
+}
@@ -0,0 +1,114 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	// SinkMQTT publishes the results to MQTT server if -publish is set
+	SinkMQTT = "mqtt"
+	// SinkStdout streams the results to stdout as JSON lines
+	SinkStdout = "stdout"
+)
+
+// StreamPublisher is Publisher which writes messages of a single topic to a stream, one message per line,
+// e.g. JSON lines to stdout; messages of other topics are discarded.
+type StreamPublisher struct {
+	// mu protects w
+	mu sync.Mutex
+	// w buffers the stream; it's flushed after every message so consumers see the messages right away
+	w *bufio.Writer
+	// topic is the only topic written to the stream
+	topic string
+	// rate measures the rate of written messages
+	rate *EWMARate
+	// stats are the write statistics; they're protected by mu
+	stats PublishStats
+}
+
+// NewStreamPublisher creates new publisher which writes messages of topic to w
+func NewStreamPublisher(w io.Writer, topic string) *StreamPublisher {
+	return &StreamPublisher{w: bufio.NewWriter(w), topic: topic, rate: NewEWMARate(publishRateTau)}
+}
+
+// PublishContext implements Publisher interface; ctx is ignored since writes can't be cancelled
+func (p *StreamPublisher) PublishContext(ctx context.Context, topic, message string) error {
+	if topic != p.topic {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := p.w.WriteString(message + "\n")
+	if err == nil {
+		err = p.w.Flush()
+	}
+	p.stats.record(len(message), err, false)
+	if err != nil {
+		return err
+	}
+	p.rate.Mark()
+
+	return nil
+}
+
+// PublishQoS implements Publisher interface; qos is ignored
+func (p *StreamPublisher) PublishQoS(ctx context.Context, topic, message string, qos byte) error {
+	return p.PublishContext(ctx, topic, message)
+}
+
+// Close implements Publisher interface; every message is already flushed, so it only flushes a failed write
+func (p *StreamPublisher) Close(timeout time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.w.Flush()
+}
+
+// Connected implements Publisher interface; the stream is always connected
+func (p *StreamPublisher) Connected() bool {
+	return true
+}
+
+// PublishRate implements Publisher interface
+func (p *StreamPublisher) PublishRate() float64 {
+	return p.rate.Rate()
+}
+
+// Stats implements Publisher interface; the stream is always connected
+func (p *StreamPublisher) Stats() PublishStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.stats
+	s.Connected = true
+	return s
+}
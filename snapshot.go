@@ -0,0 +1,148 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"image"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"gocv.io/x/gocv"
+)
+
+// Snapshot keeps the latest annotated frame of a video source so it can be requested remotely
+// The frame is only encoded when it's requested, so keeping it costs a single frame copy.
+type Snapshot struct {
+	mu sync.Mutex
+	// img is the latest annotated frame; it's empty until the first frame is rendered
+	img gocv.Mat
+}
+
+// NewSnapshot creates new empty snapshot and returns it
+func NewSnapshot() *Snapshot {
+	return &Snapshot{img: gocv.NewMat()}
+}
+
+// Update replaces the snapshot with a copy of annotated frame screen
+func (s *Snapshot) Update(screen gocv.Mat) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	screen.CopyTo(&s.img)
+}
+
+// JPEG encodes the snapshot as JPEG of given quality, resized to width preserving aspect ratio unless width is 0
+// It returns nil if no frame has been rendered yet.
+func (s *Snapshot) JPEG(width, quality int) ([]byte, error) {
+	// encode a copy so the render path is only blocked while the frame is copied
+	s.mu.Lock()
+	img := s.img.Clone()
+	s.mu.Unlock()
+	defer img.Close()
+
+	if img.Empty() {
+		return nil, nil
+	}
+
+	if width > 0 && width != img.Cols() {
+		size := procSize(image.Point{img.Cols(), img.Rows()}, width)
+		gocv.Resize(img, &img, size, 0, 0, gocv.InterpolationArea)
+	}
+
+	return gocv.IMEncodeWithParams(gocv.JPEGFileExt, img, []int{gocv.IMWriteJpegQuality, quality})
+}
+
+// Close releases the snapshot frame
+func (s *Snapshot) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.img.Close()
+}
+
+// SnapshotHandler serves the latest annotated frames of video sources as JPEG images
+type SnapshotHandler struct {
+	// snaps maps video source names to their snapshots
+	snaps map[string]*Snapshot
+	// first is name of the video source served when no source is requested
+	first string
+	// width is width snapshots are resized to; they're not resized if it's 0
+	width int
+	// quality is JPEG quality of the snapshots
+	quality int
+}
+
+// NewSnapshotHandler creates new snapshot handler serving snapshots resized to width with JPEG quality and returns it
+func NewSnapshotHandler(width, quality int) *SnapshotHandler {
+	return &SnapshotHandler{
+		snaps:   make(map[string]*Snapshot),
+		width:   width,
+		quality: quality,
+	}
+}
+
+// Add creates and returns snapshot of video source; the first added source is served by default
+// Sources must be added before the handler starts serving.
+func (h *SnapshotHandler) Add(source string) *Snapshot {
+	if len(h.snaps) == 0 {
+		h.first = source
+	}
+	s := NewSnapshot()
+	h.snaps[source] = s
+
+	return s
+}
+
+// ServeHTTP serves GET requests with the latest annotated frame of video source read from source query parameter
+func (h *SnapshotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	source := r.URL.Query().Get("source")
+	if source == "" {
+		source = h.first
+	}
+	s, ok := h.snaps[source]
+	if !ok {
+		http.Error(w, "unknown source "+strconv.Quote(source), http.StatusNotFound)
+		return
+	}
+
+	buf, err := s.JPEG(h.width, h.quality)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if buf == nil {
+		http.Error(w, "no frame rendered yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(buf)
+}
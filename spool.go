@@ -0,0 +1,222 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// spoolFile is name of the file in the spool directory the undelivered messages are stored in
+const spoolFile = "spool.jsonl"
+
+// spoolEntry is message which failed to publish
+type spoolEntry struct {
+	// ID is message ID embedded in the payload; it identifies the message in the spool
+	ID string `json:"id"`
+	// Time is time when the message failed to publish
+	Time time.Time `json:"time"`
+	// Topic is MQTT topic the message is published to
+	Topic string `json:"topic"`
+	// Payload is message payload; it's stored as bytes as protobuf encoded analytics are not valid UTF-8
+	Payload []byte `json:"payload"`
+}
+
+// Spool is on-disk queue of messages which failed to publish
+// Messages are appended to the spool file as JSON lines, so they survive program restarts, and they are removed
+// once delivered. When the spool holds size messages, the oldest message is evicted.
+// Spool is not safe for concurrent use; it's owned by messageRunner.
+type Spool struct {
+	// path is path to the spool file
+	path string
+	// size is maximum number of messages in the spool
+	size int
+	// entries are spooled messages ordered from the oldest
+	entries []spoolEntry
+	// seq is sequence number of the last message ID
+	seq uint64
+}
+
+// OpenSpool opens spool of up to size messages in directory dir, creating it if it doesn't exist, and returns it
+func OpenSpool(dir string, size int) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory: %v", err)
+	}
+
+	s := &Spool{path: filepath.Join(dir, spoolFile), size: size}
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open spool: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var e spoolEntry
+		// a line can be truncated if the program was killed while appending it
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			fmt.Fprintf(os.Stderr, "Skipping corrupted spool entry: %v\n", err)
+			continue
+		}
+		s.entries = append(s.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read spool: %v", err)
+	}
+
+	if len(s.entries) > s.size {
+		s.entries = s.entries[len(s.entries)-s.size:]
+		if err := s.write(); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// Len returns number of messages in the spool
+func (s *Spool) Len() int {
+	return len(s.entries)
+}
+
+// NextID returns unique ID of the next published message
+// The ID is embedded in the message payload before it's published for the first time, so consumers can drop
+// the duplicates of messages which were delivered although their publish failed and which were published again.
+func (s *Spool) NextID() string {
+	s.seq++
+	return fmt.Sprintf("%d-%d", time.Now().UnixNano(), s.seq)
+}
+
+// Append appends message id with payload which failed to publish to topic to the spool
+// The oldest message is evicted if the spool is full.
+func (s *Spool) Append(id, topic, payload string) error {
+	e := spoolEntry{
+		ID:      id,
+		Time:    time.Now(),
+		Topic:   topic,
+		Payload: []byte(payload),
+	}
+	s.entries = append(s.entries, e)
+
+	if len(s.entries) > s.size {
+		fmt.Fprintf(os.Stderr, "Spool is full: evicting message %s to %s\n", s.entries[0].ID, s.entries[0].Topic)
+		s.entries = s.entries[len(s.entries)-s.size:]
+		return s.write()
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode spool entry: %v", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open spool: %v", err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to append to spool: %v", err)
+	}
+
+	return f.Close()
+}
+
+// withMessageID embeds message id in JSON object payload as its MessageID field and returns the payload
+// Payloads which are not JSON objects are returned unchanged.
+func withMessageID(payload, id string) string {
+	if !strings.HasPrefix(payload, "{") {
+		return payload
+	}
+	field := fmt.Sprintf("{\"MessageID\":%q", id)
+	if strings.TrimSpace(payload[1:]) == "}" {
+		return field + "}"
+	}
+
+	return field + "," + payload[1:]
+}
+
+// Flush publishes the spooled messages in order using publisher c, waiting at most timeout for each of them.
+// It stops at the first message which fails to publish or when ctx is done. Delivered messages are removed from
+// the spool. It returns number of delivered messages.
+func (s *Spool) Flush(ctx context.Context, c Publisher, timeout time.Duration) (int, error) {
+	n := 0
+	for _, e := range s.entries {
+		pubCtx, cancel := context.WithTimeout(ctx, timeout)
+		err := c.PublishContext(pubCtx, e.Topic, string(e.Payload))
+		cancel()
+		if err != nil {
+			break
+		}
+		n++
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+	s.entries = s.entries[n:]
+
+	return n, s.write()
+}
+
+// write atomically replaces the spool file with the spooled messages
+func (s *Spool) write() error {
+	f, err := ioutil.TempFile(filepath.Dir(s.path), spoolFile)
+	if err != nil {
+		return fmt.Errorf("failed to create spool: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, e := range s.entries {
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return fmt.Errorf("failed to encode spool entry: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write spool: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write spool: %v", err)
+	}
+
+	if err := os.Rename(f.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace spool: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,175 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// fakeBroker is Publisher delivering messages to its consumer, which can be taken down
+type fakeBroker struct {
+	// down fails every publish
+	down bool
+	// lostAck delivers the next message but fails its publish as if the acknowledgement timed out
+	lostAck bool
+	// delivered are payloads of the delivered messages in delivery order
+	delivered []string
+}
+
+func (b *fakeBroker) PublishContext(ctx context.Context, topic, message string) error {
+	return b.PublishQoS(ctx, topic, message, QOS)
+}
+
+func (b *fakeBroker) PublishQoS(ctx context.Context, topic, message string, qos byte) error {
+	if b.down {
+		return errors.New("not connected")
+	}
+	b.delivered = append(b.delivered, message)
+	if b.lostAck {
+		b.lostAck = false
+		return fmt.Errorf("publish to %s timed out", topic)
+	}
+
+	return nil
+}
+
+func (b *fakeBroker) Close(timeout time.Duration) error { return nil }
+func (b *fakeBroker) Connected() bool                   { return !b.down }
+func (b *fakeBroker) PublishRate() float64              { return 0 }
+func (b *fakeBroker) Stats() PublishStats               { return PublishStats{} }
+
+func TestWithMessageID(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string
+	}{
+		{`{"Event":"left"}`, `{"MessageID":"1-1","Event":"left"}`},
+		{`{}`, `{"MessageID":"1-1"}`},
+		// protobuf payloads carry the ID in their own field
+		{"\x0a\x04cam0", "\x0a\x04cam0"},
+	}
+
+	for _, tt := range tests {
+		if got := withMessageID(tt.payload, "1-1"); got != tt.want {
+			t.Errorf("withMessageID(%q) = %q, want %q", tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestSpoolBrokerOutage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "osd-spool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := OpenSpool(dir, 100)
+	if err != nil {
+		t.Fatalf("failed to open spool: %v", err)
+	}
+	broker := &fakeBroker{}
+	ctx := context.Background()
+
+	// publish publishes defect event i the way messageRunner does, spooling it if the publish fails
+	publish := func(i int) {
+		id := spool.NextID()
+		payload := withMessageID(fmt.Sprintf(`{"Source":"cam0","Defect":true,"EventID":"cam0-%d"}`, i), id)
+		if err := broker.PublishContext(ctx, alarmsTopic, payload); err != nil {
+			if err := spool.Append(id, alarmsTopic, payload); err != nil {
+				t.Fatalf("failed to spool event %d: %v", i, err)
+			}
+		}
+	}
+
+	const events = 12
+	for i := 0; i < events; i++ {
+		switch i {
+		case 2:
+			// the event reaches the broker but its publish fails, so it's spooled as well
+			broker.lostAck = true
+		case 4:
+			broker.down = true
+		case 7:
+			// the spool survives restart during the outage
+			if spool, err = OpenSpool(dir, 100); err != nil {
+				t.Fatalf("failed to reopen spool: %v", err)
+			}
+		case 9:
+			broker.down = false
+			// the acknowledgement of the first spooled event is lost again, so the flush stops there
+			// and the broker goes down before the spool is flushed again
+			broker.lostAck = true
+			if _, err := spool.Flush(ctx, broker, time.Second); err != nil {
+				t.Fatalf("failed to flush spool: %v", err)
+			}
+			broker.down = true
+		}
+		publish(i)
+	}
+
+	broker.down = false
+	if _, err := spool.Flush(ctx, broker, time.Second); err != nil {
+		t.Fatalf("failed to flush spool: %v", err)
+	}
+	if spool.Len() != 0 {
+		t.Errorf("%d messages left in spool", spool.Len())
+	}
+
+	// the consumer drops the messages it has already seen
+	seen := make(map[string]bool)
+	received := make(map[string]int)
+	for _, payload := range broker.delivered {
+		var msg struct {
+			MessageID string
+			EventID   string
+		}
+		if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+			t.Fatalf("invalid message %s: %v", payload, err)
+		}
+		if msg.MessageID == "" {
+			t.Fatalf("message %s has no message ID", payload)
+		}
+		if seen[msg.MessageID] {
+			continue
+		}
+		seen[msg.MessageID] = true
+		received[msg.EventID]++
+	}
+
+	if len(broker.delivered) <= events {
+		t.Errorf("%d messages delivered; the test expects duplicates", len(broker.delivered))
+	}
+	for i := 0; i < events; i++ {
+		if n := received[fmt.Sprintf("cam0-%d", i)]; n != 1 {
+			t.Errorf("event cam0-%d received %d times, want once", i, n)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stateSaveInterval is how often the session state is saved to the state file
+const stateSaveInterval = 5 * time.Second
+
+// State is session state persisted across program restarts
+type State struct {
+	mu sync.Mutex
+	// path is path to the state file; the state is not persisted if it's empty
+	path string
+	// dirty means the state has changed since it was last saved
+	dirty bool
+	// RestartCount is number of times the program was restarted with the state file
+	RestartCount int `json:"restart_count"`
+	// Seq maps video source names to the sequence number of their last result
+	Seq map[string]uint64 `json:"seq"`
+	// Thresholds are detector thresholds updated at runtime; they're nil if they were never updated
+	Thresholds *Thresholds `json:"thresholds,omitempty"`
+}
+
+// LoadState loads session state from state file path and counts the program restart
+// The state file is created if it does not exist; the state is only kept in memory if path is empty.
+// It returns error if the state file can't be read or written.
+func LoadState(path string) (*State, error) {
+	s := &State{path: path, Seq: make(map[string]uint64)}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := json.Unmarshal(data, s); err != nil {
+			return nil, fmt.Errorf("invalid state file %s: %v", path, err)
+		}
+		if s.Seq == nil {
+			s.Seq = make(map[string]uint64)
+		}
+		s.RestartCount++
+	}
+
+	s.dirty = true
+	if err := s.Save(); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Next increments the sequence number of video source and returns it
+func (s *State) Next(source string) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Seq[source]++
+	s.dirty = true
+
+	return s.Seq[source]
+}
+
+// SetThresholds records detector thresholds t updated at runtime
+func (s *State) SetThresholds(t Thresholds) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Thresholds = &t
+	s.dirty = true
+}
+
+// Restarts returns number of times the program was restarted with the state file
+func (s *State) Restarts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.RestartCount
+}
+
+// Save writes the state to the state file if it has changed since it was last saved
+// The file is replaced atomically so a crash never leaves it truncated.
+func (s *State) Save() error {
+	s.mu.Lock()
+	if s.path == "" || !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(s)
+	s.dirty = false
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Run saves the state every stateSaveInterval until a signal is received on doneChan
+// The final state must be saved by the caller once the sequence numbers stop changing.
+func (s *State) Run(doneChan <-chan struct{}) error {
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to save state: %v\n", err)
+			}
+		case <-doneChan:
+			fmt.Printf("Stopping state saver: received stop signal\n")
+			return nil
+		}
+	}
+}
+
+// newUUID generates random version 4 UUID and returns it
+func newUUID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
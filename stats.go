@@ -0,0 +1,84 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// EWMARate computes exponentially weighted moving average of the rate of events per second
+type EWMARate struct {
+	mu sync.Mutex
+	// tau is time constant of the average; events older than tau weigh less than 1/e
+	tau time.Duration
+	// rate is average rate of events per second at time last
+	rate float64
+	// last is time of the last event
+	last time.Time
+}
+
+// NewEWMARate creates new moving average rate with time constant tau and returns it
+func NewEWMARate(tau time.Duration) *EWMARate {
+	return &EWMARate{tau: tau}
+}
+
+// Mark records an event which happened now
+func (e *EWMARate) Mark() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if e.last.IsZero() {
+		e.last = now
+		return
+	}
+
+	dt := now.Sub(e.last).Seconds()
+	e.last = now
+	if dt <= 0 {
+		return
+	}
+	alpha := 1 - math.Exp(-dt/e.tau.Seconds())
+	e.rate += alpha * (1/dt - e.rate)
+}
+
+// Rate returns the current average rate of events per second
+// The rate decays once no event has happened for longer than the average interval between events.
+func (e *EWMARate) Rate() float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.rate == 0 {
+		return 0
+	}
+
+	idle := time.Since(e.last).Seconds()
+	if idle <= 1/e.rate {
+		return e.rate
+	}
+
+	return e.rate * math.Exp(-(idle-1/e.rate)/e.tau.Seconds())
+}
@@ -0,0 +1,400 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	mrand "math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// defaultOTLPEndpoint is base URL of the OTLP/HTTP collector used if OTEL_EXPORTER_OTLP_ENDPOINT is not set
+	defaultOTLPEndpoint = "http://localhost:4318"
+	// traceQueueSize is number of ended spans waiting to be exported before new spans are dropped
+	traceQueueSize = 2048
+	// traceBatchSize is maximum number of spans exported in one request
+	traceBatchSize = 512
+	// traceExportInterval is interval between exports of the ended spans
+	traceExportInterval = 5 * time.Second
+	// traceExportTimeout is time limit of one export request
+	traceExportTimeout = 10 * time.Second
+)
+
+// otlpTracesEndpoint returns URL of the OTLP/HTTP traces endpoint configured by the standard environment variables
+func otlpTracesEndpoint() string {
+	if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"); endpoint != "" {
+		return endpoint
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		endpoint = defaultOTLPEndpoint
+	}
+
+	return strings.TrimSuffix(endpoint, "/") + "/v1/traces"
+}
+
+// SpanContext identifies a span within its trace; it's carried across channels to propagate the trace
+type SpanContext struct {
+	// TraceID identifies the trace
+	TraceID [16]byte
+	// SpanID identifies the span within the trace
+	SpanID [8]byte
+}
+
+// Valid returns true if c identifies a span
+func (c SpanContext) Valid() bool {
+	return c.TraceID != [16]byte{} && c.SpanID != [8]byte{}
+}
+
+// TraceParent returns W3C traceparent representation of c; it's empty if c is not valid
+func (c SpanContext) TraceParent() string {
+	if !c.Valid() {
+		return ""
+	}
+
+	return "00-" + hex.EncodeToString(c.TraceID[:]) + "-" + hex.EncodeToString(c.SpanID[:]) + "-01"
+}
+
+// ParseTraceParent parses W3C traceparent s; it returns invalid span context if s is malformed
+func ParseTraceParent(s string) SpanContext {
+	var c SpanContext
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return SpanContext{}
+	}
+	if _, err := hex.Decode(c.TraceID[:], []byte(parts[1])); err != nil {
+		return SpanContext{}
+	}
+	if _, err := hex.Decode(c.SpanID[:], []byte(parts[2])); err != nil {
+		return SpanContext{}
+	}
+
+	return c
+}
+
+// spanAttr is span attribute; its value is either int64 or string
+type spanAttr struct {
+	key   string
+	value interface{}
+}
+
+// Span is a timed operation of the frame pipeline
+// All methods of Span can be called on nil span which is returned if the frame is not traced.
+type Span struct {
+	tracer *Tracer
+	name   string
+	ctx    SpanContext
+	// parent is ID of the parent span; it's zero for root spans
+	parent [8]byte
+	start  time.Time
+	end    time.Time
+	attrs  []spanAttr
+	links  []SpanContext
+}
+
+// Context returns span context of s; it's invalid if s is nil
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+
+	return s.ctx
+}
+
+// Child starts new span called name which is a child of s at time start
+func (s *Span) Child(name string, start time.Time) *Span {
+	if s == nil {
+		return nil
+	}
+
+	child := s.tracer.newSpan(name, start)
+	child.ctx.TraceID, child.parent = s.ctx.TraceID, s.ctx.SpanID
+	return child
+}
+
+// SetInt sets integer attribute key of s to v
+func (s *Span) SetInt(key string, v int64) {
+	if s != nil {
+		s.attrs = append(s.attrs, spanAttr{key, v})
+	}
+}
+
+// SetString sets string attribute key of s to v
+func (s *Span) SetString(key string, v string) {
+	if s != nil {
+		s.attrs = append(s.attrs, spanAttr{key, v})
+	}
+}
+
+// End ends s and queues it for export; s must not be used afterwards
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.end = time.Now()
+	s.tracer.queueSpan(s)
+}
+
+// Tracer samples frames of the pipeline and exports their spans in batches to OTLP/HTTP collector
+type Tracer struct {
+	// endpoint is URL of the OTLP/HTTP traces endpoint
+	endpoint string
+	// service is service name the spans are reported under
+	service string
+	// sample is fraction of the traced frames
+	sample float64
+	// client sends export requests
+	client *http.Client
+	// queue contains ended spans waiting to be exported
+	queue chan *Span
+	// dropped counts spans which were not exported
+	dropped uint64
+}
+
+// NewTracer creates new tracer which exports spans of sample fraction of frames to OTLP/HTTP traces endpoint.
+// The spans are reported under OTEL_SERVICE_NAME service name if it's set.
+func NewTracer(endpoint string, sample float64) *Tracer {
+	service := os.Getenv("OTEL_SERVICE_NAME")
+	if service == "" {
+		service = name
+	}
+
+	return &Tracer{
+		endpoint: endpoint,
+		service:  service,
+		sample:   sample,
+		client:   &http.Client{Timeout: traceExportTimeout},
+		queue:    make(chan *Span, traceQueueSize),
+	}
+}
+
+// Sample returns true if the next frame should be traced; it's always false if t is nil
+func (t *Tracer) Sample() bool {
+	return t != nil && t.sample > 0 && mrand.Float64() < t.sample
+}
+
+// Root starts new trace with root span called name at time start.
+// The span is linked to spans links; invalid links are ignored. It returns nil if t is nil.
+func (t *Tracer) Root(name string, start time.Time, links ...SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+
+	s := t.newSpan(name, start)
+	if _, err := rand.Read(s.ctx.TraceID[:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate trace ID: %v\n", err)
+	}
+	for _, l := range links {
+		if l.Valid() {
+			s.links = append(s.links, l)
+		}
+	}
+
+	return s
+}
+
+// newSpan creates new span called name with new span ID started at time start
+func (t *Tracer) newSpan(name string, start time.Time) *Span {
+	s := &Span{tracer: t, name: name, start: start}
+	if _, err := rand.Read(s.ctx.SpanID[:]); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to generate span ID: %v\n", err)
+	}
+
+	return s
+}
+
+// queueSpan queues ended span s for export; it's dropped if too many spans are already waiting
+func (t *Tracer) queueSpan(s *Span) {
+	select {
+	case t.queue <- s:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// Dropped returns number of spans which were not exported
+func (t *Tracer) Dropped() uint64 {
+	return atomic.LoadUint64(&t.dropped)
+}
+
+// Run exports queued spans every traceExportInterval until it receives a signal on doneChan
+// doneChan is used to receive a signal from the main goroutine to notify the routine to stop and return
+// The spans queued when the signal is received are exported before Run returns.
+func (t *Tracer) Run(doneChan <-chan struct{}) error {
+	ticker := time.NewTicker(traceExportInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, traceBatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.export(batch); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to export %d spans: %v\n", len(batch), err)
+			atomic.AddUint64(&t.dropped, uint64(len(batch)))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case s := <-t.queue:
+			if batch = append(batch, s); len(batch) == traceBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-doneChan:
+			fmt.Printf("Stopping tracer: received stop signal\n")
+			for {
+				select {
+				case s := <-t.queue:
+					if batch = append(batch, s); len(batch) == traceBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					return nil
+				}
+			}
+		}
+	}
+}
+
+// otlpAttr is attribute in OTLP/JSON encoding
+type otlpAttr struct {
+	Key   string `json:"key"`
+	Value struct {
+		IntValue    string `json:"intValue,omitempty"`
+		StringValue string `json:"stringValue,omitempty"`
+	} `json:"value"`
+}
+
+// otlpLink is span link in OTLP/JSON encoding
+type otlpLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+// otlpSpan is span in OTLP/JSON encoding
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Links             []otlpLink `json:"links,omitempty"`
+}
+
+// newOTLPAttr returns attribute a in OTLP/JSON encoding
+func newOTLPAttr(a spanAttr) otlpAttr {
+	attr := otlpAttr{Key: a.key}
+	switch v := a.value.(type) {
+	case int64:
+		// 64-bit integers are encoded as strings in OTLP/JSON
+		attr.Value.IntValue = strconv.FormatInt(v, 10)
+	case string:
+		attr.Value.StringValue = v
+	}
+
+	return attr
+}
+
+// newOTLPSpan returns span s in OTLP/JSON encoding
+func newOTLPSpan(s *Span) otlpSpan {
+	span := otlpSpan{
+		TraceID: hex.EncodeToString(s.ctx.TraceID[:]),
+		SpanID:  hex.EncodeToString(s.ctx.SpanID[:]),
+		Name:    s.name,
+		// SPAN_KIND_INTERNAL; the spans of a frame never leave the program
+		Kind:              1,
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parent != [8]byte{} {
+		span.ParentSpanID = hex.EncodeToString(s.parent[:])
+	}
+	for _, a := range s.attrs {
+		span.Attributes = append(span.Attributes, newOTLPAttr(a))
+	}
+	for _, l := range s.links {
+		span.Links = append(span.Links, otlpLink{hex.EncodeToString(l.TraceID[:]), hex.EncodeToString(l.SpanID[:])})
+	}
+
+	return span
+}
+
+// export sends spans to the OTLP/HTTP traces endpoint in OTLP/JSON encoding
+func (t *Tracer) export(spans []*Span) error {
+	encoded := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		encoded[i] = newOTLPSpan(s)
+	}
+	service := otlpAttr{Key: "service.name"}
+	service.Value.StringValue = t.service
+
+	req := map[string]interface{}{
+		"resourceSpans": []interface{}{map[string]interface{}{
+			"resource": map[string]interface{}{"attributes": []otlpAttr{service}},
+			"scopeSpans": []interface{}{map[string]interface{}{
+				"scope": map[string]string{"name": name},
+				"spans": encoded,
+			}},
+		}},
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.client.Post(t.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// drain the body so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector responded with %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,436 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+	"gocv.io/x/gocv"
+)
+
+const (
+	// triggerTopic is MQTT topic the trigger responses are published to
+	triggerTopic = "defects/trigger"
+	// triggerImageTopic is MQTT topic the JPEG frames grabbed on the triggers are published to
+	triggerImageTopic = "defects/trigger/image"
+	// triggerQueue is number of triggers queued while a frame is being inspected
+	triggerQueue = 16
+	// triggerFrameAge is age above which the latest grabbed frame is too old to be inspected
+	triggerFrameAge = 2 * time.Second
+)
+
+const (
+	// TriggerHTTP is origin of the triggers received over HTTP
+	TriggerHTTP = "http"
+	// TriggerMQTT is origin of the triggers received over MQTT
+	TriggerMQTT = "mqtt"
+	// TriggerSignal is origin of the triggers received as SIGUSR1
+	TriggerSignal = "signal"
+)
+
+// TriggerResponse is result of the inspection of the frame grabbed on a trigger
+type TriggerResponse struct {
+	// ID is sequence number of the trigger; it starts at 1
+	ID uint64
+	// Source is name of the inspected video source
+	Source string
+	// Trigger is origin of the trigger
+	Trigger string
+	// Timestamp is time when the inspection finished
+	Timestamp string
+	// Defect is true if the part in any zone has a defect
+	Defect bool
+	// Results are detection results of the zones
+	Results []json.RawMessage
+	// LatencyMs is number of milliseconds from the trigger to the result, including the time spent in the queue
+	LatencyMs float64
+	// FrameAgeMs is age of the inspected frame in milliseconds when the inspection started
+	FrameAgeMs float64
+	// Error is reason why no frame could be inspected; it's empty on success
+	Error string `json:",omitempty"`
+
+	// results are the detection results published to the analytics topic
+	results []*detector.Result
+	// image is the inspected frame encoded as JPEG; it's nil if the image is not published
+	image []byte
+}
+
+// triggerRequest is a queued trigger
+type triggerRequest struct {
+	// id is sequence number of the trigger
+	id uint64
+	// origin is origin of the trigger
+	origin string
+	// at is time when the trigger was received
+	at time.Time
+	// reply receives the response; it's buffered, so nobody has to wait for it
+	reply chan TriggerResponse
+}
+
+// Triggerer keeps the video capture of a pipeline warm and inspects the latest frame on every trigger
+// Triggers are queued and inspected one by one in the order they were received.
+type Triggerer struct {
+	// p is the pipeline of the inspected video source
+	p *pipeline
+	// pub publishes the responses; it's nil if publishing is disabled
+	pub Publisher
+	// image publishes the inspected frames as JPEG
+	image bool
+	// requests queues the triggers
+	requests chan triggerRequest
+	// done is closed when the triggerer stops
+	done <-chan struct{}
+
+	mu sync.Mutex
+	// latest is the latest frame grabbed from the capture
+	latest gocv.Mat
+	// grabbedAt is time when latest was grabbed
+	grabbedAt time.Time
+	// err is error which stopped the capture; it's nil while the capture runs
+	err error
+	// id is sequence number of the last trigger
+	id uint64
+}
+
+// NewTriggerer creates new triggerer which inspects frames of pipeline p and publishes the responses to pub
+// until done is closed; pub can be nil if publishing is disabled.
+// The frame read by the pipeline to measure the frame size is the first frame available to the triggers.
+func NewTriggerer(p *pipeline, pub Publisher, done <-chan struct{}) *Triggerer {
+	t := &Triggerer{
+		p:        p,
+		pub:      pub,
+		image:    p.cfg.TriggerImage && p.cfg.Publish,
+		requests: make(chan triggerRequest, triggerQueue),
+		done:     done,
+		latest:   gocv.NewMat(),
+	}
+	if p.pending {
+		p.img.CopyTo(&t.latest)
+		t.grabbedAt = time.Now()
+		p.pending = false
+	}
+
+	return t
+}
+
+// Close releases the latest grabbed frame; it must only be called once grab and run have returned
+func (t *Triggerer) Close() {
+	t.latest.Close()
+}
+
+// Fire queues a trigger from origin and returns channel the response is delivered to
+// The trigger is rejected with an error response if the queue is full.
+func (t *Triggerer) Fire(origin string) <-chan TriggerResponse {
+	t.mu.Lock()
+	t.id++
+	req := triggerRequest{id: t.id, origin: origin, at: time.Now(), reply: make(chan TriggerResponse, 1)}
+	t.mu.Unlock()
+
+	select {
+	case t.requests <- req:
+	default:
+		t.respond(req, t.failed(req, fmt.Errorf("%d triggers are already queued", triggerQueue)))
+	}
+
+	return req.reply
+}
+
+// grab reads frames from the capture until done is closed, so the latest frame is always at hand
+// Video files and image sequences are read at their frame rate. If the capture stops,
+// every following trigger gets an error response.
+func (t *Triggerer) grab() {
+	img := gocv.NewMat()
+	defer img.Close()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		default:
+		}
+
+		if ok := t.p.vc.Read(&img); !ok {
+			fmt.Fprintf(os.Stderr, "Failed to read frame from %s: triggers can't be inspected\n", t.p.src.Name)
+			t.mu.Lock()
+			t.err = fmt.Errorf("capture of %s stopped", t.p.src.Name)
+			t.mu.Unlock()
+			return
+		}
+		if img.Empty() {
+			continue
+		}
+
+		t.mu.Lock()
+		t.latest, img = img, t.latest
+		t.grabbedAt = time.Now()
+		t.mu.Unlock()
+
+		if t.p.src.DeviceID < 0 {
+			time.Sleep(time.Duration(t.p.delay * float64(time.Millisecond)))
+		}
+	}
+}
+
+// run inspects the queued triggers until done is closed; the triggers still queued then get an error response
+func (t *Triggerer) run() {
+	for {
+		select {
+		case req := <-t.requests:
+			t.respond(req, t.inspect(req))
+		case <-t.done:
+			for {
+				select {
+				case req := <-t.requests:
+					t.respond(req, t.failed(req, fmt.Errorf("trigger mode is stopping")))
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// inspect detects part in every zone of the latest grabbed frame for trigger req
+// It returns an error response if no recent frame has been grabbed.
+func (t *Triggerer) inspect(req triggerRequest) TriggerResponse {
+	t.mu.Lock()
+	err, age := t.err, time.Since(t.grabbedAt)
+	if err == nil && (t.latest.Empty() || age > triggerFrameAge) {
+		err = fmt.Errorf("no frame grabbed from %s within %v", t.p.src.Name, triggerFrameAge)
+	}
+	if err == nil {
+		t.latest.CopyTo(&t.p.img)
+	}
+	t.mu.Unlock()
+	if err != nil {
+		return t.failed(req, err)
+	}
+
+	resp := t.failed(req, nil)
+	resp.FrameAgeMs = float64(age) / float64(time.Millisecond)
+	if t.image {
+		if resp.image, err = gocv.IMEncode(gocv.JPEGFileExt, t.p.img); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to encode trigger %d frame: %v\n", req.id, err)
+		}
+	}
+
+	t.p.transform()
+	resp.results = detectZones(&t.p.img, t.p.cfg, t.p.src.Name, t.p.cfg.Scale)
+	for _, r := range resp.results {
+		resp.Results = append(resp.Results, json.RawMessage(r.ToMQTTMessage()))
+		resp.Defect = resp.Defect || r.Defect
+	}
+
+	return resp
+}
+
+// failed returns response to trigger req which failed with err; it returns an empty response if err is nil
+func (t *Triggerer) failed(req triggerRequest, err error) TriggerResponse {
+	return TriggerResponse{
+		ID:        req.id,
+		Source:    t.p.src.Name,
+		Trigger:   req.origin,
+		Timestamp: time.Now().UTC().Format(time.RFC3339Nano),
+		Error:     errString(err),
+	}
+}
+
+// respond stamps the latency of response resp to trigger req, publishes it and delivers it to the trigger
+func (t *Triggerer) respond(req triggerRequest, resp TriggerResponse) {
+	resp.LatencyMs = float64(time.Since(req.at)) / float64(time.Millisecond)
+	if resp.Error != "" {
+		fmt.Fprintf(os.Stderr, "Trigger %d from %s failed: %s\n", resp.ID, resp.Trigger, resp.Error)
+	} else {
+		fmt.Printf("Trigger %d from %s inspected in %.1f ms: defect %v\n", resp.ID, resp.Trigger, resp.LatencyMs, resp.Defect)
+	}
+
+	if t.pub != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(t.p.cfg.PublishTimeout)*time.Millisecond)
+		for _, r := range resp.results {
+			if err := t.pub.PublishContext(ctx, topic, r.Payload(t.p.cfg.MQTTEncoding)); err != nil {
+				fmt.Fprintf(os.Stderr, "Error publishing trigger %d result: %v\n", resp.ID, err)
+			}
+		}
+		if resp.image != nil {
+			if err := t.pub.PublishContext(ctx, triggerImageTopic, string(resp.image)); err != nil {
+				fmt.Fprintf(os.Stderr, "Error publishing trigger %d image: %v\n", resp.ID, err)
+			}
+		}
+		if data, err := json.Marshal(resp); err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding trigger %d response: %v\n", resp.ID, err)
+		} else if err := t.pub.PublishContext(ctx, triggerTopic, string(data)); err != nil {
+			fmt.Fprintf(os.Stderr, "Error publishing trigger %d response: %v\n", resp.ID, err)
+		}
+		cancel()
+	}
+
+	req.reply <- resp
+}
+
+// handleMessage implements MQTT.MessageHandler; it fires a trigger on trigger command received in msg
+// Other commands are not supported in trigger mode.
+func (t *Triggerer) handleMessage(client MQTT.Client, msg MQTT.Message) {
+	var cmd Command
+	if err := json.Unmarshal(msg.Payload(), &cmd); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid command received on %s: %v\n", msg.Topic(), err)
+		return
+	}
+	if cmd.Action == "" {
+		cmd.Action = cmd.Cmd
+	}
+
+	if cmd.Action != CommandTrigger {
+		fmt.Fprintf(os.Stderr, "Command %q received on %s is not supported in trigger mode\n", cmd.Action, msg.Topic())
+		return
+	}
+	t.Fire(TriggerMQTT)
+}
+
+// ServeHTTP implements http.Handler interface; it fires a trigger on POST requests and responds with its result
+// Failed inspections are answered with 503 status code.
+func (t *Triggerer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resp TriggerResponse
+	select {
+	case resp = <-t.Fire(TriggerHTTP):
+	case <-r.Context().Done():
+		return
+	case <-t.done:
+		http.Error(w, "trigger mode is stopping", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if resp.Error != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		fmt.Printf("Error encoding trigger response: %v\n", err)
+	}
+}
+
+// triggered runs trigger mode: the capture of the only video source configured in cfg is kept warm
+// and a part is detected in a single frame on every trigger received over HTTP, MQTT or as SIGUSR1.
+// The results are published like in the continuous mode, but with no debounce, since every trigger
+// sees a single frame; stream sink writes them to stdout. It returns program exit code.
+func triggered(cfg Config, stdout io.Writer) int {
+	p, err := newPipeline(cfg.Sources()[0], cfg)
+	if err != nil {
+		msg, code := explain(err)
+		fmt.Fprintf(os.Stderr, "%s\n", msg)
+		return code
+	}
+	defer p.close()
+
+	var pub Publisher
+	if cfg.Publish {
+		c, err := NewMQTTPublisher(cfg.MQTT)
+		if err != nil {
+			msg, code := explain(err)
+			fmt.Fprintf(os.Stderr, "Failed to create MQTT publisher: %s\n", msg)
+			return code
+		}
+		defer closePublisher(c, time.Duration(cfg.MQTTDisconnect)*time.Millisecond)
+		pub = c
+	} else if cfg.Sink == SinkStdout {
+		pub = NewStreamPublisher(stdout, topic)
+	}
+
+	doneChan := make(chan struct{})
+	errChan := make(chan error, 1)
+	t := NewTriggerer(p, pub, doneChan)
+	defer t.Close()
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		t.grab()
+	}()
+	go func() {
+		defer wg.Done()
+		t.run()
+	}()
+
+	if s, ok := pub.(subscriber); ok {
+		if err := s.Subscribe(commandsTopic, QOS, t.handleMessage); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to subscribe to %s: %v\n", commandsTopic, err)
+		}
+	}
+
+	if cfg.HTTPAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/trigger", t)
+		srv := &http.Server{Addr: cfg.HTTPAddr, Handler: mux}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := httpRunner(srv, doneChan); err != nil {
+				errChan <- err
+			}
+		}()
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	usr1Chan := make(chan os.Signal, 1)
+	signal.Notify(usr1Chan, syscall.SIGUSR1)
+	fmt.Printf("Waiting for triggers of %s\n", p.src.Name)
+
+	code := 0
+wait:
+	for {
+		select {
+		case <-usr1Chan:
+			t.Fire(TriggerSignal)
+		case sig := <-sigChan:
+			fmt.Printf("Shutting down. Got signal: %s\n", sig)
+			break wait
+		case err := <-errChan:
+			fmt.Printf("Shutting down. Encountered error: %s\n", err)
+			code = 1
+			break wait
+		}
+	}
+
+	close(doneChan)
+	wg.Wait()
+
+	return code
+}
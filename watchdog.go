@@ -0,0 +1,89 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// ErrFrameTimeout is returned when frame processing repeatedly fails to produce results in time
+var ErrFrameTimeout = errors.New("frame processing timed out")
+
+// Watchdog monitors frame processing and reports when it stalls
+type Watchdog struct {
+	// last is time of the last processed frame in unix nanoseconds; accessed atomically
+	last int64
+	// timeout is maximum time between two processed frames
+	timeout time.Duration
+	// count is number of consecutive timeouts after which the watchdog fails
+	count int
+}
+
+// NewWatchdog creates new watchdog which fails after count consecutive timeouts and returns it
+func NewWatchdog(timeout time.Duration, count int) *Watchdog {
+	return &Watchdog{
+		last:    time.Now().UnixNano(),
+		timeout: timeout,
+		count:   count,
+	}
+}
+
+// Touch records that a frame has just been processed
+func (w *Watchdog) Touch() {
+	atomic.StoreInt64(&w.last, time.Now().UnixNano())
+}
+
+// Run checks every timeout whether a frame has been processed since the last check.
+// It logs a warning on every timeout and returns ErrFrameTimeout when the timeout repeats more than count
+// times in a row. doneChan is used to receive a signal from the main goroutine to notify the routine to stop.
+func (w *Watchdog) Run(doneChan <-chan struct{}) error {
+	ticker := time.NewTicker(w.timeout)
+	defer ticker.Stop()
+
+	timeouts := 0
+
+	for {
+		select {
+		case now := <-ticker.C:
+			since := now.Sub(time.Unix(0, atomic.LoadInt64(&w.last)))
+			if since < w.timeout {
+				timeouts = 0
+				continue
+			}
+
+			timeouts++
+			fmt.Fprintf(os.Stderr, "Warning: no frame processed in %v (%d/%d)\n", since, timeouts, w.count)
+			if timeouts > w.count {
+				return ErrFrameTimeout
+			}
+		case <-doneChan:
+			fmt.Printf("Stopping watchdog: received stop signal\n")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,129 @@
+/*
+* Copyright (c) 2018 Intel Corporation.
+*
+* Permission is hereby granted, free of charge, to any person obtaining
+* a copy of this software and associated documentation files (the
+* "Software"), to deal in the Software without restriction, including
+* without limitation the rights to use, copy, modify, merge, publish,
+* distribute, sublicense, and/or sell copies of the Software, and to
+* permit persons to whom the Software is furnished to do so, subject to
+* the following conditions:
+*
+* The above copyright notice and this permission notice shall be
+* included in all copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+* EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+* MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+* NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+* LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+* OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+* WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+ */
+
+package main
+
+import (
+	"math"
+	"time"
+
+	"github.com/intel-iot-devkit/object-size-detector-go/pkg/detector"
+)
+
+// zoneDetector keeps the state of part detection in a single zone of a video source
+type zoneDetector struct {
+	// name is name of the zone; it's empty if no zones are configured
+	name string
+	// cfg is detector configuration of the zone
+	cfg DetectorConfig
+	// filter selects contours which can be parts
+	filter detector.ContourFilter
+	// result stores detection results of the zone
+	result *detector.Result
+	// part is the part in view of the zone
+	part *detector.Part
+	// defects tracks rolling defect rate of the recent parts
+	defects *rollingRate
+	// dwells tracks rolling average dwell time of the recent parts
+	dwells *rollingMean
+	// lastPartSeen is time when a part was last seen; the zone is jammed if it's too long ago
+	lastPartSeen time.Time
+	// confirmed is set if status of the part in view was confirmed in the last processed frame
+	confirmed bool
+}
+
+// newZoneDetectors creates detectors of the zones configured in cfg for video source named source and returns them.
+// A single unnamed zone covering the whole frame is created if no zones are configured.
+// Detectors in prev of zones which are still configured keep their state; restarts is the program restart count.
+func newZoneDetectors(source string, cfg Config, restarts int, prev []*zoneDetector) []*zoneDetector {
+	zones := []detector.Zone{{}}
+	if len(cfg.Zones) > 0 {
+		zones = cfg.Zones
+	}
+
+	detectors := make([]*zoneDetector, len(zones))
+	for i, zone := range zones {
+		var z *zoneDetector
+		for _, p := range prev {
+			if p.name == zone.Name {
+				z = p
+				break
+			}
+		}
+		if z == nil {
+			z = &zoneDetector{
+				name:         zone.Name,
+				result:       &detector.Result{Source: source, Zone: zone.Name, RestartCount: restarts, FPS: cfg.FPS},
+				part:         &detector.Part{Now: new(detector.Status)},
+				defects:      newRollingRate(defectRateWindow),
+				dwells:       newRollingMean(defectRateWindow),
+				lastPartSeen: time.Now(),
+			}
+		}
+		z.cfg = cfg.ZoneConfig(zone)
+		z.filter = z.cfg.ContourFilter()
+		z.result.ZoneRect = zone.Bounds
+		z.result.Profile = cfg.Profile
+		detectors[i] = z
+	}
+
+	return detectors
+}
+
+// resetCounters resets the part and defect totals and the defect rate of the zone
+func (z *zoneDetector) resetCounters() {
+	z.result.TotalParts, z.result.TotalDefects, z.result.DefectRate = 0, 0, 0
+	z.defects = newRollingRate(defectRateWindow)
+}
+
+// resetPart forgets the part in view and its classification, so the next part is tracked afresh
+func (z *zoneDetector) resetPart() {
+	z.part = &detector.Part{Now: new(detector.Status)}
+	z.result.Defect, z.result.EventID, z.result.Confidence, z.result.DefectAge = false, "", 0, 0
+	z.result.PartMinArea, z.result.PartMaxArea = 0, 0
+}
+
+// rollup sums up the results of zone detectors zones into result of the whole video source
+// The source has a defect or is jammed if any of its zones is; its defect rate is the highest zone defect rate.
+// Copies of the zone results are kept in result.Zones.
+func rollup(result *detector.Result, zones []*zoneDetector) {
+	result.Zones = make([]detector.Result, len(zones))
+	result.Defect, result.Severity, result.BeltJam = false, detector.SeverityOK, false
+	result.TotalParts, result.TotalDefects, result.DefectAge, result.DefectRate = 0, 0, 0, 0
+	for i, z := range zones {
+		r := z.result
+		result.Zones[i] = *r
+		result.Defect = result.Defect || r.Defect
+		result.BeltJam = result.BeltJam || r.BeltJam
+		if r.Severity > result.Severity {
+			result.Severity = r.Severity
+		}
+		result.TotalParts += r.TotalParts
+		result.TotalDefects += r.TotalDefects
+		// the most recent defect decides whether the frame flashes
+		if r.DefectAge > 0 && (result.DefectAge == 0 || r.DefectAge < result.DefectAge) {
+			result.DefectAge = r.DefectAge
+		}
+		result.DefectRate = math.Max(result.DefectRate, r.DefectRate)
+	}
+}